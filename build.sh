@@ -1,13 +1,16 @@
 #!/bin/bash
 
-go build -o msm-client main.go
+# The version identifies the binary to self-updates; override with VERSION=...
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+
+go build -ldflags "-X main.Version=${VERSION}" -o msm-client main.go
 
 if [ $? -ne 0 ]; then
     echo "Build failed. Please check the output for errors."
     exit 1
 fi
 
-echo "Build successful. Executable created: msm-client"
+echo "Build successful. Executable created: msm-client (version ${VERSION})"
 
 tar -czf msm-client.tar.gz msm-client templates
 
@@ -1,34 +1,160 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
+
+	"msm-client/utils"
 )
 
 type ClientConfig struct {
 	ClientID             string        `json:"client_id"`
 	DeviceName           string        `json:"device_name,omitempty"`            // Optional friendly name for the device
+	MaxDeviceNameLength  int           `json:"max_device_name_length,omitempty"` // Longer device names are truncated (default: 128)
 	StatusUpdateInterval time.Duration `json:"status_update_interval,omitempty"` // How often to send status updates (default: 5 seconds)
 	DisableCommands      bool          `json:"disable_commands,omitempty"`       // Disable remote command execution
+	DryRun               bool          `json:"dry_run,omitempty"`                // Log external commands instead of running them
+
+	PersistEnvOverrides bool `json:"persist_env_overrides,omitempty"` // Write values set through MSM_* environment variables back to the config file
+
+	DisableDiagnosticCommands bool `json:"disable_diagnostic_commands,omitempty"` // Disable network diagnostic commands (check_port, etc.)
+
+	MaxCommandAgeSeconds    int `json:"max_command_age_seconds,omitempty"`    // Commands timestamped further in the past are refused as stale (default: 0, disabled)
+	MaxCommandFutureSeconds int `json:"max_command_future_seconds,omitempty"` // Commands timestamped further in the future are refused (default: 0, disabled)
+
+	DisableSystemInfo bool `json:"disable_system_info,omitempty"` // Disable commands reporting running processes (get_process_cpu)
+
+	AllowNotifications bool `json:"allow_notifications,omitempty"` // Enable the send_test_notification command (runs notify-send)
+
+	DebugRuntimeStats bool `json:"debug_runtime_stats,omitempty"` // Report Go runtime stats in status under "runtime" and enable the runtime_profile command
+
+	// Directory listing via list_files; nothing can be listed unless both are set
+	AllowFileBrowse    bool     `json:"allow_file_browse,omitempty"`    // Enable the list_files command
+	AllowedBrowsePaths []string `json:"allowed_browse_paths,omitempty"` // Absolute directories list_files may read under
+
+	// Remote content sync; download_file and file_sync_request are rejected outside these directories
+	AllowedDownloadPaths []string `json:"allowed_download_paths,omitempty"` // Absolute directories download_file may write under
+
+	// Log forwarding; log package output is reported at info level
+	EnableRemoteLogging bool   `json:"enable_remote_logging,omitempty"` // Send log entries to the server while connected
+	RemoteLogLevel      string `json:"remote_log_level,omitempty"`      // Minimum level forwarded: debug, info, warn or error (default: warn)
+
+	DisableConnectivityCheck bool `json:"disable_connectivity_check,omitempty"` // Skip the connectivity summary in pairing responses
+
+	MaxStatusPayloadSize int `json:"max_status_payload_size,omitempty"` // Max size in bytes of an outgoing status payload (default: 65536)
+
+	MaxMessageBytes int `json:"max_message_bytes,omitempty"` // Command responses larger than this are sent in chunks; incoming messages may be twice as large (default: 262144)
+
+	MalformedMessageThreshold int `json:"malformed_message_threshold,omitempty"` // Consecutive undecodable messages after which the connection is closed and re-dialled (default: 5)
+
+	SendFailureThreshold int `json:"send_failure_threshold,omitempty"` // Consecutive failed sends after which the connection is closed and re-dialled (default: 3)
+
+	ClientPingInterval  time.Duration `json:"client_ping_interval,omitempty"`   // How often the client pings the server to measure latency (default: 0, disabled)
+	ClientPingMaxMissed int           `json:"client_ping_max_missed,omitempty"` // Consecutive unanswered client pings after which the connection is closed and re-dialled (default: 3)
+
+	// Watchdog recovering a client that is running but stuck
+	WatchdogInterval       time.Duration `json:"watchdog_interval,omitempty"`        // How often the watchdog checks run (default: 1 minute, negative disables)
+	WatchdogDisabledChecks []string      `json:"watchdog_disabled_checks,omitempty"` // Watchdog checks to skip: "status", "pairing" or "commands"
+	CommandTimeout         time.Duration `json:"command_timeout,omitempty"`          // Expected max run time of an external command; the watchdog kills commands running 3 times longer (default: 2 minutes)
+
+	StatusFields []string `json:"status_fields,omitempty"` // Top-level status keys to send, or "all" (default: all); clientId and timestamp are always sent
+
+	// Wire names for servers other than MSM; empty maps send the canonical names
+	MessageTypeAliases map[string]string `json:"message_type_aliases,omitempty"` // Message type -> name on the wire, both directions
+	StatusFieldAliases map[string]string `json:"status_field_aliases,omitempty"` // Status field -> key in sent status messages
+
+	RedactNetworkIdentifiers bool `json:"redact_network_identifiers,omitempty"` // Mask MAC and IP host parts in status and pairing responses
+
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"` // Preferred message encryption: "aes-cbc" (default), "aes-gcm" or "chacha20-poly1305"
+
+	CompressPayloadsOverBytes int `json:"compress_payloads_over_bytes,omitempty"` // Gzip encrypted payloads larger than this (default: 4096, negative disables)
+
+	MeasureNetworkSpeed bool `json:"measure_network_speed,omitempty"` // Include a 1-second interface speed measurement in status updates
+
+	UseBinaryFraming bool `json:"use_binary_framing,omitempty"` // Offer type-prefixed binary frames to the server during the handshake
+
+	CloseTimeout time.Duration `json:"close_timeout,omitempty"` // Max time to wait for the server's close frame on disconnect (default: 2 seconds)
+
+	StateMissingGracePeriod time.Duration `json:"state_missing_grace_period,omitempty"` // How long a state file that disappeared unexpectedly is waited for before pairing restarts (default: 10 seconds, negative disables)
 
 	VerificationCodeLength   int `json:"verification_code_length,omitempty"`   // Length of verification code (default: 6)
 	VerificationCodeAttempts int `json:"verification_code_attempts,omitempty"` // Max attempts for verification code (default: 3)
 
+	PairingCodeType   string `json:"pairing_code_type,omitempty"`   // "alphanumeric" (default) or "numeric", for entry on a numeric keypad
+	NumericCodeLength int    `json:"numeric_code_length,omitempty"` // Length of numeric verification codes, longer to keep the entropy (default: 8)
+
+	PairingMaxBodyBytes int `json:"pairing_max_body_bytes,omitempty"` // Max request body size accepted by the pairing server (default: 4096)
+
+	// Pairing server bind retries, tried in order on the pairing port and then each fallback port
+	PairingBindAttempts  int   `json:"pairing_bind_attempts,omitempty"`  // Bind attempts per port, with exponential backoff (default: 5)
+	PairingFallbackPorts []int `json:"pairing_fallback_ports,omitempty"` // Ports tried when the pairing port stays busy
+
+	MinAvailableMemoryBytes uint64 `json:"min_available_memory_bytes,omitempty"` // Pairing requests get 503 below this much available memory (default: 50 MB)
+
 	// Pairing code expiration setting
 	PairingCodeExpiration time.Duration `json:"pairing_code_expiration,omitempty"` // How long pairing codes remain valid (default: 1 minute)
 
+	PairingConfirmGrace time.Duration `json:"pairing_confirm_grace,omitempty"` // How long a repeated successful confirm is answered again, until the WebSocket connects (default: 30 seconds, negative disables)
+
+	// Pairing restart after the state is deleted by deactivation or a decryption failure
+	PairingRetryOnDisconnect bool          `json:"pairing_retry_on_disconnect"`     // Probe the last server before restarting the pairing server (default: true)
+	PairingBackoffDelay      time.Duration `json:"pairing_backoff_delay,omitempty"` // How long the last server is probed before the pairing server restarts anyway (default: 30 seconds)
+
 	// Screen management settings
-	ScreenSwitchPath string `json:"screen_switch_path,omitempty"` // Path to screen switch script (default: /usr/local/bin/mediascreen-installer/scripts/screen-switch.sh)
+	ScreenSwitchPath    string        `json:"screen_switch_path,omitempty"`    // Path to screen switch script (default: /usr/local/bin/mediascreen-installer/scripts/screen-switch.sh)
+	ScreenWatchInterval time.Duration `json:"screen_watch_interval,omitempty"` // How often the active screen is polled for changes (default: 2 seconds)
+
+	// Power commands, run through the command executor; the first element is an absolute path or a binary on PATH
+	RebootCommand   []string `json:"reboot_command,omitempty"`   // Argv of the reboot command (default: ["reboot"])
+	ShutdownCommand []string `json:"shutdown_command,omitempty"` // Argv of the shutdown command (default: ["poweroff"])
+
+	DisplayOrientations               map[string]int `json:"display_orientations,omitempty"`        // Screen ID -> rotation in degrees (0, 90, 180, 270), set with set_display_orientation
+	RestoreDisplayOrientationsOnStart bool           `json:"restore_display_orientations_on_start"` // Reapply DisplayOrientations through the screen switch script on start (default: true)
+
+	// Lifecycle hooks, run with "sh -c"
+	StartupScript  string        `json:"startup_script,omitempty"`  // Run after the config is loaded on start
+	ShutdownScript string        `json:"shutdown_script,omitempty"` // Run during graceful shutdown, after the WebSocket is closed
+	ScriptTimeout  time.Duration `json:"script_timeout,omitempty"`  // Max run time of a lifecycle script (default: 30 seconds)
+
+	// Connection hooks, run through the command executor with MSM_EVENT, MSM_SERVER_URL and MSM_REASON set
+	OnConnectScriptPath    string `json:"on_connect_script_path,omitempty"`    // Run when the WebSocket connects
+	OnDisconnectScriptPath string `json:"on_disconnect_script_path,omitempty"` // Run when the connection is lost, the state is deleted or the device is deactivated
+
+	// Restrictions applied to external commands (screen switch, reboot, update scripts)
+	ExecPolicy ExecPolicy `json:"exec_policy"`
+
+	// Local management listeners (control socket TCP fallback); bound to loopback unless remote management is allowed
+	ManagementBindAddress string `json:"management_bind_address,omitempty"` // IP management listeners bind to (default: 127.0.0.1)
+	AllowRemoteManagement bool   `json:"allow_remote_management,omitempty"` // Permit binding management listeners to all interfaces (0.0.0.0 or ::)
+	ControlTCPPort        int    `json:"control_tcp_port,omitempty"`        // Serve the control protocol on this TCP port when the unix socket is unavailable (0 disables)
+
+	// Self-update settings
+	// AutoUpdate only takes effect when AllowAutoUpdate is also set
+	AutoUpdate       bool   `json:"auto_update,omitempty"`        // Automatically install updates announced by the server
+	AllowAutoUpdate  bool   `json:"allow_auto_update,omitempty"`  // Permit AutoUpdate on this device
+	UpdateScriptPath string `json:"update_script_path,omitempty"` // Path to update script (default: /usr/local/bin/mediascreen-installer/scripts/update.sh)
 
 	// Pairing security settings
 	// IP validation modes (in order of precedence):
@@ -43,25 +169,117 @@ type ClientConfig struct {
 	// IP blacklist security settings
 	MaxIPViolations     int           `json:"max_ip_violations,omitempty"`     // Max IP violations before blacklisting (default: 3)
 	IPBlacklistDuration time.Duration `json:"ip_blacklist_duration,omitempty"` // How long to blacklist an IP (default: 1 hour)
+	IPViolationWindow   time.Duration `json:"ip_violation_window,omitempty"`   // How long violations below the blacklist threshold are remembered (default: the blacklist duration)
+
+	// Pairing event notifications
+	PairingWebhookURL    string `json:"pairing_webhook_url,omitempty"`    // http(s) URL that pairing events are posted to (default: none)
+	PairingWebhookSecret string `json:"pairing_webhook_secret,omitempty"` // Shared secret for the X-MSM-Signature HMAC-SHA256 header; unsigned when empty
+
+	DisplayAdminToken string `json:"display_admin_token,omitempty"` // HTTP basic auth password for /display/admin; the admin view is disabled when empty
 }
 
+// ExecPolicy restricts the environment and resources of commands run by the client
+type ExecPolicy struct {
+	EnvAllowlist   []string `json:"env_allowlist,omitempty"`    // Environment variables passed through to commands (default: PATH, HOME, LANG, TZ and the display variables)
+	WorkingDir     string   `json:"working_dir,omitempty"`      // Working directory for commands (default: inherited)
+	RunAsUser      string   `json:"run_as_user,omitempty"`      // Run commands as this user; only applies when the client runs as root
+	CPUSeconds     uint64   `json:"cpu_seconds,omitempty"`      // CPU time limit in seconds (0 = unlimited)
+	MaxMemoryBytes uint64   `json:"max_memory_bytes,omitempty"` // Address space limit in bytes (0 = unlimited)
+	MaxProcesses   uint64   `json:"max_processes,omitempty"`    // Process limit for the command's user, as RLIMIT_NPROC (0 = unlimited)
+}
+
+// HasLimits reports whether the policy sets any resource limit
+func (p ExecPolicy) HasLimits() bool {
+	return p.CPUSeconds > 0 || p.MaxMemoryBytes > 0 || p.MaxProcesses > 0
+}
+
+// defaultExecEnvAllowlist is passed through to commands when no allowlist is
+// configured. The display variables let screen commands reach the X11 or
+// Wayland session.
+var defaultExecEnvAllowlist = []string{"PATH", "HOME", "LANG", "TZ", "DISPLAY", "WAYLAND_DISPLAY", "XDG_RUNTIME_DIR"}
+
 const defaultPath = "/etc/msm-client" // Default path for config file
 
 const configFile = "config.json"
 
+// maxPathLength bounds configured script paths; longer values are truncated
+const maxPathLength = 512
+
+// Watchdog checks, named in watchdog_disabled_checks
+const (
+	WatchdogCheckStatus   = "status"   // A status was sent recently while connected
+	WatchdogCheckPairing  = "pairing"  // The pairing server answers /pair/status while pairing
+	WatchdogCheckCommands = "commands" // No external command runs far past command_timeout
+)
+
+// KnownWatchdogChecks lists the watchdog checks
+var KnownWatchdogChecks = []string{WatchdogCheckStatus, WatchdogCheckPairing, WatchdogCheckCommands}
+
+// StatusFieldsAll selects every status field
+const StatusFieldsAll = "all"
+
+// KnownStatusFields lists the optional top-level keys of a status payload
+var KnownStatusFields = []string{
+	"uptime", "interfaces", "last_update", "metadata", "current_screen",
+	"recent_commands", "network_speed", "bandwidth", "mode", "runtime", "latency_ms",
+}
+
+// KnownMessageTypes lists the message types exchanged with the server
+var KnownMessageTypes = []string{
+	"ping", "command", "deactivated", "update_available", "file_sync_request",
+	"config_push", "status_request", "pong", "status", "command_response", "error",
+	"disconnect", "event", "file_sync_manifest", "command_response_chunk",
+	"log_entry", "config_push_ack",
+}
+
+// reservedStatusKeys are keys of every sent message that no status field can be renamed to
+var reservedStatusKeys = []string{"type", "clientId", "timestamp"}
+
 // defaultConfig contains all default configuration values
 var defaultConfig = ClientConfig{
-	StatusUpdateInterval:     30 * time.Second,
-	DisableCommands:          false,
-	VerificationCodeLength:   6,
-	VerificationCodeAttempts: 3,
-	PairingCodeExpiration:    2 * time.Minute,
-	ScreenSwitchPath:         "/usr/local/bin/mediascreen-installer/scripts/screen-switch.sh",
-	StrictIPValidation:       false,
-	AllowIPSubnetMatch:       true, // Default to subnet validation for good NAT compatibility
-	DisableIPValidation:      false,
-	MaxIPViolations:          3,
-	IPBlacklistDuration:      1 * time.Hour,
+	MaxDeviceNameLength:       128,
+	StatusUpdateInterval:      30 * time.Second,
+	DisableCommands:           false,
+	MaxStatusPayloadSize:      65536,
+	MaxMessageBytes:           262144,
+	SendFailureThreshold:      3,
+	MalformedMessageThreshold: 5,
+	ClientPingMaxMissed:       3,
+	WatchdogInterval:          time.Minute,
+	CommandTimeout:            2 * time.Minute,
+	StatusFields:              []string{StatusFieldsAll},
+	CompressPayloadsOverBytes: 4096,
+	EncryptionAlgorithm:       "aes-cbc",
+	CloseTimeout:              2 * time.Second,
+	VerificationCodeLength:    6,
+	VerificationCodeAttempts:  3,
+	PairingCodeType:           PairingCodeAlphanumeric,
+	NumericCodeLength:         8,
+	PairingCodeExpiration:     2 * time.Minute,
+	PairingConfirmGrace:       30 * time.Second,
+	ManagementBindAddress:     "127.0.0.1",
+	RemoteLogLevel:            "warn",
+	PairingMaxBodyBytes:       4096,
+	PairingBindAttempts:       5,
+	MinAvailableMemoryBytes:   50 * 1024 * 1024,
+	ScreenSwitchPath:          "/usr/local/bin/mediascreen-installer/scripts/screen-switch.sh",
+	ScreenWatchInterval:       2 * time.Second,
+	ScriptTimeout:             30 * time.Second,
+	UpdateScriptPath:          "/usr/local/bin/mediascreen-installer/scripts/update.sh",
+	RebootCommand:             []string{"reboot"},
+	ShutdownCommand:           []string{"poweroff"},
+	StrictIPValidation:        false,
+	AllowIPSubnetMatch:        true, // Default to subnet validation for good NAT compatibility
+	DisableIPValidation:       false,
+	MaxIPViolations:           3,
+	IPBlacklistDuration:       1 * time.Hour,
+
+	// Default to true; LoadOrCreateConfig presets them before reading the file
+	RestoreDisplayOrientationsOnStart: true,
+	PairingRetryOnDisconnect:          true,
+
+	PairingBackoffDelay:     30 * time.Second,
+	StateMissingGracePeriod: 10 * time.Second,
 }
 
 // getConfigPath returns the path for the config file based on environment variable or default
@@ -72,41 +290,240 @@ func getConfigPath() string {
 	return filepath.Join(defaultPath, configFile)
 }
 
-func LoadOrCreateConfig() (ClientConfig, error) {
-	var cfg ClientConfig
-	configPath := getConfigPath()
+// FilePath returns the path of the config file in use
+func FilePath() string {
+	return getConfigPath()
+}
+
+// presetConfig returns an empty config with the settings that default to true
+// preset, so only an explicit false in a config document clears them
+func presetConfig() ClientConfig {
+	return ClientConfig{
+		RestoreDisplayOrientationsOnStart: defaultConfig.RestoreDisplayOrientationsOnStart,
+		PairingRetryOnDisconnect:          defaultConfig.PairingRetryOnDisconnect,
+	}
+}
+
+// ParseConfig decodes a complete config document, such as one pushed by the
+// server. Unknown fields are rejected; the result is not validated.
+func ParseConfig(data []byte) (ClientConfig, error) {
+	cfg := presetConfig()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return ClientConfig{}, err
+	}
+	return cfg, nil
+}
 
-	// Try to load existing config
-	if data, err := os.ReadFile(configPath); err == nil {
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			return cfg, err
+// ChangedFields returns the sorted JSON names of the fields whose values differ
+// between from and to
+func ChangedFields(from, to ClientConfig) []string {
+	fromFields, fromErr := configFields(from)
+	toFields, toErr := configFields(to)
+	if fromErr != nil || toErr != nil {
+		return nil
+	}
+
+	var changed []string
+	for name, value := range toFields {
+		if !bytes.Equal(value, fromFields[name]) {
+			changed = append(changed, name)
 		}
-	} else {
+	}
+	for name := range fromFields {
+		if _, ok := toFields[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// configFields returns the JSON encoding of each field of cfg by name
+func configFields(cfg ClientConfig) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	return fields, json.Unmarshal(data, &fields)
+}
+
+// restartRequiredFields are only read on start or when the connection is set
+// up, so changing them at runtime takes effect after a restart
+var restartRequiredFields = map[string]bool{
+	"enable_remote_logging":                 true,
+	"remote_log_level":                      true,
+	"use_binary_framing":                    true,
+	"restore_display_orientations_on_start": true,
+	"startup_script":                        true,
+	"shutdown_script":                       true,
+	"management_bind_address":               true,
+	"allow_remote_management":               true,
+	"control_tcp_port":                      true,
+}
+
+// RequiresRestart reports whether a change to the field with the given JSON
+// name only takes effect after the client restarts
+func RequiresRestart(field string) bool {
+	return restartRequiredFields[field]
+}
+
+// KeepProtectedFields copies the security settings from local into cfg: what
+// the client may execute, read or write, who may manage or pair it, where
+// pairing events go and whether it installs updates unattended. These are set
+// by the device operator and never taken from a config push. It returns the
+// sorted JSON names of the fields cfg had set differently.
+func KeepProtectedFields(cfg *ClientConfig, local ClientConfig) []string {
+	kept := *cfg
+	kept.DisableCommands = local.DisableCommands
+	kept.DisableDiagnosticCommands = local.DisableDiagnosticCommands
+	kept.AllowFileBrowse = local.AllowFileBrowse
+	kept.AllowedBrowsePaths = local.AllowedBrowsePaths
+	kept.AllowedDownloadPaths = local.AllowedDownloadPaths
+	kept.ExecPolicy = local.ExecPolicy
+	kept.ScreenSwitchPath = local.ScreenSwitchPath
+	kept.RebootCommand = local.RebootCommand
+	kept.ShutdownCommand = local.ShutdownCommand
+	kept.StartupScript = local.StartupScript
+	kept.ShutdownScript = local.ShutdownScript
+	kept.OnConnectScriptPath = local.OnConnectScriptPath
+	kept.OnDisconnectScriptPath = local.OnDisconnectScriptPath
+	kept.UpdateScriptPath = local.UpdateScriptPath
+	kept.AllowRemoteManagement = local.AllowRemoteManagement
+	kept.ManagementBindAddress = local.ManagementBindAddress
+	kept.ControlTCPPort = local.ControlTCPPort
+	kept.DisplayAdminToken = local.DisplayAdminToken
+	kept.AllowAutoUpdate = local.AllowAutoUpdate
+	kept.PairingWebhookURL = local.PairingWebhookURL
+	kept.PairingWebhookSecret = local.PairingWebhookSecret
+	kept.StrictIPValidation = local.StrictIPValidation
+	kept.AllowIPSubnetMatch = local.AllowIPSubnetMatch
+	kept.DisableIPValidation = local.DisableIPValidation
+
+	changed := ChangedFields(*cfg, kept)
+	*cfg = kept
+	return changed
+}
+
+// LoadOrCreateConfig loads the config file, creating it with a new client ID
+// when missing, and returns the effective config. The file is written only
+// when it is new or validation corrected it; environment and runtime
+// overrides are not saved unless persist_env_overrides is set.
+func LoadOrCreateConfig() (ClientConfig, error) {
+	loaded, fileExists, err := readConfigFile()
+	if err != nil {
+		return loaded, err
+	}
+	if !fileExists {
 		// Generate new UUID for new config
-		cfg.ClientID = uuid.New().String()
+		loaded.ClientID = uuid.New().String()
 	}
 
 	// Load environment variables from .env file
 	loadEnv()
 
-	// Apply environment variable overrides
-	cfg.ApplyEnvironmentOverrides()
+	// Validate and auto-correct the file's own values
+	persisted, err := ValidateConfig(loaded)
+	if err != nil {
+		return loaded, err
+	}
 
-	// Validate and auto-correct configuration
-	correctedCfg, err := ValidateConfig(cfg)
+	cfg, err := persisted.EffectiveConfig()
 	if err != nil {
 		return cfg, err
 	}
-	cfg = correctedCfg
 
-	// Save the updated config
-	if err := SaveConfig(cfg); err != nil {
+	// Rewriting an unchanged file wears flash and fails on a read-only root
+	if fileExists && reflect.DeepEqual(loaded, persistedView(persisted)) {
+		return cfg, nil
+	}
+
+	if err := SaveConfig(persisted); err != nil {
+		// Corrections still apply in memory; a new client ID must be saved
+		if fileExists && (errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)) {
+			fmt.Printf("Warning: Could not save corrected config to %s, using the corrections until restart: %v\n", getConfigPath(), err)
+			return cfg, nil
+		}
 		return cfg, err
 	}
 
 	return cfg, nil
 }
 
+// readConfigFile decodes the config file over the presets. It reports false
+// when the file does not exist.
+func readConfigFile() (ClientConfig, bool, error) {
+	cfg := presetConfig()
+	data, err := os.ReadFile(getConfigPath())
+	if err != nil {
+		return cfg, false, nil
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, true, err
+	}
+	return cfg, true, nil
+}
+
+// LoadPersistedConfig returns the config as saved in the file, validated but
+// without environment or runtime overrides, for changes that are saved. When
+// there is no file yet it returns the defaults for clientID.
+func LoadPersistedConfig(clientID string) (ClientConfig, error) {
+	cfg, fileExists, err := readConfigFile()
+	if err != nil {
+		return cfg, err
+	}
+	if !fileExists {
+		cfg.ClientID = clientID
+	}
+	return ValidateConfig(cfg)
+}
+
+// runtimeOverrides is applied by EffectiveConfig after the environment
+var (
+	runtimeOverrides      func(cfg *ClientConfig)
+	runtimeOverridesMutex sync.RWMutex
+)
+
+// SetRuntimeOverrides sets the overrides, such as command line flags, that
+// EffectiveConfig applies on top of the environment. They are never saved.
+func SetRuntimeOverrides(overrides func(cfg *ClientConfig)) {
+	runtimeOverridesMutex.Lock()
+	defer runtimeOverridesMutex.Unlock()
+	runtimeOverrides = overrides
+}
+
+// EffectiveConfig returns the config consumers should use: cfg, as persisted,
+// with environment and runtime overrides applied and validated
+func (cfg ClientConfig) EffectiveConfig() (ClientConfig, error) {
+	cfg.ApplyEnvironmentOverrides()
+
+	runtimeOverridesMutex.RLock()
+	overrides := runtimeOverrides
+	runtimeOverridesMutex.RUnlock()
+	if overrides != nil {
+		overrides(&cfg)
+	}
+
+	return ValidateConfig(cfg)
+}
+
+// persistedView returns what SaveConfig writes for cfg: cfg itself, or cfg
+// with environment overrides when persist_env_overrides is set in either
+func persistedView(cfg ClientConfig) ClientConfig {
+	withEnv := cfg
+	withEnv.ApplyEnvironmentOverrides()
+	if !withEnv.PersistEnvOverrides {
+		return cfg
+	}
+	validated, err := ValidateConfig(withEnv)
+	if err != nil {
+		return cfg
+	}
+	return validated
+}
+
 func ValidateConfig(cfg ClientConfig) (ClientConfig, error) {
 	// Auto-correct invalid values by replacing with defaults
 
@@ -114,24 +531,146 @@ func ValidateConfig(cfg ClientConfig) (ClientConfig, error) {
 	if cfg.StatusUpdateInterval <= 0 {
 		cfg.StatusUpdateInterval = defaultConfig.StatusUpdateInterval
 	}
+	if cfg.CloseTimeout <= 0 {
+		cfg.CloseTimeout = defaultConfig.CloseTimeout
+	}
+	if cfg.MaxStatusPayloadSize <= 0 {
+		cfg.MaxStatusPayloadSize = defaultConfig.MaxStatusPayloadSize
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		cfg.MaxMessageBytes = defaultConfig.MaxMessageBytes
+	}
+	if cfg.SendFailureThreshold <= 0 {
+		cfg.SendFailureThreshold = defaultConfig.SendFailureThreshold
+	}
+	if cfg.MalformedMessageThreshold <= 0 {
+		cfg.MalformedMessageThreshold = defaultConfig.MalformedMessageThreshold
+	}
+	if cfg.ClientPingInterval < 0 {
+		fmt.Printf("Warning: Negative client_ping_interval %v, disabling client pings\n", cfg.ClientPingInterval)
+		cfg.ClientPingInterval = 0
+	}
+	if cfg.ClientPingMaxMissed <= 0 {
+		cfg.ClientPingMaxMissed = defaultConfig.ClientPingMaxMissed
+	}
+	if cfg.WatchdogInterval == 0 {
+		cfg.WatchdogInterval = defaultConfig.WatchdogInterval
+	}
+	warnUnknownWatchdogChecks(cfg.WatchdogDisabledChecks)
+	if cfg.CommandTimeout <= 0 {
+		cfg.CommandTimeout = defaultConfig.CommandTimeout
+	}
+	if cfg.MaxCommandAgeSeconds < 0 {
+		fmt.Printf("Warning: Invalid max_command_age_seconds %d, disabling the check\n", cfg.MaxCommandAgeSeconds)
+		cfg.MaxCommandAgeSeconds = 0
+	}
+	if cfg.MaxCommandFutureSeconds < 0 {
+		fmt.Printf("Warning: Invalid max_command_future_seconds %d, disabling the check\n", cfg.MaxCommandFutureSeconds)
+		cfg.MaxCommandFutureSeconds = 0
+	}
+	if len(cfg.StatusFields) == 0 {
+		cfg.StatusFields = defaultConfig.StatusFields
+	}
+	warnUnknownStatusFields(cfg.StatusFields)
+	cfg.MessageTypeAliases = validateAliases("message_type_aliases", cfg.MessageTypeAliases, KnownMessageTypes, nil)
+	cfg.StatusFieldAliases = validateAliases("status_field_aliases", cfg.StatusFieldAliases,
+		append(slices.Clone(KnownStatusFields), "clientId", "timestamp"), reservedStatusKeys)
+	if cfg.CompressPayloadsOverBytes == 0 {
+		cfg.CompressPayloadsOverBytes = defaultConfig.CompressPayloadsOverBytes
+	}
+	if !isValidEncryptionAlgorithm(cfg.EncryptionAlgorithm) {
+		cfg.EncryptionAlgorithm = defaultConfig.EncryptionAlgorithm
+	}
 	if cfg.MaxIPViolations < 0 {
 		cfg.MaxIPViolations = defaultConfig.MaxIPViolations
 	}
 	if cfg.IPBlacklistDuration < 0 {
 		cfg.IPBlacklistDuration = defaultConfig.IPBlacklistDuration
 	}
+	if cfg.PairingWebhookURL != "" && !isValidWebhookURL(cfg.PairingWebhookURL) {
+		fmt.Printf("Warning: Invalid pairing_webhook_url '%s', pairing webhooks disabled\n", cfg.PairingWebhookURL)
+		cfg.PairingWebhookURL = ""
+	}
+	if cfg.IPViolationWindow < 0 {
+		fmt.Printf("Warning: Negative IP violation window %v, using the blacklist duration\n", cfg.IPViolationWindow)
+		cfg.IPViolationWindow = 0
+	}
 	if cfg.VerificationCodeLength <= 0 {
 		cfg.VerificationCodeLength = defaultConfig.VerificationCodeLength
 	}
 	if cfg.VerificationCodeAttempts <= 0 {
 		cfg.VerificationCodeAttempts = defaultConfig.VerificationCodeAttempts
 	}
+	if cfg.PairingCodeType == "" {
+		cfg.PairingCodeType = defaultConfig.PairingCodeType
+	} else if !isValidPairingCodeType(cfg.PairingCodeType) {
+		fmt.Printf("Warning: Unknown pairing code type %q, using %q\n", cfg.PairingCodeType, defaultConfig.PairingCodeType)
+		cfg.PairingCodeType = defaultConfig.PairingCodeType
+	}
+	if cfg.NumericCodeLength <= 0 {
+		cfg.NumericCodeLength = defaultConfig.NumericCodeLength
+	}
 	if cfg.PairingCodeExpiration <= 0 {
 		cfg.PairingCodeExpiration = defaultConfig.PairingCodeExpiration
 	}
+	if cfg.StateMissingGracePeriod == 0 {
+		cfg.StateMissingGracePeriod = defaultConfig.StateMissingGracePeriod
+	}
+	if cfg.PairingBackoffDelay <= 0 {
+		cfg.PairingBackoffDelay = defaultConfig.PairingBackoffDelay
+	}
+	if cfg.PairingConfirmGrace == 0 {
+		cfg.PairingConfirmGrace = defaultConfig.PairingConfirmGrace
+	}
+	if cfg.PairingMaxBodyBytes <= 0 {
+		cfg.PairingMaxBodyBytes = defaultConfig.PairingMaxBodyBytes
+	}
+	if cfg.PairingBindAttempts <= 0 {
+		cfg.PairingBindAttempts = defaultConfig.PairingBindAttempts
+	}
+	if cfg.MinAvailableMemoryBytes == 0 {
+		cfg.MinAvailableMemoryBytes = defaultConfig.MinAvailableMemoryBytes
+	}
+	if !isValidLogLevel(cfg.RemoteLogLevel) {
+		if cfg.RemoteLogLevel != "" {
+			fmt.Printf("Warning: invalid remote_log_level '%s', using %s\n", cfg.RemoteLogLevel, defaultConfig.RemoteLogLevel)
+		}
+		cfg.RemoteLogLevel = defaultConfig.RemoteLogLevel
+	}
+	if cfg.ManagementBindAddress == "" {
+		cfg.ManagementBindAddress = defaultConfig.ManagementBindAddress
+	}
+	cfg.ManagementBindAddress = validManagementBindAddress(cfg.ManagementBindAddress, cfg.AllowRemoteManagement)
+	if cfg.ControlTCPPort < 0 || cfg.ControlTCPPort > 65535 {
+		cfg.ControlTCPPort = 0
+	}
 	if cfg.ScreenSwitchPath == "" {
 		cfg.ScreenSwitchPath = defaultConfig.ScreenSwitchPath
 	}
+	for screenID, angle := range cfg.DisplayOrientations {
+		if !IsValidDisplayAngle(angle) {
+			fmt.Printf("Warning: Invalid display orientation %d for screen '%s', ignoring\n", angle, screenID)
+			delete(cfg.DisplayOrientations, screenID)
+		}
+	}
+	if cfg.ScreenWatchInterval <= 0 {
+		cfg.ScreenWatchInterval = defaultConfig.ScreenWatchInterval
+	}
+	if cfg.ScriptTimeout <= 0 {
+		cfg.ScriptTimeout = defaultConfig.ScriptTimeout
+	}
+	if cfg.UpdateScriptPath == "" {
+		cfg.UpdateScriptPath = defaultConfig.UpdateScriptPath
+	}
+	cfg.RebootCommand = validPowerCommand("reboot_command", cfg.RebootCommand, defaultConfig.RebootCommand)
+	cfg.ShutdownCommand = validPowerCommand("shutdown_command", cfg.ShutdownCommand, defaultConfig.ShutdownCommand)
+	if cfg.MaxDeviceNameLength <= 0 {
+		cfg.MaxDeviceNameLength = defaultConfig.MaxDeviceNameLength
+	}
+
+	// Truncate overlong values rather than failing, so a bad field can't break a deployment
+	cfg.ScreenSwitchPath = truncateField("screen_switch_path", cfg.ScreenSwitchPath, maxPathLength)
+	cfg.UpdateScriptPath = truncateField("update_script_path", cfg.UpdateScriptPath, maxPathLength)
 
 	// Validate and fix ClientID if invalid
 	if cfg.ClientID == "" {
@@ -152,6 +691,7 @@ func ValidateConfig(cfg ClientConfig) (ClientConfig, error) {
 			cfg.DeviceName = hostname
 		}
 	}
+	cfg.DeviceName = truncateField("device_name", cfg.DeviceName, cfg.GetMaxDeviceNameLength())
 
 	// Set default IP validation (subnet mode for good NAT compatibility)
 	if !cfg.StrictIPValidation && !cfg.AllowIPSubnetMatch && !cfg.DisableIPValidation {
@@ -171,8 +711,122 @@ func ValidateConfig(cfg ClientConfig) (ClientConfig, error) {
 	return cfg, nil
 }
 
+// truncateField shortens value to at most maxLen characters, warning when it does
+func truncateField(name, value string, maxLen int) string {
+	if utf8.RuneCountInString(value) <= maxLen {
+		return value
+	}
+	fmt.Printf("Warning: %s exceeds %d characters, truncating\n", name, maxLen)
+	return string([]rune(value)[:maxLen])
+}
+
+// isValidLogLevel reports whether name is a level slog can parse
+func isValidLogLevel(name string) bool {
+	var level slog.Level
+	return name != "" && level.UnmarshalText([]byte(name)) == nil
+}
+
+// validManagementBindAddress returns address when management listeners may bind
+// to it, or the loopback default with a warning otherwise
+func validManagementBindAddress(address string, allowRemote bool) string {
+	if managementBindAllowed(address, allowRemote) {
+		return address
+	}
+	fmt.Printf("Warning: management_bind_address '%s' is not a loopback or interface IP (all interfaces require allow_remote_management), using %s\n", address, defaultConfig.ManagementBindAddress)
+	return defaultConfig.ManagementBindAddress
+}
+
+// managementBindAllowed reports whether address is an IP management listeners may
+// bind to. Unspecified addresses expose the listeners to the whole network and
+// require allowRemote.
+func managementBindAllowed(address string, allowRemote bool) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && (allowRemote || !ip.IsUnspecified())
+}
+
+// validPowerCommand returns command, or a copy of fallback when it is empty or
+// does not start with an absolute path or a bare binary name looked up on PATH
+func validPowerCommand(field string, command, fallback []string) []string {
+	if len(command) == 0 {
+		return slices.Clone(fallback)
+	}
+	if !isValidCommandName(command[0]) {
+		fmt.Printf("Warning: Invalid %s %q, the first element must be an absolute path or a binary name; using %q\n", field, command, fallback)
+		return slices.Clone(fallback)
+	}
+	return command
+}
+
+// isValidCommandName reports whether name is an absolute path or a bare binary name
+func isValidCommandName(name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	return filepath.IsAbs(name) || !strings.ContainsRune(name, '/')
+}
+
+// ResolveCommand returns the path of the binary that runs command, failing when
+// the command is empty or the binary does not exist or is not executable
+func ResolveCommand(command []string) (string, error) {
+	if len(command) == 0 || !isValidCommandName(command[0]) {
+		return "", fmt.Errorf("invalid command %q", command)
+	}
+	return exec.LookPath(command[0])
+}
+
+// warnUnknownStatusFields prints a warning for status field names that are never sent
+func warnUnknownStatusFields(fields []string) {
+	for _, field := range fields {
+		if field != StatusFieldsAll && !slices.Contains(KnownStatusFields, field) {
+			fmt.Printf("Warning: unknown status field '%s' in status_fields\n", field)
+		}
+	}
+}
+
+// validateAliases returns the usable entries of the setting's alias map. Names
+// must be one of canonical, and aliases must be unique and collide with
+// neither a canonical nor a reserved name; other entries are dropped with a
+// warning. Returns nil when no alias remains.
+func validateAliases(setting string, aliases map[string]string, canonical, reserved []string) map[string]string {
+	var valid map[string]string
+	used := make(map[string]bool)
+	for _, name := range slices.Sorted(maps.Keys(aliases)) {
+		alias := aliases[name]
+		switch {
+		case !slices.Contains(canonical, name):
+			fmt.Printf("Warning: unknown name '%s' in %s, ignoring\n", name, setting)
+		case alias == "" || alias == name:
+			// No renaming
+		case slices.Contains(canonical, alias) || slices.Contains(reserved, alias):
+			fmt.Printf("Warning: alias '%s' for '%s' in %s collides with a reserved name, ignoring\n", alias, name, setting)
+		case used[alias]:
+			fmt.Printf("Warning: alias '%s' for '%s' in %s is used twice, ignoring\n", alias, name, setting)
+		default:
+			if valid == nil {
+				valid = make(map[string]string)
+			}
+			valid[name] = alias
+			used[alias] = true
+		}
+	}
+	return valid
+}
+
+// warnUnknownWatchdogChecks prints a warning for watchdog check names that don't exist
+func warnUnknownWatchdogChecks(checks []string) {
+	for _, check := range checks {
+		if !slices.Contains(KnownWatchdogChecks, check) {
+			fmt.Printf("Warning: unknown watchdog check '%s' in watchdog_disabled_checks\n", check)
+		}
+	}
+}
+
+// SaveConfig writes cfg, a persisted config without overrides (see
+// LoadPersistedConfig), to the config file. Environment overrides are only
+// written when persist_env_overrides is set.
 func SaveConfig(cfg ClientConfig) error {
 	configPath := getConfigPath()
+	cfg = persistedView(cfg)
 
 	// Create directory if it doesn't exist
 	if dir := filepath.Dir(configPath); dir != "." {
@@ -198,7 +852,7 @@ func loadEnv() {
 // ApplyEnvironmentOverrides applies environment variable overrides to the config
 func (cfg *ClientConfig) ApplyEnvironmentOverrides() {
 	if updateStatusInterval := os.Getenv("MSM_STATUS_UPDATE_INTERVAL"); updateStatusInterval != "" {
-		if duration, err := time.ParseDuration(updateStatusInterval); err == nil && duration > 0 {
+		if duration, err := utils.ParseDurationExtended(updateStatusInterval); err == nil && duration > 0 {
 			cfg.StatusUpdateInterval = duration
 		} else {
 			fmt.Printf("Warning: Invalid MSM_STATUS_UPDATE_INTERVAL value '%s', using default\n", updateStatusInterval)
@@ -227,6 +881,204 @@ func (cfg *ClientConfig) ApplyEnvironmentOverrides() {
 		cfg.DisableCommands = true
 	}
 
+	// Check for client ping overrides; an interval of 0 disables the pings
+	if pingInterval := os.Getenv("MSM_CLIENT_PING_INTERVAL"); pingInterval != "" {
+		if duration, err := utils.ParseDurationExtended(pingInterval); err == nil && duration >= 0 {
+			cfg.ClientPingInterval = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_CLIENT_PING_INTERVAL value '%s', ignoring\n", pingInterval)
+		}
+	}
+	if maxMissed := os.Getenv("MSM_CLIENT_PING_MAX_MISSED"); maxMissed != "" {
+		if val, err := strconv.Atoi(maxMissed); err == nil && val > 0 {
+			cfg.ClientPingMaxMissed = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_CLIENT_PING_MAX_MISSED value '%s', ignoring\n", maxMissed)
+		}
+	}
+
+	// Check for watchdog overrides
+	if watchdogInterval := os.Getenv("MSM_WATCHDOG_INTERVAL"); watchdogInterval != "" {
+		if duration, err := utils.ParseDurationExtended(watchdogInterval); err == nil {
+			cfg.WatchdogInterval = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_WATCHDOG_INTERVAL value '%s', ignoring\n", watchdogInterval)
+		}
+	}
+	if disabledChecks := os.Getenv("MSM_WATCHDOG_DISABLED_CHECKS"); disabledChecks != "" {
+		cfg.WatchdogDisabledChecks = nil
+		for _, check := range strings.Split(disabledChecks, ",") {
+			if check = strings.TrimSpace(check); check != "" {
+				cfg.WatchdogDisabledChecks = append(cfg.WatchdogDisabledChecks, check)
+			}
+		}
+		warnUnknownWatchdogChecks(cfg.WatchdogDisabledChecks)
+	}
+	if commandTimeout := os.Getenv("MSM_COMMAND_TIMEOUT"); commandTimeout != "" {
+		if duration, err := utils.ParseDurationExtended(commandTimeout); err == nil && duration > 0 {
+			cfg.CommandTimeout = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_COMMAND_TIMEOUT value '%s', ignoring\n", commandTimeout)
+		}
+	}
+
+	// Check for command timestamp window overrides; 0 disables a check
+	if maxAge := os.Getenv("MSM_MAX_COMMAND_AGE_SECONDS"); maxAge != "" {
+		if val, err := strconv.Atoi(maxAge); err == nil && val >= 0 {
+			cfg.MaxCommandAgeSeconds = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_MAX_COMMAND_AGE_SECONDS value '%s', ignoring\n", maxAge)
+		}
+	}
+	if maxFuture := os.Getenv("MSM_MAX_COMMAND_FUTURE_SECONDS"); maxFuture != "" {
+		if val, err := strconv.Atoi(maxFuture); err == nil && val >= 0 {
+			cfg.MaxCommandFutureSeconds = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_MAX_COMMAND_FUTURE_SECONDS value '%s', ignoring\n", maxFuture)
+		}
+	}
+
+	// Check for binary framing override
+	if binaryFraming := os.Getenv("MSM_USE_BINARY_FRAMING"); binaryFraming == "true" || binaryFraming == "1" {
+		cfg.UseBinaryFraming = true
+	}
+
+	// Check for env override persistence
+	if persist := os.Getenv("MSM_PERSIST_ENV_OVERRIDES"); persist == "true" || persist == "1" {
+		cfg.PersistEnvOverrides = true
+	}
+
+	// Check for dry-run override
+	if dryRun := os.Getenv("MSM_DRY_RUN"); dryRun == "true" || dryRun == "1" {
+		cfg.DryRun = true
+	}
+
+	// Check for file browse override
+	if allowBrowse := os.Getenv("MSM_ALLOW_FILE_BROWSE"); allowBrowse == "true" || allowBrowse == "1" {
+		cfg.AllowFileBrowse = true
+	}
+
+	// Check for diagnostic commands disable override
+	if disableDiagnostics := os.Getenv("MSM_DISABLE_DIAGNOSTIC_COMMANDS"); disableDiagnostics == "true" || disableDiagnostics == "1" {
+		cfg.DisableDiagnosticCommands = true
+	}
+
+	// Check for system info disable override
+	if disableSystemInfo := os.Getenv("MSM_DISABLE_SYSTEM_INFO"); disableSystemInfo == "true" || disableSystemInfo == "1" {
+		cfg.DisableSystemInfo = true
+	}
+
+	// Check for notifications override
+	if allowNotifications := os.Getenv("MSM_ALLOW_NOTIFICATIONS"); allowNotifications == "true" || allowNotifications == "1" {
+		cfg.AllowNotifications = true
+	}
+	if debugRuntime := os.Getenv("MSM_DEBUG_RUNTIME_STATS"); debugRuntime == "true" || debugRuntime == "1" {
+		cfg.DebugRuntimeStats = true
+	}
+
+	// Check for management listener overrides
+	if bindAddress := os.Getenv("MSM_MANAGEMENT_BIND_ADDRESS"); bindAddress != "" {
+		cfg.ManagementBindAddress = bindAddress
+	}
+	if allowRemote := os.Getenv("MSM_ALLOW_REMOTE_MANAGEMENT"); allowRemote == "true" || allowRemote == "1" {
+		cfg.AllowRemoteManagement = true
+	}
+	if controlPort := os.Getenv("MSM_CONTROL_TCP_PORT"); controlPort != "" {
+		if port, err := strconv.Atoi(controlPort); err == nil {
+			cfg.ControlTCPPort = port
+		} else {
+			fmt.Printf("Warning: Invalid MSM_CONTROL_TCP_PORT value '%s', ignoring\n", controlPort)
+		}
+	}
+
+	// Check for remote logging overrides
+	if enableRemote := os.Getenv("MSM_ENABLE_REMOTE_LOGGING"); enableRemote == "true" || enableRemote == "1" {
+		cfg.EnableRemoteLogging = true
+	}
+	if remoteLevel := os.Getenv("MSM_REMOTE_LOG_LEVEL"); remoteLevel != "" {
+		cfg.RemoteLogLevel = remoteLevel
+	}
+
+	// Check for connectivity check disable override
+	if disableConnectivity := os.Getenv("MSM_DISABLE_CONNECTIVITY_CHECK"); disableConnectivity == "true" || disableConnectivity == "1" {
+		cfg.DisableConnectivityCheck = true
+	}
+
+	// Check for encryption algorithm override
+	if alg := os.Getenv("MSM_ENCRYPTION_ALGORITHM"); alg != "" {
+		if isValidEncryptionAlgorithm(alg) {
+			cfg.EncryptionAlgorithm = alg
+		} else {
+			fmt.Printf("Warning: Invalid MSM_ENCRYPTION_ALGORITHM value '%s', ignoring\n", alg)
+		}
+	}
+
+	// Check for payload compression threshold override
+	if compressOver := os.Getenv("MSM_COMPRESS_PAYLOADS_OVER_BYTES"); compressOver != "" {
+		if val, err := strconv.Atoi(compressOver); err == nil {
+			cfg.CompressPayloadsOverBytes = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_COMPRESS_PAYLOADS_OVER_BYTES value '%s', ignoring\n", compressOver)
+		}
+	}
+
+	// Check for network speed measurement override
+	if measureSpeed := os.Getenv("MSM_MEASURE_NETWORK_SPEED"); measureSpeed == "true" || measureSpeed == "1" {
+		cfg.MeasureNetworkSpeed = true
+	}
+
+	// Check for network identifier redaction override
+	if redact := os.Getenv("MSM_REDACT_NETWORK_IDENTIFIERS"); redact == "true" || redact == "1" {
+		cfg.RedactNetworkIdentifiers = true
+	}
+
+	// Check for status field selection override
+	if statusFields := os.Getenv("MSM_STATUS_FIELDS"); statusFields != "" {
+		cfg.StatusFields = nil
+		for _, field := range strings.Split(statusFields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				cfg.StatusFields = append(cfg.StatusFields, field)
+			}
+		}
+		warnUnknownStatusFields(cfg.StatusFields)
+	}
+
+	// Check for status payload size override
+	if maxPayloadSize := os.Getenv("MSM_MAX_STATUS_PAYLOAD_SIZE"); maxPayloadSize != "" {
+		if val, err := strconv.Atoi(maxPayloadSize); err == nil && val > 0 {
+			cfg.MaxStatusPayloadSize = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_MAX_STATUS_PAYLOAD_SIZE value '%s', ignoring\n", maxPayloadSize)
+		}
+	}
+
+	// Check for message size override
+	if maxMessageBytes := os.Getenv("MSM_MAX_MESSAGE_BYTES"); maxMessageBytes != "" {
+		if val, err := strconv.Atoi(maxMessageBytes); err == nil && val > 0 {
+			cfg.MaxMessageBytes = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_MAX_MESSAGE_BYTES value '%s', ignoring\n", maxMessageBytes)
+		}
+	}
+
+	// Check for malformed message threshold override
+	if threshold := os.Getenv("MSM_MALFORMED_MESSAGE_THRESHOLD"); threshold != "" {
+		if val, err := strconv.Atoi(threshold); err == nil && val > 0 {
+			cfg.MalformedMessageThreshold = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_MALFORMED_MESSAGE_THRESHOLD value '%s', ignoring\n", threshold)
+		}
+	}
+
+	// Check for send failure threshold override
+	if threshold := os.Getenv("MSM_SEND_FAILURE_THRESHOLD"); threshold != "" {
+		if val, err := strconv.Atoi(threshold); err == nil && val > 0 {
+			cfg.SendFailureThreshold = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_SEND_FAILURE_THRESHOLD value '%s', ignoring\n", threshold)
+		}
+	}
+
 	// Check for security settings overrides
 	if maxViolations := os.Getenv("MSM_MAX_IP_VIOLATIONS"); maxViolations != "" {
 		if val, err := strconv.Atoi(maxViolations); err == nil && val >= 0 {
@@ -237,13 +1089,35 @@ func (cfg *ClientConfig) ApplyEnvironmentOverrides() {
 	}
 
 	if blacklistDuration := os.Getenv("MSM_IP_BLACKLIST_DURATION"); blacklistDuration != "" {
-		if duration, err := time.ParseDuration(blacklistDuration); err == nil && duration >= 0 {
+		if duration, err := utils.ParseDurationExtended(blacklistDuration); err == nil && duration >= 0 {
 			cfg.IPBlacklistDuration = duration
 		} else {
 			fmt.Printf("Warning: Invalid MSM_IP_BLACKLIST_DURATION value '%s', ignoring\n", blacklistDuration)
 		}
 	}
 
+	if webhookURL := os.Getenv("MSM_PAIRING_WEBHOOK_URL"); webhookURL != "" {
+		if isValidWebhookURL(webhookURL) {
+			cfg.PairingWebhookURL = webhookURL
+		} else {
+			fmt.Printf("Warning: Invalid MSM_PAIRING_WEBHOOK_URL value '%s', ignoring\n", webhookURL)
+		}
+	}
+	if webhookSecret := os.Getenv("MSM_PAIRING_WEBHOOK_SECRET"); webhookSecret != "" {
+		cfg.PairingWebhookSecret = webhookSecret
+	}
+	if adminToken := os.Getenv("MSM_DISPLAY_ADMIN_TOKEN"); adminToken != "" {
+		cfg.DisplayAdminToken = adminToken
+	}
+
+	if violationWindow := os.Getenv("MSM_IP_VIOLATION_WINDOW"); violationWindow != "" {
+		if duration, err := utils.ParseDurationExtended(violationWindow); err == nil && duration >= 0 {
+			cfg.IPViolationWindow = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_IP_VIOLATION_WINDOW value '%s', ignoring\n", violationWindow)
+		}
+	}
+
 	// Check for verification code settings overrides
 	if codeLength := os.Getenv("MSM_VERIFICATION_CODE_LENGTH"); codeLength != "" {
 		if val, err := strconv.Atoi(codeLength); err == nil && val > 0 {
@@ -261,19 +1135,91 @@ func (cfg *ClientConfig) ApplyEnvironmentOverrides() {
 		}
 	}
 
+	if codeType := os.Getenv("MSM_PAIRING_CODE_TYPE"); codeType != "" {
+		if isValidPairingCodeType(codeType) {
+			cfg.PairingCodeType = codeType
+		} else {
+			fmt.Printf("Warning: Invalid MSM_PAIRING_CODE_TYPE value '%s', ignoring\n", codeType)
+		}
+	}
+
+	if numericLength := os.Getenv("MSM_NUMERIC_CODE_LENGTH"); numericLength != "" {
+		if val, err := strconv.Atoi(numericLength); err == nil && val > 0 {
+			cfg.NumericCodeLength = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_NUMERIC_CODE_LENGTH value '%s', ignoring\n", numericLength)
+		}
+	}
+
 	// Check for pairing code expiration override
 	if codeExpiration := os.Getenv("MSM_PAIRING_CODE_EXPIRATION"); codeExpiration != "" {
-		if duration, err := time.ParseDuration(codeExpiration); err == nil && duration > 0 {
+		if duration, err := utils.ParseDurationExtended(codeExpiration); err == nil && duration > 0 {
 			cfg.PairingCodeExpiration = duration
 		} else {
 			fmt.Printf("Warning: Invalid MSM_PAIRING_CODE_EXPIRATION value '%s', ignoring\n", codeExpiration)
 		}
 	}
 
+	// Check for pairing confirm grace override
+	if confirmGrace := os.Getenv("MSM_PAIRING_CONFIRM_GRACE"); confirmGrace != "" {
+		if duration, err := utils.ParseDurationExtended(confirmGrace); err == nil {
+			cfg.PairingConfirmGrace = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_PAIRING_CONFIRM_GRACE value '%s', ignoring\n", confirmGrace)
+		}
+	}
+
+	if grace := os.Getenv("MSM_STATE_MISSING_GRACE_PERIOD"); grace != "" {
+		if duration, err := utils.ParseDurationExtended(grace); err == nil {
+			cfg.StateMissingGracePeriod = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_STATE_MISSING_GRACE_PERIOD value '%s', ignoring\n", grace)
+		}
+	}
+
+	if retry := os.Getenv("MSM_PAIRING_RETRY_ON_DISCONNECT"); retry != "" {
+		if val, err := strconv.ParseBool(retry); err == nil {
+			cfg.PairingRetryOnDisconnect = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_PAIRING_RETRY_ON_DISCONNECT value '%s', ignoring\n", retry)
+		}
+	}
+
+	if backoffDelay := os.Getenv("MSM_PAIRING_BACKOFF_DELAY"); backoffDelay != "" {
+		if duration, err := utils.ParseDurationExtended(backoffDelay); err == nil && duration > 0 {
+			cfg.PairingBackoffDelay = duration
+		} else {
+			fmt.Printf("Warning: Invalid MSM_PAIRING_BACKOFF_DELAY value '%s', ignoring\n", backoffDelay)
+		}
+	}
+
 	// Check for screen switch path override
 	if screenSwitchPath := os.Getenv("MSM_SCREEN_SWITCH_PATH"); screenSwitchPath != "" {
 		cfg.ScreenSwitchPath = screenSwitchPath
 	}
+
+	// Power command overrides, split on whitespace
+	if rebootCommand := strings.Fields(os.Getenv("MSM_REBOOT_COMMAND")); len(rebootCommand) > 0 {
+		cfg.RebootCommand = rebootCommand
+	}
+	if shutdownCommand := strings.Fields(os.Getenv("MSM_SHUTDOWN_COMMAND")); len(shutdownCommand) > 0 {
+		cfg.ShutdownCommand = shutdownCommand
+	}
+
+	if onConnect := os.Getenv("MSM_ON_CONNECT_SCRIPT_PATH"); onConnect != "" {
+		cfg.OnConnectScriptPath = onConnect
+	}
+	if onDisconnect := os.Getenv("MSM_ON_DISCONNECT_SCRIPT_PATH"); onDisconnect != "" {
+		cfg.OnDisconnectScriptPath = onDisconnect
+	}
+
+	if restore := os.Getenv("MSM_RESTORE_DISPLAY_ORIENTATIONS_ON_START"); restore != "" {
+		if val, err := strconv.ParseBool(restore); err == nil {
+			cfg.RestoreDisplayOrientationsOnStart = val
+		} else {
+			fmt.Printf("Warning: Invalid MSM_RESTORE_DISPLAY_ORIENTATIONS_ON_START value '%s', ignoring\n", restore)
+		}
+	}
 }
 
 // SetStrictIPValidation configures strict IP validation mode
@@ -311,6 +1257,148 @@ func (cfg *ClientConfig) GetStatusUpdateInterval() time.Duration {
 	return cfg.StatusUpdateInterval
 }
 
+// GetMaxStatusPayloadSize returns the max status payload size with default fallback
+func (cfg *ClientConfig) GetMaxStatusPayloadSize() int {
+	if cfg.MaxStatusPayloadSize <= 0 {
+		return defaultConfig.MaxStatusPayloadSize
+	}
+	return cfg.MaxStatusPayloadSize
+}
+
+// GetMaxMessageBytes returns the max single-message size with default fallback
+func (cfg *ClientConfig) GetMaxMessageBytes() int {
+	if cfg.MaxMessageBytes <= 0 {
+		return defaultConfig.MaxMessageBytes
+	}
+	return cfg.MaxMessageBytes
+}
+
+// GetRebootCommand returns the reboot argv with default fallback
+func (cfg *ClientConfig) GetRebootCommand() []string {
+	if len(cfg.RebootCommand) == 0 {
+		return defaultConfig.RebootCommand
+	}
+	return cfg.RebootCommand
+}
+
+// GetShutdownCommand returns the shutdown argv with default fallback
+func (cfg *ClientConfig) GetShutdownCommand() []string {
+	if len(cfg.ShutdownCommand) == 0 {
+		return defaultConfig.ShutdownCommand
+	}
+	return cfg.ShutdownCommand
+}
+
+// GetMaxCommandAge returns how old a command timestamp may be, 0 when the check is disabled
+func (cfg *ClientConfig) GetMaxCommandAge() time.Duration {
+	if cfg.MaxCommandAgeSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.MaxCommandAgeSeconds) * time.Second
+}
+
+// GetMaxCommandFuture returns how far ahead a command timestamp may be, 0 when the check is disabled
+func (cfg *ClientConfig) GetMaxCommandFuture() time.Duration {
+	if cfg.MaxCommandFutureSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.MaxCommandFutureSeconds) * time.Second
+}
+
+// GetWatchdogInterval returns the watchdog check interval with default fallback;
+// 0 means the watchdog is disabled
+func (cfg *ClientConfig) GetWatchdogInterval() time.Duration {
+	if cfg.WatchdogInterval < 0 {
+		return 0
+	}
+	if cfg.WatchdogInterval == 0 {
+		return defaultConfig.WatchdogInterval
+	}
+	return cfg.WatchdogInterval
+}
+
+// WatchdogCheckEnabled reports whether the watchdog runs the named check
+func (cfg *ClientConfig) WatchdogCheckEnabled(name string) bool {
+	return !slices.Contains(cfg.WatchdogDisabledChecks, name)
+}
+
+// GetCommandTimeout returns the expected max run time of an external command with default fallback
+func (cfg *ClientConfig) GetCommandTimeout() time.Duration {
+	if cfg.CommandTimeout <= 0 {
+		return defaultConfig.CommandTimeout
+	}
+	return cfg.CommandTimeout
+}
+
+// GetClientPingMaxMissed returns the unanswered client ping limit with default fallback
+func (cfg *ClientConfig) GetClientPingMaxMissed() int {
+	if cfg.ClientPingMaxMissed <= 0 {
+		return defaultConfig.ClientPingMaxMissed
+	}
+	return cfg.ClientPingMaxMissed
+}
+
+// GetMalformedMessageThreshold returns the consecutive undecodable message limit with default fallback
+func (cfg *ClientConfig) GetMalformedMessageThreshold() int {
+	if cfg.MalformedMessageThreshold <= 0 {
+		return defaultConfig.MalformedMessageThreshold
+	}
+	return cfg.MalformedMessageThreshold
+}
+
+// GetSendFailureThreshold returns the consecutive send failure limit with default fallback
+func (cfg *ClientConfig) GetSendFailureThreshold() int {
+	if cfg.SendFailureThreshold <= 0 {
+		return defaultConfig.SendFailureThreshold
+	}
+	return cfg.SendFailureThreshold
+}
+
+// GetStatusFields returns the status fields to send, or nil when all fields are selected
+func (cfg *ClientConfig) GetStatusFields() []string {
+	if len(cfg.StatusFields) == 0 || slices.Contains(cfg.StatusFields, StatusFieldsAll) {
+		return nil
+	}
+	return cfg.StatusFields
+}
+
+// isValidEncryptionAlgorithm reports whether alg is a supported encryption algorithm
+func isValidEncryptionAlgorithm(alg string) bool {
+	switch alg {
+	case "aes-cbc", "aes-gcm", "chacha20-poly1305":
+		return true
+	}
+	return false
+}
+
+// GetEncryptionAlgorithm returns the preferred encryption algorithm with default fallback
+func (cfg *ClientConfig) GetEncryptionAlgorithm() string {
+	if !isValidEncryptionAlgorithm(cfg.EncryptionAlgorithm) {
+		return defaultConfig.EncryptionAlgorithm
+	}
+	return cfg.EncryptionAlgorithm
+}
+
+// GetCompressPayloadsOverBytes returns the payload compression threshold with default fallback.
+// Returns 0 when compression is disabled.
+func (cfg *ClientConfig) GetCompressPayloadsOverBytes() int {
+	if cfg.CompressPayloadsOverBytes < 0 {
+		return 0
+	}
+	if cfg.CompressPayloadsOverBytes == 0 {
+		return defaultConfig.CompressPayloadsOverBytes
+	}
+	return cfg.CompressPayloadsOverBytes
+}
+
+// GetCloseTimeout returns the WebSocket close timeout with default fallback
+func (cfg *ClientConfig) GetCloseTimeout() time.Duration {
+	if cfg.CloseTimeout <= 0 {
+		return defaultConfig.CloseTimeout
+	}
+	return cfg.CloseTimeout
+}
+
 // GetIPValidationMode returns a string describing the current IP validation mode
 func (cfg *ClientConfig) GetIPValidationMode() string {
 	if cfg.DisableIPValidation {
@@ -341,6 +1429,51 @@ func (cfg *ClientConfig) GetIPBlacklistDuration() time.Duration {
 	return cfg.IPBlacklistDuration
 }
 
+// isValidWebhookURL reports whether rawURL is an absolute http or https URL
+func isValidWebhookURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// GetIPViolationWindow returns how long violations are remembered, the blacklist duration by default
+func (cfg *ClientConfig) GetIPViolationWindow() time.Duration {
+	if cfg.IPViolationWindow <= 0 {
+		return cfg.GetIPBlacklistDuration()
+	}
+	return cfg.IPViolationWindow
+}
+
+// Pairing code types
+const (
+	PairingCodeAlphanumeric = "alphanumeric" // Uppercase letters and digits
+	PairingCodeNumeric      = "numeric"      // Digits only
+)
+
+// isValidPairingCodeType reports whether codeType is a supported pairing code type
+func isValidPairingCodeType(codeType string) bool {
+	return codeType == PairingCodeAlphanumeric || codeType == PairingCodeNumeric
+}
+
+// GetPairingCodeType returns the pairing code type with default fallback
+func (cfg *ClientConfig) GetPairingCodeType() string {
+	if !isValidPairingCodeType(cfg.PairingCodeType) {
+		return defaultConfig.PairingCodeType
+	}
+	return cfg.PairingCodeType
+}
+
+// GetPairingCodeLength returns the length of generated pairing codes: the
+// numeric code length for numeric codes, the verification code length otherwise
+func (cfg *ClientConfig) GetPairingCodeLength() int {
+	if cfg.GetPairingCodeType() == PairingCodeNumeric {
+		if cfg.NumericCodeLength <= 0 {
+			return defaultConfig.NumericCodeLength
+		}
+		return cfg.NumericCodeLength
+	}
+	return cfg.GetVerificationCodeLength()
+}
+
 // GetVerificationCodeLength returns the verification code length with default fallback
 func (cfg *ClientConfig) GetVerificationCodeLength() int {
 	if cfg.VerificationCodeLength <= 0 {
@@ -365,6 +1498,106 @@ func (cfg *ClientConfig) GetPairingCodeExpiration() time.Duration {
 	return cfg.PairingCodeExpiration
 }
 
+// GetPairingConfirmGrace returns how long a successful confirm is replayed, 0 when disabled
+func (cfg *ClientConfig) GetPairingConfirmGrace() time.Duration {
+	if cfg.PairingConfirmGrace < 0 {
+		return 0
+	}
+	if cfg.PairingConfirmGrace == 0 {
+		return defaultConfig.PairingConfirmGrace
+	}
+	return cfg.PairingConfirmGrace
+}
+
+// GetStateMissingGracePeriod returns how long a vanished state file is waited for, 0 when disabled
+func (cfg *ClientConfig) GetStateMissingGracePeriod() time.Duration {
+	if cfg.StateMissingGracePeriod < 0 {
+		return 0
+	}
+	if cfg.StateMissingGracePeriod == 0 {
+		return defaultConfig.StateMissingGracePeriod
+	}
+	return cfg.StateMissingGracePeriod
+}
+
+// GetPairingBackoffDelay returns how long the last server is probed before pairing restarts, with default fallback
+func (cfg *ClientConfig) GetPairingBackoffDelay() time.Duration {
+	if cfg.PairingBackoffDelay <= 0 {
+		return defaultConfig.PairingBackoffDelay
+	}
+	return cfg.PairingBackoffDelay
+}
+
+// GetRemoteLogLevel returns the minimum level of forwarded log entries with default fallback
+func (cfg *ClientConfig) GetRemoteLogLevel() string {
+	if !isValidLogLevel(cfg.RemoteLogLevel) {
+		return defaultConfig.RemoteLogLevel
+	}
+	return cfg.RemoteLogLevel
+}
+
+// GetManagementBindAddress returns the IP management listeners bind to, loopback by default
+func (cfg *ClientConfig) GetManagementBindAddress() string {
+	if !managementBindAllowed(cfg.ManagementBindAddress, cfg.AllowRemoteManagement) {
+		return defaultConfig.ManagementBindAddress
+	}
+	return cfg.ManagementBindAddress
+}
+
+// ManagementListenAddress returns the host:port a management listener on port binds to
+func (cfg *ClientConfig) ManagementListenAddress(port int) string {
+	return net.JoinHostPort(cfg.GetManagementBindAddress(), strconv.Itoa(port))
+}
+
+// GetPairingBindAttempts returns the bind attempts per pairing port with default fallback
+func (cfg *ClientConfig) GetPairingBindAttempts() int {
+	if cfg.PairingBindAttempts <= 0 {
+		return defaultConfig.PairingBindAttempts
+	}
+	return cfg.PairingBindAttempts
+}
+
+// GetPairingMaxBodyBytes returns the pairing server request body limit with default fallback
+func (cfg *ClientConfig) GetPairingMaxBodyBytes() int {
+	if cfg.PairingMaxBodyBytes <= 0 {
+		return defaultConfig.PairingMaxBodyBytes
+	}
+	return cfg.PairingMaxBodyBytes
+}
+
+// GetMinAvailableMemoryBytes returns the pairing load shedding threshold with default fallback
+func (cfg *ClientConfig) GetMinAvailableMemoryBytes() uint64 {
+	if cfg.MinAvailableMemoryBytes == 0 {
+		return defaultConfig.MinAvailableMemoryBytes
+	}
+	return cfg.MinAvailableMemoryBytes
+}
+
+// GetMaxDeviceNameLength returns the device name length limit with default fallback
+func (cfg *ClientConfig) GetMaxDeviceNameLength() int {
+	if cfg.MaxDeviceNameLength <= 0 {
+		return defaultConfig.MaxDeviceNameLength
+	}
+	return cfg.MaxDeviceNameLength
+}
+
+// GetEnvAllowlist returns the environment variables passed to commands with default fallback
+func (p *ExecPolicy) GetEnvAllowlist() []string {
+	if p.EnvAllowlist == nil {
+		return defaultExecEnvAllowlist
+	}
+	return p.EnvAllowlist
+}
+
+// IsValidDisplayAngle reports whether angle is a supported display rotation in degrees
+func IsValidDisplayAngle(angle int) bool {
+	switch angle {
+	case 0, 90, 180, 270:
+		return true
+	}
+	return false
+}
+
 // GetScreenSwitchPath returns the screen switch path with default fallback
 func (cfg *ClientConfig) GetScreenSwitchPath() string {
 	if cfg.ScreenSwitchPath == "" {
@@ -372,3 +1605,32 @@ func (cfg *ClientConfig) GetScreenSwitchPath() string {
 	}
 	return cfg.ScreenSwitchPath
 }
+
+// GetScreenWatchInterval returns the active screen polling interval with default fallback
+func (cfg *ClientConfig) GetScreenWatchInterval() time.Duration {
+	if cfg.ScreenWatchInterval <= 0 {
+		return defaultConfig.ScreenWatchInterval
+	}
+	return cfg.ScreenWatchInterval
+}
+
+// GetScriptTimeout returns the lifecycle script timeout with default fallback
+func (cfg *ClientConfig) GetScriptTimeout() time.Duration {
+	if cfg.ScriptTimeout <= 0 {
+		return defaultConfig.ScriptTimeout
+	}
+	return cfg.ScriptTimeout
+}
+
+// GetUpdateScriptPath returns the update script path with default fallback
+func (cfg *ClientConfig) GetUpdateScriptPath() string {
+	if cfg.UpdateScriptPath == "" {
+		return defaultConfig.UpdateScriptPath
+	}
+	return cfg.UpdateScriptPath
+}
+
+// IsAutoUpdateEnabled returns true if auto-update is both requested and allowed
+func (cfg *ClientConfig) IsAutoUpdateEnabled() bool {
+	return cfg.AutoUpdate && cfg.AllowAutoUpdate
+}
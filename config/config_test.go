@@ -1,8 +1,11 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -115,6 +118,30 @@ func TestSecuritySettings(t *testing.T) {
 		t.Errorf("Expected blacklist duration of 2 hours, got %v", cfg.GetIPBlacklistDuration())
 	}
 
+	if cfg.GetPairingCodeLength() != 8 {
+		t.Errorf("Expected alphanumeric codes to use the verification code length 8, got %d", cfg.GetPairingCodeLength())
+	}
+	cfg.PairingCodeType = PairingCodeNumeric
+	if cfg.GetPairingCodeLength() != 8 {
+		t.Errorf("Expected default numeric code length of 8, got %d", cfg.GetPairingCodeLength())
+	}
+	cfg.NumericCodeLength = 10
+	if cfg.GetPairingCodeLength() != 10 {
+		t.Errorf("Expected numeric code length of 10, got %d", cfg.GetPairingCodeLength())
+	}
+	cfg.PairingCodeType = "hex"
+	if cfg.GetPairingCodeType() != PairingCodeAlphanumeric {
+		t.Errorf("Expected unknown code type to fall back to alphanumeric, got %q", cfg.GetPairingCodeType())
+	}
+
+	if cfg.GetIPViolationWindow() != 2*time.Hour {
+		t.Errorf("Expected violation window to follow the blacklist duration, got %v", cfg.GetIPViolationWindow())
+	}
+	cfg.IPViolationWindow = 10 * time.Minute
+	if cfg.GetIPViolationWindow() != 10*time.Minute {
+		t.Errorf("Expected violation window of 10 minutes, got %v", cfg.GetIPViolationWindow())
+	}
+
 	if cfg.GetVerificationCodeLength() != 8 {
 		t.Errorf("Expected code length of 8, got %d", cfg.GetVerificationCodeLength())
 	}
@@ -243,6 +270,17 @@ func TestAutoCorrection(t *testing.T) {
 	if correctedCfg.VerificationCodeAttempts != 3 {
 		t.Errorf("Expected auto-corrected VerificationCodeAttempts to be 3, got %d", correctedCfg.VerificationCodeAttempts)
 	}
+
+	// Test PairingWebhookURL correction
+	cfg.PairingWebhookURL = "ftp://hooks.example/pairing" // Invalid
+	correctedCfg, err = ValidateConfig(cfg)
+	if err != nil {
+		t.Errorf("Expected validation to succeed with auto-correction, got: %v", err)
+	}
+
+	if correctedCfg.PairingWebhookURL != "" {
+		t.Errorf("Expected invalid PairingWebhookURL to be cleared, got %s", correctedCfg.PairingWebhookURL)
+	}
 }
 
 func TestStatusUpdateInterval(t *testing.T) {
@@ -301,6 +339,161 @@ func TestScreenSwitchPath(t *testing.T) {
 	}
 }
 
+func TestScriptTimeout(t *testing.T) {
+	var cfg ClientConfig
+	if timeout := cfg.GetScriptTimeout(); timeout != 30*time.Second {
+		t.Errorf("Expected default script timeout of 30s, got %v", timeout)
+	}
+
+	cfg.ScriptTimeout = 5 * time.Second
+	if timeout := cfg.GetScriptTimeout(); timeout != 5*time.Second {
+		t.Errorf("Expected script timeout of 5s, got %v", timeout)
+	}
+
+	cfg.ScriptTimeout = -1
+	validated, err := ValidateConfig(cfg)
+	if err != nil {
+		t.Fatalf("ValidateConfig failed: %v", err)
+	}
+	if validated.ScriptTimeout != 30*time.Second {
+		t.Errorf("Expected invalid script timeout to be corrected to 30s, got %v", validated.ScriptTimeout)
+	}
+}
+
+func TestStatusFields(t *testing.T) {
+	var cfg ClientConfig
+	if fields := cfg.GetStatusFields(); fields != nil {
+		t.Errorf("Expected all status fields by default, got %v", fields)
+	}
+
+	validated, err := ValidateConfig(cfg)
+	if err != nil {
+		t.Fatalf("ValidateConfig failed: %v", err)
+	}
+	if len(validated.StatusFields) != 1 || validated.StatusFields[0] != StatusFieldsAll {
+		t.Errorf("Expected status_fields to default to [all], got %v", validated.StatusFields)
+	}
+
+	cfg.StatusFields = []string{"uptime", StatusFieldsAll}
+	if fields := cfg.GetStatusFields(); fields != nil {
+		t.Errorf("Expected \"all\" to select every field, got %v", fields)
+	}
+
+	t.Setenv("MSM_STATUS_FIELDS", "uptime, current_screen")
+	cfg.ApplyEnvironmentOverrides()
+	fields := cfg.GetStatusFields()
+	if len(fields) != 2 || fields[0] != "uptime" || fields[1] != "current_screen" {
+		t.Errorf("Expected [uptime current_screen] from MSM_STATUS_FIELDS, got %v", fields)
+	}
+}
+
+func TestWireAliases(t *testing.T) {
+	validated, err := ValidateConfig(ClientConfig{})
+	if err != nil {
+		t.Fatalf("ValidateConfig failed: %v", err)
+	}
+	if validated.MessageTypeAliases != nil || validated.StatusFieldAliases != nil {
+		t.Errorf("Expected no aliases by default, got %v and %v", validated.MessageTypeAliases, validated.StatusFieldAliases)
+	}
+
+	validated, err = ValidateConfig(ClientConfig{
+		MessageTypeAliases: map[string]string{
+			"status":  "heartbeat",
+			"error":   "heartbeat", // Used twice
+			"pong":    "command",   // Another message type
+			"unknown": "whatever",
+			"ping":    "ping",
+		},
+		StatusFieldAliases: map[string]string{
+			"clientId": "deviceId",
+			"uptime":   "type",      // Reserved
+			"mode":     "timestamp", // Another status field
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateConfig failed: %v", err)
+	}
+	if len(validated.MessageTypeAliases) != 1 || validated.MessageTypeAliases["error"] != "heartbeat" {
+		t.Errorf("Expected only the first use of an alias kept, got %v", validated.MessageTypeAliases)
+	}
+	if len(validated.StatusFieldAliases) != 1 || validated.StatusFieldAliases["clientId"] != "deviceId" {
+		t.Errorf("Expected only clientId -> deviceId kept, got %v", validated.StatusFieldAliases)
+	}
+}
+
+func TestFieldLengthLimits(t *testing.T) {
+	base := ClientConfig{ClientID: "550e8400-e29b-41d4-a716-446655440000"}
+
+	t.Run("Device name truncated to default limit", func(t *testing.T) {
+		cfg := base
+		cfg.DeviceName = strings.Repeat("a", 200)
+
+		correctedCfg, err := ValidateConfig(cfg)
+		if err != nil {
+			t.Fatalf("Expected overlong device name to be truncated, got error: %v", err)
+		}
+		if len(correctedCfg.DeviceName) != 128 {
+			t.Errorf("Expected device name of 128 characters, got %d", len(correctedCfg.DeviceName))
+		}
+	})
+
+	t.Run("Device name within limit unchanged", func(t *testing.T) {
+		cfg := base
+		cfg.DeviceName = "  lobby-screen  "
+
+		correctedCfg, _ := ValidateConfig(cfg)
+		if correctedCfg.DeviceName != "lobby-screen" {
+			t.Errorf("Expected trimmed device name 'lobby-screen', got '%s'", correctedCfg.DeviceName)
+		}
+	})
+
+	t.Run("Configurable device name limit", func(t *testing.T) {
+		cfg := base
+		cfg.MaxDeviceNameLength = 10
+		cfg.DeviceName = "abcdefghijklmnop"
+
+		correctedCfg, _ := ValidateConfig(cfg)
+		if correctedCfg.DeviceName != "abcdefghij" {
+			t.Errorf("Expected device name truncated to 'abcdefghij', got '%s'", correctedCfg.DeviceName)
+		}
+	})
+
+	t.Run("Multi-byte device name truncated on character boundary", func(t *testing.T) {
+		cfg := base
+		cfg.MaxDeviceNameLength = 3
+		cfg.DeviceName = "ééééé"
+
+		correctedCfg, _ := ValidateConfig(cfg)
+		if correctedCfg.DeviceName != "ééé" {
+			t.Errorf("Expected device name 'ééé', got '%s'", correctedCfg.DeviceName)
+		}
+	})
+
+	t.Run("Invalid device name limit uses default", func(t *testing.T) {
+		cfg := base
+		cfg.MaxDeviceNameLength = -1
+
+		correctedCfg, _ := ValidateConfig(cfg)
+		if correctedCfg.MaxDeviceNameLength != 128 {
+			t.Errorf("Expected MaxDeviceNameLength 128, got %d", correctedCfg.MaxDeviceNameLength)
+		}
+	})
+
+	t.Run("Script paths truncated", func(t *testing.T) {
+		cfg := base
+		cfg.ScreenSwitchPath = "/" + strings.Repeat("s", 600)
+		cfg.UpdateScriptPath = "/" + strings.Repeat("u", 600)
+
+		correctedCfg, _ := ValidateConfig(cfg)
+		if len(correctedCfg.ScreenSwitchPath) != 512 {
+			t.Errorf("Expected screen switch path of 512 characters, got %d", len(correctedCfg.ScreenSwitchPath))
+		}
+		if len(correctedCfg.UpdateScriptPath) != 512 {
+			t.Errorf("Expected update script path of 512 characters, got %d", len(correctedCfg.UpdateScriptPath))
+		}
+	})
+}
+
 func TestEnvironmentOverrides(t *testing.T) {
 	// Save original environment variables
 	originalStatusInterval := os.Getenv("MSM_STATUS_UPDATE_INTERVAL")
@@ -342,6 +535,21 @@ func TestEnvironmentOverrides(t *testing.T) {
 	}
 }
 
+func TestEnvironmentOverridesExtendedDuration(t *testing.T) {
+	t.Setenv("MSM_IP_BLACKLIST_DURATION", "7d")
+	t.Setenv("MSM_PAIRING_CODE_EXPIRATION", "1d12h")
+
+	cfg := ClientConfig{ClientID: "test-client"}
+	cfg.ApplyEnvironmentOverrides()
+
+	if cfg.IPBlacklistDuration != 7*24*time.Hour {
+		t.Errorf("Expected IP blacklist duration of 7 days, got %v", cfg.IPBlacklistDuration)
+	}
+	if cfg.PairingCodeExpiration != 36*time.Hour {
+		t.Errorf("Expected pairing code expiration of 36h, got %v", cfg.PairingCodeExpiration)
+	}
+}
+
 func TestJSONSerialization(t *testing.T) {
 	// Test that status_update_interval is properly serialized/deserialized
 	cfg := ClientConfig{
@@ -383,3 +591,362 @@ func TestJSONSerialization(t *testing.T) {
 		t.Errorf("Expected disable commands to be %v after unmarshaling, got %v", cfg.DisableCommands, newCfg.DisableCommands)
 	}
 }
+
+func TestPairingBindAttempts(t *testing.T) {
+	var cfg ClientConfig
+	if attempts := cfg.GetPairingBindAttempts(); attempts != 5 {
+		t.Errorf("Expected 5 bind attempts by default, got %d", attempts)
+	}
+
+	cfg.PairingBindAttempts = 2
+	if attempts := cfg.GetPairingBindAttempts(); attempts != 2 {
+		t.Errorf("Expected 2 bind attempts, got %d", attempts)
+	}
+}
+
+func TestManagementBindAddress(t *testing.T) {
+	cfg, err := ValidateConfig(ClientConfig{})
+	if err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	if cfg.ManagementBindAddress != "127.0.0.1" {
+		t.Errorf("Expected loopback by default, got %q", cfg.ManagementBindAddress)
+	}
+
+	tests := []struct {
+		address     string
+		allowRemote bool
+		expected    string
+	}{
+		{"0.0.0.0", false, "127.0.0.1"},
+		{"::", false, "127.0.0.1"},
+		{"0.0.0.0", true, "0.0.0.0"},
+		{"192.168.1.10", false, "192.168.1.10"},
+		{"not-an-ip", true, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		cfg, err := ValidateConfig(ClientConfig{ManagementBindAddress: tt.address, AllowRemoteManagement: tt.allowRemote})
+		if err != nil {
+			t.Fatalf("Validation failed: %v", err)
+		}
+		if cfg.ManagementBindAddress != tt.expected {
+			t.Errorf("%q (allow remote %v): expected %q, got %q", tt.address, tt.allowRemote, tt.expected, cfg.ManagementBindAddress)
+		}
+	}
+
+	unvalidated := ClientConfig{ManagementBindAddress: "0.0.0.0"}
+	if addr := unvalidated.ManagementListenAddress(9000); addr != "127.0.0.1:9000" {
+		t.Errorf("Expected listen address 127.0.0.1:9000 without remote management, got %s", addr)
+	}
+}
+
+func TestDisplayOrientationsPersist(t *testing.T) {
+	t.Setenv("MSC_CONFIG_PATH", t.TempDir())
+
+	cfg, err := LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	if !cfg.RestoreDisplayOrientationsOnStart {
+		t.Error("Expected orientations to be restored on start by default")
+	}
+
+	cfg.DisplayOrientations = map[string]int{"1": 270, "2": 45}
+	cfg.RestoreDisplayOrientationsOnStart = false
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	loaded, err := LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.DisplayOrientations["1"] != 270 {
+		t.Errorf("Expected saved orientation 270 for screen 1, got %v", loaded.DisplayOrientations)
+	}
+	if _, ok := loaded.DisplayOrientations["2"]; ok {
+		t.Error("Invalid orientation should be dropped")
+	}
+	if loaded.RestoreDisplayOrientationsOnStart {
+		t.Error("An explicit false should survive reloading")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"device_name":"Lobby","status_update_interval":10000000000}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.DeviceName != "Lobby" || cfg.StatusUpdateInterval != 10*time.Second {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if !cfg.RestoreDisplayOrientationsOnStart || !cfg.PairingRetryOnDisconnect {
+		t.Error("Settings that default to true should be preset")
+	}
+
+	if _, err := ParseConfig([]byte(`{"device_nmae":"Lobby"}`)); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if _, err := ParseConfig([]byte(`{"status_update_interval":"soon"}`)); err == nil {
+		t.Error("Expected an error for a mistyped field")
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	from := ClientConfig{ClientID: "a", DeviceName: "Lobby", ControlTCPPort: 9000}
+	to := from
+	to.DeviceName = "Foyer"
+	to.ControlTCPPort = 0
+	to.StatusFields = []string{"uptime"}
+
+	changed := ChangedFields(from, to)
+	expected := []string{"control_tcp_port", "device_name", "status_fields"}
+	if strings.Join(changed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, changed)
+	}
+	if len(ChangedFields(from, from)) != 0 {
+		t.Error("Expected no changes between identical configs")
+	}
+	if !RequiresRestart("control_tcp_port") || RequiresRestart("device_name") {
+		t.Error("Unexpected restart requirement")
+	}
+}
+
+func TestKeepProtectedFields(t *testing.T) {
+	local := ClientConfig{
+		DisableCommands:    true,
+		RebootCommand:      []string{"systemctl", "reboot"},
+		AllowIPSubnetMatch: true,
+	}
+
+	protected := []struct {
+		field string
+		push  func(cfg *ClientConfig)
+	}{
+		{"disable_commands", func(cfg *ClientConfig) { cfg.DisableCommands = false }},
+		{"disable_diagnostic_commands", func(cfg *ClientConfig) { cfg.DisableDiagnosticCommands = true }},
+		{"allow_file_browse", func(cfg *ClientConfig) { cfg.AllowFileBrowse = true }},
+		{"allowed_browse_paths", func(cfg *ClientConfig) { cfg.AllowedBrowsePaths = []string{"/"} }},
+		{"allowed_download_paths", func(cfg *ClientConfig) { cfg.AllowedDownloadPaths = []string{"/usr/bin"} }},
+		{"exec_policy", func(cfg *ClientConfig) { cfg.ExecPolicy.RunAsUser = "root" }},
+		{"screen_switch_path", func(cfg *ClientConfig) { cfg.ScreenSwitchPath = "/tmp/evil.sh" }},
+		{"reboot_command", func(cfg *ClientConfig) { cfg.RebootCommand = []string{"sh", "-c", "id"} }},
+		{"shutdown_command", func(cfg *ClientConfig) { cfg.ShutdownCommand = []string{"sh", "-c", "id"} }},
+		{"startup_script", func(cfg *ClientConfig) { cfg.StartupScript = "/tmp/evil.sh" }},
+		{"shutdown_script", func(cfg *ClientConfig) { cfg.ShutdownScript = "/tmp/evil.sh" }},
+		{"on_connect_script_path", func(cfg *ClientConfig) { cfg.OnConnectScriptPath = "/tmp/evil.sh" }},
+		{"on_disconnect_script_path", func(cfg *ClientConfig) { cfg.OnDisconnectScriptPath = "/tmp/evil.sh" }},
+		{"update_script_path", func(cfg *ClientConfig) { cfg.UpdateScriptPath = "/tmp/evil.sh" }},
+		{"allow_remote_management", func(cfg *ClientConfig) { cfg.AllowRemoteManagement = true }},
+		{"management_bind_address", func(cfg *ClientConfig) { cfg.ManagementBindAddress = "0.0.0.0" }},
+		{"control_tcp_port", func(cfg *ClientConfig) { cfg.ControlTCPPort = 9100 }},
+		{"display_admin_token", func(cfg *ClientConfig) { cfg.DisplayAdminToken = "pushed" }},
+		{"allow_auto_update", func(cfg *ClientConfig) { cfg.AllowAutoUpdate = true }},
+		{"pairing_webhook_url", func(cfg *ClientConfig) { cfg.PairingWebhookURL = "https://attacker.example/hook" }},
+		{"pairing_webhook_secret", func(cfg *ClientConfig) { cfg.PairingWebhookSecret = "pushed" }},
+		{"strict_ip_validation", func(cfg *ClientConfig) { cfg.StrictIPValidation = true }},
+		{"allow_ip_subnet_match", func(cfg *ClientConfig) { cfg.AllowIPSubnetMatch = false }},
+		{"disable_ip_validation", func(cfg *ClientConfig) { cfg.DisableIPValidation = true }},
+	}
+	for _, tt := range protected {
+		t.Run(tt.field, func(t *testing.T) {
+			pushed := local
+			pushed.DeviceName = "Foyer"
+			tt.push(&pushed)
+
+			kept := KeepProtectedFields(&pushed, local)
+			if len(kept) != 1 || kept[0] != tt.field {
+				t.Errorf("Expected [%s] to be kept, got %v", tt.field, kept)
+			}
+			if changed := ChangedFields(local, pushed); len(changed) != 1 || changed[0] != "device_name" {
+				t.Errorf("Expected only device_name to differ from the local config, got %v", changed)
+			}
+		})
+	}
+
+	// Auto update itself is an ordinary setting, gated by allow_auto_update
+	pushed := local
+	pushed.AutoUpdate = true
+	if kept := KeepProtectedFields(&pushed, local); len(kept) != 0 || !pushed.AutoUpdate {
+		t.Errorf("Expected auto_update to be pushable, got %v", kept)
+	}
+}
+
+func TestLoadOrCreateConfigLeavesUnchangedFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSC_CONFIG_PATH", dir)
+	configPath := filepath.Join(dir, configFile)
+
+	created, err := LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+
+	// Backdate the file so a rewrite shows up in its mtime
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(configPath, old, old); err != nil {
+		t.Fatalf("Failed to backdate config: %v", err)
+	}
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	assertUntouched := func(step string) {
+		t.Helper()
+		info, err := os.Stat(configPath)
+		if err != nil {
+			t.Fatalf("%s: failed to stat config: %v", step, err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("%s: expected the config file not to be rewritten, mtime changed to %v", step, info.ModTime())
+		}
+		if after, _ := os.ReadFile(configPath); !bytes.Equal(before, after) {
+			t.Errorf("%s: expected the config file content to be unchanged", step)
+		}
+	}
+
+	loaded, err := LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.ClientID != created.ClientID {
+		t.Errorf("Expected client ID %s, got %s", created.ClientID, loaded.ClientID)
+	}
+	assertUntouched("Second load")
+
+	// Env overrides apply without being written back by default
+	t.Setenv("MSM_STATUS_UPDATE_INTERVAL", "42s")
+	loaded, err = LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.StatusUpdateInterval != 42*time.Second {
+		t.Errorf("Expected the env override to apply, got %v", loaded.StatusUpdateInterval)
+	}
+	assertUntouched("Load with env override")
+
+	t.Setenv("MSM_PERSIST_ENV_OVERRIDES", "1")
+	if _, err := LoadOrCreateConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var saved ClientConfig
+	data, _ := os.ReadFile(configPath)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to decode saved config: %v", err)
+	}
+	if saved.StatusUpdateInterval != 42*time.Second || !saved.PersistEnvOverrides {
+		t.Errorf("Expected the env override to be persisted, got %v", saved.StatusUpdateInterval)
+	}
+}
+
+func TestLoadOrCreateConfigSavesCorrections(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSC_CONFIG_PATH", dir)
+	configPath := filepath.Join(dir, configFile)
+
+	if err := os.WriteFile(configPath, []byte(`{"client_id":"5b0c6f4e-3a53-4f0e-9b8e-2f1a4d7c9e10","verification_code_attempts":-2}`), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadOrCreateConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	var saved ClientConfig
+	data, _ := os.ReadFile(configPath)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to decode saved config: %v", err)
+	}
+	if saved.VerificationCodeAttempts != defaultConfig.VerificationCodeAttempts {
+		t.Errorf("Expected the corrected attempts to be saved, got %d", saved.VerificationCodeAttempts)
+	}
+}
+
+func TestOverridesNotPersisted(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSC_CONFIG_PATH", dir)
+	configPath := filepath.Join(dir, configFile)
+
+	SetRuntimeOverrides(func(cfg *ClientConfig) { cfg.DeviceName = "flag-name" })
+	t.Cleanup(func() { SetRuntimeOverrides(nil) })
+
+	t.Setenv("MSM_DISABLE_COMMANDS", "true")
+	cfg, err := LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	if !cfg.DisableCommands || cfg.DeviceName != "flag-name" {
+		t.Errorf("Expected the env and runtime overrides in the effective config, got %v and %q", cfg.DisableCommands, cfg.DeviceName)
+	}
+
+	// A save of the persisted config, as done for display orientations, keeps overrides out too
+	persisted, err := LoadPersistedConfig(cfg.ClientID)
+	if err != nil {
+		t.Fatalf("Failed to load persisted config: %v", err)
+	}
+	if persisted.DisableCommands || persisted.DeviceName == "flag-name" {
+		t.Errorf("Expected the persisted config without overrides, got %+v", persisted)
+	}
+	persisted.DisplayOrientations = map[string]int{"1": 90}
+	if err := SaveConfig(persisted); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "disable_commands") || strings.Contains(string(data), "flag-name") {
+		t.Errorf("Expected no override in the config file, got %s", data)
+	}
+
+	t.Setenv("MSM_DISABLE_COMMANDS", "")
+	SetRuntimeOverrides(nil)
+	cfg, err = LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.DisableCommands || cfg.DeviceName == "flag-name" {
+		t.Errorf("Expected the overrides to be gone on the next load, got %v and %q", cfg.DisableCommands, cfg.DeviceName)
+	}
+	if cfg.DisplayOrientations["1"] != 90 {
+		t.Errorf("Expected the saved orientation to survive, got %v", cfg.DisplayOrientations)
+	}
+}
+
+func TestPowerCommandValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  []string
+		expected []string
+	}{
+		{"Empty uses the default", nil, []string{"reboot"}},
+		{"Binary on PATH", []string{"systemctl", "reboot"}, []string{"systemctl", "reboot"}},
+		{"Absolute path", []string{"/sbin/reboot", "-f"}, []string{"/sbin/reboot", "-f"}},
+		{"Relative path", []string{"bin/reboot"}, []string{"reboot"}},
+		{"Blank binary", []string{" ", "reboot"}, []string{"reboot"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ValidateConfig(ClientConfig{RebootCommand: tt.command})
+			if err != nil {
+				t.Fatalf("ValidateConfig failed: %v", err)
+			}
+			if !slices.Equal(cfg.RebootCommand, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, cfg.RebootCommand)
+			}
+		})
+	}
+
+	t.Setenv("MSM_SHUTDOWN_COMMAND", "systemctl poweroff")
+	var cfg ClientConfig
+	cfg.ApplyEnvironmentOverrides()
+	if !slices.Equal(cfg.GetShutdownCommand(), []string{"systemctl", "poweroff"}) {
+		t.Errorf("Expected the env override to be split into argv, got %q", cfg.ShutdownCommand)
+	}
+
+	if _, err := ResolveCommand([]string{"/nonexistent/reboot"}); err == nil {
+		t.Error("Expected a missing binary not to resolve")
+	}
+	if _, err := ResolveCommand(nil); err == nil {
+		t.Error("Expected an empty command not to resolve")
+	}
+}
@@ -0,0 +1,366 @@
+package control
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"msm-client/utils"
+)
+
+const defaultSocketPath = "/run/msm-client/control.sock" // Default path for the control socket
+
+const defaultTCPEndpointPath = "/var/lib/msm-client/control-tcp.json" // Default path for the TCP fallback's address and token
+
+// Control socket timeouts
+const (
+	dialTimeout       = 2 * time.Second
+	connectionTimeout = 5 * time.Second
+)
+
+// maxRequestSize bounds a single request line read from the socket
+const maxRequestSize = 64 * 1024
+
+var (
+	// ErrDaemonNotRunning is returned by Call when no daemon is listening on the socket
+	ErrDaemonNotRunning = errors.New("daemon is not running")
+	// ErrAlreadyRunning is returned by Start when another daemon owns the socket
+	ErrAlreadyRunning = errors.New("control socket is already in use")
+)
+
+// SocketPath returns the path for the control socket based on environment variable or default
+func SocketPath() string {
+	if path := os.Getenv("MSC_CONTROL_SOCKET"); path != "" {
+		return path
+	}
+	return defaultSocketPath
+}
+
+// TCPEndpointPath returns the path of the file describing the TCP fallback based
+// on environment variable or default
+func TCPEndpointPath() string {
+	if path := os.Getenv("MSC_CONTROL_TCP_FILE"); path != "" {
+		return path
+	}
+	return defaultTCPEndpointPath
+}
+
+// TCPEndpoint is where the TCP fallback listens and the token it requires. It
+// is saved readable by the daemon's user only, which takes the place of the
+// socket's file permissions.
+type TCPEndpoint struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token"`
+}
+
+// Request is a single control command sent by a client
+type Request struct {
+	Verb   string          `json:"verb"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Token  string          `json:"token,omitempty"` // Required by the TCP fallback, see TCPEndpoint
+}
+
+// Response is the reply to a Request
+type Response struct {
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// HandlerFunc serves a control verb and returns a JSON-encodable result
+type HandlerFunc func(params json.RawMessage) (interface{}, error)
+
+// Server serves control verbs over a unix socket, or a TCP fallback, one JSON request per connection
+type Server struct {
+	path         string
+	tcp          bool   // Listening on TCP rather than the socket at path
+	token        string // Token TCP requests must carry
+	endpointPath string // TCPEndpoint file written by StartTCP
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+	listener net.Listener
+}
+
+// NewServer creates a control server listening on path
+func NewServer(path string) *Server {
+	return &Server{
+		path:     path,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers handler for verb, replacing any existing handler
+func (s *Server) Handle(verb string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[verb] = handler
+}
+
+// Start listens on the socket and serves requests in the background
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	// Remove a stale socket left behind by a crashed daemon, but never a live one
+	if _, err := os.Stat(s.path); err == nil {
+		if conn, dialErr := net.DialTimeout("unix", s.path, dialTimeout); dialErr == nil {
+			conn.Close()
+			return ErrAlreadyRunning
+		}
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	go s.serve(listener)
+	log.Printf("Control socket listening on %s", s.path)
+	return nil
+}
+
+// StartTCP listens on the TCP address addr instead of the socket and serves
+// requests in the background. It is a fallback for systems where the socket
+// cannot be created; addr comes from ClientConfig.ManagementListenAddress so
+// the listener stays on loopback unless remote management is allowed. Any
+// local user, or the LAN, can connect, so every request must carry a random
+// token that is saved with the address to a private TCPEndpoint file at
+// endpointPath, where Call finds it.
+func (s *Server) StartTCP(addr, endpointPath string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(secret)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(TCPEndpoint{Addr: listener.Addr().String(), Token: token})
+	if err == nil {
+		err = utils.WritePrivateFile(endpointPath, data)
+	}
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to save control endpoint: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.tcp = true
+	s.token = token
+	s.endpointPath = endpointPath
+	s.mu.Unlock()
+
+	go s.serve(listener)
+	log.Printf("Control listener on tcp %s", listener.Addr())
+	return nil
+}
+
+// Addr returns the address the server listens on, or nil when it is not running
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop closes the listener and removes the socket or endpoint file
+func (s *Server) Stop() {
+	s.mu.Lock()
+	listener := s.listener
+	s.listener = nil
+	tcp := s.tcp
+	s.mu.Unlock()
+
+	if listener != nil {
+		listener.Close()
+		if tcp {
+			os.Remove(s.endpointPath)
+		} else {
+			os.Remove(s.path)
+		}
+	}
+}
+
+func (s *Server) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connectionTimeout))
+
+	reader := bufio.NewReaderSize(conn, 4096)
+	line, err := readLine(reader)
+	if err != nil {
+		writeResponse(conn, Response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeResponse(conn, Response{Error: "invalid request: malformed JSON"})
+		return
+	}
+
+	if !s.authorized(req) {
+		writeResponse(conn, Response{Error: "unauthorized"})
+		return
+	}
+	writeResponse(conn, s.dispatch(req))
+}
+
+// authorized reports whether req may be served: always on the socket, whose
+// permissions limit who connects, and with the endpoint token on TCP
+func (s *Server) authorized(req Request) bool {
+	s.mu.RLock()
+	tcp, token := s.tcp, s.token
+	s.mu.RUnlock()
+	return !tcp || hmac.Equal([]byte(req.Token), []byte(token))
+}
+
+func (s *Server) dispatch(req Request) Response {
+	s.mu.RLock()
+	handler, ok := s.handlers[req.Verb]
+	s.mu.RUnlock()
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown verb: %s", req.Verb)}
+	}
+
+	result, err := handler(req.Params)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("failed to encode result: %v", err)}
+	}
+	return Response{OK: true, Result: data}
+}
+
+// readLine reads a newline-terminated request, rejecting lines over maxRequestSize
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxRequestSize {
+			return nil, errors.New("request too large")
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func writeResponse(conn net.Conn, resp Response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	conn.Write(append(data, '\n'))
+}
+
+// Call sends verb with params to the daemon listening on SocketPath, or on the
+// TCP fallback described at TCPEndpointPath, and decodes the result into result
+// (which may be nil). It returns ErrDaemonNotRunning when nothing is listening.
+func Call(verb string, params interface{}, result interface{}) error {
+	err := CallPath(SocketPath(), verb, params, result)
+	if !errors.Is(err, ErrDaemonNotRunning) {
+		return err
+	}
+	data, readErr := os.ReadFile(TCPEndpointPath())
+	if readErr != nil {
+		return err
+	}
+	var endpoint TCPEndpoint
+	if jsonErr := json.Unmarshal(data, &endpoint); jsonErr != nil {
+		return fmt.Errorf("invalid control endpoint file: %w", jsonErr)
+	}
+	return CallTCP(endpoint, verb, params, result)
+}
+
+// CallPath is like Call but uses the socket at path
+func CallPath(path, verb string, params interface{}, result interface{}) error {
+	return call("unix", path, "", verb, params, result)
+}
+
+// CallTCP is like Call but uses a daemon serving the TCP fallback at endpoint
+func CallTCP(endpoint TCPEndpoint, verb string, params interface{}, result interface{}) error {
+	return call("tcp", endpoint.Addr, endpoint.Token, verb, params, result)
+}
+
+func call(network, address, token, verb string, params interface{}, result interface{}) error {
+	conn, err := net.DialTimeout(network, address, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDaemonNotRunning, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connectionTimeout))
+
+	req := Request{Verb: verb, Token: token}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read control response: %w", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"msm-client/config"
+)
+
+func TestServerCall(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	server := NewServer(socketPath)
+	server.Handle("echo", func(params json.RawMessage) (interface{}, error) {
+		var p map[string]string
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+	server.Handle("fail", func(_ json.RawMessage) (interface{}, error) {
+		return nil, errors.New("handler failed")
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected socket permissions 0600, got %o", perm)
+	}
+
+	t.Run("Result", func(t *testing.T) {
+		var result map[string]string
+		if err := CallPath(socketPath, "echo", map[string]string{"key": "value"}, &result); err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		if result["key"] != "value" {
+			t.Errorf("Expected echoed value, got %v", result)
+		}
+	})
+
+	t.Run("Handler error", func(t *testing.T) {
+		err := CallPath(socketPath, "fail", nil, nil)
+		if err == nil || err.Error() != "handler failed" {
+			t.Errorf("Expected handler error, got %v", err)
+		}
+	})
+
+	t.Run("Unknown verb", func(t *testing.T) {
+		if err := CallPath(socketPath, "missing", nil, nil); err == nil {
+			t.Error("Expected error for unknown verb")
+		}
+	})
+
+	t.Run("Already running", func(t *testing.T) {
+		if err := NewServer(socketPath).Start(); !errors.Is(err, ErrAlreadyRunning) {
+			t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	})
+}
+
+func TestServerRemovesStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+
+	// Leave a socket file behind with nothing listening on it
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	server := NewServer(socketPath)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Expected stale socket to be replaced, got %v", err)
+	}
+	server.Stop()
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Error("Socket should be removed on Stop")
+	}
+}
+
+func TestCallDaemonNotRunning(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "missing.sock")
+	if err := CallPath(socketPath, "pairing.get", nil, nil); !errors.Is(err, ErrDaemonNotRunning) {
+		t.Errorf("Expected ErrDaemonNotRunning, got %v", err)
+	}
+}
+
+func TestServerTCPFallback(t *testing.T) {
+	endpointPath := filepath.Join(t.TempDir(), "control-tcp.json")
+	listen := func(cfg config.ClientConfig) *Server {
+		t.Helper()
+		server := NewServer(filepath.Join(t.TempDir(), "control.sock"))
+		server.Handle("ping", func(_ json.RawMessage) (interface{}, error) {
+			return "pong", nil
+		})
+		if err := server.StartTCP(cfg.ManagementListenAddress(0), endpointPath); err != nil {
+			t.Fatalf("Failed to start TCP listener: %v", err)
+		}
+		t.Cleanup(server.Stop)
+		return server
+	}
+
+	t.Run("Loopback by default", func(t *testing.T) {
+		cfg, _ := config.ValidateConfig(config.ClientConfig{})
+		server := listen(cfg)
+		addr := server.Addr().(*net.TCPAddr)
+		if !addr.IP.IsLoopback() {
+			t.Errorf("Expected a loopback listener, got %s", addr)
+		}
+	})
+
+	t.Run("Token required", func(t *testing.T) {
+		cfg, _ := config.ValidateConfig(config.ClientConfig{})
+		server := listen(cfg)
+		if info, err := os.Stat(endpointPath); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("Expected a private endpoint file, got %v (%v)", info, err)
+		}
+
+		var result string
+		for _, token := range []string{"", "guess"} {
+			endpoint := TCPEndpoint{Addr: server.Addr().String(), Token: token}
+			if err := CallTCP(endpoint, "ping", nil, &result); err == nil || err.Error() != "unauthorized" {
+				t.Errorf("Expected token %q to be refused, got %q (%v)", token, result, err)
+			}
+		}
+
+		// Call falls back to the endpoint file when the socket is missing
+		t.Setenv("MSC_CONTROL_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+		t.Setenv("MSC_CONTROL_TCP_FILE", endpointPath)
+		if err := Call("ping", nil, &result); err != nil || result != "pong" {
+			t.Errorf("Expected pong over TCP, got %q (%v)", result, err)
+		}
+
+		server.Stop()
+		if _, err := os.Stat(endpointPath); !os.IsNotExist(err) {
+			t.Error("Expected Stop to remove the endpoint file")
+		}
+	})
+
+	t.Run("All interfaces only when allowed", func(t *testing.T) {
+		cfg, _ := config.ValidateConfig(config.ClientConfig{ManagementBindAddress: "0.0.0.0"})
+		if addr := listen(cfg).Addr().(*net.TCPAddr); !addr.IP.IsLoopback() {
+			t.Errorf("Expected 0.0.0.0 to be refused without remote management, got %s", addr)
+		}
+
+		cfg, _ = config.ValidateConfig(config.ClientConfig{ManagementBindAddress: "0.0.0.0", AllowRemoteManagement: true})
+		if addr := listen(cfg).Addr().(*net.TCPAddr); !addr.IP.IsUnspecified() {
+			t.Errorf("Expected a listener on all interfaces, got %s", addr)
+		}
+	})
+}
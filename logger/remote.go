@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// remoteBufferSize is the number of entries queued for the server before new ones are dropped
+const remoteBufferSize = 256
+
+// Entry is a log record forwarded to the server
+type Entry struct {
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// SendFunc delivers an entry to the server. It fails when the WebSocket is not
+// connected, in which case the entry is dropped.
+type SendFunc func(entry Entry) error
+
+// ParseLevel parses a level name such as "debug", "info", "warn" or "error"
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log level %q", name)
+	}
+	return level, nil
+}
+
+// RemoteHandler is a slog.Handler that passes every record to the next handler
+// and additionally forwards records at or above its level to the server.
+// Handle never blocks on the network: entries are queued and dropped when the
+// queue is full, so a slow or disconnected server cannot stall logging.
+type RemoteHandler struct {
+	next    slog.Handler
+	level   slog.Leveler
+	entries chan Entry
+	attrs   []slog.Attr // Attributes added with WithAttrs, already qualified by group
+	group   string      // Group prefix added with WithGroup, joined with "."
+}
+
+// NewRemoteHandler creates a handler forwarding records at or above level through
+// send, in a background goroutine, in addition to writing them to next
+func NewRemoteHandler(next slog.Handler, level slog.Leveler, send SendFunc) *RemoteHandler {
+	h := &RemoteHandler{
+		next:    next,
+		level:   level,
+		entries: make(chan Entry, remoteBufferSize),
+	}
+	go func() {
+		for entry := range h.entries {
+			// Errors are not logged: logging them would be forwarded in turn
+			_ = send(entry)
+		}
+	}()
+	return h
+}
+
+// Enabled reports whether either the next handler or the server wants records at level
+func (h *RemoteHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || h.next.Enabled(ctx, level)
+}
+
+// Handle writes the record to the next handler and queues it for the server
+func (h *RemoteHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	if h.next.Enabled(ctx, record.Level) {
+		err = h.next.Handle(ctx, record)
+	}
+
+	if record.Level >= h.level.Level() {
+		entry := Entry{
+			Level:     strings.ToLower(record.Level.String()),
+			Message:   record.Message,
+			Timestamp: record.Time,
+		}
+		if len(h.attrs) > 0 || record.NumAttrs() > 0 {
+			entry.Fields = make(map[string]interface{}, len(h.attrs)+record.NumAttrs())
+			for _, attr := range h.attrs {
+				entry.Fields[attr.Key] = attr.Value.Resolve().Any()
+			}
+			record.Attrs(func(attr slog.Attr) bool {
+				entry.Fields[h.qualify(attr.Key)] = attr.Value.Resolve().Any()
+				return true
+			})
+		}
+
+		select {
+		case h.entries <- entry:
+		default:
+			// Queue full, drop the entry
+		}
+	}
+	return err
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *RemoteHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		clone.attrs = append(clone.attrs, slog.Attr{Key: h.qualify(attr.Key), Value: attr.Value})
+	}
+	return &clone
+}
+
+// WithGroup returns a handler that qualifies later attributes with name
+func (h *RemoteHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.group = h.qualify(name)
+	return &clone
+}
+
+// qualify prefixes key with the current group
+func (h *RemoteHandler) qualify(key string) string {
+	if h.group == "" {
+		return key
+	}
+	return h.group + "." + key
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteHandlerForwardsAtLevel(t *testing.T) {
+	var local bytes.Buffer
+	entries := make(chan Entry, 10)
+	handler := NewRemoteHandler(slog.NewTextHandler(&local, nil), slog.LevelWarn, func(entry Entry) error {
+		entries <- entry
+		return nil
+	})
+	logger := slog.New(handler).With("component", "pairing").WithGroup("request")
+
+	logger.Info("code requested")
+	logger.Warn("code rejected", "ip", "192.168.1.20", "attempt", 2)
+
+	select {
+	case entry := <-entries:
+		if entry.Level != "warn" || entry.Message != "code rejected" {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+		if entry.Fields["component"] != "pairing" || entry.Fields["request.ip"] != "192.168.1.20" || entry.Fields["request.attempt"] != int64(2) {
+			t.Errorf("Unexpected fields %v", entry.Fields)
+		}
+		if entry.Timestamp.IsZero() {
+			t.Error("Expected a timestamp")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the forwarded entry")
+	}
+
+	select {
+	case entry := <-entries:
+		t.Errorf("Info entry should not be forwarded at warn level, got %+v", entry)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Every record still reaches the local handler
+	if !strings.Contains(local.String(), "code requested") || !strings.Contains(local.String(), "code rejected") {
+		t.Errorf("Expected both records locally, got:\n%s", local.String())
+	}
+}
+
+func TestRemoteHandlerDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	handler := NewRemoteHandler(slog.NewTextHandler(&bytes.Buffer{}, nil), slog.LevelInfo, func(Entry) error {
+		<-release
+		return nil
+	})
+	defer close(release)
+
+	logger := slog.New(handler)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < remoteBufferSize*2; i++ {
+			logger.Info("entry")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Logging blocked while the sender was stalled")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("warn"); err != nil || level != slog.LevelWarn {
+		t.Errorf("Expected warn, got %v (%v)", level, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
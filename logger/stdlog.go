@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// stdLogLevels maps message prefixes used with the standard log package to levels
+var stdLogLevels = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"Warning:", slog.LevelWarn},
+	{"Error:", slog.LevelError},
+	{"Failed ", slog.LevelError}, // "Failed to ...", how most failures are logged
+}
+
+// stdLogLevel returns the level of a standard log line: warn for "Warning:",
+// error for "Error:" and "Failed ..." and info for everything else
+func stdLogLevel(message string) slog.Level {
+	for _, l := range stdLogLevels {
+		if strings.HasPrefix(message, l.prefix) {
+			return l.level
+		}
+	}
+	return slog.LevelInfo
+}
+
+// logWriter passes each line written by the standard log package to a handler
+type logWriter struct {
+	handler slog.Handler
+}
+
+// NewLogWriter returns an io.Writer for log.SetOutput that passes every line to
+// handler at the level given by its prefix, see stdLogLevel
+func NewLogWriter(handler slog.Handler) io.Writer {
+	return &logWriter{handler: handler}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	level := stdLogLevel(message)
+	ctx := context.Background()
+	if !w.handler.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	if err := w.handler.Handle(ctx, slog.NewRecord(time.Now(), level, message, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Install makes handler the default slog handler and routes the standard log
+// package through it. Unlike slog.SetDefault alone, which logs every line at
+// info, "Warning:", "Error:" and "Failed ..." lines keep their level.
+func Install(handler slog.Handler) {
+	slog.SetDefault(slog.New(handler))
+	// SetDefault already cleared the log flags; the handler adds the time
+	log.SetOutput(NewLogWriter(handler))
+}
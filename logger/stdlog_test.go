@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstallMapsStdLogLevels(t *testing.T) {
+	previous := slog.Default()
+	defer func() {
+		slog.SetDefault(previous)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	var local bytes.Buffer
+	entries := make(chan Entry, 10)
+	Install(NewRemoteHandler(slog.NewTextHandler(&local, nil), slog.LevelInfo, func(entry Entry) error {
+		entries <- entry
+		return nil
+	}))
+
+	log.Printf("Warning: disk almost full")
+	log.Println("Error: screen not found")
+	log.Printf("Failed to save state: disk full")
+	log.Printf("Connected to server")
+	slog.Info("through slog", "screen", "hdmi-1")
+
+	expected := []struct{ level, message string }{
+		{"warn", "Warning: disk almost full"},
+		{"error", "Error: screen not found"},
+		{"error", "Failed to save state: disk full"},
+		{"info", "Connected to server"},
+		{"info", "through slog"},
+	}
+	for _, want := range expected {
+		select {
+		case entry := <-entries:
+			if entry.Level != want.level || entry.Message != want.message {
+				t.Errorf("Expected %s %q, got %s %q", want.level, want.message, entry.Level, entry.Message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for %q", want.message)
+		}
+	}
+
+	if !strings.Contains(local.String(), `level=WARN msg="Warning: disk almost full"`) {
+		t.Errorf("Expected the warning at WARN locally, got:\n%s", local.String())
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"sync"
@@ -10,8 +14,11 @@ import (
 	"time"
 
 	"msm-client/config"
+	"msm-client/control"
+	"msm-client/logger"
 	"msm-client/pairing"
 	"msm-client/state"
+	"msm-client/utils"
 	"msm-client/ws"
 
 	"github.com/akamensky/argparse"
@@ -19,12 +26,18 @@ import (
 
 const DEFAULT_PAIRING_PORT = 49174 // Default port for pairing server
 
+// Version is the client version, set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"
+
 // Global variables for graceful shutdown
 var (
 	shutdownMutex  sync.Mutex
 	isShuttingDown bool
 	wsm            = ws.NewWebSocketManager()    // WebSocket manager instance
 	pm             = pairing.NewPairingManager() // Pairing manager instance
+	controlServer  *control.Server               // Control socket server, set while the daemon runs
+	modeTracker    = state.NewModeTracker()      // Pairing/connected transition bookkeeping
+	shutdownConfig config.ClientConfig           // Config used by gracefulShutdown, set once started
 )
 
 // setupSignalHandler sets up graceful shutdown on interrupt signals
@@ -47,14 +60,21 @@ func gracefulShutdown() {
 		return
 	}
 	isShuttingDown = true
+	modeTracker.EnterShuttingDown()
 
 	log.Println("Graceful shutdown initiated...")
 
 	// Disconnect WebSocket if connected
 	if wsm.IsConnected() {
 		log.Println("Disconnecting WebSocket...")
-		wsm.ShutdownWebSocket(true)
-		time.Sleep(100 * time.Millisecond) // Allow time for disconnect message
+		if err := wsm.DrainAndClose(5 * time.Second); err != nil {
+			log.Printf("Error closing WebSocket: %v", err)
+		}
+	}
+
+	// Run the shutdown hook once the server connection is closed
+	if shutdownConfig.ShutdownScript != "" {
+		runLifecycleScript("shutdown script", shutdownConfig.ShutdownScript, shutdownConfig)
 	}
 
 	// Stop pairing server
@@ -63,10 +83,43 @@ func gracefulShutdown() {
 		pm.StopPairingServer()
 	}
 
+	// Stop serving control requests
+	if controlServer != nil {
+		controlServer.Stop()
+	}
+
+	// Zero in-memory key material before exit
+	utils.ClearECDHKeys()
+	state.ZeroizeCachedKey()
+
 	log.Println("Shutdown complete")
 }
 
+// runLifecycleScript runs a startup or shutdown hook, only logging it in dry-run mode
+func runLifecycleScript(name, script string, cfg config.ClientConfig) {
+	if cfg.DryRun {
+		log.Printf("Dry run: would run %s: %s", name, script)
+		return
+	}
+	if err := utils.RunScript(name, script, cfg.GetScriptTimeout()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// discardInvalidState deletes a state file LoadState rejected, so the client pairs again
+func discardInvalidState(err error) {
+	log.Printf("Discarding unusable pairing state: %v", err)
+	if deleteErr := state.DeleteState(); deleteErr != nil {
+		log.Printf("Failed to delete state file: %v", deleteErr)
+	}
+}
+
 func main() {
+	// Commands run under exec policy limits re-execute the client as a helper
+	if len(os.Args) > 1 && os.Args[1] == ws.ExecHelperArg {
+		ws.RunExecHelper(os.Args[2:])
+	}
+
 	parser := argparse.NewParser("msm-client", "MediaScreen Manager Client")
 
 	startCmd := parser.NewCommand("start", "Start the client")
@@ -85,7 +138,7 @@ func main() {
 	pairingPortFlag := startCmd.Int("", "pairing-port", &argparse.Options{
 		Required: false,
 		Default:  DEFAULT_PAIRING_PORT,
-		Help:     "Specify the port for the pairing server (default is 49174)",
+		Help:     "Specify the port for the pairing server (default is 49174, 0 picks a free port)",
 	})
 	ipValidationFlag := startCmd.String("", "ip-validation", &argparse.Options{
 		Required: false,
@@ -98,7 +151,7 @@ func main() {
 	})
 	ipBlacklistDurationFlag := startCmd.String("", "ip-blacklist-duration", &argparse.Options{
 		Required: false,
-		Help:     "Duration to blacklist violating IPs (e.g., '1h', '30m', '2h30m')",
+		Help:     "Duration to blacklist violating IPs (e.g., '1h', '30m', '2h30m', '7d')",
 	})
 	verificationCodeLengthFlag := startCmd.Int("", "verification-code-length", &argparse.Options{
 		Required: false,
@@ -110,7 +163,7 @@ func main() {
 	})
 	pairingCodeExpirationFlag := startCmd.String("", "pairing-code-expiration", &argparse.Options{
 		Required: false,
-		Help:     "Duration before pairing codes expire (e.g., '1m', '30s', '2m30s')",
+		Help:     "Duration before pairing codes expire (e.g., '1m', '30s', '2m30s', '1d')",
 	})
 	screenSwitchPathFlag := startCmd.String("", "screen-switch-path", &argparse.Options{
 		Required: false,
@@ -126,6 +179,25 @@ func main() {
 	})
 	resetCmd := pairingCmd.NewCommand("reset", "Reset pairing (delete code and state)")
 
+	// Blacklist command, served by the running client
+	blacklistCmd := parser.NewCommand("blacklist", "Pairing IP blacklist operations")
+	blacklistListCmd := blacklistCmd.NewCommand("list", "List blacklisted IPs and IPs with violations")
+
+	// Status command
+	statusCmd := parser.NewCommand("status", "Show the client mode and whether it is paired")
+
+	// Connection commands, served by the running client
+	reconnectCmd := parser.NewCommand("reconnect", "Drop the server connection and re-dial immediately")
+	disconnectCmd := parser.NewCommand("disconnect", "Drop the server connection and keep it down until resumed or restarted")
+	untilRestartFlag := disconnectCmd.Flag("", "until-restart", &argparse.Options{
+		Required: true,
+		Help:     "Confirm the connection stays down until 'resume' or a restart",
+	})
+	resumeCmd := parser.NewCommand("resume", "Reconnect after 'disconnect --until-restart'")
+
+	// Doctor command
+	doctorCmd := parser.NewCommand("doctor", "Check the configuration for problems")
+
 	err := parser.Parse(os.Args)
 	if err != nil {
 		fmt.Print(parser.Usage(err))
@@ -135,6 +207,9 @@ func main() {
 	if startCmd.Happened() {
 		fmt.Println("Starting MediaScreen Manager Client...")
 
+		// Keep recent log lines in memory for support bundles
+		log.SetOutput(io.MultiWriter(os.Stderr, utils.RecentLogs))
+
 		// Set up signal handler for graceful shutdown
 		signalChan := setupSignalHandler()
 
@@ -146,14 +221,65 @@ func main() {
 			os.Exit(0)
 		}()
 
+		// Command line flags override the config for this run and are never saved
+		config.SetRuntimeOverrides(func(cfg *config.ClientConfig) {
+			if *deviceNameFlag != "" {
+				cfg.DeviceName = *deviceNameFlag
+			}
+			if *disableCommandsFlag {
+				cfg.DisableCommands = true
+			}
+			if ipValidationFlag != nil {
+				switch *ipValidationFlag {
+				case "strict":
+					cfg.SetStrictIPValidation()
+				case "subnet":
+					cfg.SetSubnetIPValidation()
+				case "permissive":
+					cfg.SetPermissiveIPValidation()
+				case "disabled":
+					cfg.DisableAllIPValidation()
+				}
+			}
+			if maxIPViolationsFlag != nil && *maxIPViolationsFlag > 0 {
+				cfg.MaxIPViolations = *maxIPViolationsFlag
+			}
+			if ipBlacklistDurationFlag != nil && *ipBlacklistDurationFlag != "" {
+				if duration, err := utils.ParseDurationExtended(*ipBlacklistDurationFlag); err == nil && duration >= 0 {
+					cfg.IPBlacklistDuration = duration
+				}
+			}
+			if verificationCodeLengthFlag != nil && *verificationCodeLengthFlag > 0 {
+				cfg.VerificationCodeLength = *verificationCodeLengthFlag
+			}
+			if verificationCodeAttemptsFlag != nil && *verificationCodeAttemptsFlag > 0 {
+				cfg.VerificationCodeAttempts = *verificationCodeAttemptsFlag
+			}
+			if pairingCodeExpirationFlag != nil && *pairingCodeExpirationFlag != "" {
+				if duration, err := utils.ParseDurationExtended(*pairingCodeExpirationFlag); err == nil && duration > 0 {
+					cfg.PairingCodeExpiration = duration
+				}
+			}
+			if screenSwitchPathFlag != nil && *screenSwitchPathFlag != "" {
+				cfg.ScreenSwitchPath = *screenSwitchPathFlag
+			}
+		})
+
 		cfg, err := config.LoadOrCreateConfig()
 		if err != nil {
 			log.Fatalf("Invalid config: %v", err)
 		}
 
-		// Set device name if provided
+		// Forward log entries to the server while connected
+		if cfg.EnableRemoteLogging {
+			level, _ := logger.ParseLevel(cfg.GetRemoteLogLevel())
+			local := slog.NewTextHandler(io.MultiWriter(os.Stderr, utils.RecentLogs), nil)
+			logger.Install(logger.NewRemoteHandler(local, level, wsm.SendLogEntry))
+			log.Printf("Remote logging enabled at level %s", cfg.GetRemoteLogLevel())
+		}
+
+		// Report the settings given on the command line
 		if *deviceNameFlag != "" {
-			cfg.DeviceName = *deviceNameFlag
 			log.Printf("Device name set to: %s", cfg.DeviceName)
 		} else if cfg.DeviceName != "" {
 			log.Printf("Device name: %s", cfg.DeviceName)
@@ -161,26 +287,19 @@ func main() {
 			log.Println("Device name not set")
 		}
 
-		// Set command execution flag based on command line argument
 		if *disableCommandsFlag {
-			cfg.DisableCommands = true
 			log.Println("Command execution disabled via command line flag")
 		}
 
-		// Set IP validation mode based on command line argument
 		if ipValidationFlag != nil && *ipValidationFlag != "" {
 			switch *ipValidationFlag {
 			case "strict":
-				cfg.SetStrictIPValidation()
 				log.Println("IP validation mode set to: strict (exact IP match required)")
 			case "subnet":
-				cfg.SetSubnetIPValidation()
 				log.Println("IP validation mode set to: subnet (same subnet allowed)")
 			case "permissive":
-				cfg.SetPermissiveIPValidation()
 				log.Println("IP validation mode set to: permissive (flexible validation)")
 			case "disabled":
-				cfg.DisableAllIPValidation()
 				log.Println("IP validation mode set to: disabled (no IP checking)")
 			default:
 				log.Printf("Invalid IP validation mode '%s', using current setting: %s", *ipValidationFlag, cfg.GetIPValidationMode())
@@ -189,35 +308,28 @@ func main() {
 			log.Printf("IP validation mode: %s", cfg.GetIPValidationMode())
 		}
 
-		// Set security settings based on command line arguments
 		if maxIPViolationsFlag != nil && *maxIPViolationsFlag > 0 {
-			cfg.MaxIPViolations = *maxIPViolationsFlag
 			log.Printf("Max IP violations set to: %d", cfg.MaxIPViolations)
 		}
 
 		if ipBlacklistDurationFlag != nil && *ipBlacklistDurationFlag != "" {
-			if duration, err := time.ParseDuration(*ipBlacklistDurationFlag); err == nil && duration >= 0 {
-				cfg.IPBlacklistDuration = duration
+			if duration, err := utils.ParseDurationExtended(*ipBlacklistDurationFlag); err == nil && duration >= 0 {
 				log.Printf("IP blacklist duration set to: %v", cfg.IPBlacklistDuration)
 			} else {
 				log.Printf("Invalid IP blacklist duration '%s', using current setting: %v", *ipBlacklistDurationFlag, cfg.GetIPBlacklistDuration())
 			}
 		}
 
-		// Set verification code settings based on command line arguments
 		if verificationCodeLengthFlag != nil && *verificationCodeLengthFlag > 0 {
-			cfg.VerificationCodeLength = *verificationCodeLengthFlag
 			log.Printf("Verification code length set to: %d", cfg.VerificationCodeLength)
 		}
 
 		if verificationCodeAttemptsFlag != nil && *verificationCodeAttemptsFlag > 0 {
-			cfg.VerificationCodeAttempts = *verificationCodeAttemptsFlag
 			log.Printf("Verification code attempts set to: %d", cfg.VerificationCodeAttempts)
 		}
 
 		if pairingCodeExpirationFlag != nil && *pairingCodeExpirationFlag != "" {
-			if duration, err := time.ParseDuration(*pairingCodeExpirationFlag); err == nil && duration > 0 {
-				cfg.PairingCodeExpiration = duration
+			if duration, err := utils.ParseDurationExtended(*pairingCodeExpirationFlag); err == nil && duration > 0 {
 				log.Printf("Pairing code expiration set to: %v", cfg.PairingCodeExpiration)
 			} else {
 				log.Printf("Invalid pairing code expiration '%s', using current setting: %v", *pairingCodeExpirationFlag, cfg.GetPairingCodeExpiration())
@@ -225,13 +337,117 @@ func main() {
 		}
 
 		if screenSwitchPathFlag != nil && *screenSwitchPathFlag != "" {
-			cfg.ScreenSwitchPath = *screenSwitchPathFlag
 			log.Printf("Screen switch path set to: %s", cfg.ScreenSwitchPath)
 		}
 
+		if cfg.DryRun {
+			log.Println("Dry-run mode enabled: external commands will be logged, not executed")
+		}
+
+		// Record the outcome of a pending self-update, rolling back if the new version is crash-looping
+		if executablePath, err := os.Executable(); err == nil {
+			journal, err := state.ReconcileUpdateJournal(Version, executablePath)
+			switch {
+			case errors.Is(err, state.ErrUpdateRolledBack):
+				// Exit so the supervisor restarts the restored binary
+				log.Printf("Update to %s rolled back after %d starts, restarting on %s", journal.ToVersion, journal.Starts-1, journal.FromVersion)
+				gracefulShutdown()
+				os.Exit(1)
+			case err != nil:
+				log.Printf("Failed to reconcile update journal: %v", err)
+			case journal != nil && journal.Result == state.UpdateResultPending:
+				log.Printf("Running update %s -> %s, start %d", journal.FromVersion, journal.ToVersion, journal.Starts)
+				time.AfterFunc(state.UpdateStableAfter, func() {
+					if err := state.ConfirmUpdate(executablePath); err != nil {
+						log.Printf("Failed to confirm update: %v", err)
+					}
+				})
+			case journal != nil:
+				log.Printf("Last update %s -> %s: %s", journal.FromVersion, journal.ToVersion, journal.Result)
+			}
+		}
+
+		// Describe the device once per start for log aggregation and support tooling
+		if err := state.RecordBoot(state.NewBootRecord(Version, cfg)); err != nil {
+			log.Printf("Failed to save boot record: %v", err)
+		}
+
+		// Reapply display rotations, which the display stack forgets on reboot
+		wsm.RestoreDisplayOrientations(cfg)
+
+		// Run the startup hook and remember the shutdown hook for gracefulShutdown
+		if cfg.StartupScript != "" {
+			runLifecycleScript("startup script", cfg.StartupScript, cfg)
+		}
+		shutdownMutex.Lock()
+		shutdownConfig = cfg
+		shutdownMutex.Unlock()
+
+		// Serve pairing state to the CLI from the live pairing manager
+		server := control.NewServer(control.SocketPath())
+		pm.RegisterControlHandlers(server)
+		pm.SetStateResetter(wsm.ResetConnection)
+		wsm.RegisterControlHandlers(server)
+		// Pairing settings from a config push apply the next time pairing starts
+		wsm.SetOnConfigPushed(pm.SetConfig)
+		modeTracker.RegisterControlHandlers(server)
+		err = server.Start()
+		if err != nil && !errors.Is(err, control.ErrAlreadyRunning) && cfg.ControlTCPPort > 0 {
+			log.Printf("Control socket unavailable (%v), falling back to TCP", err)
+			err = server.StartTCP(cfg.ManagementListenAddress(cfg.ControlTCPPort), control.TCPEndpointPath())
+		}
+		if err != nil {
+			log.Printf("Control socket unavailable: %v", err)
+		} else {
+			shutdownMutex.Lock()
+			controlServer = server
+			shutdownMutex.Unlock()
+		}
+
+		// Recover from a stalled connection, an unresponsive pairing server and stuck commands
+		if interval := cfg.GetWatchdogInterval(); interval > 0 {
+			watchdog := utils.NewWatchdog()
+			for _, check := range append(wsm.WatchdogChecks(), pm.WatchdogCheck()) {
+				if cfg.WatchdogCheckEnabled(check.Name) {
+					watchdog.Add(check)
+				}
+			}
+			wsm.SetWatchdog(watchdog)
+			go watchdog.Run(context.Background(), interval)
+		}
+
+		// Report how the client moved between pairing and connected modes in the first status
+		wsm.SetModeTracker(modeTracker)
+		wsm.SetVersion(Version)
+		pm.AddOnPairingSuccess(func(string) { modeTracker.RecordPairingAttempt() })
+		pm.AddOnPairingFailed(func(string, int) { modeTracker.RecordPairingAttempt() })
+
+		// The pairing server keeps answering confirm retries until the WebSocket connects
+		modeChanges := make(chan state.ModeChange, 8)
+		modeTracker.Subscribe(modeChanges)
+		go func() {
+			for change := range modeChanges {
+				if change.To == state.ModeConnected {
+					pm.ClearConfirmGrace()
+				}
+			}
+		}()
+
+		// Signals that the pairing server is listening, so Wait can follow it
+		pairingServerStarted := make(chan struct{}, 1)
+		pm.SetOnServerStarted(func(string) {
+			select {
+			case pairingServerStarted <- struct{}{}:
+			default:
+			}
+		})
+
 		log.Println("MSM Client started. Press Ctrl+C to exit gracefully.")
 
 		savedState, err := state.LoadState()
+		if errors.Is(err, state.ErrInvalidState) {
+			discardInvalidState(err)
+		}
 		if err == nil {
 			log.Printf("Found saved state, connecting to %s", savedState.ServerWs)
 			for {
@@ -244,6 +460,7 @@ func main() {
 				shutdownMutex.Unlock()
 
 				wsm.ConnectWebSocket(cfg, savedState.ServerWs)
+				cfg = wsm.Config() // Keep settings pushed by the server
 
 				shutdownMutex.Lock()
 				if isShuttingDown {
@@ -256,8 +473,8 @@ func main() {
 				// and the state file was deleted (triggering restart)
 				log.Println("WebSocket connection ended, checking if pairing is needed...")
 
-				// Check if state still exists
-				if state.HasState() {
+				// Check if state still exists, waiting out a file that vanished only briefly
+				if !state.ConfirmStateMissing(context.Background(), cfg.GetStateMissingGracePeriod()) {
 					log.Println("State still exists, attempting to reconnect...")
 					// Reload state in case it changed
 					if newState, loadErr := state.LoadState(); loadErr == nil {
@@ -265,9 +482,13 @@ func main() {
 						continue
 					} else {
 						log.Printf("Error reloading state: %v", loadErr)
+						if errors.Is(loadErr, state.ErrInvalidState) {
+							discardInvalidState(loadErr)
+						}
 						break
 					}
 				} else {
+					pm.WaitBeforePairing(context.Background(), cfg, savedState.ServerWs)
 					log.Println("State file deleted, starting pairing server...")
 					break // Exit loop to start pairing
 				}
@@ -287,15 +508,53 @@ func main() {
 			if *enableDisplayFlag {
 				log.Println("Pairing display enabled - web interface available at /display")
 			}
-			pm.StartPairingServerOnPort(cfg, *pairingPortFlag, *enableDisplayFlag)
+			modeTracker.EnterPairing()
 
-			// After pairing server stops, check if we now have saved state
-			// This happens when pairing was successful
-			pairedState, stateErr := state.LoadState()
-			if stateErr == nil {
-				log.Printf("Pairing completed! Connecting to %s", pairedState.ServerWs)
-				wsm.ConnectWebSocket(cfg, pairedState.ServerWs)
+			// The server stays up after a successful confirm to answer retries, so
+			// connect as soon as the result arrives rather than when the server stops
+			select {
+			case <-pairingServerStarted:
+			default:
+			}
+			serverCtx, serverStopped := context.WithCancel(context.Background())
+			var serverErr error
+			serverCfg := cfg
+			go func() {
+				defer serverStopped()
+				serverErr = pm.StartPairingServerOnPort(serverCfg, *pairingPortFlag, *enableDisplayFlag)
+			}()
+
+			var result pairing.PairingResult
+			resultErr := pairing.ErrNoPairingResult
+			select {
+			case <-pairingServerStarted:
+				result, resultErr = pm.Wait(serverCtx)
+			case <-serverCtx.Done():
+			}
+			if resultErr != nil {
+				<-serverCtx.Done()
+				if errors.Is(serverErr, pairing.ErrPairingPortUnavailable) {
+					// Exit non-zero so the supervisor restarts the client later
+					log.Printf("Cannot start pairing server: %v", serverErr)
+					gracefulShutdown()
+					os.Exit(1)
+				}
+				// A result may have been delivered just before the server stopped
+				result, resultErr = pm.Wait(context.Background())
+			}
+
+			if resultErr == nil {
+				log.Printf("Pairing completed! Connecting to %s", result.ServerWs)
+				wsm.ConnectWebSocket(cfg, result.ServerWs)
+				cfg = wsm.Config() // Keep settings pushed by the server
 				// If we get here, the WebSocket connection ended and might need to restart pairing
+				<-serverCtx.Done()
+				if !state.HasState() {
+					pm.WaitBeforePairing(context.Background(), cfg, result.ServerWs)
+				}
+				continue
+			} else if pm.TakeRestartRequest() {
+				log.Println("Pairing server stopped by the watchdog, starting it again")
 				continue
 			} else {
 				log.Println("Pairing server stopped without successful pairing")
@@ -304,6 +563,119 @@ func main() {
 		}
 	}
 
+	// Handle status command
+	if statusCmd.Happened() {
+		var status state.ClientStatus
+		err := control.Call(state.ControlVerbStatus, nil, &status)
+		if err == nil {
+			fmt.Printf("Mode: %s (since %s)\n", status.Mode, status.Since.Format(time.RFC3339))
+			fmt.Printf("Paired: %t\n", status.Paired)
+			if status.LastServerError != nil {
+				fmt.Printf("Last server error: %s (at %s)\n", status.LastServerError.Message, status.LastServerError.ReceivedAt.Format(time.RFC3339))
+			}
+			return
+		}
+		if !errors.Is(err, control.ErrDaemonNotRunning) {
+			log.Fatalf("Failed to get status: %v", err)
+		}
+
+		// Daemon is not running, only the stored pairing state is known
+		fmt.Println("Mode: not running")
+		fmt.Printf("Paired: %t\n", state.IsPaired())
+		return
+	}
+
+	// Handle doctor command
+	if doctorCmd.Happened() {
+		cfg, err := config.LoadOrCreateConfig()
+		if err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+
+		failed := false
+		for _, check := range []struct {
+			name    string
+			command []string
+		}{
+			{"reboot_command", cfg.GetRebootCommand()},
+			{"shutdown_command", cfg.GetShutdownCommand()},
+		} {
+			if path, err := config.ResolveCommand(check.command); err != nil {
+				fmt.Printf("FAIL %s %q: %v\n", check.name, check.command, err)
+				failed = true
+			} else {
+				fmt.Printf("OK   %s %q (%s)\n", check.name, check.command, path)
+			}
+		}
+		// Other local users must not read the keys and pairing code in these files
+		for _, path := range []string{config.FilePath(), state.FilePath(), pairing.CodeFilePath()} {
+			if err := utils.CheckPrivatePath(path); err != nil {
+				fmt.Printf("WARN permissions: %v\n", err)
+			} else {
+				fmt.Printf("OK   permissions %s\n", path)
+			}
+		}
+		if record, err := state.LoadBootRecord(); err == nil {
+			fmt.Printf("Last start: %s, version %s, state present: %t\n", record.Time.Local().Format(time.RFC3339), record.Version, record.StatePresent)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle connection commands
+	if reconnectCmd.Happened() || disconnectCmd.Happened() || resumeCmd.Happened() {
+		verb, params, action := ws.ControlVerbReconnect, interface{}(nil), "reconnect"
+		if disconnectCmd.Happened() {
+			verb, params, action = ws.ControlVerbDisconnect, ws.DisconnectParams{UntilRestart: *untilRestartFlag}, "disconnect"
+		} else if resumeCmd.Happened() {
+			verb, action = ws.ControlVerbResume, "resume"
+		}
+
+		var result ws.ConnectionControlResult
+		if err := control.Call(verb, params, &result); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				log.Fatalf("Failed to %s: the client is not running", action)
+			}
+			log.Fatalf("Failed to %s: %v", action, err)
+		}
+
+		switch {
+		case result.Held:
+			fmt.Println("Disconnected. The connection stays down until 'msm-client resume' or a restart.")
+		case disconnectCmd.Happened():
+			fmt.Println("Disconnected.")
+		default:
+			fmt.Println("Reconnecting to the server.")
+		}
+		return
+	}
+
+	// Handle blacklist command
+	if blacklistListCmd.Happened() {
+		var result pairing.BlacklistResult
+		if err := control.Call(pairing.ControlVerbBlacklist, nil, &result); err != nil {
+			if errors.Is(err, control.ErrDaemonNotRunning) {
+				log.Fatal("Failed to list the blacklist: the client is not running")
+			}
+			log.Fatalf("Failed to list the blacklist: %v", err)
+		}
+
+		if len(result.Entries) == 0 {
+			fmt.Println("No blacklisted or violating IPs.")
+			return
+		}
+		for _, entry := range result.Entries {
+			if entry.Blacklisted {
+				fmt.Printf("%-40s %d/%d violations, blacklisted for %v\n", entry.IP, entry.Violations, result.MaxIPViolations, entry.Remaining.Round(time.Second))
+			} else {
+				fmt.Printf("%-40s %d/%d violations\n", entry.IP, entry.Violations, result.MaxIPViolations)
+			}
+		}
+		return
+	}
+
 	// Handle pairing command
 	if pairingCmd.Happened() {
 		if getCmd.Happened() {
@@ -313,16 +685,48 @@ func main() {
 				return
 			}
 
-			code, expiry := pm.GetPairingCode()
-			if code == "" {
+			var info pairing.PairingInfo
+			err := control.Call(pairing.ControlVerbGet, nil, &info)
+			if err == nil {
+				if info.Code == "" {
+					fmt.Printf("No pairing code available or it has expired (%d/%d attempts used).\n", info.AttemptsUsed, info.AttemptsMax)
+					return
+				}
+				fmt.Printf("Pairing code: %s (%s)\n", info.Code, info.Summary())
+				return
+			}
+			if !errors.Is(err, control.ErrDaemonNotRunning) {
+				log.Fatalf("Failed to get pairing code: %v", err)
+			}
+
+			// Daemon is not running, fall back to the last code it wrote
+			file, loadErr := pm.LoadPairingCodeFile()
+			now := time.Now()
+			if loadErr != nil || file.Code == "" || file.Expired(now) {
 				fmt.Println("No pairing code available or it has expired.")
 				return
 			}
-			fmt.Printf("Pairing code: %s (expires at %s)\n", code, expiry.Format(time.RFC3339))
+			fmt.Printf("Pairing code: %s (%s)\n", file.Code, file.Summary(now))
 			return
 		}
 
 		if resetCmd.Happened() {
+			var result pairing.ResetResult
+			err := control.Call(pairing.ControlVerbReset, nil, &result)
+			if err == nil {
+				if result.StateDeleted && result.ServerNotified {
+					fmt.Println("Pairing reset successfully. The server was notified.")
+				} else if result.StateDeleted {
+					fmt.Println("Pairing reset successfully. The client was not connected, so the server was not notified.")
+				} else {
+					fmt.Println("Pairing code reset. Not paired yet.")
+				}
+				return
+			}
+			if !errors.Is(err, control.ErrDaemonNotRunning) {
+				log.Fatalf("Failed to reset pairing: %v", err)
+			}
+
 			if !state.HasState() {
 				fmt.Println("Not paired yet. Nothing to reset.")
 				return
@@ -338,7 +742,7 @@ func main() {
 				log.Fatalf("Failed to delete state: %v", stateErr)
 			}
 
-			fmt.Println("Pairing reset successfully.")
+			fmt.Println("Pairing reset successfully. The client is not running, so the server was not notified.")
 			return
 		}
 	}
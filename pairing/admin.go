@@ -0,0 +1,187 @@
+package pairing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"msm-client/config"
+)
+
+// maxAttemptHistory bounds the confirm outcomes kept for the admin view
+const maxAttemptHistory = 20
+
+// adminRealm is the HTTP basic auth realm of the admin view
+const adminRealm = "MSM pairing admin"
+
+//go:embed admin_display.html
+var adminTemplateFS embed.FS
+
+// adminTemplate renders the admin view. It is embedded so the view keeps
+// working when the display template directory is customised.
+var adminTemplate = template.Must(template.ParseFS(adminTemplateFS, "admin_display.html"))
+
+// pairingAttempt is one confirm outcome shown on the admin view
+type pairingAttempt struct {
+	At        time.Time
+	Outcome   string // "success" or "failed"
+	Reason    string // Failure reason, e.g. "incorrect_code"
+	RequestID string
+}
+
+// AdminTemplateData represents the data passed to the admin template
+type AdminTemplateData struct {
+	IPValidationMode string
+	HasCode          bool
+	CodeRemaining    time.Duration
+	AttemptsUsed     int
+	AttemptsMax      int
+	MaxIPViolations  int
+	Blacklist        []BlacklistEntry // Blacklisted and violating IPs, most severe first
+	Attempts         []pairingAttempt
+	FormToken        string // Proves the admin forms were served by this page
+}
+
+// ipValidationMode names the IP validation mode cfg selects
+func ipValidationMode(cfg config.ClientConfig) string {
+	switch {
+	case cfg.DisableIPValidation:
+		return "disabled"
+	case cfg.StrictIPValidation:
+		return "strict"
+	case cfg.AllowIPSubnetMatch:
+		return "subnet"
+	default:
+		return "permissive"
+	}
+}
+
+// adminFormToken derives the token the admin forms post back. Browsers resend
+// basic auth credentials on cross-site requests, so the forms must also prove
+// they came from the admin page.
+func adminFormToken(adminToken string) string {
+	mac := hmac.New(sha256.New, []byte(adminToken))
+	mac.Write([]byte("msm-display-admin-form"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorizeAdmin checks the basic auth password of r against the configured
+// admin token and writes the error response when access is denied. The admin
+// view does not exist while no token is configured. A wrong password counts as
+// an IP violation, so guessing the token gets the IP blacklisted like guessing
+// the pairing code.
+func (pm *PairingManager) authorizeAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	adminToken := pm.GetConfig().DisplayAdminToken
+	if adminToken == "" {
+		http.NotFound(w, r)
+		return "", false
+	}
+
+	clientIP := getClientIP(r)
+	if pm.isIPBlacklisted(clientIP) {
+		log.Printf("Admin view access rejected: IP %s is blacklisted", clientIP)
+		writeJSONError(w, r, http.StatusForbidden, "Access denied")
+		return "", false
+	}
+
+	_, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(adminToken)) != 1 {
+		log.Printf("Admin view access denied for IP %s", clientIP)
+		// A browser asks for credentials only after the first challenge
+		if ok {
+			pm.recordIPViolation(clientIP)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+adminRealm+`"`)
+		writeJSONError(w, r, http.StatusUnauthorized, "Authentication required")
+		return "", false
+	}
+	return adminToken, true
+}
+
+// GetAdminTemplateData collects the pairing security state shown on the admin view
+func (pm *PairingManager) GetAdminTemplateData() *AdminTemplateData {
+	cfg := pm.GetConfig()
+	info := pm.GetPairingCodeInfo()
+
+	data := &AdminTemplateData{
+		IPValidationMode: ipValidationMode(cfg),
+		HasCode:          info.Code != "",
+		CodeRemaining:    info.Remaining.Round(time.Second),
+		AttemptsUsed:     info.AttemptsUsed,
+		AttemptsMax:      info.AttemptsMax,
+		MaxIPViolations:  cfg.GetMaxIPViolations(),
+		Blacklist:        pm.GetBlacklistDetailed(),
+		Attempts:         pm.attempts.Recent(),
+	}
+	for i := range data.Blacklist {
+		data.Blacklist[i].Remaining = data.Blacklist[i].Remaining.Round(time.Second)
+	}
+	return data
+}
+
+// HandleAdmin serves the admin view with the pairing security state
+func (pm *PairingManager) HandleAdmin(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken, ok := pm.authorizeAdmin(w, r)
+		if !ok {
+			return
+		}
+
+		data := pm.GetAdminTemplateData()
+		data.FormToken = adminFormToken(adminToken)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		if err := adminTemplate.Execute(w, data); err != nil {
+			log.Printf("Admin template execution error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleAdminBlacklist serves the blacklisted and violating IPs as JSON
+func (pm *PairingManager) HandleAdminBlacklist(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := pm.authorizeAdmin(w, r); !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		json.NewEncoder(w).Encode(pm.GetBlacklistResult())
+	}
+}
+
+// HandleAdminAction runs action for a form posted from the admin view and
+// redirects back to it
+func (pm *PairingManager) HandleAdminAction(cfg config.ClientConfig, action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken, ok := pm.authorizeAdmin(w, r)
+		if !ok {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		pm.limitRequestBody(w, r)
+		formToken := r.PostFormValue("form_token")
+		if subtle.ConstantTimeCompare([]byte(formToken), []byte(adminFormToken(adminToken))) != 1 {
+			writeJSONError(w, r, http.StatusForbidden, "Invalid form token")
+			return
+		}
+
+		log.Printf("Admin action %s requested by IP %s", r.URL.Path, getClientIP(r))
+		action()
+		http.Redirect(w, r, "/display/admin", http.StatusSeeOther)
+	}
+}
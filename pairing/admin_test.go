@@ -0,0 +1,235 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestAdminAuth(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{}
+	pm.SetConfig(cfg)
+
+	serveAdmin := func(password string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/display/admin", nil)
+		if password != "" {
+			req.SetBasicAuth("admin", password)
+		}
+		rr := httptest.NewRecorder()
+		pm.HandleAdmin(cfg).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serveAdmin("anything"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an admin token, got %d", rr.Code)
+	}
+
+	pm.SetConfig(config.ClientConfig{DisplayAdminToken: "letmein", StrictIPValidation: true})
+
+	rr := serveAdmin("")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", rr.Code)
+	}
+	if rr.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate challenge")
+	}
+	if rr := serveAdmin("wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong password, got %d", rr.Code)
+	}
+
+	rr = serveAdmin("letmein")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with the admin token, got %d", rr.Code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "strict") || !strings.Contains(body, adminFormToken("letmein")) {
+		t.Errorf("Expected the IP validation mode and form token in the page, got %s", body)
+	}
+
+	t.Run("Wrong passwords blacklist the IP", func(t *testing.T) {
+		pm.ClearBlacklist()
+		pm.SetConfig(config.ClientConfig{DisplayAdminToken: "letmein", MaxIPViolations: 2})
+
+		// The browser's first request without credentials is not a violation
+		serveAdmin("")
+		if entries := pm.GetBlacklistDetailed(); len(entries) != 0 {
+			t.Errorf("Expected no violation without credentials, got %+v", entries)
+		}
+
+		serveAdmin("guess1")
+		serveAdmin("guess2")
+		if rr := serveAdmin("letmein"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a blacklisted IP, got %d", rr.Code)
+		}
+	})
+}
+
+func TestAdminClearBlacklist(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{DisplayAdminToken: "letmein"}
+	pm.SetConfig(cfg)
+
+	pm.blacklistMutex.Lock()
+	pm.ipBlacklist["192.0.2.10"] = time.Now().Add(time.Hour)
+	pm.blacklistMutex.Unlock()
+	pm.triggerOnPairingFailed("incorrect_code", 1, "req-1")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/display/admin", nil)
+	req.SetBasicAuth("admin", "letmein")
+	pm.HandleAdmin(cfg).ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "192.0.2.10") || !strings.Contains(body, "incorrect_code") {
+		t.Fatalf("Expected the blacklist entry and attempt history in the page, got %s", body)
+	}
+
+	clearBlacklist := func(password, formToken string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"form_token": {formToken}}
+		req := httptest.NewRequest("POST", "/display/admin/clear-blacklist", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", password)
+		rr := httptest.NewRecorder()
+		pm.HandleAdminAction(cfg, pm.ClearBlacklist).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := clearBlacklist("wrong", adminFormToken("letmein")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong password, got %d", rr.Code)
+	}
+	if rr := clearBlacklist("letmein", "forged"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 with a wrong form token, got %d", rr.Code)
+	}
+	if len(pm.GetBlacklistStatus()) != 1 {
+		t.Fatal("Rejected requests must not clear the blacklist")
+	}
+
+	rr = clearBlacklist("letmein", adminFormToken("letmein"))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/display/admin" {
+		t.Errorf("Expected a redirect to the admin view, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if entries := pm.GetBlacklistStatus(); len(entries) != 0 {
+		t.Errorf("Expected the blacklist to be cleared, got %v", entries)
+	}
+}
+
+func TestAdminBlacklistJSON(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{DisplayAdminToken: "letmein", MaxIPViolations: 3}
+	pm.SetConfig(cfg)
+	pm.recordIPViolation("192.0.2.20")
+	pm.recordIPViolation("192.0.2.20")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/display/admin", nil)
+	req.SetBasicAuth("admin", "letmein")
+	pm.HandleAdmin(cfg).ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "192.0.2.20") || !strings.Contains(body, "2/3") {
+		t.Errorf("Expected the violating IP with its violation count in the page, got %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/display/admin/blacklist", nil)
+	pm.HandleAdminBlacklist(cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	req.SetBasicAuth("admin", "letmein")
+	pm.HandleAdminBlacklist(cfg).ServeHTTP(rr, req)
+	var result BlacklistResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode the blacklist: %v", err)
+	}
+	if result.MaxIPViolations != 3 || len(result.Entries) != 1 || result.Entries[0].Violations != 2 || result.Entries[0].Blacklisted {
+		t.Errorf("Unexpected blacklist %+v", result)
+	}
+}
+
+func TestAdminRegenerateCode(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Cleanup(utils.ClearECDHKeys)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		DisplayAdminToken:        "letmein",
+		VerificationCodeLength:   6,
+		VerificationCodeAttempts: 3,
+		PairingCodeExpiration:    time.Minute,
+		StrictIPValidation:       true,
+	}
+	pm.SetConfig(cfg)
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.0.2.30"
+	pm.expiry = time.Now().Add(time.Minute)
+	pm.failCount = 2
+	pm.codeMutex.Unlock()
+
+	form := url.Values{"form_token": {adminFormToken("letmein")}}
+	req := httptest.NewRequest("POST", "/display/admin/reset-code", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "letmein")
+	rr := httptest.NewRecorder()
+	pm.HandleAdminAction(cfg, pm.RegenerateCode).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect to the admin view, got %d", rr.Code)
+	}
+
+	info := pm.GetPairingInfo()
+	if info.Code == "" || info.Code == "123456" {
+		t.Errorf("Expected a fresh pairing code, got %q", info.Code)
+	}
+	if info.FailCount != 0 {
+		t.Errorf("Expected the fresh code to have no failed attempts, got %d", info.FailCount)
+	}
+	pm.codeMutex.Lock()
+	boundIP := pm.pairCodeIP
+	pm.codeMutex.Unlock()
+	if boundIP != "" {
+		t.Errorf("Expected the fresh code to be bound to no IP, got %s", boundIP)
+	}
+}
+
+func TestAttemptHistory(t *testing.T) {
+	pm := NewPairingManager()
+	for i := 0; i < maxAttemptHistory+5; i++ {
+		pm.attempts.Add(pairingAttempt{RequestID: string(rune('a' + i))})
+	}
+
+	recent := pm.attempts.Recent()
+	if len(recent) != maxAttemptHistory {
+		t.Fatalf("Expected %d attempts, got %d", maxAttemptHistory, len(recent))
+	}
+	if recent[0].RequestID != string(rune('a'+maxAttemptHistory+4)) {
+		t.Errorf("Expected the newest attempt first, got %q", recent[0].RequestID)
+	}
+}
+
+func TestAttemptHistoryUsesClock(t *testing.T) {
+	pm := NewPairingManager()
+	pm.SetConfig(config.ClientConfig{})
+	clock := utils.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	pm.clock = clock
+
+	pm.triggerOnPairingFailed("incorrect_code", 1, "req-1")
+	clock.Advance(time.Minute)
+	pm.triggerOnPairingSuccess("ws://server/ws")
+
+	recent := pm.attempts.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(recent))
+	}
+	if !recent[0].At.Equal(clock.Now()) || !recent[1].At.Equal(clock.Now().Add(-time.Minute)) {
+		t.Errorf("Expected the attempts at the manager's clock, got %v and %v", recent[0].At, recent[1].At)
+	}
+}
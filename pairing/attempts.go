@@ -0,0 +1,57 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"msm-client/config"
+)
+
+// AttemptStatus reports both limits on failed confirms as seen by one IP: the
+// attempt budget shared by everyone confirming the current code and the
+// violations recorded for the IP. See HandleConfirm for what counts against each.
+type AttemptStatus struct {
+	AttemptsUsed      int  `json:"attempts_used"` // Attempts used from the code's budget
+	AttemptsMax       int  `json:"attempts_max"`
+	AttemptsRemaining int  `json:"attempts_remaining"`
+	IPViolations      int  `json:"ip_violations"` // Violations of the IP within the violation window
+	MaxIPViolations   int  `json:"max_ip_violations"`
+	Blacklisted       bool `json:"blacklisted"`
+}
+
+// GetAttemptStatus returns the attempt budget of the current code and the
+// violations and blacklisting of ip
+func (pm *PairingManager) GetAttemptStatus(ip string) AttemptStatus {
+	cfg := pm.GetConfig()
+	status := AttemptStatus{
+		AttemptsMax:     cfg.GetVerificationCodeAttempts(),
+		MaxIPViolations: cfg.GetMaxIPViolations(),
+	}
+
+	pm.codeMutex.Lock()
+	status.AttemptsUsed = pm.failCount
+	status.AttemptsRemaining = pm.attemptsRemainingLocked(status.AttemptsMax)
+	pm.codeMutex.Unlock()
+
+	status.Blacklisted = pm.isIPBlacklisted(ip)
+
+	pm.blacklistMutex.Lock()
+	status.IPViolations, _ = pm.ipViolations.Get(ip)
+	pm.blacklistMutex.Unlock()
+
+	return status
+}
+
+// HandleStatus serves /pair/status: the attempt status of the requesting IP
+func (pm *PairingManager) HandleStatus(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		_ = json.NewEncoder(w).Encode(pm.GetAttemptStatus(getClientIP(r)))
+	}
+}
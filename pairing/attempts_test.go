@@ -0,0 +1,166 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+)
+
+func TestConfirmFailureAccounting(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	const codeIP = "192.168.1.100"
+	modes := map[string]config.ClientConfig{
+		"disabled": {DisableIPValidation: true},
+		"default":  {},
+		"subnet":   {AllowIPSubnetMatch: true},
+		"strict":   {StrictIPValidation: true},
+	}
+
+	tests := []struct {
+		mode          string
+		failure       string
+		clientIP      string
+		body          string
+		expired       bool
+		wantStatus    int
+		wantBudget    int
+		wantViolation int
+	}{
+		// Wrong codes count against both limits in every mode
+		{"disabled", "incorrect code", codeIP, `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+		{"default", "incorrect code", codeIP, `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+		{"subnet", "incorrect code", codeIP, `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+		{"strict", "incorrect code", codeIP, `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+
+		// Only strict and subnet mode reject a foreign IP; only subnet mode charges the code
+		{"disabled", "IP mismatch", "10.0.0.5", `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+		{"default", "IP mismatch", "10.0.0.5", `{"code":"WRONG1"}`, false, http.StatusUnauthorized, 1, 1},
+		{"subnet", "IP mismatch", "10.0.0.5", `{"code":"123456"}`, false, http.StatusForbidden, 1, 1},
+		{"strict", "IP mismatch", "10.0.0.5", `{"code":"123456"}`, false, http.StatusForbidden, 0, 1},
+
+		// Rejections that are no guess count against neither
+		{"disabled", "expired code", codeIP, `{"code":"123456"}`, true, http.StatusForbidden, 0, 0},
+		{"default", "expired code", codeIP, `{"code":"123456"}`, true, http.StatusForbidden, 0, 0},
+		{"subnet", "expired code", codeIP, `{"code":"123456"}`, true, http.StatusForbidden, 0, 0},
+		{"strict", "expired code", codeIP, `{"code":"123456"}`, true, http.StatusForbidden, 0, 0},
+		{"disabled", "invalid request", codeIP, `{`, false, http.StatusBadRequest, 0, 0},
+		{"default", "invalid request", codeIP, `{`, false, http.StatusBadRequest, 0, 0},
+		{"subnet", "invalid request", codeIP, `{`, false, http.StatusBadRequest, 0, 0},
+		{"strict", "invalid request", codeIP, `{`, false, http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.failure, func(t *testing.T) {
+			cfg := modes[tt.mode]
+			cfg.VerificationCodeAttempts = 3
+			pm := NewPairingManager()
+			pm.SetConfig(cfg)
+
+			pm.codeMutex.Lock()
+			pm.pairCode = "123456"
+			pm.pairCodeIP = codeIP
+			pm.expiry = time.Now().Add(time.Minute)
+			if tt.expired {
+				pm.expiry = time.Now().Add(-time.Minute)
+			}
+			pm.codeMutex.Unlock()
+
+			req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(tt.body))
+			req.RemoteAddr = tt.clientIP + ":12345"
+			rr := httptest.NewRecorder()
+			pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			status := pm.GetAttemptStatus(tt.clientIP)
+			if status.AttemptsUsed != tt.wantBudget {
+				t.Errorf("Expected %d attempts used, got %d", tt.wantBudget, status.AttemptsUsed)
+			}
+			if status.IPViolations != tt.wantViolation {
+				t.Errorf("Expected %d IP violations, got %d", tt.wantViolation, status.IPViolations)
+			}
+		})
+	}
+}
+
+func TestWrongCodesBlacklistIP(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	cfg := config.ClientConfig{VerificationCodeAttempts: 5, MaxIPViolations: 3}
+	pm := NewPairingManager()
+	pm.SetConfig(cfg)
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(time.Minute)
+	pm.codeMutex.Unlock()
+
+	confirm := func() int {
+		req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(`{"code":"WRONG1"}`))
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if code := confirm(); code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected 401, got %d", attempt, code)
+		}
+	}
+	if code := confirm(); code != http.StatusForbidden {
+		t.Errorf("Expected the brute-forcing IP to be blacklisted, got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/pair/status", nil)
+	req.RemoteAddr = "192.168.1.100:12345"
+	rr := httptest.NewRecorder()
+	pm.HandleStatus(cfg).ServeHTTP(rr, req)
+
+	var status AttemptStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode /pair/status: %v", err)
+	}
+	expected := AttemptStatus{AttemptsUsed: 3, AttemptsMax: 5, AttemptsRemaining: 2, IPViolations: 3, MaxIPViolations: 3, Blacklisted: true}
+	if status != expected {
+		t.Errorf("Expected %+v, got %+v", expected, status)
+	}
+}
+
+func TestConfirmUsesCurrentValidationMode(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	// The server started in subnet mode, then strict validation was switched on
+	startCfg := config.ClientConfig{VerificationCodeAttempts: 3, AllowIPSubnetMatch: true}
+	pm := NewPairingManager()
+	pm.SetConfig(startCfg)
+	handler := pm.HandleConfirm(startCfg)
+	pm.SetConfig(config.ClientConfig{VerificationCodeAttempts: 3, StrictIPValidation: true})
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(time.Minute)
+	pm.codeMutex.Unlock()
+
+	req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(`{"code":"123456"}`))
+	req.RemoteAddr = "10.0.0.5:12345"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rr.Code)
+	}
+	code, _, failCount, violations := pm.GetPairingStatus("10.0.0.5")
+	if code != "123456" || failCount != 0 || violations != 1 {
+		t.Errorf("Expected a strict-mode mismatch to leave the code intact with 1 violation, got %q, %d attempts used, %d violations", code, failCount, violations)
+	}
+}
@@ -0,0 +1,170 @@
+package pairing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// Connectivity probe targets and timeouts. These are variables so tests can
+// point the probes at local listeners.
+var (
+	internetProbeHost  = "8.8.8.8"
+	internetProbePort  = 443
+	gatewayProbePort   = 80
+	defaultGatewayFunc = utils.GetDefaultGateway
+
+	internetProbeTimeout = 2 * time.Second
+	gatewayProbeTimeout  = 1 * time.Second
+	connectivityTimeout  = 3 * time.Second
+	// connectivityCacheTTL is how long a summary is reused across /pair requests
+	connectivityCacheTTL = 30 * time.Second
+
+	serverProbeInterval = 5 * time.Second
+	serverProbeTimeout  = 3 * time.Second
+)
+
+// ConnectivitySummary describes the network state reported in pairing responses
+type ConnectivitySummary struct {
+	HasInternet      bool                 `json:"has_internet"`
+	GatewayReachable bool                 `json:"gateway_reachable"`
+	PrimaryInterface *utils.InterfaceInfo `json:"primary_interface,omitempty"`
+}
+
+// connectivityCache holds the last summary so repeated /pair requests don't
+// dial out every time
+type connectivityCache struct {
+	mu      sync.Mutex
+	summary *ConnectivitySummary
+	expiry  time.Time
+}
+
+// checkConnectivity probes internet and gateway reachability concurrently.
+// The whole check is bounded by connectivityTimeout.
+func checkConnectivity(ctx context.Context) ConnectivitySummary {
+	ctx, cancel := context.WithTimeout(ctx, connectivityTimeout)
+	defer cancel()
+
+	summary := ConnectivitySummary{PrimaryInterface: utils.GetPrimaryInterface()}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		probeCtx, probeCancel := context.WithTimeout(ctx, internetProbeTimeout)
+		defer probeCancel()
+		_, err := utils.CheckPort(probeCtx, internetProbeHost, internetProbePort, "tcp")
+		summary.HasInternet = err == nil
+	}()
+
+	go func() {
+		defer wg.Done()
+		gateway, err := defaultGatewayFunc()
+		if err != nil {
+			return
+		}
+		probeCtx, probeCancel := context.WithTimeout(ctx, gatewayProbeTimeout)
+		defer probeCancel()
+		_, err = utils.CheckPort(probeCtx, gateway, gatewayProbePort, "tcp")
+		summary.GatewayReachable = err == nil
+	}()
+
+	wg.Wait()
+	return summary
+}
+
+// connectivitySummary returns the cached summary while it is younger than
+// connectivityCacheTTL and probes again otherwise. The primary interface is
+// masked when cfg.RedactNetworkIdentifiers is set.
+func (pm *PairingManager) connectivitySummary(ctx context.Context, cfg config.ClientConfig) *ConnectivitySummary {
+	cache := &pm.connectivity
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.summary == nil || pm.clock.Expired(cache.expiry) {
+		summary := checkConnectivity(ctx)
+		if cfg.RedactNetworkIdentifiers && summary.PrimaryInterface != nil {
+			redacted := utils.RedactInterfaceInfo([]utils.InterfaceInfo{*summary.PrimaryInterface})
+			summary.PrimaryInterface = &redacted[0]
+		}
+		cache.summary = &summary
+		cache.expiry = pm.clock.Deadline(connectivityCacheTTL)
+	}
+	return cache.summary
+}
+
+// serverProbeAddress returns the host and port of a ws or wss server URL,
+// defaulting the port from the scheme
+func serverProbeAddress(serverWs string) (string, int, error) {
+	parsed, err := url.Parse(serverWs)
+	if err != nil {
+		return "", 0, err
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return "", 0, fmt.Errorf("no host in server URL")
+	}
+	if portStr := parsed.Port(); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid port %q", portStr)
+		}
+		return host, port, nil
+	}
+	switch parsed.Scheme {
+	case "wss", "https":
+		return host, 443, nil
+	default:
+		return host, 80, nil
+	}
+}
+
+// WaitBeforePairing holds back the pairing server after the state was deleted
+// by deactivation or a decryption failure. For up to cfg.PairingBackoffDelay it
+// probes the last server (serverWs) over TCP every serverProbeInterval, so a
+// device that is briefly offline does not show a QR code right away. It returns
+// true as soon as the server answers, false when the delay runs out, the server
+// URL cannot be probed or ctx is done. Without PairingRetryOnDisconnect it
+// returns false immediately.
+func (pm *PairingManager) WaitBeforePairing(ctx context.Context, cfg config.ClientConfig, serverWs string) bool {
+	if !cfg.PairingRetryOnDisconnect {
+		return false
+	}
+	host, port, err := serverProbeAddress(serverWs)
+	if err != nil {
+		log.Printf("Cannot probe last server %s: %v", utils.RedactURL(serverWs), err)
+		return false
+	}
+
+	delay := cfg.GetPairingBackoffDelay()
+	log.Printf("Probing last server %s for up to %v before restarting pairing", utils.RedactURL(serverWs), delay)
+	ctx, cancel := context.WithTimeout(ctx, delay)
+	defer cancel()
+
+	ticker := time.NewTicker(serverProbeInterval)
+	defer ticker.Stop()
+	for {
+		probeCtx, probeCancel := context.WithTimeout(ctx, serverProbeTimeout)
+		_, err := utils.CheckPort(probeCtx, host, port, "tcp")
+		probeCancel()
+		if err == nil {
+			log.Println("Last server is reachable, restarting pairing")
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Last server unreachable: %v", err)
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pairing
+
+import (
+	"encoding/json"
+	"time"
+
+	"msm-client/control"
+	"msm-client/state"
+)
+
+// Control socket verbs served by the pairing manager
+const (
+	ControlVerbGet       = "pairing.get"
+	ControlVerbReset     = "pairing.reset"
+	ControlVerbBlacklist = "pairing.blacklist"
+	ControlVerbWebhook   = "pairing.webhook"
+)
+
+// PairingInfo is the live pairing state reported over the control socket
+type PairingInfo struct {
+	PairingCodeInfo
+	Expiry            time.Time `json:"expiry"`     // Same as ExpiresAt
+	FailCount         int       `json:"fail_count"` // Same as AttemptsUsed
+	AttemptsRemaining int       `json:"attempts_remaining"`
+}
+
+// BlacklistResult lists the blacklisted and violating IPs for pairing.blacklist
+type BlacklistResult struct {
+	MaxIPViolations int              `json:"max_ip_violations"`
+	Entries         []BlacklistEntry `json:"entries"` // Most severe first
+}
+
+// ResetResult reports what pairing.reset cleared
+type ResetResult struct {
+	StateDeleted   bool `json:"state_deleted"`
+	ServerNotified bool `json:"server_notified"` // The connected server was told why the device left
+}
+
+// ResetReasonLocal is the reason reported to the server when the pairing is reset on the device
+const ResetReasonLocal = "local_reset"
+
+// StateResetter deletes the pairing state for pairing.reset. It receives the
+// reason to report to the server and the function deleting the state, and
+// returns whether the server was notified.
+type StateResetter func(reason string, deleteState func() error) (serverNotified bool, err error)
+
+// SetStateResetter sets how pairing.reset deletes the state, typically
+// WebSocketManager.ResetConnection so the server hears about it. Passing nil
+// restores plain deletion.
+func (pm *PairingManager) SetStateResetter(resetter StateResetter) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.stateResetter = resetter
+}
+
+// resetState deletes the state through the state resetter if one is set
+func (pm *PairingManager) resetState(reason string) (bool, error) {
+	pm.callbackMutex.RLock()
+	resetter := pm.stateResetter
+	pm.callbackMutex.RUnlock()
+
+	if resetter == nil {
+		return false, state.DeleteState()
+	}
+	return resetter(reason, state.DeleteState)
+}
+
+// GetPairingInfo returns the current code, expiry, remaining validity and attempts.
+// Code and Expiry are empty once the code has expired or run out of attempts.
+func (pm *PairingManager) GetPairingInfo() PairingInfo {
+	pm.codeMutex.Lock()
+	defer pm.codeMutex.Unlock()
+
+	codeInfo := pm.pairingCodeInfoLocked()
+	return PairingInfo{
+		PairingCodeInfo:   codeInfo,
+		Expiry:            codeInfo.ExpiresAt,
+		FailCount:         codeInfo.AttemptsUsed,
+		AttemptsRemaining: pm.attemptsRemainingLocked(codeInfo.AttemptsMax),
+	}
+}
+
+// GetBlacklistResult returns the blacklisted and violating IPs with the violation limit
+func (pm *PairingManager) GetBlacklistResult() BlacklistResult {
+	cfg := pm.GetConfig()
+	return BlacklistResult{
+		MaxIPViolations: cfg.GetMaxIPViolations(),
+		Entries:         pm.GetBlacklistDetailed(),
+	}
+}
+
+// RegisterControlHandlers serves the pairing verbs on the control server
+func (pm *PairingManager) RegisterControlHandlers(server *control.Server) {
+	server.Handle(ControlVerbGet, func(_ json.RawMessage) (interface{}, error) {
+		return pm.GetPairingInfo(), nil
+	})
+
+	server.Handle(ControlVerbBlacklist, func(_ json.RawMessage) (interface{}, error) {
+		return pm.GetBlacklistResult(), nil
+	})
+
+	server.Handle(ControlVerbWebhook, func(_ json.RawMessage) (interface{}, error) {
+		return pm.GetWebhookStats(), nil
+	})
+
+	server.Handle(ControlVerbReset, func(_ json.RawMessage) (interface{}, error) {
+		pm.ResetPairing()
+
+		result := ResetResult{}
+		if state.HasState() {
+			notified, err := pm.resetState(ResetReasonLocal)
+			if err != nil {
+				return nil, err
+			}
+			result.StateDeleted = true
+			result.ServerNotified = notified
+		}
+		return result, nil
+	})
+}
@@ -0,0 +1,189 @@
+package pairing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/control"
+	"msm-client/state"
+	"msm-client/utils"
+)
+
+func startTestControlServer(t *testing.T, pm *PairingManager) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	server := control.NewServer(socketPath)
+	pm.RegisterControlHandlers(server)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return socketPath
+}
+
+func TestControlPairingGet(t *testing.T) {
+	pm := NewPairingManager()
+	pm.SetConfig(config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	})
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	defer os.Unsetenv("MSC_STATE_PATH")
+
+	socketPath := startTestControlServer(t, pm)
+
+	testCode := "123456"
+	pm.codeMutex.Lock()
+	pm.pairCode = testCode
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.failCount = 0
+	pm.codeMutex.Unlock()
+
+	handler := pm.HandleConfirm(pm.GetConfig())
+	for attempt := 1; attempt <= 3; attempt++ {
+		jsonBody, _ := json.Marshal(map[string]string{
+			"code":     "wrong1",
+			"serverWs": "ws://test-server:8080/ws",
+		})
+		req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code == http.StatusOK {
+			t.Fatalf("Attempt %d: wrong code should not pair", attempt)
+		}
+
+		var info PairingInfo
+		if err := control.CallPath(socketPath, ControlVerbGet, nil, &info); err != nil {
+			t.Fatalf("Attempt %d: pairing.get failed: %v", attempt, err)
+		}
+
+		expected := pm.GetPairingInfo()
+		if info.FailCount != expected.FailCount || info.FailCount != attempt {
+			t.Errorf("Attempt %d: expected fail count %d, socket reported %d", attempt, expected.FailCount, info.FailCount)
+		}
+		if info.AttemptsRemaining != expected.AttemptsRemaining || info.AttemptsRemaining != 3-attempt {
+			t.Errorf("Attempt %d: expected %d attempts remaining, socket reported %d", attempt, expected.AttemptsRemaining, info.AttemptsRemaining)
+		}
+		if info.Code != expected.Code || !info.Expiry.Equal(expected.Expiry) {
+			t.Errorf("Attempt %d: socket reported code %q/%v, manager has %q/%v", attempt, info.Code, info.Expiry, expected.Code, expected.Expiry)
+		}
+	}
+
+	// The code is invalidated once attempts run out, even though it is still in memory
+	var info PairingInfo
+	if err := control.CallPath(socketPath, ControlVerbGet, nil, &info); err != nil {
+		t.Fatalf("pairing.get failed: %v", err)
+	}
+	if info.Code != "" {
+		t.Errorf("Expected no code after max attempts, got %q", info.Code)
+	}
+}
+
+func TestControlPairingReset(t *testing.T) {
+	pm := NewPairingManager()
+	pm.SetConfig(config.ClientConfig{VerificationCodeAttempts: 3})
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	defer os.Unsetenv("MSC_STATE_PATH")
+	os.Setenv("MSC_PAIRING_PATH", tmpDir)
+	defer os.Unsetenv("MSC_PAIRING_PATH")
+
+	socketPath := startTestControlServer(t, pm)
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.failCount = 2
+	pm.codeMutex.Unlock()
+
+	if err := state.SaveState(state.PairedState{ServerWs: "ws://test-server:8080/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	var result ResetResult
+	if err := control.CallPath(socketPath, ControlVerbReset, nil, &result); err != nil {
+		t.Fatalf("pairing.reset failed: %v", err)
+	}
+	if !result.StateDeleted {
+		t.Error("Expected pairing.reset to report the state as deleted")
+	}
+	if result.ServerNotified {
+		t.Error("Without a state resetter the server cannot have been notified")
+	}
+	if state.HasState() {
+		t.Error("State should be deleted after pairing.reset")
+	}
+
+	info := pm.GetPairingInfo()
+	if info.Code != "" || info.FailCount != 0 {
+		t.Errorf("Expected pairing to be cleared, got code %q fail count %d", info.Code, info.FailCount)
+	}
+}
+
+func TestGetPairingCodeInfoLifecycle(t *testing.T) {
+	clock := utils.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	pm := NewPairingManager()
+	pm.clock = clock
+	pm.SetConfig(config.ClientConfig{VerificationCodeAttempts: 3})
+
+	if info := pm.GetPairingCodeInfo(); info.Code != "" || info.Remaining != 0 || info.AttemptsMax != 3 {
+		t.Errorf("Expected no code before pairing, got %+v", info)
+	}
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.expiry = clock.Deadline(time.Minute)
+	pm.codeMutex.Unlock()
+
+	info := pm.GetPairingCodeInfo()
+	if info.Code != "123456" || info.Remaining != time.Minute || info.AttemptsUsed != 0 || !info.ExpiresAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Unexpected info for a new code: %+v", info)
+	}
+
+	clock.Advance(18 * time.Second)
+	pm.codeMutex.Lock()
+	pm.failCount = 1
+	pm.codeMutex.Unlock()
+	info = pm.GetPairingCodeInfo()
+	if summary := info.Summary(); summary != "expires in 42s, 1/3 attempts used" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+	if code, expiry := pm.GetPairingCode(); code != info.Code || !expiry.Equal(info.ExpiresAt) {
+		t.Errorf("GetPairingCode returned %q/%v, expected %q/%v", code, expiry, info.Code, info.ExpiresAt)
+	}
+
+	data := pm.display.GetTemplateData()
+	if data.RemainingSeconds != 42 || data.AttemptsUsed != 1 || data.AttemptsMax != 3 {
+		t.Errorf("Unexpected template data %+v", data)
+	}
+
+	// Attempts run out before the code expires
+	pm.codeMutex.Lock()
+	pm.failCount = 3
+	pm.codeMutex.Unlock()
+	if info := pm.GetPairingCodeInfo(); info.Code != "" || info.Remaining != 0 || info.AttemptsUsed != 3 {
+		t.Errorf("Expected no code after max attempts, got %+v", info)
+	}
+
+	// The code expires after its validity
+	pm.codeMutex.Lock()
+	pm.failCount = 0
+	pm.codeMutex.Unlock()
+	clock.Advance(42 * time.Second)
+	if info := pm.GetPairingCodeInfo(); info.Code != "" || !info.ExpiresAt.IsZero() {
+		t.Errorf("Expected no code after expiry, got %+v", info)
+	}
+}
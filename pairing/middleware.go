@@ -0,0 +1,302 @@
+package pairing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"msm-client/utils"
+)
+
+// RequestIDHeader carries the correlation ID of a pairing server request
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds caller-supplied request IDs
+const maxRequestIDLength = 128
+
+// Load shedding under memory pressure
+const (
+	memoryReadingTTL     = 5 * time.Second
+	pressureRetryAfter   = 30 * time.Second
+	pressureErrorMessage = "Service temporarily unavailable: system memory is low"
+)
+
+// Per-IP rate limit shared by the code-issuing endpoints
+const (
+	pairingRateLimit       = 30
+	pairingRateLimitWindow = time.Minute
+)
+
+// getMemoryUsage is a variable so tests can simulate memory pressure
+var getMemoryUsage = utils.GetMemoryUsage
+
+// quietLogPaths are polled frequently, so only failed requests to them are logged
+var quietLogPaths = map[string]bool{
+	"/display":           true,
+	"/display/code.json": true,
+}
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID assigned by the logging middleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// requestID returns the request ID of r, or an empty string if none was assigned
+func requestID(r *http.Request) string {
+	return RequestIDFromContext(r.Context())
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID reports whether a caller-supplied ID is safe to log and echo back
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder captures the response status for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush passes through to the underlying writer so handlers can still flush responses
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// withRequestLogging assigns each request an ID, honouring one supplied by the caller,
+// echoes it in the X-Request-ID response header and logs the completed request
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if quietLogPaths[r.URL.Path] && status < http.StatusBadRequest {
+			return
+		}
+		log.Printf("[%s] %s %s from %s: %d (%s)", id, r.Method, r.URL.Path, getClientIP(r), status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// writeJSONError writes a JSON error response including the request ID when one is assigned
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeJSONErrorWithFields(w, r, status, message, nil)
+}
+
+// writeJSONErrorWithFields is like writeJSONError but adds extra fields to the body
+func writeJSONErrorWithFields(w http.ResponseWriter, r *http.Request, status int, message string, fields map[string]any) {
+	body := map[string]any{"error": message}
+	for key, value := range fields {
+		body[key] = value
+	}
+	if id := requestID(r); id != "" {
+		body["request_id"] = id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// ErrorCodeBodyTooLarge is the error_code of a 413 response to an oversized request body
+const ErrorCodeBodyTooLarge = "BODY_TOO_LARGE"
+
+// ErrorCodeClientIDMismatch is the error_code of a 409 response to a confirm
+// whose expectedClientId is not the client ID of this device
+const ErrorCodeClientIDMismatch = "CLIENT_ID_MISMATCH"
+
+// clientIDPrefixLength is the number of client ID characters a mismatch reveals
+const clientIDPrefixLength = 8
+
+// clientIDPrefix returns the start of clientID reported on a client ID mismatch
+func clientIDPrefix(clientID string) string {
+	if len(clientID) > clientIDPrefixLength {
+		return clientID[:clientIDPrefixLength]
+	}
+	return clientID
+}
+
+// PairingError is the JSON body of a pairing server error response
+type PairingError struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code,omitempty"` // Machine-readable cause, set for some errors
+	RequestID string `json:"request_id,omitempty"`
+
+	ClientIDPrefix string `json:"client_id_prefix,omitempty"` // Set on a client ID mismatch
+
+}
+
+// writeBodyTooLarge answers a request whose body exceeded limitRequestBody
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	writeJSONErrorWithFields(w, r, http.StatusRequestEntityTooLarge, "request body too large", map[string]any{
+		"error_code": ErrorCodeBodyTooLarge,
+	})
+}
+
+// isBodyTooLarge reports whether err was caused by exceeding the request body limit
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// memoryReading caches the available memory so /proc/meminfo is read at most once per memoryReadingTTL
+type memoryReading struct {
+	mu        sync.Mutex
+	available uint64
+	ok        bool
+	readAt    time.Time
+}
+
+// availableBytes returns the cached available memory, refreshing it when stale.
+// ok is false when memory usage cannot be determined.
+func (m *memoryReading) availableBytes() (available uint64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.readAt.IsZero() || time.Since(m.readAt) >= memoryReadingTTL {
+		usage, err := getMemoryUsage()
+		m.available, m.ok = usage.AvailableBytes, err == nil
+		m.readAt = time.Now()
+	}
+	return m.available, m.ok
+}
+
+// systemPressureMiddleware returns a wrapper that rejects requests with 503 and
+// Retry-After while available memory is below minAvailable. Handlers wrapped by
+// the same returned function share one cached memory reading.
+func systemPressureMiddleware(minAvailable uint64) func(http.Handler) http.Handler {
+	reading := &memoryReading{}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if available, ok := reading.availableBytes(); ok && available < minAvailable {
+				log.Printf("Rejecting %s under memory pressure: %d bytes available, %d required", r.URL.Path, available, minAvailable)
+				w.Header().Set("Retry-After", strconv.Itoa(int(pressureRetryAfter.Seconds())))
+				writeJSONError(w, r, http.StatusServiceUnavailable, pressureErrorMessage)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateWindow counts the requests of one client IP in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter allows up to limit requests per client IP in fixed windows
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	clients   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+// allow records a request from ip at now and reports whether it is within the
+// limit; when it is not, retryAfter is the time until the window resets
+func (rl *rateLimiter) allow(ip string, now time.Time) (allowed bool, retryAfter time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Drop idle clients at most once per window so the map stays bounded
+	if now.Sub(rl.lastPrune) >= rl.window {
+		for clientIP, w := range rl.clients {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.clients, clientIP)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	w, ok := rl.clients[ip]
+	if !ok || now.Sub(w.start) >= rl.window {
+		w = &rateWindow{start: now}
+		rl.clients[ip] = w
+	}
+	if w.count >= rl.limit {
+		return false, w.start.Add(rl.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// rateLimitMiddleware returns a wrapper that rejects requests with 429 and
+// Retry-After once a client IP exceeds limit requests per window. Handlers
+// wrapped by the same returned function share one budget per IP.
+func rateLimitMiddleware(limit int, window time.Duration) func(http.Handler) http.Handler {
+	limiter := &rateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*rateWindow),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := getClientIP(r)
+			if allowed, retryAfter := limiter.allow(clientIP, time.Now()); !allowed {
+				log.Printf("Rate limit exceeded for %s on %s", clientIP, r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+				writeJSONError(w, r, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestRequestLoggingMiddleware(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	}
+	pm.SetConfig(cfg)
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.codeMutex.Unlock()
+
+	var failedRequestID string
+	pm.SetOnPairingFailedWithRequest(func(reason string, failCount int, requestID string) {
+		failedRequestID = requestID
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pair/confirm", pm.HandleConfirm(cfg))
+	handler := withRequestLogging(mux)
+
+	t.Run("Caller-supplied ID round-trips", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(`{"code":"WRONG1","serverWs":"ws://test"}`))
+		req.RemoteAddr = "192.168.1.100:12345"
+		req.Header.Set(RequestIDHeader, "provision-42")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401, got %d", rr.Code)
+		}
+		if id := rr.Header().Get(RequestIDHeader); id != "provision-42" {
+			t.Errorf("Expected %s header provision-42, got %q", RequestIDHeader, id)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected JSON error body, got %q", rr.Body.String())
+		}
+		if body["request_id"] != "provision-42" {
+			t.Errorf("Expected request_id provision-42 in error body, got %v", body["request_id"])
+		}
+		if failedRequestID != "provision-42" {
+			t.Errorf("Expected failure callback to receive provision-42, got %q", failedRequestID)
+		}
+	})
+
+	t.Run("Generated ID", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader("invalid json"))
+		req.RemoteAddr = "192.168.1.100:12345"
+		req.Header.Set(RequestIDHeader, "bad id with spaces")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		id := rr.Header().Get(RequestIDHeader)
+		if id == "" || id == "bad id with spaces" {
+			t.Errorf("Expected a generated request ID, got %q", id)
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected JSON error body, got %q", rr.Body.String())
+		}
+		if body["request_id"] != id {
+			t.Errorf("Expected request_id %q in error body, got %q", id, body["request_id"])
+		}
+	})
+}
+
+func TestSystemPressureMiddleware(t *testing.T) {
+	original := getMemoryUsage
+	defer func() { getMemoryUsage = original }()
+
+	var reads int
+	available := uint64(10 * 1024 * 1024)
+	getMemoryUsage = func() (utils.MemoryUsage, error) {
+		reads++
+		return utils.MemoryUsage{TotalBytes: 512 * 1024 * 1024, AvailableBytes: available}, nil
+	}
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{MinAvailableMemoryBytes: 50 * 1024 * 1024, DisableConnectivityCheck: true}
+	pm.SetConfig(cfg)
+
+	shed := systemPressureMiddleware(cfg.GetMinAvailableMemoryBytes())
+	handler := withRequestLogging(shed(pm.HandlePair(cfg)))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/pair", nil)
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected 503 under memory pressure, got %d", rr.Code)
+		}
+		if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "30" {
+			t.Errorf("Expected Retry-After 30, got %q", retryAfter)
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected JSON error body: %v", err)
+		}
+		if body["error"] == "" || body["request_id"] == "" {
+			t.Errorf("Expected error and request_id in body, got %v", body)
+		}
+	}
+
+	if reads != 1 {
+		t.Errorf("Expected memory to be read once within the cache TTL, got %d reads", reads)
+	}
+
+	t.Run("Sufficient memory", func(t *testing.T) {
+		available = 200 * 1024 * 1024
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		rr := httptest.NewRecorder()
+		systemPressureMiddleware(cfg.GetMinAvailableMemoryBytes())(next).ServeHTTP(rr, httptest.NewRequest("GET", "/pair", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Expected request to pass through, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Memory unavailable", func(t *testing.T) {
+		getMemoryUsage = func() (utils.MemoryUsage, error) {
+			return utils.MemoryUsage{}, utils.ErrNotAvailable
+		}
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		rr := httptest.NewRecorder()
+		systemPressureMiddleware(cfg.GetMinAvailableMemoryBytes())(next).ServeHTTP(rr, httptest.NewRequest("GET", "/pair", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Expected request to pass through when memory is unknown, got %d", rr.Code)
+		}
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rateLimited := rateLimitMiddleware(2, time.Minute)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	pair := rateLimited(ok)
+	codeJSON := rateLimited(ok)
+
+	request := func(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/pair", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Both endpoints draw on the same per-IP budget
+	if rr := request(pair, "192.168.1.100:1"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected first request allowed, got %d", rr.Code)
+	}
+	if rr := request(codeJSON, "192.168.1.100:2"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected second request allowed, got %d", rr.Code)
+	}
+	rr := request(pair, "192.168.1.100:3")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 over the limit, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	if rr := request(codeJSON, "192.168.1.101:1"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another IP to have its own budget, got %d", rr.Code)
+	}
+}
+
+func TestRateLimiterWindowReset(t *testing.T) {
+	limiter := &rateLimiter{limit: 1, window: time.Minute, clients: make(map[string]*rateWindow)}
+	now := time.Now()
+
+	if allowed, _ := limiter.allow("10.0.0.1", now); !allowed {
+		t.Fatal("Expected first request allowed")
+	}
+	if allowed, retryAfter := limiter.allow("10.0.0.1", now.Add(20*time.Second)); allowed || retryAfter != 40*time.Second {
+		t.Errorf("Expected rejection with 40s retry, got allowed=%v retryAfter=%v", allowed, retryAfter)
+	}
+	if allowed, _ := limiter.allow("10.0.0.1", now.Add(time.Minute)); !allowed {
+		t.Error("Expected request allowed in the next window")
+	}
+}
@@ -1,19 +1,29 @@
 package pairing
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"msm-client/config"
+	"msm-client/control"
 	"msm-client/state"
 	"msm-client/utils"
 )
@@ -23,13 +33,19 @@ type PairingManager struct {
 	// Pairing code management
 	pairCode   string
 	expiry     time.Time
-	failCount  int
+	failCount  int    // Attempts used from the code's budget; see HandleConfirm for what counts
 	pairCodeIP string // IP address that generated the current pairing code
 	codeMutex  sync.Mutex
+	// clock creates and checks the code, blacklist and grace deadlines
+	clock utils.Clock
+	// generatingCode is set while HandlePair generates a code; codeCond (on
+	// codeMutex) is broadcast when it finishes
+	generatingCode bool
+	codeCond       *sync.Cond
 
 	// IP blacklist management
-	ipBlacklist    map[string]time.Time // IP -> blacklist expiry time
-	ipViolations   map[string]int       // IP -> violation count
+	ipBlacklist    map[string]time.Time           // IP -> blacklist expiry time
+	ipViolations   *utils.BoundedMap[string, int] // IP -> violation count, forgotten after the violation window
 	blacklistMutex sync.Mutex
 
 	// Server management
@@ -38,33 +54,99 @@ type PairingManager struct {
 	serverMutex    sync.RWMutex
 	serverRunning  bool
 	showingDisplay bool
+	// restartRequested is set when the watchdog stopped an unresponsive server; guarded by serverMutex
+	restartRequested bool
 
 	// Global configuration
 	globalConfig config.ClientConfig
 	configMutex  sync.RWMutex
 
 	// Callback functions for external use
-	onPairingStarted func(code string, expiry time.Time)
-	onPairingSuccess func(serverWs string)
-	onPairingFailed  func(reason string, failCount int)
-	onServerStarted  func(addr string)
-	onServerStopped  func()
-	callbackMutex    sync.RWMutex
+	onPairingStarted       func(code string, expiry time.Time)
+	onPairingSuccess       func(serverWs string)
+	onPairingFailed        func(reason string, failCount int)
+	onPairingFailedRequest func(reason string, failCount int, requestID string)
+	onServerStarted        func(addr string)
+	onServerStopped        func()
+	onCodeExpired          func(code string, reason string)
+	callbackMutex          sync.RWMutex
+
+	// Listeners added with AddOnPairingSuccess and AddOnPairingFailed, run
+	// after the callbacks above
+	pairingSuccessListeners []func(serverWs string)
+	pairingFailedListeners  []func(reason string, failCount int)
+
+	// Optional custom code validation, used instead of exact matching when set
+	confirmValidator ConfirmValidator
+
+	// Optional state deletion for pairing.reset that notifies the server first
+	stateResetter StateResetter
 
 	// Display manager
 	display *PairingDisplay
+
+	// Outcome of a successful confirm, delivered to Wait
+	resultCh chan PairingResult
+
+	// Successful confirm replayed to retries during the grace period; guarded by codeMutex
+	confirmed *confirmedPairing
+
+	// Delivers pairing events to the configured webhook
+	webhook *webhookNotifier
+
+	// Recent confirm outcomes shown on the admin view
+	attempts *utils.History[pairingAttempt]
+
+	// Last connectivity summary reported by /pair
+	connectivity connectivityCache
+}
+
+// confirmedPairing remembers a successful confirm so that a retry of the same
+// request, whose response the installer may have missed, gets the same answer
+type confirmedPairing struct {
+	code     string
+	clientIP string
+	response []byte
+	expires  time.Time
+	done     chan struct{} // Closed when the grace period is cleared early
+}
+
+// ConfirmValidator decides whether a submitted code is valid. It receives the
+// submitted code, the currently stored pairing code and the client IP, and
+// returns whether the code is accepted along with a reason when it is not.
+type ConfirmValidator func(submittedCode string, pairCode string, clientIP string) (valid bool, reason string)
+
+// PairingResult describes the outcome of a successful pairing
+type PairingResult struct {
+	ServerWs          string
+	SessionKeyPresent bool
+	PairedAt          time.Time
 }
 
-const DEFAULT_PATH = "/var/lib/msm-client"   // Default path for pairing file
-const PAIRING_CODE_FILE = "pairing_code.txt" // File name for pairing code
+// ErrPairingPortUnavailable is returned by StartPairingServerOnPort when neither the
+// pairing port nor any fallback port could be bound
+var ErrPairingPortUnavailable = errors.New("no pairing port available")
+
+// ErrNoPairingResult is returned by Wait when the pairing server is not running and no result is pending
+var ErrNoPairingResult = errors.New("pairing server stopped without successful pairing")
+
+const DEFAULT_PATH = "/var/lib/msm-client"          // Default path for pairing file
+const PAIRING_CODE_FILE = "pairing_code.txt"        // File name for pairing code
+const PAIRING_SESSION_FILE = "pairing_session.json" // File name for the pairing session restored after a restart
 
 // NewPairingManager creates a new PairingManager instance
 func NewPairingManager() *PairingManager {
 	pm := &PairingManager{
 		ipBlacklist:  make(map[string]time.Time),
-		ipViolations: make(map[string]int),
+		ipViolations: utils.NewBoundedMap[string, int](maxIPViolationEntries),
+		resultCh:     make(chan PairingResult, 1),
+		clock:        utils.SystemClock{},
+		webhook:      newWebhookNotifier(),
+		attempts:     utils.NewHistory[pairingAttempt](maxAttemptHistory),
 	}
 
+	pm.codeCond = sync.NewCond(&pm.codeMutex)
+
 	// Initialize the display manager
 	pm.display = NewPairingDisplay(pm)
 
@@ -137,10 +219,9 @@ func isIPInSameSubnet(ip1, ip2 string) bool {
 	return false
 }
 
-// validatePairingIP validates if the pairing attempt should be allowed based on IP
-func (pm *PairingManager) validatePairingIP(clientIP string) (bool, string) {
-	cfg := pm.GetConfig()
-
+// validatePairingIP validates if the pairing attempt should be allowed based on
+// IP, using the validation mode of cfg
+func (pm *PairingManager) validatePairingIP(cfg config.ClientConfig, clientIP string) (bool, string) {
 	// If IP validation is completely disabled, allow all
 	if cfg.DisableIPValidation {
 		log.Printf("IP validation disabled, allowing pairing from %s", clientIP)
@@ -184,7 +265,7 @@ func (pm *PairingManager) isIPBlacklisted(ip string) bool {
 	defer pm.blacklistMutex.Unlock()
 
 	if expiry, exists := pm.ipBlacklist[ip]; exists {
-		if time.Now().Before(expiry) {
+		if !pm.clock.Expired(expiry) {
 			return true
 		}
 		// Clean up expired blacklist entry
@@ -202,15 +283,18 @@ func (pm *PairingManager) recordIPViolation(ip string) bool {
 	maxViolations := cfg.GetMaxIPViolations()
 	blacklistDuration := cfg.GetIPBlacklistDuration()
 
-	// Increment violation count
-	pm.ipViolations[ip]++
-	violations := pm.ipViolations[ip]
+	// Increment violation count; the window restarts with every violation
+	violations, _ := pm.ipViolations.Get(ip)
+	violations++
+	if evicted := pm.ipViolations.Set(ip, violations, pm.clock.Deadline(cfg.GetIPViolationWindow())); evicted > 0 {
+		log.Printf("IP violation records full, forgot the %d oldest", evicted)
+	}
 
 	log.Printf("IP violation recorded for %s: %d/%d violations", ip, violations, maxViolations)
 
 	// Blacklist if max violations reached
 	if violations >= maxViolations {
-		pm.ipBlacklist[ip] = time.Now().Add(blacklistDuration)
+		pm.ipBlacklist[ip] = pm.clock.Deadline(blacklistDuration)
 		log.Printf("IP %s blacklisted for %v due to %d violations", ip, blacklistDuration, violations)
 		return true
 	}
@@ -218,19 +302,110 @@ func (pm *PairingManager) recordIPViolation(ip string) bool {
 	return false
 }
 
-// cleanupBlacklist removes expired blacklist entries
+// cleanupBlacklist removes expired blacklist entries and violation records
+// older than the violation window
 func (pm *PairingManager) cleanupBlacklist() {
 	pm.blacklistMutex.Lock()
 	defer pm.blacklistMutex.Unlock()
 
-	now := time.Now()
 	for ip, expiry := range pm.ipBlacklist {
-		if now.After(expiry) {
+		if pm.clock.Expired(expiry) {
 			delete(pm.ipBlacklist, ip)
-			delete(pm.ipViolations, ip) // Also reset violation count
+			pm.ipViolations.Delete(ip) // Also reset violation count
 			log.Printf("Removed expired blacklist entry for IP %s", ip)
 		}
 	}
+
+	if removed := pm.ipViolations.Expire(pm.clock.Expired); removed > 0 {
+		log.Printf("Removed %d expired IP violation records", removed)
+	}
+}
+
+// codesMatch compares a submitted code with the active code in constant time,
+// after normalizing both with NormalizePairingCode
+func codesMatch(submitted, active, codeType string) bool {
+	if active == "" {
+		return false
+	}
+	submitted = NormalizePairingCode(submitted, codeType)
+	active = NormalizePairingCode(active, codeType)
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(active)) == 1
+}
+
+// numericConfusables maps letters commonly typed in place of digits
+var numericConfusables = map[rune]rune{'O': '0', 'I': '1', 'L': '1'}
+
+// NormalizePairingCode returns the canonical form of a typed pairing code: upper
+// case, without whitespace or dashes, so "abcd 12" and "ABCD-12" match "ABCD12".
+// For numeric codes, the letters O, I and L become 0, 1 and 1.
+//
+// Normalization never merges two codes the generator can produce, so the
+// effective keyspace stays that of the charset: 36^length for alphanumeric codes
+// and 10^length for numeric ones. Alphanumeric codes contain both O and 0, and
+// both I and 1, so no confusable mapping is applied to them.
+func NormalizePairingCode(code, codeType string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		r = unicode.ToUpper(r)
+		if codeType == config.PairingCodeNumeric {
+			if digit, ok := numericConfusables[r]; ok {
+				return digit
+			}
+		}
+		return r
+	}, code)
+}
+
+// numericCodeGroupSize is the number of digits per group when displaying numeric codes
+const numericCodeGroupSize = 4
+
+// formatDisplayCode returns code as shown on the display and in the QR code:
+// numeric codes in space-separated groups for readability (1234 5678), other
+// codes unchanged. The canonical code never contains the separators.
+func formatDisplayCode(code, codeType string) string {
+	if codeType != config.PairingCodeNumeric || len(code) <= numericCodeGroupSize {
+		return code
+	}
+
+	var display strings.Builder
+	for i := 0; i < len(code); i += numericCodeGroupSize {
+		if i > 0 {
+			display.WriteByte(' ')
+		}
+		display.WriteString(code[i:min(i+numericCodeGroupSize, len(code))])
+	}
+	return display.String()
+}
+
+// generatePairingCode generates a code of the configured type and length
+func generatePairingCode(cfg config.ClientConfig) string {
+	charset := utils.AlphanumericCharset
+	if cfg.GetPairingCodeType() == config.PairingCodeNumeric {
+		charset = utils.NumericCharset
+	}
+	return utils.GenerateCodeFromCharset(cfg.GetPairingCodeLength(), charset)
+}
+
+// fingerprintKey keys code fingerprints so short codes cannot be brute-forced from logs
+var fingerprintKey = func() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}()
+
+// codeFingerprint returns a short keyed hash of a pairing code for log correlation
+// without revealing the code itself
+func codeFingerprint(code string) string {
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(code))
+	return "#" + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// CodeFilePath returns the path of the pairing code file
+func CodeFilePath() string {
+	return getPairingPath()
 }
 
 // getPairingPath returns the path for the pairing code file based on environment variable or default
@@ -241,7 +416,28 @@ func getPairingPath() string {
 	return filepath.Join(DEFAULT_PATH, PAIRING_CODE_FILE)
 }
 
-const pairingCodeCleanupInterval = 5 * time.Second
+// maxIPViolationEntries caps the IP violation records; the oldest are forgotten
+// beyond it. A variable so tests can lower it.
+var maxIPViolationEntries = 4096
+
+// pairingCodeCleanupInterval is how often expired codes and blacklist entries are cleaned up
+var pairingCodeCleanupInterval = 5 * time.Second
+
+// Pairing server timeouts, protecting against slow or stalled clients
+const (
+	pairingServerReadHeaderTimeout = 5 * time.Second
+	pairingServerReadTimeout       = 10 * time.Second
+	pairingServerWriteTimeout      = 10 * time.Second
+	pairingServerIdleTimeout       = 60 * time.Second
+)
+
+// limitRequestBody caps the request body at the configured pairing body limit.
+// Every handler reading a request body calls it first and answers a body that
+// exceeds the limit with writeBodyTooLarge.
+func (pm *PairingManager) limitRequestBody(w http.ResponseWriter, r *http.Request) {
+	cfg := pm.GetConfig()
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.GetPairingMaxBodyBytes()))
+}
 
 // IsServerRunning returns whether the pairing server is currently running
 func (pm *PairingManager) IsServerRunning() bool {
@@ -310,7 +506,31 @@ func (pm *PairingManager) SetOnPairingFailed(callback func(reason string, failCo
 	pm.onPairingFailed = callback
 }
 
-// SetOnServerStarted sets a callback for when the server starts
+// AddOnPairingSuccess adds a listener for successful pairing. Unlike
+// SetOnPairingSuccess it keeps the callbacks registered before it.
+func (pm *PairingManager) AddOnPairingSuccess(listener func(serverWs string)) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.pairingSuccessListeners = append(pm.pairingSuccessListeners, listener)
+}
+
+// AddOnPairingFailed adds a listener for failed pairing attempts. Unlike
+// SetOnPairingFailed it keeps the callbacks registered before it.
+func (pm *PairingManager) AddOnPairingFailed(listener func(reason string, failCount int)) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.pairingFailedListeners = append(pm.pairingFailedListeners, listener)
+}
+
+// SetOnPairingFailedWithRequest sets a callback for failed pairing attempts that
+// also receives the X-Request-ID of the failing request
+func (pm *PairingManager) SetOnPairingFailedWithRequest(callback func(reason string, failCount int, requestID string)) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.onPairingFailedRequest = callback
+}
+
+// SetOnServerStarted sets a callback for when the server starts; it receives the bound address
 func (pm *PairingManager) SetOnServerStarted(callback func(addr string)) {
 	pm.callbackMutex.Lock()
 	defer pm.callbackMutex.Unlock()
@@ -324,6 +544,39 @@ func (pm *PairingManager) SetOnServerStopped(callback func()) {
 	pm.onServerStopped = callback
 }
 
+// SetOnCodeExpired sets a callback for when the cleanup goroutine invalidates a code,
+// with reason "expired" or "max_attempts"
+func (pm *PairingManager) SetOnCodeExpired(callback func(code string, reason string)) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.onCodeExpired = callback
+}
+
+// SetConfirmValidator sets a custom code validator for HandleConfirm.
+// Passing nil restores the default exact-match comparison.
+func (pm *PairingManager) SetConfirmValidator(validator ConfirmValidator) {
+	pm.callbackMutex.Lock()
+	defer pm.callbackMutex.Unlock()
+	pm.confirmValidator = validator
+}
+
+// validateSubmittedCode checks a submitted code using the custom validator if set,
+// falling back to a constant-time exact match
+func (pm *PairingManager) validateSubmittedCode(submittedCode, pairCode, clientIP string) (bool, string) {
+	pm.callbackMutex.RLock()
+	validator := pm.confirmValidator
+	pm.callbackMutex.RUnlock()
+
+	if validator != nil {
+		return validator(submittedCode, pairCode, clientIP)
+	}
+	cfg := pm.GetConfig()
+	if !codesMatch(submittedCode, pairCode, cfg.GetPairingCodeType()) {
+		return false, "incorrect code"
+	}
+	return true, ""
+}
+
 // ClearAllCallbacks clears all callback functions
 func (pm *PairingManager) ClearAllCallbacks() {
 	pm.callbackMutex.Lock()
@@ -331,12 +584,19 @@ func (pm *PairingManager) ClearAllCallbacks() {
 	pm.onPairingStarted = nil
 	pm.onPairingSuccess = nil
 	pm.onPairingFailed = nil
+	pm.onPairingFailedRequest = nil
 	pm.onServerStarted = nil
 	pm.onServerStopped = nil
+	pm.onCodeExpired = nil
+	pm.pairingSuccessListeners = nil
+	pm.pairingFailedListeners = nil
 }
 
 // triggerCallback safely calls a callback function
 func (pm *PairingManager) triggerOnPairingStarted(code string, expiry time.Time) {
+	// The code itself is never sent to the webhook
+	pm.notifyWebhook(WebhookEventCodeGenerated, map[string]any{"expires_at": expiry.UTC().Format(time.RFC3339)})
+
 	pm.callbackMutex.RLock()
 	callback := pm.onPairingStarted
 	pm.callbackMutex.RUnlock()
@@ -346,24 +606,44 @@ func (pm *PairingManager) triggerOnPairingStarted(code string, expiry time.Time)
 }
 
 func (pm *PairingManager) triggerOnPairingSuccess(serverWs string) {
+	pm.notifyWebhook(WebhookEventPairingSucceeded, map[string]any{"server_url": utils.RedactURL(serverWs)})
+	pm.attempts.Add(pairingAttempt{At: pm.clock.Now(), Outcome: "success"})
+
 	pm.callbackMutex.RLock()
 	callback := pm.onPairingSuccess
+	listeners := pm.pairingSuccessListeners
 	pm.callbackMutex.RUnlock()
 	if callback != nil {
 		callback(serverWs)
 	}
+	for _, listener := range listeners {
+		listener(serverWs)
+	}
 }
 
-func (pm *PairingManager) triggerOnPairingFailed(reason string, failCount int) {
+func (pm *PairingManager) triggerOnPairingFailed(reason string, failCount int, requestID string) {
+	pm.notifyWebhook(WebhookEventPairingFailed, map[string]any{"reason": reason, "fail_count": failCount})
+	pm.attempts.Add(pairingAttempt{At: pm.clock.Now(), Outcome: "failed", Reason: reason, RequestID: requestID})
+
 	pm.callbackMutex.RLock()
 	callback := pm.onPairingFailed
+	requestCallback := pm.onPairingFailedRequest
+	listeners := pm.pairingFailedListeners
 	pm.callbackMutex.RUnlock()
 	if callback != nil {
 		callback(reason, failCount)
 	}
+	for _, listener := range listeners {
+		listener(reason, failCount)
+	}
+	if requestCallback != nil {
+		requestCallback(reason, failCount, requestID)
+	}
 }
 
 func (pm *PairingManager) triggerOnServerStarted(addr string) {
+	pm.notifyWebhook(WebhookEventServerStarted, map[string]any{"address": addr})
+
 	pm.callbackMutex.RLock()
 	callback := pm.onServerStarted
 	pm.callbackMutex.RUnlock()
@@ -373,6 +653,8 @@ func (pm *PairingManager) triggerOnServerStarted(addr string) {
 }
 
 func (pm *PairingManager) triggerOnServerStopped() {
+	pm.notifyWebhook(WebhookEventServerStopped, nil)
+
 	pm.callbackMutex.RLock()
 	callback := pm.onServerStopped
 	pm.callbackMutex.RUnlock()
@@ -381,8 +663,19 @@ func (pm *PairingManager) triggerOnServerStopped() {
 	}
 }
 
+func (pm *PairingManager) triggerOnCodeExpired(code string, reason string) {
+	pm.callbackMutex.RLock()
+	callback := pm.onCodeExpired
+	pm.callbackMutex.RUnlock()
+	if callback != nil {
+		callback(code, reason)
+	}
+}
+
 // StartPairingServerOnPort starts the pairing server on a specific port using the manager
-func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port int, enableDisplay bool) {
+// and blocks until it stops. Port 0 binds an ephemeral port, reported to SetOnServerStarted. When the port stays busy it falls back to
+// cfg.PairingFallbackPorts and returns ErrPairingPortUnavailable once all are exhausted.
+func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port int, enableDisplay bool) error {
 	// Set global configuration first (even in test mode)
 	pm.SetConfig(cfg)
 
@@ -391,22 +684,58 @@ func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port
 	// Check if server is already running
 	if pm.IsServerRunning() {
 		log.Println("Pairing server already running")
-		return
+		return nil
+	}
+
+	listener, err := listenPairingPort(port, cfg.PairingFallbackPorts, cfg.GetPairingBindAttempts())
+	if err != nil {
+		log.Printf("Pairing server failed to start: %v", err)
+		return err
+	}
+
+	// Pick up a code issued before the server was restarted
+	pm.RestorePairingSession()
+
+	// Discard any result left over from a previous pairing session
+	select {
+	case <-pm.resultCh:
+	default:
 	}
+	pm.ClearConfirmGrace()
+
+	// Shed pairing load when memory is critically low; the display stays available
+	shedUnderPressure := systemPressureMiddleware(cfg.GetMinAvailableMemoryBytes())
+
+	// /pair and the /display/ endpoints share one per-IP request budget
+	rateLimited := rateLimitMiddleware(pairingRateLimit, pairingRateLimitWindow)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/pair", pm.HandlePair(cfg))
-	mux.HandleFunc("/pair/confirm", pm.HandleConfirm(cfg))
+	mux.Handle("/pair", shedUnderPressure(rateLimited(pm.HandlePair(cfg))))
+	mux.Handle("/pair/confirm", shedUnderPressure(pm.HandleConfirm(cfg)))
+	mux.Handle("/pair/status", rateLimited(pm.HandleStatus(cfg)))
 
 	// Add pairing display route if enabled
 	if enableDisplay {
 		mux.HandleFunc("/display", pm.display.HandleQRCodeDisplay(cfg))
+		mux.Handle("/display/code.json", rateLimited(pm.display.HandleCodeJSON(cfg)))
+		// The display's own code requests bind no IP and are not rate limited
+		mux.HandleFunc("/display/new-code", pm.display.HandleNewCode(cfg))
+		mux.Handle("/display/ecdh-key", rateLimited(pm.display.HandleECDHKeyQR(cfg)))
+		mux.Handle("/display/admin", rateLimited(pm.HandleAdmin(cfg)))
+		mux.Handle("/display/admin/blacklist", rateLimited(pm.HandleAdminBlacklist(cfg)))
+		mux.Handle("/display/admin/clear-blacklist", rateLimited(pm.HandleAdminAction(cfg, pm.ClearBlacklist)))
+		mux.Handle("/display/admin/reset-code", rateLimited(pm.HandleAdminAction(cfg, pm.RegenerateCode)))
 	}
 
-	addr := fmt.Sprintf(":%d", port)
+	// The bound address carries the actual port when port 0 asked the OS for one
+	addr := listener.Addr().String()
 	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:              addr,
+		Handler:           withRequestLogging(mux),
+		ReadHeaderTimeout: pairingServerReadHeaderTimeout,
+		ReadTimeout:       pairingServerReadTimeout,
+		WriteTimeout:      pairingServerWriteTimeout,
+		IdleTimeout:       pairingServerIdleTimeout,
 	}
 
 	// Set the global server instance
@@ -427,25 +756,30 @@ func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port
 				pm.codeMutex.Lock()
 				cfg := pm.GetConfig()
 				maxAttempts := cfg.GetVerificationCodeAttempts()
-				if time.Now().After(pm.expiry) && pm.pairCode != "" {
-					log.Printf("Pairing code '%s' expired, invalidating code (had %d failed attempts)", pm.pairCode, pm.failCount)
-					pm.pairCode = ""
-					pm.pairCodeIP = ""
-					pm.expiry = time.Time{}
-					pm.failCount = 0
-					_ = pm.DeletePairingCode()
-					utils.ClearECDHKeys() // Clear ECDH keys when code expires
+				expiredCode, reason := pm.pairCode, ""
+				if pm.clock.Expired(pm.expiry) && pm.pairCode != "" {
+					log.Printf("Pairing code %s expired, invalidating code (had %d failed attempts)", codeFingerprint(pm.pairCode), pm.failCount)
+					reason = "expired"
 				} else if pm.failCount >= maxAttempts && pm.pairCode != "" {
-					log.Printf("Max pairing attempts reached for code '%s' (%d/%d failed attempts), invalidating code", pm.pairCode, pm.failCount, maxAttempts)
+					log.Printf("Max pairing attempts reached for code %s (%d/%d failed attempts), invalidating code", codeFingerprint(pm.pairCode), pm.failCount, maxAttempts)
+					reason = "max_attempts"
+				}
+				if reason != "" {
 					pm.pairCode = ""
 					pm.pairCodeIP = ""
 					pm.expiry = time.Time{}
 					pm.failCount = 0
 					_ = pm.DeletePairingCode()
-					utils.ClearECDHKeys() // Clear ECDH keys when max attempts reached
+					utils.ClearECDHKeys() // Clear ECDH keys with the invalidated code
 				}
 				pm.codeMutex.Unlock()
 
+				// Notify outside the lock so callbacks can query the manager
+				if reason != "" {
+					pm.display.recordCodeExpired(reason)
+					pm.triggerOnCodeExpired(expiredCode, reason)
+				}
+
 				// Clean up expired blacklist entries
 				pm.cleanupBlacklist()
 			case <-ctx.Done():
@@ -463,7 +797,7 @@ func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port
 	// Start server in a goroutine to avoid blocking
 	serverDone := make(chan error, 1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Printf("Pairing server failed: %v", err)
 			serverDone <- err
 		} else {
@@ -473,9 +807,72 @@ func (pm *PairingManager) StartPairingServerOnPort(cfg config.ClientConfig, port
 	}()
 
 	// Wait for server to finish
-	<-serverDone
+	err = <-serverDone
 	pm.clearServer()
 	pm.triggerOnServerStopped()
+	return err
+}
+
+// bindRetryDelay is the delay after the first failed bind; it doubles after each failure
+var bindRetryDelay = 500 * time.Millisecond
+
+// maxBindRetryDelay caps the delay between bind attempts
+const maxBindRetryDelay = 8 * time.Second
+
+// listenPairingPort binds the first available of port and fallbackPorts, trying
+// each up to attempts times with exponential backoff
+func listenPairingPort(port int, fallbackPorts []int, attempts int) (net.Listener, error) {
+	var lastErr error
+	for _, candidate := range append([]int{port}, fallbackPorts...) {
+		delay := bindRetryDelay
+		for attempt := 1; attempt <= attempts; attempt++ {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", candidate))
+			if err == nil {
+				if candidate != port {
+					log.Printf("Pairing port %d unavailable, using fallback port %d", port, candidate)
+				}
+				return listener, nil
+			}
+
+			lastErr = err
+			log.Printf("Failed to bind pairing port %d (attempt %d/%d): %v", candidate, attempt, attempts, err)
+			if attempt < attempts {
+				time.Sleep(delay)
+				delay = min(delay*2, maxBindRetryDelay)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrPairingPortUnavailable, lastErr)
+}
+
+// codeGenerationWait bounds how long a /pair request waits for a concurrent code generation
+const codeGenerationWait = 500 * time.Millisecond
+
+// generateECDHKeyPair creates the pairing session key pair; tests replace it to slow generation down
+var generateECDHKeyPair = utils.GenerateECDHKeyPair
+
+// waitForCodeGenerationLocked waits until no code is being generated, for at most
+// timeout. codeMutex must be held. It returns false if generation is still running.
+func (pm *PairingManager) waitForCodeGenerationLocked(timeout time.Duration) bool {
+	if !pm.generatingCode {
+		return true
+	}
+
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		pm.codeMutex.Lock()
+		defer pm.codeMutex.Unlock()
+		pm.codeCond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for pm.generatingCode {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		pm.codeCond.Wait()
+	}
+	return true
 }
 
 func (pm *PairingManager) HandlePair(cfg config.ClientConfig) http.HandlerFunc {
@@ -486,68 +883,73 @@ func (pm *PairingManager) HandlePair(cfg config.ClientConfig) http.HandlerFunc {
 		// Check if IP is blacklisted
 		if pm.isIPBlacklisted(clientIP) {
 			log.Printf("Pairing request rejected: IP %s is blacklisted", clientIP)
-			http.Error(w, "Access denied", http.StatusForbidden)
+			writeJSONError(w, r, http.StatusForbidden, "Access denied")
 			return
 		}
 
+		// Probe connectivity before taking the code lock so slow networks
+		// don't block other pairing requests
+		var connectivity *ConnectivitySummary
+		if cfg := pm.GetConfig(); !cfg.DisableConnectivityCheck {
+			connectivity = pm.connectivitySummary(r.Context(), cfg)
+		}
+
 		pm.codeMutex.Lock()
 		defer pm.codeMutex.Unlock()
 
+		// Another request is generating a code; wait for it rather than replacing it
+		if !pm.waitForCodeGenerationLocked(codeGenerationWait) {
+			log.Printf("Pairing code request from IP %s: code generation still in progress", clientIP)
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, r, http.StatusServiceUnavailable, "Pairing code generation in progress, please retry")
+			return
+		}
+
+		// The client paired and is only answering confirm retries until it connects
+		if pm.confirmed != nil {
+			log.Printf("Pairing code request from IP %s rejected: already paired", clientIP)
+			writeJSONError(w, r, http.StatusConflict, "Already paired")
+			return
+		}
+
 		// Check if a valid pairing code already exists
-		if pm.pairCode != "" && time.Now().Before(pm.expiry) {
-			log.Printf("Pairing code request from IP %s: existing valid code '%s' still active, expires at %s", clientIP, pm.pairCode, pm.expiry.Local().Format(time.RFC3339))
+		if pm.pairCode != "" && !pm.clock.Expired(pm.expiry) {
+			log.Printf("Pairing code request from IP %s: existing valid code %s still active, expires at %s", clientIP, codeFingerprint(pm.pairCode), pm.expiry.Local().Format(time.RFC3339))
 
-			// Return the existing code information
+			// Return the existing code information, never the code itself
+			currentCfg := pm.GetConfig()
+			attemptsRemaining := pm.attemptsRemainingLocked(currentCfg.GetVerificationCodeAttempts())
 			message := "Pairing code already active, "
-			if pm.showingDisplay {
+			if attemptsRemaining == 0 {
+				message = "Pairing code has no attempts remaining, please wait for it to expire."
+			} else if pm.showingDisplay {
 				message += "please check /display for the code."
 			} else {
 				message += "please check device for code."
 			}
 
-			_ = json.NewEncoder(w).Encode(map[string]any{
-				"message": message,
-				"expiry":  pm.expiry.Format(time.RFC3339),
-			})
+			response := map[string]any{
+				"message":            message,
+				"expiry":             pm.expiry.Format(time.RFC3339),
+				"attempts_remaining": attemptsRemaining,
+			}
+			if connectivity != nil {
+				response["connectivity"] = connectivity
+			}
+			_ = json.NewEncoder(w).Encode(response)
 			return
 		}
 
 		// Generate new code only if no valid code exists
-		cfg := pm.GetConfig()
-		codeLength := cfg.GetVerificationCodeLength()
-		codeExpiration := cfg.GetPairingCodeExpiration()
-		pm.pairCode = utils.GenerateCode(codeLength)
-		pm.pairCodeIP = clientIP
-		pm.expiry = time.Now().Add(codeExpiration)
-		pm.failCount = 0
-
-		log.Printf("Generated pairing code: %s for IP %s, expires at %s", pm.pairCode, clientIP, pm.expiry.Local().Format(time.RFC3339))
-
-		// Log IP validation configuration for transparency
-		cfg = pm.GetConfig()
-		if cfg.DisableIPValidation {
-			log.Printf("IP validation: DISABLED - any IP can confirm this pairing code")
-		} else if cfg.StrictIPValidation {
-			log.Printf("IP validation: STRICT - only IP %s can confirm this pairing code", clientIP)
-		} else if cfg.AllowIPSubnetMatch {
-			log.Printf("IP validation: SUBNET - IPs in same subnet as %s can confirm this pairing code", clientIP)
-		} else {
-			log.Printf("IP validation: PERMISSIVE - flexible IP validation enabled")
-		}
-
-		// Generate ECDH key pair for secure communication
-		log.Printf("Generating ECDH key pair for pairing session...")
-		if err := utils.GenerateECDHKeyPair(); err != nil {
-			log.Printf("Failed to generate ECDH key pair: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		if err := pm.generateCodeLocked(clientIP); err != nil {
+			if errors.Is(err, errCodeNotSaved) {
+				writeJSONError(w, r, http.StatusInternalServerError, "failed to persist pairing code")
+			} else {
+				writeJSONError(w, r, http.StatusInternalServerError, "Internal server error")
+			}
 			return
 		}
-		log.Printf("ECDH key pair generated successfully")
-
-		pm.SavePairingCode(pm.pairCode)
-
-		// Trigger pairing started callback
-		pm.triggerOnPairingStarted(pm.pairCode, pm.expiry)
+		cfg := pm.GetConfig()
 
 		message := "Pairing code generated, "
 
@@ -557,14 +959,104 @@ func (pm *PairingManager) HandlePair(cfg config.ClientConfig) http.HandlerFunc {
 			message += "please check device for code."
 		}
 
-		_ = json.NewEncoder(w).Encode(map[string]any{
+		response := map[string]any{
 			"deviceName": cfg.DeviceName,
 			"message":    message,
 			"expiry":     pm.expiry.Format(time.RFC3339),
-		})
+		}
+		if connectivity != nil {
+			response["connectivity"] = connectivity
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// errCodeNotSaved is returned by generateCodeLocked when the new code could not be persisted
+var errCodeNotSaved = errors.New("failed to persist pairing code")
+
+// generateCodeLocked generates a new pairing code bound to boundIP, or to no IP
+// when boundIP is empty, and persists it. codeMutex must be held; it is released
+// while the ECDH key pair is generated and concurrent requests wait on codeCond.
+func (pm *PairingManager) generateCodeLocked(boundIP string) error {
+	pm.generatingCode = true
+	pm.codeMutex.Unlock()
+	log.Printf("Generating ECDH key pair for pairing session...")
+	keyErr := generateECDHKeyPair()
+	pm.codeMutex.Lock()
+	pm.generatingCode = false
+	pm.codeCond.Broadcast()
+
+	if keyErr != nil {
+		log.Printf("Failed to generate ECDH key pair: %v", keyErr)
+		return keyErr
+	}
+	log.Printf("ECDH key pair generated successfully")
+
+	cfg := pm.GetConfig()
+	codeExpiration := cfg.GetPairingCodeExpiration()
+	pm.pairCode = generatePairingCode(cfg)
+	pm.pairCodeIP = boundIP
+	pm.expiry = pm.clock.Deadline(codeExpiration)
+	pm.failCount = 0
+
+	// Log IP validation configuration for transparency
+	if boundIP == "" {
+		log.Printf("Generated pairing code %s for the display, expires at %s", codeFingerprint(pm.pairCode), pm.expiry.Local().Format(time.RFC3339))
+		log.Printf("IP validation: UNBOUND - the display requested this code, any IP can confirm it")
+	} else {
+		log.Printf("Generated pairing code %s for IP %s, expires at %s", codeFingerprint(pm.pairCode), boundIP, pm.expiry.Local().Format(time.RFC3339))
+		if cfg.DisableIPValidation {
+			log.Printf("IP validation: DISABLED - any IP can confirm this pairing code")
+		} else if cfg.StrictIPValidation {
+			log.Printf("IP validation: STRICT - only IP %s can confirm this pairing code", boundIP)
+		} else if cfg.AllowIPSubnetMatch {
+			log.Printf("IP validation: SUBNET - IPs in same subnet as %s can confirm this pairing code", boundIP)
+		} else {
+			log.Printf("IP validation: PERMISSIVE - flexible IP validation enabled")
+		}
 	}
+
+	if err := pm.SavePairingCode(pm.pairCode, pm.expiry); err != nil {
+		// A code that cannot be shown on the device is useless, drop it so a retry generates a new one
+		log.Printf("Failed to save pairing code: %v", err)
+		pm.pairCode = ""
+		pm.pairCodeIP = ""
+		pm.expiry = time.Time{}
+		return fmt.Errorf("%w: %v", errCodeNotSaved, err)
+	}
+	if err := pm.savePairingSessionLocked(); err != nil {
+		log.Printf("Failed to save pairing session, a restart will require a new code: %v", err)
+	}
+
+	// Trigger pairing started callback
+	pm.triggerOnPairingStarted(pm.pairCode, pm.expiry)
+	return nil
 }
 
+// HandleConfirm serves /pair/confirm. Rejected confirms are counted against
+// two limits: the attempt budget of the code (failCount, up to
+// verification_code_attempts, after which the code is invalidated) and the
+// violations of the requesting IP (up to max_ip_violations within the
+// violation window, after which the IP is blacklisted):
+//
+//	Failure              Validation mode     Code budget  IP violation
+//	-------------------  ------------------  -----------  ------------
+//	incorrect code       any                 +1           +1
+//	IP mismatch          strict              -            +1
+//	IP mismatch          subnet              +1           +1
+//	IP mismatch          default, disabled   never rejected
+//	expired or max used  any                 -            -
+//	invalid request      any                 -            -
+//	client ID mismatch   any                 -            -
+//	blacklisted IP       any                 -            - (rejected first)
+//
+// In strict mode a foreign IP cannot use up the code of the device that
+// requested it, but is blacklisted; in subnet mode a mismatch is a wrong guess
+// like any other.
+//
+// An optional expectedClientId lets a provisioning tool assert which device
+// it is pairing; a different device answers 409 with the first characters of
+// its own client ID so the installer can locate the right one.
 func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get client IP
@@ -573,60 +1065,105 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 		// Check if IP is blacklisted
 		if pm.isIPBlacklisted(clientIP) {
 			log.Printf("Pairing confirmation rejected: IP %s is blacklisted", clientIP)
-			http.Error(w, "Access denied", http.StatusForbidden)
+			writeJSONError(w, r, http.StatusForbidden, "Access denied")
 			return
 		}
 
 		var req struct {
-			Code            string `json:"code"`
-			ServerWs        string `json:"serverWs"`
-			ServerPublicKey string `json:"serverPublicKey"` // Server's ECDH public key (base64)
+			Code             string `json:"code"`
+			ServerWs         string `json:"serverWs"`
+			ServerPublicKey  string `json:"serverPublicKey"`  // Server's ECDH public key (base64)
+			ExpectedClientID string `json:"expectedClientId"` // Client ID the provisioning tool expects, optional
+
+			SupportedAlgorithms []string `json:"supportedAlgorithms"` // Encryption algorithms the server supports
 		}
+		pm.limitRequestBody(w, r)
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			// Oversized bodies are rejected without counting as a failed attempt
+			if isBodyTooLarge(err) {
+				log.Printf("Pairing attempt rejected: request body too large from IP %s", clientIP)
+				writeBodyTooLarge(w, r)
+				return
+			}
+
 			log.Printf("Pairing attempt failed: invalid request format from IP %s", clientIP)
-			pm.triggerOnPairingFailed("invalid_request", pm.failCount)
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			pm.codeMutex.Lock()
+			failCount := pm.failCount
+			pm.codeMutex.Unlock()
+			pm.triggerOnPairingFailed("invalid_request", failCount, requestID(r))
+			writeJSONError(w, r, http.StatusBadRequest, "Invalid request")
 			return
 		}
+		// req.Code stays as submitted: the server derives the session key from
+		// the same string, so only comparisons use the normalized form
+		// One snapshot of the current config decides the whole attempt; the
+		// handler's cfg is the one the server started with
+		cfg := pm.GetConfig()
+		codeType := cfg.GetPairingCodeType()
 
 		pm.codeMutex.Lock()
 		defer pm.codeMutex.Unlock()
 
-		cfg := pm.GetConfig()
+		// A retry of the confirm that already succeeded gets the original response
+		if confirmed := pm.confirmed; confirmed != nil && !pm.clock.Expired(confirmed.expires) &&
+			confirmed.clientIP == clientIP && codesMatch(req.Code, confirmed.code, codeType) {
+			log.Printf("Repeated confirm from IP %s for the accepted code, replaying the pairing response", clientIP)
+			_, _ = w.Write(confirmed.response)
+			return
+		}
+
 		maxAttempts := cfg.GetVerificationCodeAttempts()
 
-		log.Printf("Pairing attempt received from IP %s: code='%s' (attempt %d/%d)", clientIP, req.Code, pm.failCount+1, maxAttempts)
+		log.Printf("Pairing attempt received from IP %s (attempt %d/%d)", clientIP, pm.failCount+1, maxAttempts)
 
 		// Validate IP based on configuration
-		if allowed, reason := pm.validatePairingIP(clientIP); !allowed {
+		if allowed, reason := pm.validatePairingIP(cfg, clientIP); !allowed {
 			log.Printf("Pairing attempt rejected: %s", reason)
 
-			// Only record violation for strict IP validation failures
-			cfg := pm.GetConfig()
-			if cfg.StrictIPValidation {
-				pm.recordIPViolation(clientIP)
+			pm.recordIPViolation(clientIP)
+			if !cfg.StrictIPValidation {
+				pm.failCount++
+				if err := pm.savePairingSessionLocked(); err != nil {
+					log.Printf("Failed to save pairing session: %v", err)
+				}
 			}
 
-			pm.triggerOnPairingFailed("ip_validation_failed", pm.failCount)
-			http.Error(w, "Access denied", http.StatusForbidden)
+			pm.triggerOnPairingFailed("ip_validation_failed", pm.failCount, requestID(r))
+			writeJSONError(w, r, http.StatusForbidden, "Access denied")
+			return
+		}
+
+		if req.ExpectedClientID != "" && req.ExpectedClientID != cfg.ClientID {
+			log.Printf("Pairing attempt rejected: confirm from IP %s expects another client ID", clientIP)
+			pm.triggerOnPairingFailed("client_id_mismatch", pm.failCount, requestID(r))
+			writeJSONErrorWithFields(w, r, http.StatusConflict, "Client ID mismatch", map[string]any{
+				"error_code":       ErrorCodeClientIDMismatch,
+				"client_id_prefix": clientIDPrefix(cfg.ClientID),
+			})
 			return
 		}
 
-		if time.Now().After(pm.expiry) || pm.failCount >= maxAttempts {
+		if pm.clock.Expired(pm.expiry) || pm.failCount >= maxAttempts {
 			log.Printf("Pairing attempt rejected: code expired or max attempts reached (failCount: %d)", pm.failCount)
-			pm.triggerOnPairingFailed("expired_or_max_attempts", pm.failCount)
-			http.Error(w, "Code expired or max attempts", http.StatusForbidden)
+			pm.triggerOnPairingFailed("expired_or_max_attempts", pm.failCount, requestID(r))
+			writeJSONError(w, r, http.StatusForbidden, "Code expired or max attempts")
 			return
 		}
-		if req.Code != pm.pairCode {
+		if valid, reason := pm.validateSubmittedCode(req.Code, pm.pairCode, clientIP); !valid {
 			pm.failCount++
-			log.Printf("Pairing attempt failed: incorrect code '%s' (expected '%s'). Fail count: %d/%d", req.Code, pm.pairCode, pm.failCount, maxAttempts)
-			pm.triggerOnPairingFailed("incorrect_code", pm.failCount)
-			http.Error(w, "Incorrect code", http.StatusUnauthorized)
+			log.Printf("Pairing attempt failed: %s. Fail count: %d/%d", reason, pm.failCount, maxAttempts)
+			pm.recordIPViolation(clientIP)
+			if err := pm.savePairingSessionLocked(); err != nil {
+				log.Printf("Failed to save pairing session: %v", err)
+			}
+			pm.triggerOnPairingFailed("incorrect_code", pm.failCount, requestID(r))
+			writeJSONErrorWithFields(w, r, http.StatusUnauthorized, "Incorrect code", map[string]any{
+				"attempts_remaining": pm.attemptsRemainingLocked(maxAttempts),
+			})
 			return
 		}
 
-		log.Printf("Pairing successful! Code '%s' accepted from IP %s. Connecting to %s", req.Code, clientIP, req.ServerWs)
+		log.Printf("Pairing successful! Code %s accepted from IP %s. Connecting to %s", codeFingerprint(NormalizePairingCode(req.Code, codeType)), clientIP, req.ServerWs)
 
 		// Perform ECDH key exchange if server public key is provided
 		var sessionKeyB64 string
@@ -635,7 +1172,7 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 			// Derive shared secret using ECDH
 			if err := utils.DeriveSharedSecret(req.ServerPublicKey); err != nil {
 				log.Printf("Failed to derive shared secret: %v", err)
-				http.Error(w, "Key exchange failed", http.StatusInternalServerError)
+				writeJSONError(w, r, http.StatusInternalServerError, "Key exchange failed")
 				return
 			}
 
@@ -643,7 +1180,7 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 			keyInfo := fmt.Sprintf("msm-pairing-%s", req.Code)
 			if err := utils.DeriveSessionKey(keyInfo); err != nil {
 				log.Printf("Failed to derive session key: %v", err)
-				http.Error(w, "Key derivation failed", http.StatusInternalServerError)
+				writeJSONError(w, r, http.StatusInternalServerError, "Key derivation failed")
 				return
 			}
 
@@ -651,7 +1188,7 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 			sessionKeyB64 = utils.GetSessionKey()
 			if sessionKeyB64 == "" {
 				log.Printf("Session key derivation succeeded but key is empty")
-				http.Error(w, "Session key invalid", http.StatusInternalServerError)
+				writeJSONError(w, r, http.StatusInternalServerError, "Session key invalid")
 				return
 			}
 
@@ -660,12 +1197,30 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 			log.Printf("No server public key provided, skipping ECDH key exchange")
 		}
 
+		// Agree on the message encryption algorithm with the server
+		encryptionAlgorithm := utils.NegotiateAlgorithm(cfg.GetEncryptionAlgorithm(), req.SupportedAlgorithms)
+		log.Printf("Using %s for message encryption", encryptionAlgorithm)
+
 		// Save the pairing state with session key if available
 		pairedState := state.PairedState{
-			ServerWs:   req.ServerWs,
-			SessionKey: sessionKeyB64, // Will be empty string if no ECDH was performed
+			ServerWs:            req.ServerWs,
+			SessionKey:          sessionKeyB64, // Will be empty string if no ECDH was performed
+			EncryptionAlgorithm: encryptionAlgorithm,
+			PairedAt:            pm.clock.Now(),
+		}
+		if err := state.SaveState(pairedState); err != nil {
+			// Keep the code, its attempts and the server so the installer can retry
+			log.Printf("Failed to save pairing state: %v", err)
+			writeJSONError(w, r, http.StatusInternalServerError, "failed to persist pairing state")
+			return
 		}
-		state.SaveState(pairedState)
+
+		// State is on disk, publish the result before the server is shut down
+		pm.publishResult(PairingResult{
+			ServerWs:          req.ServerWs,
+			SessionKeyPresent: sessionKeyB64 != "",
+			PairedAt:          pm.clock.Now(),
+		})
 
 		// Trigger success callback
 		pm.triggerOnPairingSuccess(req.ServerWs)
@@ -678,6 +1233,9 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 
 		// Get all network interfaces (Ethernet and WiFi only)
 		interfaces := utils.GetNetworkInterfaces()
+		if cfg.RedactNetworkIdentifiers {
+			interfaces = utils.RedactInterfaceInfo(interfaces)
+		}
 		networkInterfaces := make([]any, len(interfaces))
 		for i, iface := range interfaces {
 			networkInterfaces[i] = iface
@@ -700,6 +1258,8 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 			"deviceName":    cfg.DeviceName,
 			"interfaces":    networkInterfaces,
 			"ecdhPublicKey": ecdhPublicKeyB64,
+
+			"encryptionAlgorithm": encryptionAlgorithm,
 		}
 
 		// Include session key in response if available (for verification/debugging)
@@ -710,21 +1270,110 @@ func (pm *PairingManager) HandleConfirm(cfg config.ClientConfig) http.HandlerFun
 
 		// Clear ECDH keys after constructing response
 		utils.ClearECDHKeys()
+		_ = deletePairingSession()
 
-		_ = json.NewEncoder(w).Encode(responseData)
+		response, _ := json.Marshal(responseData)
+		response = append(response, '\n')
+		_, _ = w.Write(response)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
 
-		go func() {
-			// Give the HTTP response time to be sent
-			time.Sleep(100 * time.Millisecond)
-			server := pm.GetServer()
-			if server != nil {
-				_ = server.Shutdown(context.Background())
+		// Pairing is complete, invalidate the code while still holding codeMutex
+		pm.resetPairingLocked()
+
+		// Keep answering retries of this confirm until the grace period ends or ClearConfirmGrace is called
+		var graceDone <-chan struct{}
+		var graceTimer *time.Timer
+		if grace := cfg.GetPairingConfirmGrace(); grace > 0 {
+			pm.confirmed = &confirmedPairing{
+				code:     req.Code,
+				clientIP: clientIP,
+				response: response,
+				expires:  pm.clock.Deadline(grace),
+				done:     make(chan struct{}),
 			}
-			pm.ResetPairing()
+			graceDone = pm.confirmed.done
+			graceTimer = time.AfterFunc(grace, pm.ClearConfirmGrace)
+		}
+
+		// Shut down once the handler has returned and the grace period is over;
+		// Shutdown waits for this response to complete
+		defer func() {
+			go func() {
+				if graceDone != nil {
+					<-graceDone
+					graceTimer.Stop()
+				}
+				server := pm.GetServer()
+				if server != nil {
+					_ = server.Shutdown(context.Background())
+				}
+			}()
 		}()
 	}
 }
 
+// ClearConfirmGrace ends the grace period in which a repeated successful confirm is
+// answered again, letting the pairing server shut down. Call it once the WebSocket connects.
+func (pm *PairingManager) ClearConfirmGrace() {
+	pm.codeMutex.Lock()
+	defer pm.codeMutex.Unlock()
+	pm.clearConfirmGraceLocked()
+}
+
+// clearConfirmGraceLocked forgets the confirmed pairing; the caller must hold codeMutex
+func (pm *PairingManager) clearConfirmGraceLocked() {
+	if pm.confirmed != nil {
+		close(pm.confirmed.done)
+		pm.confirmed = nil
+	}
+}
+
+// publishResult delivers a pairing result to Wait, replacing any unread result
+func (pm *PairingManager) publishResult(result PairingResult) {
+	select {
+	case <-pm.resultCh:
+	default:
+	}
+	pm.resultCh <- result
+}
+
+// Wait returns the result of a successful pairing.
+// If the pairing server is still running it blocks until pairing succeeds or ctx is done.
+// Returns ErrNoPairingResult if the server is not running and no result is pending.
+func (pm *PairingManager) Wait(ctx context.Context) (PairingResult, error) {
+	select {
+	case result := <-pm.resultCh:
+		return result, nil
+	default:
+	}
+
+	if !pm.IsServerRunning() {
+		return PairingResult{}, ErrNoPairingResult
+	}
+
+	select {
+	case result := <-pm.resultCh:
+		return result, nil
+	case <-ctx.Done():
+		return PairingResult{}, ctx.Err()
+	}
+}
+
+// attemptsRemainingLocked returns how many confirm attempts the current code has left,
+// between 0 and maxAttempts; the caller must hold codeMutex
+func (pm *PairingManager) attemptsRemainingLocked(maxAttempts int) int {
+	remaining := maxAttempts - pm.failCount
+	if remaining < 0 {
+		return 0
+	}
+	if remaining > maxAttempts {
+		return maxAttempts
+	}
+	return remaining
+}
+
 func (pm *PairingManager) ValidateCode(code string) bool {
 	pm.codeMutex.Lock()
 	defer pm.codeMutex.Unlock()
@@ -732,10 +1381,10 @@ func (pm *PairingManager) ValidateCode(code string) bool {
 	cfg := pm.GetConfig()
 	maxAttempts := cfg.GetVerificationCodeAttempts()
 
-	if time.Now().After(pm.expiry) || pm.failCount >= maxAttempts {
+	if pm.clock.Expired(pm.expiry) || pm.failCount >= maxAttempts {
 		return false
 	}
-	if code != pm.pairCode {
+	if !codesMatch(code, pm.pairCode, cfg.GetPairingCodeType()) {
 		pm.failCount++
 		return false
 	}
@@ -746,6 +1395,31 @@ func (pm *PairingManager) ResetPairing() {
 	pm.codeMutex.Lock()
 	defer pm.codeMutex.Unlock()
 
+	pm.resetPairingLocked()
+}
+
+// RegenerateCode replaces the pairing code with a fresh one. Like a code
+// requested by the display, it is bound to no IP.
+func (pm *PairingManager) RegenerateCode() {
+	pm.codeMutex.Lock()
+	defer pm.codeMutex.Unlock()
+
+	if !pm.waitForCodeGenerationLocked(codeGenerationWait) {
+		log.Println("Pairing code not regenerated: code generation still in progress")
+		return
+	}
+	if pm.confirmed != nil {
+		log.Println("Pairing code not regenerated: already paired")
+		return
+	}
+	pm.resetPairingLocked()
+	if err := pm.generateCodeLocked(""); err != nil {
+		log.Printf("Failed to regenerate pairing code: %v", err)
+	}
+}
+
+// resetPairingLocked clears the pairing code; the caller must hold codeMutex
+func (pm *PairingManager) resetPairingLocked() {
 	pm.pairCode = ""
 	pm.pairCodeIP = ""
 	pm.expiry = time.Time{}
@@ -768,6 +1442,7 @@ func (pm *PairingManager) StopPairingServer() {
 		pm.clearServer()
 		pm.triggerOnServerStopped()
 		pm.ResetPairing()
+		pm.ClearConfirmGrace()
 	}
 
 	// Cancel cleanup goroutine if running
@@ -780,45 +1455,102 @@ func (pm *PairingManager) StopPairingServer() {
 	utils.ClearECDHKeys()
 }
 
-func (pm *PairingManager) GetPairingCode() (string, time.Time) {
+// PairingCodeInfo describes the active pairing code and its attempt budget
+type PairingCodeInfo struct {
+	Code         string        `json:"code,omitempty"` // Empty when no valid code exists
+	ExpiresAt    time.Time     `json:"expires_at"`
+	Remaining    time.Duration `json:"remaining"`     // Validity left, unaffected by wall-clock jumps
+	AttemptsUsed int           `json:"attempts_used"` // Failed confirms against the code
+	AttemptsMax  int           `json:"attempts_max"`
+}
+
+// Summary describes the validity and attempts, e.g. "expires in 42s, 1/3 attempts used"
+func (info PairingCodeInfo) Summary() string {
+	return fmt.Sprintf("expires in %s, %d/%d attempts used", info.Remaining.Round(time.Second), info.AttemptsUsed, info.AttemptsMax)
+}
+
+// GetPairingCodeInfo returns the current code with its remaining validity and attempts.
+// Code, ExpiresAt and Remaining are empty once the code has expired or run out of attempts.
+func (pm *PairingManager) GetPairingCodeInfo() PairingCodeInfo {
 	pm.codeMutex.Lock()
 	defer pm.codeMutex.Unlock()
+	return pm.pairingCodeInfoLocked()
+}
 
+// pairingCodeInfoLocked builds the PairingCodeInfo; the caller must hold codeMutex
+func (pm *PairingManager) pairingCodeInfoLocked() PairingCodeInfo {
 	cfg := pm.GetConfig()
-	maxAttempts := cfg.GetVerificationCodeAttempts()
+	info := PairingCodeInfo{
+		AttemptsUsed: pm.failCount,
+		AttemptsMax:  cfg.GetVerificationCodeAttempts(),
+	}
 
-	if time.Now().After(pm.expiry) || pm.failCount >= maxAttempts {
-		return "", time.Time{}
+	remaining := pm.clock.Remaining(pm.expiry)
+	if pm.pairCode != "" && remaining > 0 && pm.failCount < info.AttemptsMax {
+		info.Code = pm.pairCode
+		info.ExpiresAt = pm.expiry
+		info.Remaining = remaining
 	}
-	return pm.pairCode, pm.expiry
+	return info
+}
+
+// GetPairingCode returns the current code and its expiry, or empty values when no valid code exists
+func (pm *PairingManager) GetPairingCode() (string, time.Time) {
+	info := pm.GetPairingCodeInfo()
+	return info.Code, info.ExpiresAt
 }
 
-func (pm *PairingManager) GetPairingStatus() (string, time.Time, int) {
+// GetPairingStatus returns the current code, or "expired" once it has expired
+// or used its attempt budget, with its expiry, the attempts used from the
+// code's budget and the violations recorded for ip. See HandleConfirm for what
+// counts against each.
+func (pm *PairingManager) GetPairingStatus(ip string) (string, time.Time, int, int) {
+	status := pm.GetAttemptStatus(ip)
+
 	pm.codeMutex.Lock()
 	defer pm.codeMutex.Unlock()
 
-	cfg := pm.GetConfig()
-	maxAttempts := cfg.GetVerificationCodeAttempts()
-
-	if time.Now().After(pm.expiry) || pm.failCount >= maxAttempts {
-		return "expired", time.Time{}, pm.failCount
+	if pm.clock.Expired(pm.expiry) || pm.failCount >= status.AttemptsMax {
+		return "expired", time.Time{}, pm.failCount, status.IPViolations
 	}
-	return pm.pairCode, pm.expiry, pm.failCount
+	return pm.pairCode, pm.expiry, pm.failCount, status.IPViolations
 }
 
+// WatchPairingCode prints the pairing code whenever it or its attempt count changes,
+// asking the running daemon and falling back to the code file when it is not running
 func (pm *PairingManager) WatchPairingCode(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	lastCode := ""
+	lastAttempts := -1
 
 	// Check immediately on start
 	checkCode := func() {
-		code, err := pm.LoadPairingCode()
-		if err != nil {
-			log.Printf("Failed to load pairing code")
+		var info PairingInfo
+		if err := control.Call(ControlVerbGet, nil, &info); err == nil {
+			if info.Code == lastCode && info.AttemptsUsed == lastAttempts {
+				return
+			}
+			lastCode, lastAttempts = info.Code, info.AttemptsUsed
+			if info.Code == "" {
+				log.Printf("Pairing code cleared or expired (%d/%d attempts used)", info.AttemptsUsed, info.AttemptsMax)
+				return
+			}
+			log.Printf("Current pairing code: %s (%s)", info.Code, info.Summary())
+			return
 		}
 
+		file, err := pm.LoadPairingCodeFile()
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to load pairing code: %v", err)
+		}
+
+		now := time.Now()
+		code := file.Code
+		if file.Expired(now) {
+			code = ""
+		}
 		if code != lastCode {
 			lastCode = code
 			if code == "" {
@@ -826,7 +1558,7 @@ func (pm *PairingManager) WatchPairingCode(interval time.Duration) {
 				return
 			}
 
-			log.Printf("Current pairing code: %s", code)
+			log.Printf("Current pairing code: %s (%s)", code, file.Summary(now))
 		}
 	}
 
@@ -839,26 +1571,82 @@ func (pm *PairingManager) WatchPairingCode(interval time.Duration) {
 	}
 }
 
-func (pm *PairingManager) SavePairingCode(code string) error {
-	pairingPath := getPairingPath()
+// PairingCodeFile is the content of the pairing code file, readable without
+// the daemon
+type PairingCodeFile struct {
+	Code        string    `json:"code"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	AttemptsMax int       `json:"attempts_max,omitempty"`
+}
 
-	// Create directory if it doesn't exist
-	if dir := filepath.Dir(pairingPath); dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
+// Expired reports whether the code expired by now; files in the legacy format
+// carry no expiry and never count as expired
+func (f PairingCodeFile) Expired(now time.Time) bool {
+	return !f.ExpiresAt.IsZero() && !now.Before(f.ExpiresAt)
+}
+
+// Summary describes the expiry of the code for the CLI
+func (f PairingCodeFile) Summary(now time.Time) string {
+	if f.ExpiresAt.IsZero() {
+		return "possibly stale"
 	}
+	return fmt.Sprintf("expires in %s, up to %d attempts, possibly stale", f.ExpiresAt.Sub(now).Round(time.Second), f.AttemptsMax)
+}
 
-	return os.WriteFile(pairingPath, []byte(code), 0600)
+// SavePairingCode writes code with its expiry and attempt budget to the
+// pairing code file
+func (pm *PairingManager) SavePairingCode(code string, expiresAt time.Time) error {
+	cfg := pm.GetConfig()
+	data, err := json.Marshal(PairingCodeFile{
+		Code:        code,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now(),
+		AttemptsMax: cfg.GetVerificationCodeAttempts(),
+	})
+	if err != nil {
+		return err
+	}
+	// Any local user who can read the code could pair the device from localhost
+	return utils.WritePrivateFile(getPairingPath(), data)
 }
 
+// LoadPairingCode returns the code of the pairing code file
 func (pm *PairingManager) LoadPairingCode() (string, error) {
+	file, err := pm.LoadPairingCodeFile()
+	if err != nil {
+		return "", err
+	}
+	return file.Code, nil
+}
+
+// LoadPairingCodeFile reads the pairing code file. Files written before it
+// held JSON contain only the code.
+func (pm *PairingManager) LoadPairingCodeFile() (PairingCodeFile, error) {
 	pairingPath := getPairingPath()
 	data, err := os.ReadFile(pairingPath)
 	if err != nil {
-		return "", err
+		return PairingCodeFile{}, err
 	}
-	return string(data), nil
+	return parsePairingCodeFile(data)
+}
+
+// parsePairingCodeFile parses the JSON or the legacy bare-code content of the
+// pairing code file
+func parsePairingCodeFile(data []byte) (PairingCodeFile, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		// Hand-written files usually end in a newline
+		return PairingCodeFile{Code: strings.TrimSpace(string(data))}, nil
+	}
+
+	var file PairingCodeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return PairingCodeFile{}, fmt.Errorf("corrupted pairing code file: %w", err)
+	}
+	if file.Code == "" {
+		return PairingCodeFile{}, fmt.Errorf("corrupted pairing code file: no code")
+	}
+	return file, nil
 }
 
 func (pm *PairingManager) DeletePairingCode() error {
@@ -868,7 +1656,8 @@ func (pm *PairingManager) DeletePairingCode() error {
 		return fmt.Errorf("failed to delete pairing code file: %w", err)
 	}
 
-	return nil
+	// The key pair lives as long as the code
+	return deletePairingSession()
 }
 
 // GetBlacklistStatus returns the current blacklist status
@@ -878,19 +1667,75 @@ func (pm *PairingManager) GetBlacklistStatus() map[string]time.Time {
 
 	result := make(map[string]time.Time)
 	for ip, expiry := range pm.ipBlacklist {
-		if time.Now().Before(expiry) {
+		if !pm.clock.Expired(expiry) {
 			result[ip] = expiry
 		}
 	}
 	return result
 }
 
+// BlacklistEntry is an IP with recorded violations or an active blacklisting
+type BlacklistEntry struct {
+	IP          string        `json:"ip"`
+	Violations  int           `json:"violations"` // Violations within the violation window
+	Blacklisted bool          `json:"blacklisted"`
+	Until       time.Time     `json:"until,omitempty"`     // Blacklist expiry; zero when not blacklisted
+	Remaining   time.Duration `json:"remaining,omitempty"` // Time until Until
+}
+
+// GetBlacklistDetailed returns every blacklisted IP and every IP with
+// violations below the limit, most severe first: blacklisted IPs by remaining
+// time, then the others by violation count
+func (pm *PairingManager) GetBlacklistDetailed() []BlacklistEntry {
+	pm.blacklistMutex.Lock()
+	defer pm.blacklistMutex.Unlock()
+
+	pm.ipViolations.Expire(pm.clock.Expired)
+	entries := make(map[string]*BlacklistEntry)
+	pm.ipViolations.Range(func(ip string, violations int) bool {
+		entries[ip] = &BlacklistEntry{IP: ip, Violations: violations}
+		return true
+	})
+	for ip, expiry := range pm.ipBlacklist {
+		if pm.clock.Expired(expiry) {
+			continue
+		}
+		entry, ok := entries[ip]
+		if !ok {
+			entry = &BlacklistEntry{IP: ip}
+			entries[ip] = entry
+		}
+		entry.Blacklisted = true
+		entry.Until = expiry
+		entry.Remaining = pm.clock.Remaining(expiry)
+	}
+
+	result := make([]BlacklistEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		switch {
+		case a.Blacklisted != b.Blacklisted:
+			return a.Blacklisted
+		case a.Remaining != b.Remaining:
+			return a.Remaining > b.Remaining
+		case a.Violations != b.Violations:
+			return a.Violations > b.Violations
+		default:
+			return a.IP < b.IP
+		}
+	})
+	return result
+}
+
 // ClearBlacklist manually clears all blacklist entries (for admin use)
 func (pm *PairingManager) ClearBlacklist() {
 	pm.blacklistMutex.Lock()
 	defer pm.blacklistMutex.Unlock()
 
 	pm.ipBlacklist = make(map[string]time.Time)
-	pm.ipViolations = make(map[string]int)
+	pm.ipViolations.Clear()
 	log.Println("All blacklist entries cleared")
 }
@@ -2,15 +2,19 @@ package pairing
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"msm-client/config"
+	"msm-client/utils"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
@@ -20,6 +24,11 @@ type PairingDisplay struct {
 	templatePath   string
 	template       *template.Template
 	pairingManager *PairingManager // Reference to the pairing manager for code access
+
+	// Why the last code was invalidated by cleanup, shown while no code is active
+	expiryMutex       sync.RWMutex
+	lastExpiredReason string
+	lastExpiredAt     time.Time
 }
 
 // NewPairingDisplay creates a new PairingDisplay instance
@@ -31,6 +40,22 @@ func NewPairingDisplay(pairingManager *PairingManager) *PairingDisplay {
 	return pd
 }
 
+// recordCodeExpired notes that the current code was invalidated for reason
+func (pd *PairingDisplay) recordCodeExpired(reason string) {
+	pd.expiryMutex.Lock()
+	defer pd.expiryMutex.Unlock()
+	pd.lastExpiredReason = reason
+	pd.lastExpiredAt = time.Now()
+}
+
+// LastExpired returns why and when the last code was invalidated, or an empty
+// reason when no code has expired
+func (pd *PairingDisplay) LastExpired() (string, time.Time) {
+	pd.expiryMutex.RLock()
+	defer pd.expiryMutex.RUnlock()
+	return pd.lastExpiredReason, pd.lastExpiredAt
+}
+
 // getTemplatePath returns the path to the pairing display template
 func getTemplatePath() string {
 	// Check if custom template path is set via environment variable
@@ -92,32 +117,85 @@ func (pd *PairingDisplay) GenerateQRCode(code string) ([]byte, error) {
 	return png, nil
 }
 
+// GenerateQRCodeSVG generates the QR code for the given pairing code as an SVG document
+func (pd *PairingDisplay) GenerateQRCodeSVG(code string) (string, error) {
+	qr, err := qrcode.New(code, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+
+	// One unit per module, including the quiet zone border
+	bitmap := qr.Bitmap()
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	size := len(bitmap)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		size, size, size, size, path.String()), nil
+}
+
 // TemplateData represents the data passed to the template
 type TemplateData struct {
 	Code        string
+	DisplayCode string // Code formatted for reading, e.g. numeric codes in groups
 	QRCodeImage string
 	Expiry      string
+	ExpiryISO   string // RFC 3339 expiry, used by the countdown when RemainingSeconds is unset
 	IsExpired   bool
 	HasCode     bool
+
+	RemainingSeconds int // Validity left when the page was rendered, for the countdown
+	AttemptsUsed     int
+	AttemptsMax      int
+
+	ExpiredReason string // "expired" or "max_attempts" when the last code was invalidated
+
+	HasECDHKey bool
+	ECDHKeyQR  string // Base64 PNG QR code of the client's ECDH public key
 }
 
 // GetTemplateData retrieves the current pairing code data for template rendering
 func (pd *PairingDisplay) GetTemplateData() *TemplateData {
-	currentCode, currentExpiry := pd.pairingManager.GetPairingCode()
+	info := pd.pairingManager.GetPairingCodeInfo()
+	currentCode, currentExpiry := info.Code, info.ExpiresAt
 
-	data := &TemplateData{}
+	data := &TemplateData{
+		AttemptsUsed: info.AttemptsUsed,
+		AttemptsMax:  info.AttemptsMax,
+	}
 
 	// Check if we have a valid code
 	if currentCode != "" {
 		data.HasCode = true
 		data.Code = currentCode
+		cfg := pd.pairingManager.GetConfig()
+		data.DisplayCode = formatDisplayCode(currentCode, cfg.GetPairingCodeType())
 		data.Expiry = currentExpiry.Local().Format("Jan 2, 2006 3:04:05 PM")
-		data.IsExpired = time.Now().After(currentExpiry)
+		data.ExpiryISO = currentExpiry.UTC().Format(time.RFC3339)
+		data.IsExpired = pd.pairingManager.clock.Expired(currentExpiry)
+		data.RemainingSeconds = int(info.Remaining.Round(time.Second) / time.Second)
 
-		// Generate QR code containing just the pairing code
-		if qrCodeData, err := pd.GenerateQRCode(currentCode); err == nil {
+		// Generate QR code containing just the pairing code, as displayed
+		if qrCodeData, err := pd.GenerateQRCode(data.DisplayCode); err == nil {
 			data.QRCodeImage = base64.StdEncoding.EncodeToString(qrCodeData)
 		}
+	} else {
+		data.ExpiredReason, _ = pd.LastExpired()
+	}
+
+	// The ECDH public key lets the server set up encryption out of band
+	if publicKey := utils.GetECDHPublicKey(); publicKey != "" {
+		data.HasECDHKey = true
+		if qrCodeData, err := pd.GenerateQRCode(publicKey); err == nil {
+			data.ECDHKeyQR = base64.StdEncoding.EncodeToString(qrCodeData)
+		}
 	}
 
 	return data
@@ -154,3 +232,110 @@ func (pd *PairingDisplay) HandleQRCodeDisplay(cfg config.ClientConfig) http.Hand
 		}
 	}
 }
+
+// HandleCodeJSON serves the current pairing code as JSON for automation
+func (pd *PairingDisplay) HandleCodeJSON(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+		info := pd.pairingManager.GetPairingInfo()
+		if info.Code == "" {
+			response := map[string]any{
+				"code":   nil,
+				"status": "no_code",
+			}
+			if reason, at := pd.LastExpired(); reason != "" {
+				response["expired_reason"] = reason
+				response["expired_at"] = at.UTC().Format(time.RFC3339)
+			}
+			_ = json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		pairingConfig := pd.pairingManager.GetConfig()
+		displayCode := formatDisplayCode(info.Code, pairingConfig.GetPairingCodeType())
+		response := map[string]any{
+			"code":               info.Code,
+			"display_code":       displayCode,
+			"expiry":             info.Expiry.UTC().Format(time.RFC3339),
+			"attempts_remaining": info.AttemptsRemaining,
+		}
+		if svg, err := pd.GenerateQRCodeSVG(displayCode); err == nil {
+			response["qr_svg"] = svg
+		} else {
+			log.Printf("Failed to generate QR code SVG: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// HandleNewCode serves POST /display/new-code, which the display page calls when
+// it has no code to show. Unlike /pair the code is bound to no IP, so the kiosk
+// does not become the only device that may confirm it, and the request is not
+// rate limited. An active code is kept rather than replaced.
+func (pd *PairingDisplay) HandleNewCode(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		pm := pd.pairingManager
+		clientIP := getClientIP(r)
+		if pm.isIPBlacklisted(clientIP) {
+			log.Printf("Display code request rejected: IP %s is blacklisted", clientIP)
+			writeJSONError(w, r, http.StatusForbidden, "Access denied")
+			return
+		}
+
+		pm.codeMutex.Lock()
+		defer pm.codeMutex.Unlock()
+
+		if !pm.waitForCodeGenerationLocked(codeGenerationWait) {
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, r, http.StatusServiceUnavailable, "Pairing code generation in progress, please retry")
+			return
+		}
+		if pm.confirmed != nil {
+			writeJSONError(w, r, http.StatusConflict, "Already paired")
+			return
+		}
+
+		if pm.pairCode == "" || pm.clock.Expired(pm.expiry) {
+			if err := pm.generateCodeLocked(""); err != nil {
+				writeJSONError(w, r, http.StatusInternalServerError, "Failed to generate pairing code")
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"expiry": pm.expiry.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// HandleECDHKeyQR serves the client's base64 ECDH public key as a PNG QR code
+func (pd *PairingDisplay) HandleECDHKeyQR(cfg config.ClientConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		publicKey := utils.GetECDHPublicKey()
+		if publicKey == "" {
+			writeJSONError(w, r, http.StatusNotFound, "No ECDH key available")
+			return
+		}
+
+		png, err := pd.GenerateQRCode(publicKey)
+		if err != nil {
+			log.Printf("Failed to generate ECDH key QR code: %v", err)
+			writeJSONError(w, r, http.StatusInternalServerError, "Failed to generate QR code")
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		_, _ = w.Write(png)
+	}
+}
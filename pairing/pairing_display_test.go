@@ -0,0 +1,284 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestHandleQRCodeDisplayExpiryAttribute(t *testing.T) {
+	os.Setenv("MSC_TEMPLATE_PATH", "../templates")
+	defer os.Unsetenv("MSC_TEMPLATE_PATH")
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{VerificationCodeAttempts: 3}
+	pm.SetConfig(cfg)
+
+	expiry := time.Now().Add(2 * time.Minute).Truncate(time.Second)
+	pm.codeMutex.Lock()
+	pm.pairCode = "ABC123"
+	pm.expiry = expiry
+	pm.codeMutex.Unlock()
+
+	req := httptest.NewRequest("GET", "/display", nil)
+	rr := httptest.NewRecorder()
+	pm.display.HandleQRCodeDisplay(cfg).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	match := regexp.MustCompile(`data-expiry="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+	if match == nil {
+		t.Fatal("Rendered page should contain a data-expiry attribute")
+	}
+
+	renderedExpiry, err := time.Parse(time.RFC3339, match[1])
+	if err != nil {
+		t.Fatalf("data-expiry %q is not a valid RFC 3339 timestamp: %v", match[1], err)
+	}
+	if !renderedExpiry.Equal(expiry) {
+		t.Errorf("Expected data-expiry %v, got %v", expiry, renderedExpiry)
+	}
+
+	t.Run("No active code", func(t *testing.T) {
+		pm.ResetPairing()
+
+		rr := httptest.NewRecorder()
+		pm.display.HandleQRCodeDisplay(cfg).ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		if strings.Contains(body, "data-expiry") {
+			t.Error("Page without a code should not contain a data-expiry attribute")
+		}
+		if !strings.Contains(body, "Requesting code...") {
+			t.Error("Page without a code should show the requesting state")
+		}
+	})
+}
+
+func TestHandleCodeJSON(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Cleanup(utils.ClearECDHKeys)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeLength:   6,
+		VerificationCodeAttempts: 3,
+		PairingCodeExpiration:    time.Minute,
+		DisableConnectivityCheck: true,
+	}
+	pm.SetConfig(cfg)
+
+	getCodeJSON := func() map[string]any {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		pm.display.HandleCodeJSON(cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/display/code.json", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode response %q: %v", rr.Body.String(), err)
+		}
+		return response
+	}
+
+	response := getCodeJSON()
+	if code, ok := response["code"]; !ok || code != nil || response["status"] != "no_code" {
+		t.Errorf("Expected no_code without an active code, got %v", response)
+	}
+
+	req := httptest.NewRequest("GET", "/pair", nil)
+	req.RemoteAddr = "192.168.1.100:12345"
+	pm.HandlePair(cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	code, expiry := pm.GetPairingCode()
+	if code == "" {
+		t.Fatal("Expected a pairing code")
+	}
+
+	response = getCodeJSON()
+	if response["code"] != code {
+		t.Errorf("Expected code %s, got %v", code, response["code"])
+	}
+	if response["expiry"] != expiry.UTC().Format(time.RFC3339) {
+		t.Errorf("Expected expiry %s, got %v", expiry.UTC().Format(time.RFC3339), response["expiry"])
+	}
+	if response["attempts_remaining"] != float64(3) {
+		t.Errorf("Expected 3 attempts remaining, got %v", response["attempts_remaining"])
+	}
+	if svg, _ := response["qr_svg"].(string); !strings.HasPrefix(svg, "<svg") {
+		t.Errorf("Expected an SVG QR code, got %q", svg)
+	}
+}
+
+func TestHandleNewCode(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Cleanup(utils.ClearECDHKeys)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeLength:   6,
+		VerificationCodeAttempts: 3,
+		PairingCodeExpiration:    time.Minute,
+		StrictIPValidation:       true,
+	}
+	pm.SetConfig(cfg)
+
+	requestCode := func(method string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/display/new-code", nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		rr := httptest.NewRecorder()
+		pm.display.HandleNewCode(cfg).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := requestCode("GET"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rr.Code)
+	}
+
+	rr := requestCode("POST")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	code, expiry := pm.GetPairingCode()
+	if code == "" {
+		t.Fatal("Expected a pairing code")
+	}
+	var response map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", rr.Body.String(), err)
+	}
+	if response["expiry"] != expiry.UTC().Format(time.RFC3339) {
+		t.Errorf("Expected expiry %s, got %v", expiry.UTC().Format(time.RFC3339), response["expiry"])
+	}
+
+	pm.codeMutex.Lock()
+	boundIP := pm.pairCodeIP
+	pm.codeMutex.Unlock()
+	if boundIP != "" {
+		t.Errorf("Expected the display's code to be bound to no IP, got %s", boundIP)
+	}
+	if allowed, reason := pm.validatePairingIP(pm.GetConfig(), "192.168.1.50"); !allowed {
+		t.Errorf("Expected any IP to confirm the display's code, got %s", reason)
+	}
+
+	if rr := requestCode("POST"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if current, _ := pm.GetPairingCode(); current != code {
+		t.Error("Expected the active code to be kept")
+	}
+}
+
+func TestFormatDisplayCode(t *testing.T) {
+	tests := []struct {
+		code, codeType, expected string
+	}{
+		{"12345678", config.PairingCodeNumeric, "1234 5678"},
+		{"1234567890", config.PairingCodeNumeric, "1234 5678 90"},
+		{"1234", config.PairingCodeNumeric, "1234"},
+		{"ABC123", config.PairingCodeAlphanumeric, "ABC123"},
+		{"12345678", config.PairingCodeAlphanumeric, "12345678"},
+	}
+	for _, test := range tests {
+		if display := formatDisplayCode(test.code, test.codeType); display != test.expected {
+			t.Errorf("formatDisplayCode(%q, %q) = %q, expected %q", test.code, test.codeType, display, test.expected)
+		}
+	}
+}
+
+func TestNumericPairingCode(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Setenv("MSC_TEMPLATE_PATH", "../templates")
+	t.Cleanup(utils.ClearECDHKeys)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		PairingCodeExpiration:    time.Minute,
+		PairingCodeType:          config.PairingCodeNumeric,
+		DisableConnectivityCheck: true,
+	}
+	pm.SetConfig(cfg)
+
+	req := httptest.NewRequest("GET", "/pair", nil)
+	req.RemoteAddr = "192.168.1.100:12345"
+	pm.HandlePair(cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	code, _ := pm.GetPairingCode()
+	if !regexp.MustCompile(`^[0-9]{8}$`).MatchString(code) {
+		t.Fatalf("Expected an 8-digit numeric code, got %q", code)
+	}
+	display := code[:4] + " " + code[4:]
+
+	rr := httptest.NewRecorder()
+	pm.display.HandleQRCodeDisplay(cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/display", nil))
+	if !strings.Contains(rr.Body.String(), ">"+display+"</div>") {
+		t.Errorf("Display page should show the code as %q", display)
+	}
+
+	rr = httptest.NewRecorder()
+	pm.display.HandleCodeJSON(cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/display/code.json", nil))
+	var response map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response["code"] != code || response["display_code"] != display {
+		t.Errorf("Expected code %q displayed as %q, got %v and %v", code, display, response["code"], response["display_code"])
+	}
+
+	// The displayed form and a dashed form are accepted in place of the canonical code
+	if !pm.ValidateCode(display) || !pm.ValidateCode(code[:4]+"-"+code[4:]) {
+		t.Error("Codes with spaces or dashes should match the canonical code")
+	}
+	if pm.ValidateCode(code[:4] + "." + code[4:]) {
+		t.Error("Only spaces and dashes should be ignored")
+	}
+}
+
+func TestHandleECDHKeyQR(t *testing.T) {
+	utils.ClearECDHKeys()
+	t.Cleanup(utils.ClearECDHKeys)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{}
+	pm.SetConfig(cfg)
+
+	rr := httptest.NewRecorder()
+	pm.display.HandleECDHKeyQR(cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/display/ecdh-key", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a key, got %d", rr.Code)
+	}
+
+	if err := utils.GenerateECDHKeyPair(); err != nil {
+		t.Fatalf("Failed to generate ECDH key pair: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	pm.display.HandleECDHKeyQR(cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/display/ecdh-key", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Expected image/png, got %q", contentType)
+	}
+	if !strings.HasPrefix(rr.Body.String(), "\x89PNG") {
+		t.Errorf("Expected a PNG body, got %d bytes", rr.Body.Len())
+	}
+
+	data := pm.display.GetTemplateData()
+	if !data.HasECDHKey || data.ECDHKeyQR == "" {
+		t.Errorf("Expected the ECDH key QR code in the template data, got HasECDHKey=%v", data.HasECDHKey)
+	}
+}
@@ -151,7 +151,7 @@ func TestValidatePairingIP(t *testing.T) {
 			pm.SetConfig(tt.config)
 			pm.pairCodeIP = tt.pairCodeIP
 
-			result, reason := pm.validatePairingIP(tt.clientIP)
+			result, reason := pm.validatePairingIP(pm.GetConfig(), tt.clientIP)
 
 			if result != tt.expectedResult {
 				t.Errorf("validatePairingIP() result = %v, expected %v", result, tt.expectedResult)
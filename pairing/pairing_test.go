@@ -4,15 +4,24 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"msm-client/config"
 	"msm-client/state"
+	"msm-client/utils"
 )
 
 func TestNewPairingManager(t *testing.T) {
@@ -108,7 +117,7 @@ func TestCallbackManagement(t *testing.T) {
 	// Trigger callbacks
 	pm.triggerOnPairingStarted("123456", time.Now())
 	pm.triggerOnPairingSuccess("ws://test")
-	pm.triggerOnPairingFailed("test", 1)
+	pm.triggerOnPairingFailed("test", 1, "")
 	pm.triggerOnServerStarted(":8080")
 	pm.triggerOnServerStopped()
 
@@ -144,6 +153,33 @@ func TestCallbackManagement(t *testing.T) {
 	}
 }
 
+func TestPairingListeners(t *testing.T) {
+	pm := NewPairingManager()
+
+	var calls []string
+	pm.SetOnPairingSuccess(func(string) { calls = append(calls, "set success") })
+	pm.AddOnPairingSuccess(func(string) { calls = append(calls, "first success") })
+	pm.AddOnPairingSuccess(func(string) { calls = append(calls, "second success") })
+	pm.SetOnPairingFailed(func(string, int) { calls = append(calls, "set failed") })
+	pm.AddOnPairingFailed(func(string, int) { calls = append(calls, "added failed") })
+
+	pm.triggerOnPairingSuccess("ws://test")
+	pm.triggerOnPairingFailed("test", 1, "")
+
+	expected := "set success,first success,second success,set failed,added failed"
+	if got := strings.Join(calls, ","); got != expected {
+		t.Errorf("Expected every callback and listener to run, got %s", got)
+	}
+
+	pm.ClearAllCallbacks()
+	calls = nil
+	pm.triggerOnPairingSuccess("ws://test")
+	pm.triggerOnPairingFailed("test", 1, "")
+	if len(calls) != 0 {
+		t.Errorf("Expected no listeners after clearing, got %v", calls)
+	}
+}
+
 func TestPairingCodeManagement(t *testing.T) {
 	pm := NewPairingManager()
 
@@ -159,7 +195,7 @@ func TestPairingCodeManagement(t *testing.T) {
 
 	// Test saving pairing code
 	testCode := "123456"
-	err = pm.SavePairingCode(testCode)
+	err = pm.SavePairingCode(testCode, time.Now().Add(time.Minute))
 	if err != nil {
 		t.Errorf("Failed to save pairing code: %v", err)
 	}
@@ -187,6 +223,86 @@ func TestPairingCodeManagement(t *testing.T) {
 	}
 }
 
+func TestPairingCodeFile(t *testing.T) {
+	pm := NewPairingManager()
+	pm.SetConfig(config.ClientConfig{VerificationCodeAttempts: 4})
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	t.Run("JSON", func(t *testing.T) {
+		expiresAt := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+		if err := pm.SavePairingCode("123456", expiresAt); err != nil {
+			t.Fatalf("Failed to save pairing code: %v", err)
+		}
+
+		data, err := os.ReadFile(getPairingPath())
+		if err != nil {
+			t.Fatalf("Failed to read pairing code file: %v", err)
+		}
+		var raw map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			t.Fatalf("Expected a JSON pairing code file, got %q", data)
+		}
+		for _, key := range []string{"code", "expires_at", "created_at", "attempts_max"} {
+			if _, ok := raw[key]; !ok {
+				t.Errorf("Expected %s in the pairing code file, got %s", key, data)
+			}
+		}
+
+		file, err := pm.LoadPairingCodeFile()
+		if err != nil {
+			t.Fatalf("Failed to load pairing code file: %v", err)
+		}
+		if file.Code != "123456" || !file.ExpiresAt.Equal(expiresAt) || file.AttemptsMax != 4 || file.CreatedAt.IsZero() {
+			t.Errorf("Unexpected pairing code file %+v", file)
+		}
+		if file.Expired(time.Now()) || !file.Expired(expiresAt) {
+			t.Errorf("Expected the code to expire at %v", expiresAt)
+		}
+		if code, err := pm.LoadPairingCode(); err != nil || code != "123456" {
+			t.Errorf("Expected code 123456, got %q (%v)", code, err)
+		}
+		if info, err := os.Stat(getPairingPath()); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the pairing code file with mode 0600, got %v (%v)", info.Mode().Perm(), err)
+		}
+	})
+
+	t.Run("Permissions", func(t *testing.T) {
+		if err := os.Chmod(getPairingPath(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := pm.SavePairingCode("123456", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("Failed to save pairing code: %v", err)
+		}
+		if info, err := os.Stat(getPairingPath()); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the pairing code file tightened to 0600, got %v (%v)", info.Mode().Perm(), err)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		if err := os.WriteFile(getPairingPath(), []byte("654321\n"), 0600); err != nil {
+			t.Fatalf("Failed to write legacy pairing code file: %v", err)
+		}
+		file, err := pm.LoadPairingCodeFile()
+		if err != nil || file.Code != "654321" {
+			t.Fatalf("Expected the legacy code 654321, got %+v (%v)", file, err)
+		}
+		if !file.ExpiresAt.IsZero() || file.Expired(time.Now()) {
+			t.Errorf("Expected a legacy code without expiry, got %+v", file)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, content := range []string{`{"code": "1234`, `{"expires_at": "2024-01-01T00:00:00Z"}`} {
+			if err := os.WriteFile(getPairingPath(), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write pairing code file: %v", err)
+			}
+			if code, err := pm.LoadPairingCode(); err == nil {
+				t.Errorf("Expected an error for %q, got code %q", content, code)
+			}
+		}
+	})
+}
+
 func TestGetClientIP(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -306,12 +422,72 @@ func TestBlacklistManagement(t *testing.T) {
 	}
 }
 
+func TestBlacklistDetailed(t *testing.T) {
+	pm := NewPairingManager()
+	clock := utils.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	pm.clock = clock
+	pm.SetConfig(config.ClientConfig{
+		MaxIPViolations:     3,
+		IPBlacklistDuration: 10 * time.Minute,
+		IPViolationWindow:   time.Hour,
+	})
+
+	violate := func(ip string, times int) {
+		for i := 0; i < times; i++ {
+			pm.recordIPViolation(ip)
+		}
+	}
+
+	violate("192.0.2.1", 1)
+	violate("192.0.2.2", 3) // Blacklisted first, expires first
+	clock.Advance(time.Minute)
+	violate("192.0.2.3", 2)
+	violate("192.0.2.4", 3)
+	violate("192.0.2.5", 1)
+
+	// Blacklisted by hand, without recorded violations
+	pm.blacklistMutex.Lock()
+	pm.ipBlacklist["192.0.2.6"] = clock.Deadline(time.Second)
+	pm.ipBlacklist["192.0.2.7"] = clock.Deadline(-time.Second) // Already expired
+	pm.blacklistMutex.Unlock()
+
+	entries := pm.GetBlacklistDetailed()
+	expected := []BlacklistEntry{
+		{IP: "192.0.2.4", Violations: 3, Blacklisted: true, Until: clock.Now().Add(10 * time.Minute), Remaining: 10 * time.Minute},
+		{IP: "192.0.2.2", Violations: 3, Blacklisted: true, Until: clock.Now().Add(9 * time.Minute), Remaining: 9 * time.Minute},
+		{IP: "192.0.2.6", Violations: 0, Blacklisted: true, Until: clock.Now().Add(time.Second), Remaining: time.Second},
+		{IP: "192.0.2.3", Violations: 2},
+		{IP: "192.0.2.1", Violations: 1},
+		{IP: "192.0.2.5", Violations: 1},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		want := expected[i]
+		if entry.IP != want.IP || entry.Violations != want.Violations || entry.Blacklisted != want.Blacklisted ||
+			!entry.Until.Equal(want.Until) || entry.Remaining != want.Remaining {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want, entry)
+		}
+	}
+
+	// Violations are forgotten after the window, blacklistings when they expire
+	clock.Advance(time.Hour)
+	if entries := pm.GetBlacklistDetailed(); len(entries) != 0 {
+		t.Errorf("Expected no entries after everything expired, got %+v", entries)
+	}
+	if len(pm.GetBlacklistStatus()) != 0 {
+		t.Error("Expected the blacklist status to agree")
+	}
+}
+
 func TestHandlePair(t *testing.T) {
 	pm := NewPairingManager()
 
 	cfg := config.ClientConfig{
-		VerificationCodeLength: 6,
-		PairingCodeExpiration:  1 * time.Minute,
+		VerificationCodeLength:   6,
+		PairingCodeExpiration:    1 * time.Minute,
+		DisableConnectivityCheck: true,
 	}
 	pm.SetConfig(cfg)
 
@@ -398,6 +574,274 @@ func TestHandlePair(t *testing.T) {
 	})
 }
 
+// slowKeyGeneration delays ECDH key generation by delay for the duration of the test
+func slowKeyGeneration(t *testing.T, delay time.Duration) {
+	t.Helper()
+	original := generateECDHKeyPair
+	generateECDHKeyPair = func() error {
+		time.Sleep(delay)
+		return original()
+	}
+	t.Cleanup(func() { generateECDHKeyPair = original })
+}
+
+func TestHandlePairConcurrentRequests(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	slowKeyGeneration(t, 100*time.Millisecond)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeLength:   6,
+		PairingCodeExpiration:    1 * time.Minute,
+		DisableConnectivityCheck: true,
+	}
+	pm.SetConfig(cfg)
+
+	var generated atomic.Int32
+	pm.SetOnPairingStarted(func(code string, expiry time.Time) {
+		generated.Add(1)
+	})
+
+	handler := pm.HandlePair(cfg)
+
+	const requests = 5
+	recorders := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rr *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/pair", nil)
+			req.RemoteAddr = "192.168.1.100:12345"
+			handler.ServeHTTP(rr, req)
+		}(recorders[i])
+	}
+	wg.Wait()
+
+	if count := generated.Load(); count != 1 {
+		t.Errorf("Expected one code to be generated, got %d", count)
+	}
+
+	_, expiry := pm.GetPairingCode()
+	for i, rr := range recorders {
+		if rr.Code != http.StatusOK {
+			t.Errorf("Request %d: expected 200, got %d", i, rr.Code)
+			continue
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Request %d: failed to decode response: %v", i, err)
+		}
+		if response["expiry"] != expiry.Format(time.RFC3339) {
+			t.Errorf("Request %d: expected expiry of the shared code %s, got %v", i, expiry.Format(time.RFC3339), response["expiry"])
+		}
+	}
+}
+
+func TestHandlePairGenerationWaitTimeout(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	slowKeyGeneration(t, codeGenerationWait+300*time.Millisecond)
+
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{DisableConnectivityCheck: true}
+	pm.SetConfig(cfg)
+	handler := pm.HandlePair(cfg)
+
+	first := make(chan int, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/pair", nil))
+		first <- rr.Code
+	}()
+
+	// Let the first request start generating before the second arrives
+	time.Sleep(50 * time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/pair", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while generation outlasts the wait, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("Expected the generating request to succeed, got %d", code)
+	}
+}
+
+func TestHandlePairConnectivity(t *testing.T) {
+	// Fake gateway that accepts TCP connections
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	defer gateway.Close()
+	gatewayAddr := gateway.Listener.Addr().(*net.TCPAddr)
+
+	originalHost, originalPort := internetProbeHost, internetProbePort
+	originalGatewayPort, originalGatewayFunc := gatewayProbePort, defaultGatewayFunc
+	defer func() {
+		internetProbeHost, internetProbePort = originalHost, originalPort
+		gatewayProbePort, defaultGatewayFunc = originalGatewayPort, originalGatewayFunc
+	}()
+
+	internetProbeHost = "127.0.0.1"
+	internetProbePort = gatewayAddr.Port
+	gatewayProbePort = gatewayAddr.Port
+	defaultGatewayFunc = func() (string, error) { return "127.0.0.1", nil }
+
+	doPair := func(pm *PairingManager, cfg config.ClientConfig) map[string]interface{} {
+		req := httptest.NewRequest("GET", "/pair", nil)
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		pm.HandlePair(cfg).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("Reachable gateway", func(t *testing.T) {
+		pm := NewPairingManager()
+		cfg := config.ClientConfig{VerificationCodeLength: 6, PairingCodeExpiration: time.Minute}
+		pm.SetConfig(cfg)
+
+		response := doPair(pm, cfg)
+		connectivity, ok := response["connectivity"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Response should contain connectivity, got %v", response)
+		}
+		if connectivity["has_internet"] != true {
+			t.Error("Expected has_internet to be true")
+		}
+		if connectivity["gateway_reachable"] != true {
+			t.Error("Expected gateway_reachable to be true")
+		}
+		if iface, ok := connectivity["primary_interface"]; ok {
+			if _, isObject := iface.(map[string]interface{}); !isObject {
+				t.Errorf("Expected primary_interface to be an interface object, got %v", iface)
+			}
+		}
+
+		// A second request within the cache TTL reuses the summary
+		defaultGatewayFunc = func() (string, error) { return "", errors.New("no route") }
+		defer func() { defaultGatewayFunc = func() (string, error) { return "127.0.0.1", nil } }()
+		connectivity = doPair(pm, cfg)["connectivity"].(map[string]interface{})
+		if connectivity["gateway_reachable"] != true {
+			t.Error("Expected the cached summary to be reused")
+		}
+	})
+
+	t.Run("Unreachable gateway", func(t *testing.T) {
+		defaultGatewayFunc = func() (string, error) { return "", errors.New("no route") }
+		defer func() { defaultGatewayFunc = func() (string, error) { return "127.0.0.1", nil } }()
+
+		pm := NewPairingManager()
+		cfg := config.ClientConfig{VerificationCodeLength: 6, PairingCodeExpiration: time.Minute}
+		pm.SetConfig(cfg)
+
+		response := doPair(pm, cfg)
+		connectivity, ok := response["connectivity"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Response should contain connectivity, got %v", response)
+		}
+		if connectivity["gateway_reachable"] != false {
+			t.Error("Expected gateway_reachable to be false without a default gateway")
+		}
+	})
+
+	t.Run("Check disabled", func(t *testing.T) {
+		pm := NewPairingManager()
+		cfg := config.ClientConfig{
+			VerificationCodeLength:   6,
+			PairingCodeExpiration:    time.Minute,
+			DisableConnectivityCheck: true,
+		}
+		pm.SetConfig(cfg)
+
+		response := doPair(pm, cfg)
+		if _, ok := response["connectivity"]; ok {
+			t.Error("Response should not contain connectivity when the check is disabled")
+		}
+	})
+}
+
+func TestWaitBeforePairing(t *testing.T) {
+	originalInterval := serverProbeInterval
+	serverProbeInterval = 20 * time.Millisecond
+	defer func() { serverProbeInterval = originalInterval }()
+
+	// Reserve a port with nothing listening on it
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedURL := fmt.Sprintf("ws://%s/ws", closed.Addr().String())
+	closed.Close()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	reachableURL := "ws://" + server.Listener.Addr().String() + "/ws"
+
+	startWait := func(cfg config.ClientConfig, serverWs string) chan bool {
+		done := make(chan bool, 1)
+		go func() {
+			done <- NewPairingManager().WaitBeforePairing(context.Background(), cfg, serverWs)
+		}()
+		return done
+	}
+
+	t.Run("Unreachable server waits for the delay", func(t *testing.T) {
+		done := startWait(config.ClientConfig{PairingRetryOnDisconnect: true, PairingBackoffDelay: 300 * time.Millisecond}, closedURL)
+
+		select {
+		case <-done:
+			t.Fatal("Pairing should be held back while the server is unreachable")
+		case <-time.After(150 * time.Millisecond):
+		}
+
+		select {
+		case reachable := <-done:
+			if reachable {
+				t.Error("Expected the server to be reported unreachable")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Pairing should restart once the delay runs out")
+		}
+	})
+
+	t.Run("Reachable server ends the wait", func(t *testing.T) {
+		done := startWait(config.ClientConfig{PairingRetryOnDisconnect: true, PairingBackoffDelay: time.Minute}, reachableURL)
+
+		select {
+		case reachable := <-done:
+			if !reachable {
+				t.Error("Expected the server to be reported reachable")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("A reachable server should end the wait early")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		done := startWait(config.ClientConfig{PairingBackoffDelay: time.Minute}, closedURL)
+
+		select {
+		case reachable := <-done:
+			if reachable {
+				t.Error("Expected no probe when retry on disconnect is disabled")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Pairing should restart immediately when retry on disconnect is disabled")
+		}
+	})
+}
+
 func TestHandleConfirm(t *testing.T) {
 	pm := NewPairingManager()
 
@@ -512,14 +956,20 @@ func TestHandleConfirm(t *testing.T) {
 			t.Errorf("Handler should return 401 for incorrect code, got %v", status)
 		}
 
-		// Verify fail count increased
-		_, _, failCount := pm.GetPairingStatus()
+		// Verify both the code budget and the IP's violations counted the attempt
+		_, _, failCount, violations := pm.GetPairingStatus("192.168.1.100")
 		if failCount != 1 {
 			t.Errorf("Expected fail count 1, got %d", failCount)
 		}
+		if violations != 1 {
+			t.Errorf("Expected 1 IP violation, got %d", violations)
+		}
 	})
 
 	t.Run("Expired code", func(t *testing.T) {
+		// The same code would otherwise replay the successful pairing above
+		pm.ClearConfirmGrace()
+
 		// Set expired code
 		pm.codeMutex.Lock()
 		pm.pairCode = testCode
@@ -664,7 +1114,7 @@ func TestResetPairing(t *testing.T) {
 	pm.codeMutex.Unlock()
 
 	// Save pairing code file
-	err = pm.SavePairingCode(testCode)
+	err = pm.SavePairingCode(testCode, pm.expiry)
 	if err != nil {
 		t.Errorf("Failed to save pairing code: %v", err)
 	}
@@ -711,7 +1161,7 @@ func TestGetPairingStatus(t *testing.T) {
 		pm.failCount = 1
 		pm.codeMutex.Unlock()
 
-		code, expiry, failCount := pm.GetPairingStatus()
+		code, expiry, failCount, _ := pm.GetPairingStatus("192.168.1.100")
 
 		if code != testCode {
 			t.Errorf("Expected code %s, got %s", testCode, code)
@@ -733,7 +1183,7 @@ func TestGetPairingStatus(t *testing.T) {
 		pm.failCount = 1
 		pm.codeMutex.Unlock()
 
-		code, expiry, failCount := pm.GetPairingStatus()
+		code, expiry, failCount, _ := pm.GetPairingStatus("192.168.1.100")
 
 		if code != "expired" {
 			t.Errorf("Expected status 'expired', got %s", code)
@@ -755,7 +1205,7 @@ func TestGetPairingStatus(t *testing.T) {
 		pm.failCount = 3 // Max attempts
 		pm.codeMutex.Unlock()
 
-		code, expiry, failCount := pm.GetPairingStatus()
+		code, expiry, failCount, _ := pm.GetPairingStatus("192.168.1.100")
 
 		if code != "expired" {
 			t.Errorf("Expected status 'expired', got %s", code)
@@ -782,9 +1232,9 @@ func TestCleanupBlacklist(t *testing.T) {
 	pm.ipBlacklist["192.168.1.101"] = now.Add(1 * time.Hour)     // Valid
 	pm.ipBlacklist["192.168.1.102"] = now.Add(-30 * time.Minute) // Expired
 
-	pm.ipViolations["192.168.1.100"] = 3
-	pm.ipViolations["192.168.1.101"] = 5
-	pm.ipViolations["192.168.1.102"] = 2
+	pm.ipViolations.Set("192.168.1.100", 3, now.Add(1*time.Hour))
+	pm.ipViolations.Set("192.168.1.101", 5, now.Add(1*time.Hour))
+	pm.ipViolations.Set("192.168.1.102", 2, now.Add(1*time.Hour))
 	pm.blacklistMutex.Unlock()
 
 	// Run cleanup
@@ -802,17 +1252,78 @@ func TestCleanupBlacklist(t *testing.T) {
 		t.Error("Valid blacklist entry should remain after cleanup")
 	}
 
-	if len(pm.ipViolations) != 1 {
-		t.Errorf("Expected 1 violation entry after cleanup, got %d", len(pm.ipViolations))
+	if pm.ipViolations.Len() != 1 {
+		t.Errorf("Expected 1 violation entry after cleanup, got %d", pm.ipViolations.Len())
 	}
 
-	if _, exists := pm.ipViolations["192.168.1.101"]; !exists {
+	if _, exists := pm.ipViolations.Get("192.168.1.101"); !exists {
 		t.Error("Valid violation entry should remain after cleanup")
 	}
 }
 
+func TestCleanupExpiredViolations(t *testing.T) {
+	defer func(original int) { maxIPViolationEntries = original }(maxIPViolationEntries)
+	maxIPViolationEntries = 100
+
+	clock := utils.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	pm := NewPairingManager()
+	pm.clock = clock
+	pm.SetConfig(config.ClientConfig{MaxIPViolations: 3, IPViolationWindow: 10 * time.Minute})
+
+	// A slow scan: one violation each from many addresses, never reaching the threshold
+	for i := 0; i < 250; i++ {
+		if pm.recordIPViolation(fmt.Sprintf("10.0.%d.%d", i/250, i%250)) {
+			t.Fatal("A single violation should not blacklist")
+		}
+		clock.Advance(time.Second)
+	}
+
+	pm.blacklistMutex.Lock()
+	count := pm.ipViolations.Len()
+	_, oldestKept := pm.ipViolations.Get("10.0.0.0")
+	pm.blacklistMutex.Unlock()
+	if count != 100 {
+		t.Errorf("Expected violation records capped at 100, got %d", count)
+	}
+	if oldestKept {
+		t.Error("Oldest violation record should have been evicted")
+	}
+
+	// A wall clock jump does not expire records
+	clock.JumpWall(time.Hour)
+	pm.cleanupBlacklist()
+	pm.blacklistMutex.Lock()
+	count = pm.ipViolations.Len()
+	pm.blacklistMutex.Unlock()
+	if count != 100 {
+		t.Errorf("Expected 100 violation records after a clock jump, got %d", count)
+	}
+
+	// Records older than the window are dropped, leaving the last 50 recorded
+	clock.Advance(10*time.Minute - 51*time.Second)
+	pm.cleanupBlacklist()
+	pm.blacklistMutex.Lock()
+	count = pm.ipViolations.Len()
+	pm.blacklistMutex.Unlock()
+	if count != 50 {
+		t.Errorf("Expected 50 violation records within the window, got %d", count)
+	}
+
+	clock.Advance(time.Minute)
+	pm.cleanupBlacklist()
+	pm.blacklistMutex.Lock()
+	count = pm.ipViolations.Len()
+	pm.blacklistMutex.Unlock()
+	if count != 0 {
+		t.Errorf("Expected all violation records expired, got %d", count)
+	}
+}
+
 // Test helper function to create a test server
 func createTestServer(t *testing.T, pm *PairingManager, cfg config.ClientConfig) (*httptest.Server, context.CancelFunc) {
+	// Keep the tests off the network; TestHandlePairConnectivity covers the probes
+	cfg.DisableConnectivityCheck = true
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/pair", pm.HandlePair(cfg))
 	mux.HandleFunc("/pair/confirm", pm.HandleConfirm(cfg))
@@ -928,3 +1439,922 @@ func TestIntegrationPairingFlow(t *testing.T) {
 		t.Errorf("Expected server WS %s, got %s", confirmRequest["serverWs"], savedState.ServerWs)
 	}
 }
+
+func TestWaitReturnsResultAfterConfirm(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	}
+	pm.SetConfig(cfg)
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	os.Setenv("MSC_PAIRING_PATH", tmpDir)
+	defer func() {
+		os.Unsetenv("MSC_STATE_PATH")
+		os.Unsetenv("MSC_PAIRING_PATH")
+	}()
+
+	// No result before pairing and no server running
+	if _, err := pm.Wait(context.Background()); err != ErrNoPairingResult {
+		t.Errorf("Expected ErrNoPairingResult before pairing, got %v", err)
+	}
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.failCount = 0
+	pm.codeMutex.Unlock()
+
+	jsonBody, _ := json.Marshal(map[string]string{
+		"code":     "123456",
+		"serverWs": "ws://test-server:8080/ws",
+	})
+	req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+	req.RemoteAddr = "192.168.1.100:12345"
+
+	rr := httptest.NewRecorder()
+	pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Result must be available immediately, without sleeping
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	result, err := pm.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Expected pairing result, got error: %v", err)
+	}
+	if result.ServerWs != "ws://test-server:8080/ws" {
+		t.Errorf("Expected ServerWs ws://test-server:8080/ws, got %s", result.ServerWs)
+	}
+	if result.SessionKeyPresent {
+		t.Error("SessionKeyPresent should be false without a server public key")
+	}
+	if result.PairedAt.IsZero() {
+		t.Error("PairedAt should be set")
+	}
+
+	// State must already be on disk when the result is delivered
+	savedState, err := state.LoadState()
+	if err != nil {
+		t.Fatalf("State should be saved before result is delivered: %v", err)
+	}
+	if savedState.ServerWs != result.ServerWs {
+		t.Errorf("Expected saved ServerWs %s, got %s", result.ServerWs, savedState.ServerWs)
+	}
+}
+
+func TestPairingCodeNotLogged(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+		DisableConnectivityCheck: true,
+	}
+	pm.SetConfig(cfg)
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	os.Setenv("MSC_PAIRING_PATH", tmpDir)
+	defer func() {
+		os.Unsetenv("MSC_STATE_PATH")
+		os.Unsetenv("MSC_PAIRING_PATH")
+	}()
+
+	// Capture log output
+	var logBuffer bytes.Buffer
+	log.SetOutput(&logBuffer)
+	defer log.SetOutput(os.Stderr)
+
+	activeCode := "QZXW7K"
+	pm.codeMutex.Lock()
+	pm.pairCode = activeCode
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.failCount = 0
+	pm.codeMutex.Unlock()
+
+	confirm := func(code string) int {
+		jsonBody, _ := json.Marshal(map[string]string{
+			"code":     code,
+			"serverWs": "ws://test-server:8080/ws",
+		})
+		req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Existing code request
+	req := httptest.NewRequest("GET", "/pair", nil)
+	req.RemoteAddr = "192.168.1.100:12345"
+	pm.HandlePair(cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Wrong guess, then ValidateCode, then the correct code
+	if status := confirm("WRONG1"); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong code, got %d", status)
+	}
+	pm.ValidateCode("WRONG2")
+	if status := confirm(activeCode); status != http.StatusOK {
+		t.Errorf("Expected 200 for correct code, got %d", status)
+	}
+
+	output := logBuffer.String()
+	if output == "" {
+		t.Fatal("Expected log output to be captured")
+	}
+	if strings.Contains(output, activeCode) {
+		t.Errorf("Log output must not contain the active pairing code:\n%s", output)
+	}
+	if strings.Contains(output, "WRONG1") {
+		t.Errorf("Log output must not contain submitted codes:\n%s", output)
+	}
+	if !strings.Contains(output, codeFingerprint(activeCode)) {
+		t.Error("Log output should contain the code fingerprint for correlation")
+	}
+}
+
+func TestCodesMatch(t *testing.T) {
+	if !codesMatch("ABC123", "ABC123", config.PairingCodeAlphanumeric) {
+		t.Error("Identical codes should match")
+	}
+	if codesMatch("ABC124", "ABC123", config.PairingCodeAlphanumeric) {
+		t.Error("Different codes should not match")
+	}
+	if codesMatch("ABC1234", "ABC123", config.PairingCodeAlphanumeric) {
+		t.Error("Codes of different length should not match")
+	}
+	if codesMatch("", "", config.PairingCodeAlphanumeric) {
+		t.Error("Empty active code should never match")
+	}
+}
+
+func TestCodesMatchNormalization(t *testing.T) {
+	tests := []struct {
+		name      string
+		submitted string
+		active    string
+		codeType  string
+		match     bool
+	}{
+		{"Lowercase", "abc123", "ABC123", config.PairingCodeAlphanumeric, true},
+		{"Mixed case", "aBc123", "ABC123", config.PairingCodeAlphanumeric, true},
+		{"Spaces", " ABC 123 ", "ABC123", config.PairingCodeAlphanumeric, true},
+		{"Tab and dash", "abc\t-123", "ABC123", config.PairingCodeAlphanumeric, true},
+		{"Alphanumeric O is not 0", "AB0123", "ABO123", config.PairingCodeAlphanumeric, false},
+		{"Alphanumeric I is not 1", "ABCI23", "ABC123", config.PairingCodeAlphanumeric, false},
+		{"Numeric grouped", "1234 5678", "12345678", config.PairingCodeNumeric, true},
+		{"Numeric O for 0", "1O34-5678", "10345678", config.PairingCodeNumeric, true},
+		{"Numeric I and l for 1", "I234 567l", "12345671", config.PairingCodeNumeric, true},
+		{"Numeric wrong digit", "1234 5679", "12345678", config.PairingCodeNumeric, false},
+		{"Numeric other letter", "1234567B", "12345678", config.PairingCodeNumeric, false},
+		{"Dots are not separators", "1234.5678", "12345678", config.PairingCodeNumeric, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := codesMatch(test.submitted, test.active, test.codeType); match != test.match {
+				t.Errorf("codesMatch(%q, %q, %q) = %v, expected %v", test.submitted, test.active, test.codeType, match, test.match)
+			}
+		})
+	}
+
+	t.Run("ValidateCode", func(t *testing.T) {
+		pm := NewPairingManager()
+		pm.SetConfig(config.ClientConfig{VerificationCodeAttempts: 3})
+		pm.codeMutex.Lock()
+		pm.pairCode = "ABC123"
+		pm.expiry = time.Now().Add(time.Minute)
+		pm.codeMutex.Unlock()
+
+		if !pm.ValidateCode("abc-123") {
+			t.Error("ValidateCode should accept a lowercase code with a dash")
+		}
+	})
+}
+
+func TestConfirmValidator(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	}
+	pm.SetConfig(cfg)
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	os.Setenv("MSC_PAIRING_PATH", tmpDir)
+	defer func() {
+		os.Unsetenv("MSC_STATE_PATH")
+		os.Unsetenv("MSC_PAIRING_PATH")
+	}()
+
+	// Accept any 6-digit code starting with "1"
+	pm.SetConfirmValidator(func(submittedCode, pairCode, clientIP string) (bool, string) {
+		if len(submittedCode) == 6 && strings.HasPrefix(submittedCode, "1") {
+			return true, ""
+		}
+		return false, "code rejected by validator"
+	})
+
+	confirm := func(code string) int {
+		pm.codeMutex.Lock()
+		pm.pairCode = "SEEDED"
+		pm.pairCodeIP = "192.168.1.100"
+		pm.expiry = time.Now().Add(1 * time.Minute)
+		pm.failCount = 0
+		pm.codeMutex.Unlock()
+
+		jsonBody, _ := json.Marshal(map[string]string{
+			"code":     code,
+			"serverWs": "ws://test-server:8080/ws",
+		})
+		req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+		req.RemoteAddr = "192.168.1.100:12345"
+
+		rr := httptest.NewRecorder()
+		pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := confirm("200000"); status != http.StatusUnauthorized {
+		t.Errorf("Expected code 200000 to be rejected with 401, got %d", status)
+	}
+	pm.codeMutex.Lock()
+	failCount := pm.failCount
+	pm.codeMutex.Unlock()
+	if failCount != 1 {
+		t.Errorf("Expected failCount 1 after rejected code, got %d", failCount)
+	}
+
+	if status := confirm("100000"); status != http.StatusOK {
+		t.Errorf("Expected code 100000 to be accepted, got %d", status)
+	}
+}
+
+func TestConfirmNegotiatesEncryptionAlgorithm(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+		EncryptionAlgorithm:      "chacha20-poly1305",
+	}
+	pm.SetConfig(cfg)
+
+	tmpDir := t.TempDir()
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	os.Setenv("MSC_PAIRING_PATH", tmpDir)
+	defer func() {
+		os.Unsetenv("MSC_STATE_PATH")
+		os.Unsetenv("MSC_PAIRING_PATH")
+	}()
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.codeMutex.Unlock()
+
+	jsonBody, _ := json.Marshal(map[string]any{
+		"code":                "123456",
+		"serverWs":            "ws://test-server:8080/ws",
+		"supportedAlgorithms": []string{"aes-cbc", "chacha20-poly1305"},
+	})
+	req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+	req.RemoteAddr = "192.168.1.100:12345"
+
+	rr := httptest.NewRecorder()
+	pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["encryptionAlgorithm"] != "chacha20-poly1305" {
+		t.Errorf("Expected encryptionAlgorithm chacha20-poly1305, got %v", response["encryptionAlgorithm"])
+	}
+	if alg := state.GetEncryptionAlgorithm(); alg != "chacha20-poly1305" {
+		t.Errorf("Expected stored algorithm chacha20-poly1305, got %s", alg)
+	}
+}
+
+func TestHandleConfirmBodyTooLarge(t *testing.T) {
+	pm := NewPairingManager()
+
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+		PairingMaxBodyBytes:      1024,
+	}
+	pm.SetConfig(cfg)
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.pairCodeIP = "192.168.1.100"
+	pm.expiry = time.Now().Add(1 * time.Minute)
+	pm.codeMutex.Unlock()
+
+	// Valid JSON padded beyond the limit
+	body := `{"code":"123456","serverWs":"` + strings.Repeat("a", 4096) + `"}`
+	req := httptest.NewRequest("POST", "/pair/confirm", strings.NewReader(body))
+	req.RemoteAddr = "192.168.1.100:12345"
+
+	rr := httptest.NewRecorder()
+	pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", rr.Code)
+	}
+
+	var response PairingError
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected JSON error body, got %q", rr.Body.String())
+	}
+	if response.Error == "" {
+		t.Error("Expected error message in response")
+	}
+	if response.ErrorCode != ErrorCodeBodyTooLarge {
+		t.Errorf("Expected error code %s, got %q", ErrorCodeBodyTooLarge, response.ErrorCode)
+	}
+
+	pm.codeMutex.Lock()
+	failCount := pm.failCount
+	pm.codeMutex.Unlock()
+	if failCount != 0 {
+		t.Errorf("Oversized request should not increment fail count, got %d", failCount)
+	}
+}
+
+func TestConfirmExpectedClientID(t *testing.T) {
+	cfg := config.ClientConfig{
+		ClientID:                 "5b0c6f4e-3a53-4f0e-9b8e-2f1a4d7c9e10",
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	}
+
+	tmpDir := t.TempDir()
+	t.Setenv("MSC_STATE_PATH", tmpDir)
+	t.Setenv("MSC_PAIRING_PATH", tmpDir)
+
+	confirm := func(pm *PairingManager, expectedClientID string) *httptest.ResponseRecorder {
+		body := map[string]string{
+			"code":     "123456",
+			"serverWs": "ws://test-server:8080/ws",
+		}
+		if expectedClientID != "" {
+			body["expectedClientId"] = expectedClientID
+		}
+		jsonBody, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+		req.RemoteAddr = "192.168.1.100:12345"
+
+		rr := httptest.NewRecorder()
+		pm.HandleConfirm(cfg).ServeHTTP(rr, req)
+		return rr
+	}
+
+	newManager := func() *PairingManager {
+		pm := NewPairingManager()
+		pm.SetConfig(cfg)
+		pm.codeMutex.Lock()
+		pm.pairCode = "123456"
+		pm.pairCodeIP = "192.168.1.100"
+		pm.expiry = time.Now().Add(1 * time.Minute)
+		pm.codeMutex.Unlock()
+		return pm
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		if rr := confirm(newManager(), cfg.ClientID); rr.Code != http.StatusOK {
+			t.Errorf("Expected the expected client ID to pair, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		pm := newManager()
+		rr := confirm(pm, "9f1e2d3c-0000-4000-8000-000000000000")
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("Expected status 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response PairingError
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected JSON error body, got %q", rr.Body.String())
+		}
+		if response.ErrorCode != ErrorCodeClientIDMismatch || response.ClientIDPrefix != "5b0c6f4e" {
+			t.Errorf("Expected a client ID mismatch with prefix 5b0c6f4e, got %+v", response)
+		}
+
+		pm.codeMutex.Lock()
+		failCount := pm.failCount
+		pm.codeMutex.Unlock()
+		if failCount != 0 {
+			t.Errorf("A client ID mismatch should not use up an attempt, got fail count %d", failCount)
+		}
+		if violations := pm.GetBlacklistDetailed(); len(violations) != 0 {
+			t.Errorf("A client ID mismatch should not count as an IP violation, got %+v", violations)
+		}
+	})
+
+	t.Run("Absent", func(t *testing.T) {
+		if rr := confirm(newManager(), ""); rr.Code != http.StatusOK {
+			t.Errorf("Expected a confirm without expectedClientId to pair, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestConfirmDefaultBodyLimit(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{VerificationCodeAttempts: 3, AllowIPSubnetMatch: true}
+	server, cancel := createTestServer(t, pm, cfg)
+	defer server.Close()
+	defer cancel()
+
+	body := `{"code":"123456","serverWs":"` + strings.Repeat("a", 10000-31) + `"}`
+	if len(body) != 10000 {
+		t.Fatalf("Expected a 10000 byte body, got %d", len(body))
+	}
+	resp, err := http.Post(server.URL+"/pair/confirm", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post confirm: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", resp.StatusCode)
+	}
+	var response PairingError
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Expected JSON error body: %v", err)
+	}
+	if response.ErrorCode != ErrorCodeBodyTooLarge {
+		t.Errorf("Expected error code %s, got %+v", ErrorCodeBodyTooLarge, response)
+	}
+}
+
+func TestAttemptsRemaining(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		MaxIPViolations:          10, // Wrong codes also count as violations; keep the IP off the blacklist
+		PairingCodeExpiration:    1 * time.Minute,
+		AllowIPSubnetMatch:       true,
+		DisableConnectivityCheck: true,
+	}
+	pm.SetConfig(cfg)
+
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+
+	pairHandler := pm.HandlePair(cfg)
+	confirmHandler := pm.HandleConfirm(cfg)
+
+	requestPair := func() map[string]any {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/pair", nil)
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		pairHandler.ServeHTTP(rr, req)
+
+		var response map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal pair response: %v", err)
+		}
+		return response
+	}
+
+	// Generate a code, then ask again to get the existing-code response
+	requestPair()
+	pm.codeMutex.Lock()
+	code := pm.pairCode
+	pm.codeMutex.Unlock()
+
+	response := requestPair()
+	if remaining, _ := response["attempts_remaining"].(float64); remaining != 3 {
+		t.Errorf("Expected 3 attempts remaining for an active code, got %v", response["attempts_remaining"])
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		jsonBody, _ := json.Marshal(map[string]string{
+			"code":     "WRONG" + code,
+			"serverWs": "ws://test-server:8080/ws",
+		})
+		req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+		req.RemoteAddr = "192.168.1.100:12345"
+		rr := httptest.NewRecorder()
+		confirmHandler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected 401, got %d", attempt, rr.Code)
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Attempt %d: failed to unmarshal error body: %v", attempt, err)
+		}
+		if remaining, _ := body["attempts_remaining"].(float64); int(remaining) != 3-attempt {
+			t.Errorf("Attempt %d: expected %d attempts remaining, got %v", attempt, 3-attempt, body["attempts_remaining"])
+		}
+	}
+
+	response = requestPair()
+	if remaining, ok := response["attempts_remaining"].(float64); !ok || remaining != 0 {
+		t.Errorf("Expected 0 attempts remaining after exhausting attempts, got %v", response["attempts_remaining"])
+	}
+	raw, _ := json.Marshal(response)
+	if strings.Contains(string(raw), code) {
+		t.Error("Pair response must never reveal the pairing code")
+	}
+}
+
+// busyPort returns a port held by a listener for the duration of the test
+func busyPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// freePort returns a port that was free a moment ago
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestStartPairingServerFallsBackWhenPortBusy(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	original := bindRetryDelay
+	bindRetryDelay = time.Millisecond
+	defer func() { bindRetryDelay = original }()
+
+	primary := busyPort(t)
+	fallback := freePort(t)
+	cfg := config.ClientConfig{
+		PairingBindAttempts:  2,
+		PairingFallbackPorts: []int{busyPort(t), fallback},
+	}
+
+	pm := NewPairingManager()
+	started := make(chan string, 1)
+	pm.SetOnServerStarted(func(addr string) { started <- addr })
+
+	done := make(chan error, 1)
+	go func() { done <- pm.StartPairingServerOnPort(cfg, primary, false) }()
+
+	select {
+	case addr := <-started:
+		if _, boundPort, _ := net.SplitHostPort(addr); boundPort != fmt.Sprint(fallback) {
+			t.Errorf("Expected server on port %d, got %s", fallback, addr)
+		}
+	case err := <-done:
+		t.Fatalf("Server exited before starting: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to start")
+	}
+
+	pm.StopPairingServer()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to stop")
+	}
+}
+
+func TestStartPairingServerEphemeralPort(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	pm := NewPairingManager()
+	started := make(chan string, 1)
+	pm.SetOnServerStarted(func(addr string) { started <- addr })
+
+	done := make(chan error, 1)
+	go func() { done <- pm.StartPairingServerOnPort(config.ClientConfig{}, 0, true) }()
+
+	var addr string
+	select {
+	case addr = <-started:
+	case err := <-done:
+		t.Fatalf("Server exited before starting: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to start")
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "0" {
+		t.Fatalf("Expected a bound address with the assigned port, got %q", addr)
+	}
+
+	resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", port) + "/display/code.json")
+	if err != nil {
+		t.Fatalf("Failed to reach the pairing server at %s: %v", addr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 from %s, got %d", addr, resp.StatusCode)
+	}
+
+	pm.StopPairingServer()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to stop")
+	}
+}
+
+func TestStartPairingServerPortsExhausted(t *testing.T) {
+	original := bindRetryDelay
+	bindRetryDelay = time.Millisecond
+	defer func() { bindRetryDelay = original }()
+
+	cfg := config.ClientConfig{
+		PairingBindAttempts:  3,
+		PairingFallbackPorts: []int{busyPort(t)},
+	}
+
+	pm := NewPairingManager()
+	pm.SetOnServerStarted(func(addr string) { t.Errorf("Server should not report starting on %s", addr) })
+
+	start := time.Now()
+	err := pm.StartPairingServerOnPort(cfg, busyPort(t), false)
+	if !errors.Is(err, ErrPairingPortUnavailable) {
+		t.Fatalf("Expected ErrPairingPortUnavailable, got %v", err)
+	}
+	// Two ports, three attempts each: delays of 1ms and 2ms per port
+	if elapsed := time.Since(start); elapsed < 6*time.Millisecond {
+		t.Errorf("Expected backoff between attempts, finished in %v", elapsed)
+	}
+	if pm.IsServerRunning() {
+		t.Error("Server should not be running")
+	}
+}
+
+func TestOnCodeExpiredCallback(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	original := pairingCodeCleanupInterval
+	pairingCodeCleanupInterval = 20 * time.Millisecond
+	defer func() { pairingCodeCleanupInterval = original }()
+
+	pm := NewPairingManager()
+	started := make(chan string, 1)
+	pm.SetOnServerStarted(func(addr string) { started <- addr })
+
+	type expiredEvent struct{ code, reason string }
+	expired := make(chan expiredEvent, 1)
+	pm.SetOnCodeExpired(func(code string, reason string) {
+		expired <- expiredEvent{code, reason}
+	})
+
+	go pm.StartPairingServerOnPort(config.ClientConfig{}, freePort(t), true)
+	defer pm.StopPairingServer()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to start")
+	}
+
+	pm.codeMutex.Lock()
+	pm.pairCode = "123456"
+	pm.expiry = time.Now().Add(100 * time.Millisecond)
+	pm.codeMutex.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	select {
+	case event := <-expired:
+		if event.code != "123456" || event.reason != "expired" {
+			t.Errorf("Expected code 123456 with reason expired, got %+v", event)
+		}
+	default:
+		t.Fatal("Expected the code expired callback to fire")
+	}
+
+	if reason, _ := pm.display.LastExpired(); reason != "expired" {
+		t.Errorf("Expected the display to record reason expired, got %q", reason)
+	}
+	if data := pm.display.GetTemplateData(); data.HasCode || data.ExpiredReason != "expired" {
+		t.Errorf("Expected no code with reason expired, got %+v", data)
+	}
+}
+
+func TestClearAllCallbacksClearsOnCodeExpired(t *testing.T) {
+	pm := NewPairingManager()
+	called := false
+	pm.SetOnCodeExpired(func(code string, reason string) { called = true })
+	pm.ClearAllCallbacks()
+
+	pm.triggerOnCodeExpired("123456", "expired")
+	if called {
+		t.Error("Expected ClearAllCallbacks to clear the code expired callback")
+	}
+}
+
+// unwritableDir returns a directory path that cannot be created, even as root,
+// because its parent is a regular file
+func unwritableDir(t *testing.T) string {
+	t.Helper()
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatalf("Failed to create blocking file: %v", err)
+	}
+	return filepath.Join(blocker, "state")
+}
+
+func TestConfirmStateSaveFailure(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+	}
+	stateDir := t.TempDir()
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Setenv("MSC_STATE_PATH", unwritableDir(t))
+
+	server, cancel := createTestServer(t, pm, cfg)
+	defer server.Close()
+	defer cancel()
+
+	resp, err := http.Get(server.URL + "/pair")
+	if err != nil {
+		t.Fatalf("Failed to request pairing code: %v", err)
+	}
+	resp.Body.Close()
+	code, _ := pm.GetPairingCode()
+	if code == "" {
+		t.Fatal("No pairing code generated")
+	}
+
+	confirm := func() *http.Response {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"code": code, "serverWs": "ws://test-server:8080/ws"})
+		resp, err := http.Post(server.URL+"/pair/confirm", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to confirm pairing: %v", err)
+		}
+		return resp
+	}
+
+	resp = confirm()
+	var errorBody map[string]any
+	json.NewDecoder(resp.Body).Decode(&errorBody)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 when state cannot be saved, got %d", resp.StatusCode)
+	}
+	if errorBody["error"] != "failed to persist pairing state" {
+		t.Errorf("Unexpected error body: %v", errorBody)
+	}
+
+	// The code survives with its attempts intact
+	if current, _, failCount, _ := pm.GetPairingStatus("127.0.0.1"); current != code || failCount != 0 {
+		t.Errorf("Expected code %s with 0 failures to remain, got %q with %d", code, current, failCount)
+	}
+
+	// The installer retries once the disk is writable again
+	t.Setenv("MSC_STATE_PATH", stateDir)
+	resp = confirm()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected retry to succeed, got %d", resp.StatusCode)
+	}
+	if !state.HasState() {
+		t.Error("State should be saved after the retry")
+	}
+}
+
+func TestPairCodeSaveFailure(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{AllowIPSubnetMatch: true}
+	t.Setenv("MSC_PAIRING_PATH", unwritableDir(t))
+
+	server, cancel := createTestServer(t, pm, cfg)
+	defer server.Close()
+	defer cancel()
+
+	resp, err := http.Get(server.URL + "/pair")
+	if err != nil {
+		t.Fatalf("Failed to request pairing code: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 when the pairing code cannot be saved, got %d", resp.StatusCode)
+	}
+	if code, _ := pm.GetPairingCode(); code != "" {
+		t.Errorf("Expected no active code after a failed save, got %q", code)
+	}
+
+	// A retry generates a new code once the disk is writable again
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	resp, err = http.Get(server.URL + "/pair")
+	if err != nil {
+		t.Fatalf("Failed to request pairing code: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected retry to succeed, got %d", resp.StatusCode)
+	}
+}
+
+func TestConfirmReplayDuringGrace(t *testing.T) {
+	pm := NewPairingManager()
+	cfg := config.ClientConfig{
+		VerificationCodeAttempts: 3,
+		AllowIPSubnetMatch:       true,
+		PairingConfirmGrace:      time.Minute,
+	}
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+
+	server, cancel := createTestServer(t, pm, cfg)
+	defer server.Close()
+	defer cancel()
+	defer pm.ClearConfirmGrace()
+
+	resp, err := http.Get(server.URL + "/pair")
+	if err != nil {
+		t.Fatalf("Failed to request pairing code: %v", err)
+	}
+	resp.Body.Close()
+	code, _ := pm.GetPairingCode()
+	if code == "" {
+		t.Fatal("No pairing code generated")
+	}
+
+	confirm := func(code string) (int, []byte) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"code": code, "serverWs": "ws://test-server:8080/ws"})
+		resp, err := http.Post(server.URL+"/pair/confirm", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to confirm pairing: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	status, first := confirm(code)
+	if status != http.StatusOK {
+		t.Fatalf("Expected first confirm to succeed, got %d: %s", status, first)
+	}
+
+	// The installer missed the response and retries
+	status, replay := confirm(code)
+	if status != http.StatusOK {
+		t.Fatalf("Expected replayed confirm to succeed, got %d: %s", status, replay)
+	}
+	if !bytes.Equal(first, replay) {
+		t.Errorf("Replayed response differs:\nfirst:  %s\nreplay: %s", first, replay)
+	}
+
+	// A different code is not a retry of the accepted confirm
+	other := "1" + code[1:]
+	if code[0] == '1' {
+		other = "2" + code[1:]
+	}
+	if status, _ := confirm(other); status == http.StatusOK {
+		t.Error("Expected a different code to be rejected")
+	}
+
+	// No new code is handed out while the accepted confirm is replayed
+	resp, err = http.Get(server.URL + "/pair")
+	if err != nil {
+		t.Fatalf("Failed to request pairing code: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for /pair during the grace period, got %d", resp.StatusCode)
+	}
+	if current, _ := pm.GetPairingCode(); current != "" {
+		t.Error("Expected no pairing code to be generated during the grace period")
+	}
+
+	// Once the WebSocket connects, retries are no longer answered
+	pm.ClearConfirmGrace()
+	if status, _ := confirm(code); status == http.StatusOK {
+		t.Error("Expected confirm to be rejected after the grace period was cleared")
+	}
+}
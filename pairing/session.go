@@ -0,0 +1,163 @@
+package pairing
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"msm-client/utils"
+)
+
+// pairingSession is the state needed to finish a pairing after the server
+// restarts; the code itself stays in the pairing code file
+type pairingSession struct {
+	Expiry     time.Time     `json:"expiry"`
+	Remaining  time.Duration `json:"remaining"` // Validity left when saved, less the time since SavedAt on restore
+	SavedAt    time.Time     `json:"savedAt"`
+	BootID     string        `json:"bootId,omitempty"`    // Boot the session was saved in
+	SinceBoot  time.Duration `json:"sinceBoot,omitempty"` // Time since boot when saved; measures restarts within the same boot
+	CodeIP     string        `json:"codeIp"`
+	FailCount  int           `json:"failCount"`
+	PrivateKey []byte        `json:"privateKey"` // ECDH private key sealed with utils.SealAtRest
+}
+
+// bootTimeFunc reads the boot ID and time since boot; a variable so tests can fake restarts
+var bootTimeFunc = utils.GetBootTime
+
+// getPairingSessionPath returns the path of the pairing session file, next to the pairing code file
+func getPairingSessionPath() string {
+	return filepath.Join(filepath.Dir(getPairingPath()), PAIRING_SESSION_FILE)
+}
+
+// savePairingSessionLocked persists the current code's expiry, fail count and
+// ECDH key pair; the caller must hold codeMutex
+func (pm *PairingManager) savePairingSessionLocked() error {
+	privateKey := utils.ExportECDHPrivateKey()
+	if privateKey == nil {
+		return fmt.Errorf("no ECDH key pair to save")
+	}
+	defer utils.Zeroize(privateKey)
+
+	sealed, err := utils.SealAtRest(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to seal ECDH private key: %w", err)
+	}
+
+	session := pairingSession{
+		Expiry:     pm.expiry,
+		Remaining:  pm.clock.Remaining(pm.expiry),
+		SavedAt:    pm.clock.Now(),
+		CodeIP:     pm.pairCodeIP,
+		FailCount:  pm.failCount,
+		PrivateKey: sealed,
+	}
+	if bootID, sinceBoot, ok := bootTimeFunc(); ok {
+		session.BootID = bootID
+		session.SinceBoot = sinceBoot
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return utils.WritePrivateFile(getPairingSessionPath(), data)
+}
+
+// deletePairingSession removes the pairing session file
+func deletePairingSession() error {
+	if err := os.Remove(getPairingSessionPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete pairing session file: %w", err)
+	}
+	return nil
+}
+
+// sessionAge returns how long ago session was saved. Within the same boot it is
+// measured on the time since boot, which wall-clock jumps do not affect. After a
+// reboot only the wall clock is left; ok is false when it reads earlier than the
+// save time, as the downtime cannot be told then.
+func (pm *PairingManager) sessionAge(session pairingSession) (age time.Duration, ok bool) {
+	if bootID, sinceBoot, ok := bootTimeFunc(); ok && session.BootID != "" && bootID == session.BootID {
+		return sinceBoot - session.SinceBoot, true
+	}
+	age = pm.clock.Now().Sub(session.SavedAt)
+	return age, age >= 0
+}
+
+// RestorePairingSession reloads a pairing code and its ECDH key pair saved before
+// a restart. It returns true when the code is still valid and was restored; an
+// expired, exhausted or unreadable session is deleted.
+func (pm *PairingManager) RestorePairingSession() bool {
+	pm.codeMutex.Lock()
+	defer pm.codeMutex.Unlock()
+
+	if pm.pairCode != "" {
+		return false
+	}
+
+	data, err := os.ReadFile(getPairingSessionPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read pairing session: %v", err)
+		}
+		return false
+	}
+
+	var session pairingSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		log.Printf("Discarding unreadable pairing session: %v", err)
+		_ = pm.DeletePairingCode()
+		return false
+	}
+
+	cfg := pm.GetConfig()
+	maxAttempts := cfg.GetVerificationCodeAttempts()
+	// The saved expiry is a wall-clock time, wrong once the clock jumps (as on
+	// boards that boot at the epoch until NTP syncs), so take the validity left
+	// at save time less the time since. Sessions saved without it fall back to
+	// the expiry.
+	remaining := pm.clock.Remaining(session.Expiry)
+	if session.Remaining != 0 {
+		remaining = 0
+		if elapsed, ok := pm.sessionAge(session); ok {
+			remaining = session.Remaining - elapsed
+		}
+	}
+	if remaining <= 0 || session.FailCount >= maxAttempts {
+		log.Println("Discarding pairing session from before restart: code expired or max attempts reached")
+		_ = pm.DeletePairingCode()
+		return false
+	}
+
+	code, err := pm.LoadPairingCode()
+	if err != nil || code == "" {
+		log.Printf("Discarding pairing session without a pairing code")
+		_ = pm.DeletePairingCode()
+		return false
+	}
+
+	privateKey, err := utils.OpenAtRest(session.PrivateKey)
+	if err == nil {
+		err = utils.RestoreECDHPrivateKey(privateKey)
+		utils.Zeroize(privateKey)
+	}
+	if err != nil {
+		log.Printf("Discarding pairing session, failed to restore ECDH key pair: %v", err)
+		_ = pm.DeletePairingCode()
+		return false
+	}
+
+	pm.pairCode = code
+	pm.pairCodeIP = session.CodeIP
+	pm.expiry = pm.clock.Deadline(remaining)
+	pm.failCount = session.FailCount
+	// Keep the expiry in the code file in line with the restarted validity
+	if err := pm.SavePairingCode(code, pm.expiry); err != nil {
+		log.Printf("Failed to update pairing code file: %v", err)
+	}
+
+	log.Printf("Restored pairing code %s from before restart, expires in %s", codeFingerprint(code), remaining.Round(time.Second))
+	return true
+}
@@ -0,0 +1,283 @@
+package pairing
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// setupSessionTest isolates the pairing and state paths and skips when the
+// machine-bound key is unavailable
+func setupSessionTest(t *testing.T) config.ClientConfig {
+	t.Helper()
+	if _, err := utils.SealAtRest([]byte("probe")); err != nil {
+		t.Skipf("Machine-bound key unavailable: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	t.Setenv("MSC_PAIRING_PATH", tmpDir)
+	t.Setenv("MSC_STATE_PATH", tmpDir)
+	t.Cleanup(utils.ClearECDHKeys)
+
+	return config.ClientConfig{
+		VerificationCodeLength:   6,
+		VerificationCodeAttempts: 3,
+		PairingCodeExpiration:    time.Minute,
+		AllowIPSubnetMatch:       true,
+		DisableConnectivityCheck: true,
+	}
+}
+
+// requestPairingCode runs /pair on pm and returns the generated code
+func requestPairingCode(t *testing.T, pm *PairingManager, cfg config.ClientConfig) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/pair", nil)
+	req.RemoteAddr = "192.168.1.100:12345"
+	rr := httptest.NewRecorder()
+	pm.HandlePair(cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected /pair to return 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	code, _ := pm.GetPairingCode()
+	if code == "" {
+		t.Fatal("Expected a pairing code")
+	}
+	return code
+}
+
+func TestConfirmAfterPairingServerRestart(t *testing.T) {
+	cfg := setupSessionTest(t)
+
+	pm := NewPairingManager()
+	pm.SetConfig(cfg)
+	code := requestPairingCode(t, pm, cfg)
+	publicKey := utils.GetECDHPublicKey()
+
+	// The private key must not be stored in the clear
+	data, err := os.ReadFile(filepath.Join(os.Getenv("MSC_PAIRING_PATH"), PAIRING_SESSION_FILE))
+	if err != nil {
+		t.Fatalf("Expected a pairing session file: %v", err)
+	}
+	if raw := utils.ExportECDHPrivateKey(); bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(raw))) {
+		t.Error("Pairing session file contains the plaintext private key")
+	}
+	if err := utils.CheckPrivatePath(getPairingSessionPath()); err != nil {
+		t.Errorf("Expected a private pairing session file: %v", err)
+	}
+
+	// Simulate the process restarting between /pair and /pair/confirm
+	utils.ClearECDHKeys()
+	restarted := NewPairingManager()
+	restarted.SetConfig(cfg)
+	if !restarted.RestorePairingSession() {
+		t.Fatal("Expected the pairing session to be restored")
+	}
+	if utils.GetECDHPublicKey() != publicKey {
+		t.Error("Restored key pair differs from the one issued with the code")
+	}
+
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate server key: %v", err)
+	}
+	jsonBody, _ := json.Marshal(map[string]any{
+		"code":            code,
+		"serverWs":        "ws://test-server:8080/ws",
+		"serverPublicKey": base64.StdEncoding.EncodeToString(serverKey.PublicKey().Bytes()),
+	})
+	req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+	req.RemoteAddr = "192.168.1.100:12345"
+	rr := httptest.NewRecorder()
+	restarted.HandleConfirm(cfg).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected confirm to succeed after restart, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["sessionKeyDerived"] != true {
+		t.Errorf("Expected a derived session key, got %v", response)
+	}
+	if _, err := os.Stat(getPairingSessionPath()); !os.IsNotExist(err) {
+		t.Error("Expected the pairing session file to be removed after pairing")
+	}
+}
+
+func TestRestorePairingSessionDiscardsInvalidCode(t *testing.T) {
+	cfg := setupSessionTest(t)
+
+	t.Run("Reset code", func(t *testing.T) {
+		pm := NewPairingManager()
+		pm.SetConfig(cfg)
+		requestPairingCode(t, pm, cfg)
+		pm.ResetPairing()
+
+		if _, err := os.Stat(getPairingSessionPath()); !os.IsNotExist(err) {
+			t.Error("Expected ResetPairing to remove the pairing session file")
+		}
+		if NewPairingManager().RestorePairingSession() {
+			t.Error("Expected no session to restore after a reset")
+		}
+	})
+
+	t.Run("Max attempts reached", func(t *testing.T) {
+		pm := NewPairingManager()
+		pm.SetConfig(cfg)
+		requestPairingCode(t, pm, cfg)
+
+		for i := 0; i < cfg.VerificationCodeAttempts; i++ {
+			jsonBody, _ := json.Marshal(map[string]any{"code": "wrong", "serverWs": "ws://test-server:8080/ws"})
+			req := httptest.NewRequest("POST", "/pair/confirm", bytes.NewReader(jsonBody))
+			req.RemoteAddr = "192.168.1.100:12345"
+			pm.HandleConfirm(cfg).ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		restarted := NewPairingManager()
+		restarted.SetConfig(cfg)
+		if restarted.RestorePairingSession() {
+			t.Error("Expected an exhausted code not to be restored")
+		}
+		if _, err := os.Stat(getPairingPath()); !os.IsNotExist(err) {
+			t.Error("Expected the exhausted pairing code file to be removed")
+		}
+	})
+}
+
+func TestPairingDeadlinesSurviveClockJumps(t *testing.T) {
+	cfg := setupSessionTest(t)
+	cfg.MaxIPViolations = 1
+	cfg.IPBlacklistDuration = time.Hour
+
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	pm := NewPairingManager()
+	pm.clock = clock
+	pm.SetConfig(cfg)
+	code := requestPairingCode(t, pm, cfg)
+	pm.recordIPViolation("192.168.1.50")
+
+	// NTP sync on a board that booted at the epoch
+	clock.JumpWall(56 * 365 * 24 * time.Hour)
+	if current, _ := pm.GetPairingCode(); current != code {
+		t.Error("Pairing code expired after a forward wall-clock jump")
+	}
+	if !pm.isIPBlacklisted("192.168.1.50") {
+		t.Error("Blacklist entry expired after a forward wall-clock jump")
+	}
+
+	// Backwards jumps do not extend validity either
+	clock.JumpWall(-100 * 365 * 24 * time.Hour)
+	clock.Advance(cfg.PairingCodeExpiration)
+	if current, _ := pm.GetPairingCode(); current != "" {
+		t.Error("Pairing code should expire after its validity, regardless of wall-clock jumps")
+	}
+	if !pm.isIPBlacklisted("192.168.1.50") {
+		t.Error("Blacklist entry should last its full duration")
+	}
+	clock.Advance(cfg.IPBlacklistDuration)
+	if pm.isIPBlacklisted("192.168.1.50") {
+		t.Error("Blacklist entry should expire after its duration")
+	}
+}
+
+func TestRestorePairingSessionAfterClockJump(t *testing.T) {
+	cfg := setupSessionTest(t)
+
+	origBootTime := bootTimeFunc
+	defer func() { bootTimeFunc = origBootTime }()
+	fakeBoot := func(id string, sinceBoot time.Duration) {
+		bootTimeFunc = func() (string, time.Duration, bool) { return id, sinceBoot, true }
+	}
+
+	// saveSession generates a code at the epoch and saves the session with 40s left
+	saveSession := func(t *testing.T) string {
+		t.Helper()
+		fakeBoot("boot-1", 100*time.Second)
+		clock := utils.NewFakeClock(time.Unix(0, 0))
+		pm := NewPairingManager()
+		pm.clock = clock
+		pm.SetConfig(cfg)
+		code := requestPairingCode(t, pm, cfg)
+		clock.Advance(20 * time.Second)
+		pm.codeMutex.Lock()
+		defer pm.codeMutex.Unlock()
+		if err := pm.savePairingSessionLocked(); err != nil {
+			t.Fatalf("Failed to save pairing session: %v", err)
+		}
+		utils.ClearECDHKeys()
+		return code
+	}
+
+	// restart restores the session with the wall clock reading now
+	restart := func(now time.Time) (*PairingManager, *utils.FakeClock, bool) {
+		clock := utils.NewFakeClock(now)
+		restarted := NewPairingManager()
+		restarted.clock = clock
+		restarted.SetConfig(cfg)
+		return restarted, clock, restarted.RestorePairingSession()
+	}
+
+	t.Run("Same boot after a forward jump", func(t *testing.T) {
+		code := saveSession(t)
+
+		// The client restarts 10s later, after NTP moved the wall clock decades ahead
+		fakeBoot("boot-1", 110*time.Second)
+		restarted, clock, ok := restart(time.Unix(0, 0).Add(56 * 365 * 24 * time.Hour))
+		if !ok {
+			t.Fatal("Expected the pairing session to be restored after a clock jump")
+		}
+		current, expiry := restarted.GetPairingCode()
+		if current != code {
+			t.Errorf("Expected code %s to be restored, got %q", code, current)
+		}
+		if remaining := clock.Remaining(expiry); remaining != 30*time.Second {
+			t.Errorf("Expected the 40s left at save time less the 10s restart, got %v", remaining)
+		}
+	})
+
+	t.Run("Same boot after the validity ran out", func(t *testing.T) {
+		saveSession(t)
+
+		fakeBoot("boot-1", 150*time.Second)
+		if _, _, ok := restart(time.Unix(0, 0).Add(-time.Hour)); ok {
+			t.Error("Expected the session to expire while the client was down")
+		}
+	})
+
+	t.Run("Reboot measured on the wall clock", func(t *testing.T) {
+		saveSession(t)
+
+		fakeBoot("boot-2", 5*time.Second)
+		restarted, clock, ok := restart(time.Unix(0, 0).Add(35 * time.Second))
+		if !ok {
+			t.Fatal("Expected the pairing session to be restored after a reboot")
+		}
+		_, expiry := restarted.GetPairingCode()
+		if remaining := clock.Remaining(expiry); remaining != 25*time.Second {
+			t.Errorf("Expected 25s left after 15s of downtime, got %v", remaining)
+		}
+	})
+
+	t.Run("Reboot with the wall clock behind", func(t *testing.T) {
+		saveSession(t)
+
+		// The downtime cannot be told, so the code must not outlive it
+		fakeBoot("boot-2", 5*time.Second)
+		if _, _, ok := restart(time.Unix(0, 0)); ok {
+			t.Error("Expected the session to be discarded when the wall clock went back")
+		}
+	})
+}
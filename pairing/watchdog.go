@@ -0,0 +1,71 @@
+package pairing
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// selfCheckTimeout bounds the watchdog's request to the pairing server
+const selfCheckTimeout = 5 * time.Second
+
+// WatchdogCheck returns the watchdog check of the pairing server: while it
+// runs it must answer its own /pair/status on loopback, otherwise it is
+// stopped for the caller of StartPairingServerOnPort to start again
+func (pm *PairingManager) WatchdogCheck() utils.WatchdogCheck {
+	return utils.WatchdogCheck{
+		Name:    config.WatchdogCheckPairing,
+		Check:   pm.checkServerAnswers,
+		Recover: pm.RestartPairingServer,
+	}
+}
+
+// checkServerAnswers requests /pair/status from the running pairing server
+func (pm *PairingManager) checkServerAnswers() error {
+	server := pm.GetServer()
+	if server == nil || !pm.IsServerRunning() {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(server.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid pairing server address %q: %w", server.Addr, err)
+	}
+
+	client := &http.Client{Timeout: selfCheckTimeout}
+	resp, err := client.Get("http://" + net.JoinHostPort("127.0.0.1", port) + "/pair/status")
+	if err != nil {
+		return fmt.Errorf("pairing server did not answer: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("pairing server answered HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RestartPairingServer stops the pairing server and marks the stop as a
+// restart, which TakeRestartRequest reports to the caller of
+// StartPairingServerOnPort
+func (pm *PairingManager) RestartPairingServer() {
+	pm.serverMutex.Lock()
+	pm.restartRequested = true
+	pm.serverMutex.Unlock()
+
+	log.Println("Restarting pairing server")
+	pm.StopPairingServer()
+}
+
+// TakeRestartRequest reports whether the pairing server was stopped to be
+// restarted, clearing the request
+func (pm *PairingManager) TakeRestartRequest() bool {
+	pm.serverMutex.Lock()
+	defer pm.serverMutex.Unlock()
+	requested := pm.restartRequested
+	pm.restartRequested = false
+	return requested
+}
@@ -0,0 +1,69 @@
+package pairing
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestPairingWatchdogCheck(t *testing.T) {
+	t.Setenv("MSC_PAIRING_PATH", t.TempDir())
+
+	pm := NewPairingManager()
+	watchdog := utils.NewWatchdog()
+	watchdog.Add(pm.WatchdogCheck())
+
+	if violated := watchdog.RunChecks(); len(violated) != 0 {
+		t.Errorf("Expected no violation without a pairing server, got %v", violated)
+	}
+
+	started := make(chan string, 1)
+	pm.SetOnServerStarted(func(addr string) { started <- addr })
+	done := make(chan error, 1)
+	go func() { done <- pm.StartPairingServerOnPort(config.ClientConfig{}, 0, false) }()
+	select {
+	case <-started:
+	case err := <-done:
+		t.Fatalf("Server exited before starting: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pairing server to start")
+	}
+
+	if violated := watchdog.RunChecks(); len(violated) != 0 {
+		t.Errorf("Expected a running pairing server to pass, got %v", violated)
+	}
+	pm.StopPairingServer()
+	<-done
+	if pm.TakeRestartRequest() {
+		t.Error("A plain stop must not request a restart")
+	}
+
+	// A server that no longer accepts connections on its address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	pm.setServer(&http.Server{Addr: addr})
+
+	if violated := watchdog.RunChecks(); len(violated) != 1 || violated[0] != config.WatchdogCheckPairing {
+		t.Fatalf("Expected the pairing check to fail, got %v", violated)
+	}
+	if pm.IsServerRunning() {
+		t.Error("Expected the unresponsive server to be stopped")
+	}
+	if !pm.TakeRestartRequest() {
+		t.Error("Expected a restart to be requested")
+	}
+	if pm.TakeRestartRequest() {
+		t.Error("Expected the restart request to be cleared once taken")
+	}
+	if violations := watchdog.Violations(); violations[config.WatchdogCheckPairing] != 1 {
+		t.Errorf("Expected one pairing violation, got %v", violations)
+	}
+}
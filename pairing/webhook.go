@@ -0,0 +1,197 @@
+package pairing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"msm-client/utils"
+)
+
+// Pairing webhook event names
+const (
+	WebhookEventServerStarted    = "server_started"
+	WebhookEventCodeGenerated    = "code_generated"
+	WebhookEventPairingSucceeded = "pairing_succeeded"
+	WebhookEventPairingFailed    = "pairing_failed"
+	WebhookEventServerStopped    = "server_stopped"
+)
+
+// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of
+// the request body, keyed with the configured pairing webhook secret
+const WebhookSignatureHeader = "X-MSM-Signature"
+
+// Webhook delivery limits. These are variables so tests can shorten them.
+var (
+	webhookTimeout     = 5 * time.Second
+	webhookRetryDelays = []time.Duration{time.Second, 3 * time.Second} // Delays before each retry
+	webhookQueueSize   = 32
+)
+
+// WebhookStats counts pairing webhook deliveries
+type WebhookStats struct {
+	Sent    uint64 `json:"sent"`    // Events accepted by the webhook with a 2xx response
+	Failed  uint64 `json:"failed"`  // Events given up on after all attempts
+	Dropped uint64 `json:"dropped"` // Events discarded because the queue was full
+}
+
+type webhookEvent struct {
+	url     string
+	secret  string
+	name    string
+	payload []byte
+}
+
+// webhookNotifier posts pairing events in order from a single background
+// worker, so the HTTP handlers never wait on the webhook
+type webhookNotifier struct {
+	mu      sync.Mutex
+	queue   []webhookEvent
+	running bool // Whether the worker goroutine is draining the queue
+
+	client *http.Client
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newWebhookNotifier() *webhookNotifier {
+	return &webhookNotifier{client: &http.Client{}}
+}
+
+// enqueue queues an event for delivery, dropping it when the queue is full
+func (n *webhookNotifier) enqueue(event webhookEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.queue) >= webhookQueueSize {
+		n.dropped.Add(1)
+		log.Printf("Pairing webhook queue full, dropping %s event", event.name)
+		return
+	}
+	n.queue = append(n.queue, event)
+
+	if !n.running {
+		n.running = true
+		go n.run()
+	}
+}
+
+// run delivers queued events until the queue is empty
+func (n *webhookNotifier) run() {
+	for {
+		n.mu.Lock()
+		if len(n.queue) == 0 {
+			n.running = false
+			n.mu.Unlock()
+			return
+		}
+		event := n.queue[0]
+		n.queue = n.queue[1:]
+		n.mu.Unlock()
+
+		n.deliver(event)
+	}
+}
+
+// deliver posts event, retrying after each of webhookRetryDelays
+func (n *webhookNotifier) deliver(event webhookEvent) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = n.post(event); err == nil {
+			n.sent.Add(1)
+			return
+		}
+		if attempt >= len(webhookRetryDelays) {
+			break
+		}
+		time.Sleep(webhookRetryDelays[attempt])
+	}
+
+	n.failed.Add(1)
+	log.Printf("Pairing webhook %s event to %s failed: %v", event.name, utils.RedactURL(event.url), err)
+}
+
+func (n *webhookNotifier) post(event webhookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, event.url, bytes.NewReader(event.payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if event.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(event.secret, event.payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *webhookNotifier) stats() WebhookStats {
+	return WebhookStats{
+		Sent:    n.sent.Load(),
+		Failed:  n.failed.Load(),
+		Dropped: n.dropped.Load(),
+	}
+}
+
+// SignWebhookPayload returns the X-MSM-Signature header value for payload
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhook queues a pairing event for the configured webhook, if any.
+// fields are added to the event, client_id and timestamp.
+func (pm *PairingManager) notifyWebhook(name string, fields map[string]any) {
+	cfg := pm.GetConfig()
+	if cfg.PairingWebhookURL == "" {
+		return
+	}
+
+	body := map[string]any{
+		"event":     name,
+		"client_id": cfg.ClientID,
+		"timestamp": pm.clock.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range fields {
+		body[key] = value
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to encode pairing webhook %s event: %v", name, err)
+		return
+	}
+
+	pm.webhook.enqueue(webhookEvent{
+		url:     cfg.PairingWebhookURL,
+		secret:  cfg.PairingWebhookSecret,
+		name:    name,
+		payload: payload,
+	})
+}
+
+// GetWebhookStats returns the pairing webhook delivery counters
+func (pm *PairingManager) GetWebhookStats() WebhookStats {
+	return pm.webhook.stats()
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"msm-client/config"
+	"msm-client/control"
+)
+
+const bootRecordFile = "last_boot.json"
+
+// bootLogPrefix starts the boot record log line, followed by the record as JSON
+const bootLogPrefix = "boot_record "
+
+// BootRecord describes the device once per start for fleet log aggregation.
+// It is built from an allowlist of fields and never holds secrets.
+type BootRecord struct {
+	Time                    time.Time `json:"time"`
+	Version                 string    `json:"version"`
+	ClientID                string    `json:"client_id"`
+	DeviceName              string    `json:"device_name"`
+	ConfigPath              string    `json:"config_path"`
+	StatePresent            bool      `json:"state_present"`
+	IPValidationMode        string    `json:"ip_validation_mode"`
+	DryRun                  bool      `json:"dry_run"`
+	CommandsDisabled        bool      `json:"commands_disabled"`
+	DisabledCommandGroups   []string  `json:"disabled_command_groups"`   // Command groups switched off by the config
+	EnabledOptionalCommands []string  `json:"enabled_optional_commands"` // Commands that only run when the config allows them
+	ManagementListeners     []string  `json:"management_listeners"`      // Control socket and, when configured, its TCP fallback
+}
+
+// NewBootRecord describes the client running version with cfg
+func NewBootRecord(version string, cfg config.ClientConfig) BootRecord {
+	record := BootRecord{
+		Time:                    time.Now().UTC(),
+		Version:                 version,
+		ClientID:                cfg.ClientID,
+		DeviceName:              cfg.DeviceName,
+		ConfigPath:              config.FilePath(),
+		StatePresent:            HasState(),
+		IPValidationMode:        cfg.GetIPValidationMode(),
+		DryRun:                  cfg.DryRun,
+		CommandsDisabled:        cfg.DisableCommands,
+		DisabledCommandGroups:   []string{},
+		EnabledOptionalCommands: []string{},
+		ManagementListeners:     []string{"unix:" + control.SocketPath()},
+	}
+
+	if cfg.DisableDiagnosticCommands {
+		record.DisabledCommandGroups = append(record.DisabledCommandGroups, "diagnostic")
+	}
+	if cfg.DisableSystemInfo {
+		record.DisabledCommandGroups = append(record.DisabledCommandGroups, "system_info")
+	}
+	if cfg.AllowNotifications {
+		record.EnabledOptionalCommands = append(record.EnabledOptionalCommands, "send_test_notification")
+	}
+	if cfg.AllowFileBrowse {
+		record.EnabledOptionalCommands = append(record.EnabledOptionalCommands, "list_files")
+	}
+	if cfg.DebugRuntimeStats {
+		record.EnabledOptionalCommands = append(record.EnabledOptionalCommands, "runtime_profile")
+	}
+	if cfg.ControlTCPPort > 0 {
+		record.ManagementListeners = append(record.ManagementListeners, "tcp:"+cfg.ManagementListenAddress(cfg.ControlTCPPort))
+	}
+	return record
+}
+
+// RecordBoot logs record as a single line and saves it to the state directory,
+// where the doctor command and support bundles pick it up
+func RecordBoot(record BootRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	log.Print(bootLogPrefix + string(data))
+
+	dir := getStateDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, bootRecordFile), data, 0600)
+}
+
+// LoadBootRecord reads the record saved by the most recent start
+func LoadBootRecord() (BootRecord, error) {
+	var record BootRecord
+	data, err := os.ReadFile(filepath.Join(getStateDir(), bootRecordFile))
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
@@ -0,0 +1,91 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"msm-client/config"
+)
+
+func TestRecordBoot(t *testing.T) {
+	stateDir := t.TempDir()
+	t.Setenv("MSC_STATE_PATH", stateDir)
+	t.Setenv("MSC_CONFIG_PATH", "/etc/msm-test")
+	t.Setenv("MSC_CONTROL_SOCKET", "/run/msm-test/control.sock")
+
+	cfg := config.ClientConfig{
+		ClientID:             "5b0c6f4e-3a53-4f0e-9b8e-2f1a4d7c9e10",
+		DeviceName:           "Lobby",
+		StrictIPValidation:   true,
+		DisableSystemInfo:    true,
+		AllowFileBrowse:      true,
+		ControlTCPPort:       9100,
+		DisplayAdminToken:    "admin-secret",
+		PairingWebhookSecret: "webhook-secret",
+	}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	if err := RecordBoot(NewBootRecord("1.2.3", cfg)); err != nil {
+		t.Fatalf("RecordBoot failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single log line, got %q", output.String())
+	}
+	_, logged, found := strings.Cut(lines[0], bootLogPrefix)
+	if !found {
+		t.Fatalf("Expected the boot record prefix in %q", lines[0])
+	}
+
+	fileData, err := os.ReadFile(filepath.Join(stateDir, bootRecordFile))
+	if err != nil {
+		t.Fatalf("Failed to read the boot record file: %v", err)
+	}
+
+	required := []string{
+		"time", "version", "client_id", "device_name", "config_path", "state_present",
+		"ip_validation_mode", "commands_disabled", "disabled_command_groups",
+		"enabled_optional_commands", "management_listeners",
+	}
+	for source, data := range map[string]string{"log": logged, "file": string(fileData)} {
+		if strings.Contains(data, "secret") {
+			t.Errorf("The %s record must not contain secrets: %s", source, data)
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &fields); err != nil {
+			t.Fatalf("Failed to parse the %s record: %v", source, err)
+		}
+		for _, key := range required {
+			if _, ok := fields[key]; !ok {
+				t.Errorf("Expected %q in the %s record, got %v", key, source, fields)
+			}
+		}
+	}
+
+	record, err := LoadBootRecord()
+	if err != nil {
+		t.Fatalf("LoadBootRecord failed: %v", err)
+	}
+	if record.Version != "1.2.3" || record.ClientID != cfg.ClientID || record.StatePresent {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if record.ConfigPath != "/etc/msm-test/config.json" || record.IPValidationMode != "strict" {
+		t.Errorf("Expected the config path and IP validation mode, got %q and %q", record.ConfigPath, record.IPValidationMode)
+	}
+	if strings.Join(record.DisabledCommandGroups, ",") != "system_info" || strings.Join(record.EnabledOptionalCommands, ",") != "list_files" {
+		t.Errorf("Unexpected command groups %v and %v", record.DisabledCommandGroups, record.EnabledOptionalCommands)
+	}
+	if strings.Join(record.ManagementListeners, ",") != "unix:/run/msm-test/control.sock,tcp:127.0.0.1:9100" {
+		t.Errorf("Unexpected management listeners %v", record.ManagementListeners)
+	}
+}
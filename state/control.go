@@ -0,0 +1,64 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	"msm-client/control"
+)
+
+// ControlVerbStatus reports the client mode over the control socket
+const ControlVerbStatus = "client.status"
+
+// ClientStatus is the client mode reported over the control socket
+type ClientStatus struct {
+	Mode            Mode         `json:"mode"`
+	Since           time.Time    `json:"since"`
+	Paired          bool         `json:"paired"`
+	LastServerError *ServerError `json:"last_server_error,omitempty"`
+}
+
+// ServerError is an error message the server sent to the client
+type ServerError struct {
+	Message    string    `json:"message"`
+	ServerTime string    `json:"server_time,omitempty"` // Timestamp sent with the error, if any
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// IsPaired reports whether pairing state is stored, without callers needing to
+// know where or how it is kept
+func IsPaired() bool {
+	return HasState()
+}
+
+// Status returns the current mode, when it was entered and whether the client is paired
+func (t *ModeTracker) Status() ClientStatus {
+	paired := IsPaired()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := ClientStatus{
+		Mode:   t.mode,
+		Since:  t.enteredAt,
+		Paired: paired,
+	}
+	if t.lastServerError != nil {
+		lastError := *t.lastServerError
+		status.LastServerError = &lastError
+	}
+	return status
+}
+
+// RecordServerError remembers serverError as the last error from the server
+func (t *ModeTracker) RecordServerError(serverError ServerError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastServerError = &serverError
+}
+
+// RegisterControlHandlers serves the client status verb on the control server
+func (t *ModeTracker) RegisterControlHandlers(server *control.Server) {
+	server.Handle(ControlVerbStatus, func(_ json.RawMessage) (interface{}, error) {
+		return t.Status(), nil
+	})
+}
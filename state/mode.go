@@ -0,0 +1,201 @@
+package state
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Mode is the top-level mode the client is running in
+type Mode string
+
+const (
+	ModeFreshBoot    Mode = "fresh_boot"    // Started, not yet pairing or connected
+	ModePairing      Mode = "pairing"       // Pairing server running, waiting for confirm
+	ModeConnecting   Mode = "connecting"    // Paired, dialling the server
+	ModeConnected    Mode = "connected"     // WebSocket session with the server
+	ModeDeactivated  Mode = "deactivated"   // Server deactivated the device, pairing state removed
+	ModeShuttingDown Mode = "shutting_down" // Graceful shutdown in progress
+)
+
+// ModeChange is published to mode subscribers on every transition
+type ModeChange struct {
+	From Mode      `json:"from"`
+	To   Mode      `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// ModeSummary describes how the client arrived in its current connected session
+type ModeSummary struct {
+	PreviousMode    Mode    `json:"previous_mode"`
+	PairingSeconds  float64 `json:"pairing_seconds"`  // Time spent in the pairing stint before this connection
+	PairingAttempts int     `json:"pairing_attempts"` // Confirm attempts during that stint
+	PairingSessions int     `json:"pairing_sessions"` // Times pairing mode was entered since boot
+}
+
+// ModeTracker keeps transition bookkeeping across pairing and connected modes
+type ModeTracker struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	mode            Mode
+	enteredAt       time.Time
+	pairingAttempts int
+	pairingSessions int
+	lastConnect     ModeSummary
+	// pendingConnect is the summary taken when leaving for ModeConnecting, so
+	// time spent dialling is not counted as pairing
+	pendingConnect ModeSummary
+	subscribers    []chan<- ModeChange
+	// lastServerError is reported with the status; set by the WebSocket manager
+	lastServerError *ServerError
+}
+
+// NewModeTracker creates a tracker starting in ModeFreshBoot
+func NewModeTracker() *ModeTracker {
+	return newModeTracker(time.Now)
+}
+
+func newModeTracker(now func() time.Time) *ModeTracker {
+	return &ModeTracker{
+		now:       now,
+		mode:      ModeFreshBoot,
+		enteredAt: now(),
+	}
+}
+
+// Mode returns the current mode
+func (t *ModeTracker) Mode() Mode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mode
+}
+
+// Since returns when the current mode was entered
+func (t *ModeTracker) Since() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enteredAt
+}
+
+// Subscribe delivers every subsequent mode transition to ch. Delivery never
+// blocks; transitions are dropped when ch is full.
+func (t *ModeTracker) Subscribe(ch chan<- ModeChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !slices.Contains(t.subscribers, ch) {
+		t.subscribers = append(t.subscribers, ch)
+	}
+}
+
+// Unsubscribe stops delivering transitions to ch
+func (t *ModeTracker) Unsubscribe(ch chan<- ModeChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = slices.DeleteFunc(t.subscribers, func(sub chan<- ModeChange) bool {
+		return sub == ch
+	})
+}
+
+// setModeLocked switches to mode and notifies subscribers; the caller must hold mu
+func (t *ModeTracker) setModeLocked(mode Mode) {
+	change := ModeChange{From: t.mode, To: mode, At: t.now()}
+	t.mode = mode
+	t.enteredAt = change.At
+
+	for _, ch := range t.subscribers {
+		select {
+		case ch <- change:
+		default:
+			log.Printf("Warning: mode subscriber channel full, dropping %s -> %s", change.From, change.To)
+		}
+	}
+}
+
+// summaryLocked describes a connection made from the current mode; the caller must hold mu
+func (t *ModeTracker) summaryLocked() ModeSummary {
+	summary := ModeSummary{
+		PreviousMode:    t.mode,
+		PairingSessions: t.pairingSessions,
+	}
+	if t.mode == ModePairing {
+		summary.PairingSeconds = t.now().Sub(t.enteredAt).Seconds()
+		summary.PairingAttempts = t.pairingAttempts
+	}
+	return summary
+}
+
+// EnterPairing records that the pairing server is starting
+func (t *ModeTracker) EnterPairing() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mode == ModePairing {
+		return
+	}
+	t.setModeLocked(ModePairing)
+	t.pairingAttempts = 0
+	t.pairingSessions++
+}
+
+// RecordPairingAttempt counts a confirm attempt while in pairing mode
+func (t *ModeTracker) RecordPairingAttempt() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mode == ModePairing {
+		t.pairingAttempts++
+	}
+}
+
+// EnterConnecting records that the client is dialling the server
+func (t *ModeTracker) EnterConnecting() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mode == ModeConnecting {
+		return
+	}
+	t.pendingConnect = t.summaryLocked()
+	t.setModeLocked(ModeConnecting)
+}
+
+// EnterConnected records that a WebSocket session is starting and returns
+// the summary of the transition into it. When the client was connecting, the
+// summary describes the mode it was in before it started dialling.
+func (t *ModeTracker) EnterConnected() ModeSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summary := t.summaryLocked()
+	if t.mode == ModeConnecting {
+		summary = t.pendingConnect
+	}
+
+	t.setModeLocked(ModeConnected)
+	t.lastConnect = summary
+	return summary
+}
+
+// EnterDeactivated records that the server deactivated the device
+func (t *ModeTracker) EnterDeactivated() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mode != ModeDeactivated {
+		t.setModeLocked(ModeDeactivated)
+	}
+}
+
+// EnterShuttingDown records that a graceful shutdown has started
+func (t *ModeTracker) EnterShuttingDown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mode != ModeShuttingDown {
+		t.setModeLocked(ModeShuttingDown)
+	}
+}
+
+// LastConnect returns the summary recorded by the most recent EnterConnected
+func (t *ModeTracker) LastConnect() ModeSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastConnect
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModeTrackerTransitions(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := newModeTracker(func() time.Time { return clock })
+
+	if tracker.Mode() != ModeFreshBoot {
+		t.Fatalf("Expected %s at start, got %s", ModeFreshBoot, tracker.Mode())
+	}
+
+	// Pairing with two failed attempts and a successful one
+	tracker.EnterPairing()
+	tracker.RecordPairingAttempt()
+	tracker.RecordPairingAttempt()
+	tracker.RecordPairingAttempt()
+	clock = clock.Add(90 * time.Second)
+
+	summary := tracker.EnterConnected()
+	expected := ModeSummary{PreviousMode: ModePairing, PairingSeconds: 90, PairingAttempts: 3, PairingSessions: 1}
+	if summary != expected {
+		t.Errorf("First connect: expected %+v, got %+v", expected, summary)
+	}
+
+	// Attempts outside pairing mode are ignored
+	tracker.RecordPairingAttempt()
+	clock = clock.Add(time.Hour)
+
+	// Disconnect, state lost, back to pairing
+	tracker.EnterPairing()
+	tracker.RecordPairingAttempt()
+	clock = clock.Add(20 * time.Second)
+
+	summary = tracker.EnterConnected()
+	expected = ModeSummary{PreviousMode: ModePairing, PairingSeconds: 20, PairingAttempts: 1, PairingSessions: 2}
+	if summary != expected {
+		t.Errorf("Second connect: expected %+v, got %+v", expected, summary)
+	}
+	if tracker.LastConnect() != expected {
+		t.Errorf("LastConnect: expected %+v, got %+v", expected, tracker.LastConnect())
+	}
+
+	// Reconnect without pairing in between
+	summary = tracker.EnterConnected()
+	expected = ModeSummary{PreviousMode: ModeConnected, PairingSessions: 2}
+	if summary != expected {
+		t.Errorf("Reconnect: expected %+v, got %+v", expected, summary)
+	}
+}
+
+func TestModeTrackerConnectFromFreshBoot(t *testing.T) {
+	tracker := NewModeTracker()
+
+	summary := tracker.EnterConnected()
+	if summary.PreviousMode != ModeFreshBoot || summary.PairingSessions != 0 || summary.PairingAttempts != 0 {
+		t.Errorf("Expected connect from fresh boot without pairing, got %+v", summary)
+	}
+	if tracker.Mode() != ModeConnected {
+		t.Errorf("Expected %s, got %s", ModeConnected, tracker.Mode())
+	}
+}
+
+func TestModeTrackerLifecycle(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := newModeTracker(func() time.Time { return clock })
+
+	changes := make(chan ModeChange, 10)
+	tracker.Subscribe(changes)
+
+	steps := []struct {
+		name   string
+		enter  func()
+		expect Mode
+	}{
+		{"pairing", tracker.EnterPairing, ModePairing},
+		{"connecting", tracker.EnterConnecting, ModeConnecting},
+		{"connecting again", tracker.EnterConnecting, ModeConnecting},
+		{"connected", func() { tracker.EnterConnected() }, ModeConnected},
+		{"reconnecting", tracker.EnterConnecting, ModeConnecting},
+		{"reconnected", func() { tracker.EnterConnected() }, ModeConnected},
+		{"deactivated", tracker.EnterDeactivated, ModeDeactivated},
+		{"pairing after deactivation", tracker.EnterPairing, ModePairing},
+		{"shutting down", tracker.EnterShuttingDown, ModeShuttingDown},
+	}
+
+	previous := ModeFreshBoot
+	for _, step := range steps {
+		clock = clock.Add(time.Second)
+		step.enter()
+
+		if tracker.Mode() != step.expect {
+			t.Fatalf("%s: expected mode %s, got %s", step.name, step.expect, tracker.Mode())
+		}
+		if step.expect == previous {
+			if len(changes) != 0 {
+				t.Errorf("%s: expected no transition to be published", step.name)
+			}
+			continue
+		}
+
+		select {
+		case change := <-changes:
+			expected := ModeChange{From: previous, To: step.expect, At: clock}
+			if change != expected {
+				t.Errorf("%s: expected %+v, got %+v", step.name, expected, change)
+			}
+		default:
+			t.Errorf("%s: expected a transition to be published", step.name)
+		}
+		previous = step.expect
+	}
+
+	tracker.Unsubscribe(changes)
+	tracker.EnterConnecting()
+	if len(changes) != 0 {
+		t.Error("Expected no transitions after unsubscribing")
+	}
+}
+
+func TestModeSummaryExcludesConnectingTime(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := newModeTracker(func() time.Time { return clock })
+
+	tracker.EnterPairing()
+	tracker.RecordPairingAttempt()
+	clock = clock.Add(30 * time.Second)
+	tracker.EnterConnecting()
+	clock = clock.Add(time.Minute)
+
+	summary := tracker.EnterConnected()
+	expected := ModeSummary{PreviousMode: ModePairing, PairingSeconds: 30, PairingAttempts: 1, PairingSessions: 1}
+	if summary != expected {
+		t.Errorf("Expected %+v, got %+v", expected, summary)
+	}
+}
+
+func TestModeTrackerStatus(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+	tracker := NewModeTracker()
+
+	if status := tracker.Status(); status.Mode != ModeFreshBoot || status.Paired {
+		t.Errorf("Expected unpaired fresh boot, got %+v", status)
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://test-server:8080/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	tracker.EnterConnecting()
+	if status := tracker.Status(); status.Mode != ModeConnecting || !status.Paired {
+		t.Errorf("Expected paired and connecting, got %+v", status)
+	}
+	if status := tracker.Status(); status.LastServerError != nil {
+		t.Errorf("Expected no server error yet, got %+v", status.LastServerError)
+	}
+
+	tracker.RecordServerError(ServerError{Message: "first"})
+	tracker.RecordServerError(ServerError{Message: "second"})
+	if status := tracker.Status(); status.LastServerError == nil || status.LastServerError.Message != "second" {
+		t.Errorf("Expected the last server error in the status, got %+v", status.LastServerError)
+	}
+}
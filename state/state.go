@@ -1,19 +1,114 @@
 package state
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"msm-client/utils"
 )
 
 type PairedState struct {
+	StateVersion int `json:"state_version,omitempty"` // Schema version, missing (0) in files written before versioning
+
 	ServerWs   string `json:"server_ws"`
 	SessionKey string `json:"session_key,omitempty"` // Base64-encoded session key for WebSocket encryption
+
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"` // Algorithm agreed during pairing (empty means aes-cbc)
+
+	CustomMetadata map[string]string `json:"metadata,omitempty"` // Operator-defined key-value data
+
+	PairedAt time.Time `json:"paired_at"` // When pairing completed; zero when unknown
+
+	ConfigRevision int64 `json:"config_revision,omitempty"` // Revision of the last config_push applied, 0 when none
+}
+
+// ErrInvalidState is returned, wrapped with the offending value, by LoadState
+// when the state file cannot be used to connect. The state should be deleted
+// so the client pairs again.
+var ErrInvalidState = errors.New("invalid pairing state")
+
+// NormalizeServerWs checks that raw is a ws or wss URL with a host and returns
+// it with the scheme and host lowercased and trailing slashes removed from the path
+func NormalizeServerWs(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+		return "", fmt.Errorf("scheme must be ws or wss, got %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return "", errors.New("missing host")
+	}
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	parsed.RawPath = ""
+	return parsed.String(), nil
+}
+
+// currentStateVersion is the PairedState schema version written by SaveState
+const currentStateVersion = 1
+
+// MigrationFunc upgrades a state by one schema version
+type MigrationFunc func(PairedState) (PairedState, error)
+
+// migrations[i] upgrades a version i state to version i+1. Append a function
+// here and bump currentStateVersion when the schema changes.
+var migrations = []MigrationFunc{
+	// 0 -> 1: the pairing time was not recorded, so it is unknown
+	func(state PairedState) (PairedState, error) {
+		state.PairedAt = time.Time{}
+		return state, nil
+	},
+}
+
+// migrateState applies the migrations from state's version up to currentStateVersion
+func migrateState(state PairedState) (PairedState, error) {
+	for state.StateVersion < currentStateVersion {
+		if state.StateVersion < 0 || state.StateVersion >= len(migrations) {
+			return state, fmt.Errorf("no migration from state version %d", state.StateVersion)
+		}
+		migrated, err := migrations[state.StateVersion](state)
+		if err != nil {
+			return state, fmt.Errorf("migrating state from version %d: %w", state.StateVersion, err)
+		}
+		migrated.StateVersion = state.StateVersion + 1
+		state = migrated
+	}
+	return state, nil
 }
 
+// Metadata limits
+const (
+	MaxMetadataKeyLength   = 256
+	MaxMetadataValueLength = 256
+	MaxMetadataEntries     = 50
+)
+
 const defaultPath = "/var/lib/msm-client" // Default path for state file
 const stateFile = "paired.json"
 
+// deletedExplicitly is set by DeleteState and cleared by SaveState, so that a
+// deletion made by this process is not mistaken for a storage hiccup
+var deletedExplicitly atomic.Bool
+
+// stateRecheckInterval is how often ConfirmStateMissing looks for the state
+// file again; a variable so tests can shorten it
+var stateRecheckInterval = time.Second
+
 // getStatePath returns the path for the state file based on environment variable or default
 func getStatePath() string {
 	if path := os.Getenv("MSC_STATE_PATH"); path != "" {
@@ -22,6 +117,11 @@ func getStatePath() string {
 	return filepath.Join(defaultPath, stateFile)
 }
 
+// FilePath returns the path of the state file
+func FilePath() string {
+	return getStatePath()
+}
+
 func SaveState(state PairedState) error {
 	statePath := getStatePath()
 
@@ -32,11 +132,39 @@ func SaveState(state PairedState) error {
 		}
 	}
 
+	state.StateVersion = currentStateVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(statePath, data, 0600)
+
+	// Write to a temp file and fsync before renaming so readers never see a partial state
+	tmpPath := statePath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, statePath); err != nil {
+		return err
+	}
+	deletedExplicitly.Store(false)
+	ZeroizeCachedKey()
+	cacheMetadata(statePath, state.CustomMetadata)
+	return nil
 }
 
 func LoadState() (PairedState, error) {
@@ -46,8 +174,28 @@ func LoadState() (PairedState, error) {
 	if err != nil {
 		return state, err
 	}
-	err = json.Unmarshal(data, &state)
-	return state, err
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+
+	// Upgrade files written by older versions and persist the result, so
+	// migrations run once
+	if state.StateVersion < currentStateVersion {
+		fromVersion := state.StateVersion
+		if state, err = migrateState(state); err != nil {
+			return state, err
+		}
+		if err := SaveState(state); err != nil {
+			log.Printf("Failed to save state migrated from version %d: %v", fromVersion, err)
+		}
+	}
+
+	serverWs, err := NormalizeServerWs(state.ServerWs)
+	if err != nil {
+		return state, fmt.Errorf("%w: server_ws %q: %v", ErrInvalidState, state.ServerWs, err)
+	}
+	state.ServerWs = serverWs
+	return state, nil
 }
 
 func HasState() bool {
@@ -57,6 +205,9 @@ func HasState() bool {
 }
 
 func DeleteState() error {
+	deletedExplicitly.Store(true)
+	ZeroizeCachedKey()
+	forgetCachedMetadata()
 	err := os.Remove(getStatePath())
 	if os.IsNotExist(err) {
 		return nil // Ignore error if file does not exist
@@ -64,6 +215,35 @@ func DeleteState() error {
 	return err
 }
 
+// ConfirmStateMissing reports whether the state file is gone for good. A file
+// that disappears without DeleteState being called, as when a network mount
+// briefly drops, is looked for again every stateRecheckInterval for up to
+// grace; it counts as deleted only if it stays away. DeleteState in this
+// process ends the wait immediately. It reports false as soon as ctx is done,
+// so a connection closing mid-wait is not mistaken for a missing state.
+func ConfirmStateMissing(ctx context.Context, grace time.Duration) bool {
+	deadline := time.Now().Add(grace)
+	for {
+		if HasState() {
+			return false
+		}
+		if deletedExplicitly.Load() {
+			return true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return true
+		}
+		timer := time.NewTimer(min(stateRecheckInterval, remaining))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
 // GetSessionKey returns the session key from the saved state, or empty string if not available
 func GetSessionKey() string {
 	if !HasState() {
@@ -82,3 +262,179 @@ func GetSessionKey() string {
 func HasSessionKey() bool {
 	return GetSessionKey() != ""
 }
+
+// ClearSessionKey removes the session key from the saved state, keeping the server URL
+func ClearSessionKey() error {
+	state, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	state.SessionKey = ""
+	return SaveState(state)
+}
+
+// ErrNoSessionKey is returned by WithSessionKey when the state has no session key
+var ErrNoSessionKey = errors.New("no session key available")
+
+// cachedKey is the decoded session key of the state file at path, kept so
+// messages are not encrypted with a key decoded from the state file each time
+var cachedKey struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+}
+
+// WithSessionKey calls fn with the decoded session key of the saved state,
+// decoding it on first use. fn must not keep key or save the state: the key
+// is zeroed by ZeroizeCachedKey, which waits for fn to return.
+func WithSessionKey(fn func(key []byte) error) error {
+	path := getStatePath()
+	for {
+		cachedKey.mu.Lock()
+		if cachedKey.key != nil && cachedKey.path == path {
+			defer cachedKey.mu.Unlock()
+			return fn(cachedKey.key)
+		}
+		cachedKey.mu.Unlock()
+
+		// Loading may save a migrated state, which clears the cache, so the
+		// lock is not held here
+		sessionKey := GetSessionKey()
+		if sessionKey == "" {
+			return ErrNoSessionKey
+		}
+		key, err := base64.StdEncoding.DecodeString(sessionKey)
+		if err != nil {
+			return fmt.Errorf("failed to decode session key: %w", err)
+		}
+
+		cachedKey.mu.Lock()
+		utils.Zeroize(cachedKey.key)
+		cachedKey.path = path
+		cachedKey.key = key
+		cachedKey.mu.Unlock()
+	}
+}
+
+// ZeroizeCachedKey overwrites the session key cached by WithSessionKey with
+// zeros and drops it; the next WithSessionKey reads it from the state again.
+// Saving or deleting the state does this too.
+func ZeroizeCachedKey() {
+	cachedKey.mu.Lock()
+	defer cachedKey.mu.Unlock()
+	utils.Zeroize(cachedKey.key)
+	cachedKey.key = nil
+}
+
+// GetConfigRevision returns the revision of the last config push applied, or 0
+func GetConfigRevision() int64 {
+	state, err := LoadState()
+	if err != nil {
+		return 0
+	}
+	return state.ConfigRevision
+}
+
+// SetConfigRevision records revision as the last config push applied
+func SetConfigRevision(revision int64) error {
+	state, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	state.ConfigRevision = revision
+	return SaveState(state)
+}
+
+// GetEncryptionAlgorithm returns the encryption algorithm agreed during pairing,
+// or "aes-cbc" if none was recorded
+func GetEncryptionAlgorithm() string {
+	state, err := LoadState()
+	if err != nil || state.EncryptionAlgorithm == "" {
+		return "aes-cbc"
+	}
+	return state.EncryptionAlgorithm
+}
+
+// SetMetadata stores a custom metadata value in the saved state
+func SetMetadata(key, value string) error {
+	if key == "" {
+		return errors.New("metadata key must not be empty")
+	}
+	if len(key) > MaxMetadataKeyLength {
+		return fmt.Errorf("metadata key exceeds %d bytes", MaxMetadataKeyLength)
+	}
+	if len(value) > MaxMetadataValueLength {
+		return fmt.Errorf("metadata value exceeds %d bytes", MaxMetadataValueLength)
+	}
+
+	state, err := LoadState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	if state.CustomMetadata == nil {
+		state.CustomMetadata = make(map[string]string)
+	}
+	if _, exists := state.CustomMetadata[key]; !exists && len(state.CustomMetadata) >= MaxMetadataEntries {
+		return fmt.Errorf("metadata is limited to %d entries", MaxMetadataEntries)
+	}
+	state.CustomMetadata[key] = value
+
+	return SaveState(state)
+}
+
+// cachedMetadata is the custom metadata of the state file at path, kept so
+// status updates don't load the state file each time
+var cachedMetadata struct {
+	mu       sync.Mutex
+	path     string
+	loaded   bool
+	metadata map[string]string
+}
+
+// cacheMetadata records metadata as the custom metadata of the state at path
+func cacheMetadata(path string, metadata map[string]string) {
+	cachedMetadata.mu.Lock()
+	defer cachedMetadata.mu.Unlock()
+	cachedMetadata.path = path
+	cachedMetadata.loaded = true
+	cachedMetadata.metadata = maps.Clone(metadata)
+}
+
+// forgetCachedMetadata drops the cached metadata; the next Metadata reads the state again
+func forgetCachedMetadata() {
+	cachedMetadata.mu.Lock()
+	defer cachedMetadata.mu.Unlock()
+	cachedMetadata.loaded = false
+	cachedMetadata.metadata = nil
+}
+
+// Metadata returns a copy of the custom metadata of the saved state, loading
+// it on first use. Saving or deleting the state in this process updates it.
+func Metadata() map[string]string {
+	path := getStatePath()
+	cachedMetadata.mu.Lock()
+	if cachedMetadata.loaded && cachedMetadata.path == path {
+		defer cachedMetadata.mu.Unlock()
+		return maps.Clone(cachedMetadata.metadata)
+	}
+	cachedMetadata.mu.Unlock()
+
+	// Loading may save a migrated state, which takes the lock, so it is not held here
+	state, err := LoadState()
+	if err != nil {
+		return nil
+	}
+	cacheMetadata(path, state.CustomMetadata)
+	return maps.Clone(state.CustomMetadata)
+}
+
+// GetMetadata returns a custom metadata value from the saved state
+func GetMetadata(key string) (string, bool) {
+	state, err := LoadState()
+	if err != nil {
+		return "", false
+	}
+	value, ok := state.CustomMetadata[key]
+	return value, ok
+}
@@ -1,10 +1,16 @@
 package state
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSaveAndLoadState(t *testing.T) {
@@ -171,6 +177,208 @@ func TestDeleteState(t *testing.T) {
 	}
 }
 
+func TestLoadStateMigratesVersion0(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+	statePath := getStatePath()
+
+	// A state file written before versioning has no state_version or paired_at
+	legacy := `{"server_ws": "ws://example.com/ws", "session_key": "dGVzdF9zZXNzaW9uX2tleQ=="}`
+	if err := os.WriteFile(statePath, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	loaded, err := LoadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.StateVersion != 1 {
+		t.Errorf("Expected state version 1, got %d", loaded.StateVersion)
+	}
+	if !loaded.PairedAt.IsZero() {
+		t.Errorf("Expected an unknown pairing time, got %v", loaded.PairedAt)
+	}
+	if loaded.ServerWs != "ws://example.com/ws" || loaded.SessionKey != "dGVzdF9zZXNzaW9uX2tleQ==" {
+		t.Errorf("Migration should keep existing fields, got %+v", loaded)
+	}
+
+	// The upgraded state is written back
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse state: %v", err)
+	}
+	if saved["state_version"] != float64(1) {
+		t.Errorf("Expected the migrated state to be saved with state_version 1, got %v", saved["state_version"])
+	}
+}
+
+func TestMigrateStateUnknownVersion(t *testing.T) {
+	if _, err := migrateState(PairedState{StateVersion: -1}); err == nil {
+		t.Error("Expected an error for a state version without a migration")
+	}
+}
+
+func TestNormalizeServerWs(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string // Empty when the URL is invalid
+	}{
+		{"Valid", "wss://server.example.com/ws", "wss://server.example.com/ws"},
+		{"Missing scheme", "example.com", ""},
+		{"Host without scheme", "example.com:8080/ws", ""},
+		{"HTTP scheme", "http://example.com/ws", ""},
+		{"Garbage", "::not a url::", ""},
+		{"Empty", "", ""},
+		{"Missing host", "ws:///ws", ""},
+		{"Uppercase scheme and host", "WSS://Server.Example.COM:8443/ws", "wss://server.example.com:8443/ws"},
+		{"Trailing slashes", "ws://example.com/ws//", "ws://example.com/ws"},
+		{"Root path", "ws://example.com/", "ws://example.com"},
+		{"Query kept", "ws://example.com/ws/?token=abc", "ws://example.com/ws?token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := NormalizeServerWs(tt.raw)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("Expected %q to be rejected, got %q", tt.raw, normalized)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected %q to be accepted: %v", tt.raw, err)
+			}
+			if normalized != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestLoadStateValidatesServerWs(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+
+	if err := SaveState(PairedState{ServerWs: "example.com"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	_, err := LoadState()
+	if !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("Expected ErrInvalidState, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "example.com") {
+		t.Errorf("The error should name the offending value, got %v", err)
+	}
+
+	if err := SaveState(PairedState{ServerWs: "WS://Example.com/ws/"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	loaded, err := LoadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.ServerWs != "ws://example.com/ws" {
+		t.Errorf("Expected the server URL to be normalized, got %q", loaded.ServerWs)
+	}
+}
+
+func TestConfirmStateMissing(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+	statePath := getStatePath()
+
+	originalInterval := stateRecheckInterval
+	stateRecheckInterval = 10 * time.Millisecond
+	defer func() { stateRecheckInterval = originalInterval }()
+
+	const grace = 300 * time.Millisecond
+
+	// flicker removes the state file behind DeleteState's back, as a dropped
+	// mount does, and puts it back after gone
+	flicker := func(gone time.Duration) {
+		t.Helper()
+		data, err := os.ReadFile(statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state: %v", err)
+		}
+		if err := os.Remove(statePath); err != nil {
+			t.Fatalf("Failed to remove state: %v", err)
+		}
+		time.AfterFunc(gone, func() { os.WriteFile(statePath, data, 0600) })
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://example.com/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	t.Run("Present", func(t *testing.T) {
+		if ConfirmStateMissing(context.Background(), grace) {
+			t.Error("An existing state file should not be reported missing")
+		}
+	})
+
+	t.Run("Flicker within the grace period", func(t *testing.T) {
+		flicker(100 * time.Millisecond)
+		if ConfirmStateMissing(context.Background(), grace) {
+			t.Error("A state file that comes back within the grace period should not be reported missing")
+		}
+	})
+
+	t.Run("Flicker beyond the grace period", func(t *testing.T) {
+		flicker(grace + 300*time.Millisecond)
+		if !ConfirmStateMissing(context.Background(), grace) {
+			t.Error("A state file gone for longer than the grace period should be reported missing")
+		}
+		// Let the file come back before the next subtest
+		time.Sleep(400 * time.Millisecond)
+		if !HasState() {
+			t.Fatal("State file should be restored")
+		}
+	})
+
+	t.Run("Cancelled context ends the wait", func(t *testing.T) {
+		flicker(grace)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		if ConfirmStateMissing(ctx, time.Minute) {
+			t.Error("A cancelled wait should not report the state missing")
+		}
+		if elapsed := time.Since(start); elapsed > grace {
+			t.Errorf("Cancelling the context should end the wait immediately, took %v", elapsed)
+		}
+		time.Sleep(400 * time.Millisecond)
+		if !HasState() {
+			t.Fatal("State file should be restored")
+		}
+	})
+
+	t.Run("Explicit deletion skips the grace period", func(t *testing.T) {
+		if err := DeleteState(); err != nil {
+			t.Fatalf("Failed to delete state: %v", err)
+		}
+		start := time.Now()
+		if !ConfirmStateMissing(context.Background(), time.Minute) {
+			t.Error("A deleted state should be reported missing")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("DeleteState should end the wait immediately, took %v", elapsed)
+		}
+	})
+
+	t.Run("Saving clears the explicit deletion", func(t *testing.T) {
+		if err := SaveState(PairedState{ServerWs: "ws://example.com/ws"}); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		flicker(100 * time.Millisecond)
+		if ConfirmStateMissing(context.Background(), grace) {
+			t.Error("A flicker after SaveState should be waited out again")
+		}
+	})
+}
+
 func TestGetSessionKey(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "msm-state-test")
@@ -271,6 +479,77 @@ func TestHasSessionKey(t *testing.T) {
 	}
 }
 
+func TestWithSessionKey(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+	defer ZeroizeCachedKey()
+
+	if err := WithSessionKey(func([]byte) error { return nil }); !errors.Is(err, ErrNoSessionKey) {
+		t.Errorf("Expected ErrNoSessionKey without a state, got %v", err)
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://example.com:8080/ws", SessionKey: "dGVzdF9zZXNzaW9uX2tleQ=="}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	var retained []byte
+	if err := WithSessionKey(func(key []byte) error {
+		retained = key
+		return nil
+	}); err != nil {
+		t.Fatalf("WithSessionKey failed: %v", err)
+	}
+	if string(retained) != "test_session_key" {
+		t.Fatalf("Expected the decoded session key, got %q", retained)
+	}
+
+	// The key is decoded once and reused
+	if err := WithSessionKey(func(key []byte) error {
+		if &key[0] != &retained[0] {
+			t.Error("Expected the cached key to be reused")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("WithSessionKey failed: %v", err)
+	}
+
+	ZeroizeCachedKey()
+	if !bytes.Equal(retained, make([]byte, len(retained))) {
+		t.Errorf("Expected the cached key to be zeroed, got %q", retained)
+	}
+
+	t.Run("Saving the state drops the cached key", func(t *testing.T) {
+		if err := WithSessionKey(func(key []byte) error {
+			retained = key
+			return nil
+		}); err != nil {
+			t.Fatalf("WithSessionKey failed: %v", err)
+		}
+		if err := SaveState(PairedState{ServerWs: "ws://example.com:8080/ws", SessionKey: "bmV3X2tleQ=="}); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		if !bytes.Equal(retained, make([]byte, len(retained))) {
+			t.Errorf("Expected the old key to be zeroed, got %q", retained)
+		}
+		if err := WithSessionKey(func(key []byte) error {
+			if string(key) != "new_key" {
+				t.Errorf("Expected the new session key, got %q", key)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("WithSessionKey failed: %v", err)
+		}
+	})
+
+	t.Run("Deleting the state drops the cached key", func(t *testing.T) {
+		if err := DeleteState(); err != nil {
+			t.Fatalf("Failed to delete state: %v", err)
+		}
+		if err := WithSessionKey(func([]byte) error { return nil }); !errors.Is(err, ErrNoSessionKey) {
+			t.Errorf("Expected ErrNoSessionKey after deleting the state, got %v", err)
+		}
+	})
+}
+
 func TestLoadStateFileNotFound(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "msm-state-test")
@@ -427,3 +706,147 @@ func TestSaveStateCreateDirectory(t *testing.T) {
 		t.Error("Expected state file to be created")
 	}
 }
+
+func TestMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalPath := os.Getenv("MSC_STATE_PATH")
+	os.Setenv("MSC_STATE_PATH", tempDir)
+	defer os.Setenv("MSC_STATE_PATH", originalPath)
+
+	// Metadata requires an existing state
+	if err := SetMetadata("rack", "A1"); err == nil {
+		t.Error("Expected error setting metadata without state")
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://example.com:8080/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	entries := map[string]string{
+		"installer_version": "1.4.2",
+		"provisioned_at":    "2025-01-01T00:00:00Z",
+		"rack":              "A1",
+	}
+	for key, value := range entries {
+		if err := SetMetadata(key, value); err != nil {
+			t.Fatalf("SetMetadata(%s) failed: %v", key, err)
+		}
+	}
+
+	for key, expected := range entries {
+		if value, ok := GetMetadata(key); !ok || value != expected {
+			t.Errorf("GetMetadata(%s) = %q, %v; want %q", key, value, ok, expected)
+		}
+	}
+	if _, ok := GetMetadata("missing"); ok {
+		t.Error("Expected missing key to be absent")
+	}
+
+	// Metadata survives a save/load round-trip alongside other state
+	loaded, err := LoadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.ServerWs != "ws://example.com:8080/ws" {
+		t.Errorf("ServerWs lost after metadata update: %s", loaded.ServerWs)
+	}
+	if err := SaveState(loaded); err != nil {
+		t.Fatalf("Failed to re-save state: %v", err)
+	}
+	if value, ok := GetMetadata("rack"); !ok || value != "A1" {
+		t.Errorf("Metadata lost after round-trip: %q, %v", value, ok)
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		if err := SetMetadata("", "value"); err == nil {
+			t.Error("Expected error for empty key")
+		}
+		if err := SetMetadata(strings.Repeat("k", MaxMetadataKeyLength+1), "value"); err == nil {
+			t.Error("Expected error for oversized key")
+		}
+		if err := SetMetadata("key", strings.Repeat("v", MaxMetadataValueLength+1)); err == nil {
+			t.Error("Expected error for oversized value")
+		}
+	})
+
+	t.Run("Entry limit", func(t *testing.T) {
+		for i := len(entries); i < MaxMetadataEntries; i++ {
+			if err := SetMetadata(fmt.Sprintf("key%d", i), "v"); err != nil {
+				t.Fatalf("SetMetadata failed before reaching limit: %v", err)
+			}
+		}
+		if err := SetMetadata("one-too-many", "v"); err == nil {
+			t.Error("Expected error when exceeding entry limit")
+		}
+		// Updating an existing key is still allowed at the limit
+		if err := SetMetadata("rack", "B2"); err != nil {
+			t.Errorf("Expected update of existing key to succeed: %v", err)
+		}
+	})
+}
+
+func TestMetadataCache(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+
+	if metadata := Metadata(); metadata != nil {
+		t.Errorf("Expected no metadata without state, got %v", metadata)
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://example.com/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if err := SetMetadata("rack", "A1"); err != nil {
+		t.Fatalf("SetMetadata failed: %v", err)
+	}
+	if metadata := Metadata(); metadata["rack"] != "A1" {
+		t.Fatalf("Expected the saved metadata, got %v", metadata)
+	}
+
+	// The state file is not read again once cached
+	data, err := os.ReadFile(getStatePath())
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if err := os.WriteFile(getStatePath(), bytes.Replace(data, []byte(`"A1"`), []byte(`"B2"`), 1), 0600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	metadata := Metadata()
+	if metadata["rack"] != "A1" {
+		t.Errorf("Expected the cached metadata, got %v", metadata)
+	}
+
+	// Callers get a copy
+	metadata["rack"] = "changed"
+	if Metadata()["rack"] != "A1" {
+		t.Error("Changing the returned map should not change the cache")
+	}
+
+	if err := DeleteState(); err != nil {
+		t.Fatalf("Failed to delete state: %v", err)
+	}
+	if metadata := Metadata(); metadata != nil {
+		t.Errorf("Expected no metadata after DeleteState, got %v", metadata)
+	}
+}
+
+func TestConfigRevision(t *testing.T) {
+	t.Setenv("MSC_STATE_PATH", t.TempDir())
+
+	if got := GetConfigRevision(); got != 0 {
+		t.Errorf("Expected revision 0 without state, got %d", got)
+	}
+	if err := SetConfigRevision(3); err == nil {
+		t.Error("Expected an error without state")
+	}
+
+	if err := SaveState(PairedState{ServerWs: "ws://example.com/ws"}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if err := SetConfigRevision(3); err != nil {
+		t.Fatalf("Failed to set revision: %v", err)
+	}
+	if got := GetConfigRevision(); got != 3 {
+		t.Errorf("Expected revision 3, got %d", got)
+	}
+}
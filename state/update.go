@@ -0,0 +1,168 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UpdateResult represents the outcome of a self-update
+type UpdateResult string
+
+const (
+	UpdateResultPending    UpdateResult = "pending"     // Update staged, new version not yet started
+	UpdateResultSuccess    UpdateResult = "success"     // New version started successfully
+	UpdateResultFailed     UpdateResult = "failed"      // Client restarted on the previous version
+	UpdateResultRolledBack UpdateResult = "rolled_back" // New version crash-looped and the backup was restored
+)
+
+// UpdateJournal records the progress of the most recent self-update
+type UpdateJournal struct {
+	FromVersion string       `json:"from_version"`
+	ToVersion   string       `json:"to_version"`
+	BackupPath  string       `json:"backup_path,omitempty"` // Previous binary, restored on rollback
+	StagedAt    time.Time    `json:"staged_at"`
+	Result      UpdateResult `json:"result"`
+	CompletedAt time.Time    `json:"completed_at,omitempty"`
+	Starts      int          `json:"starts,omitempty"` // Starts of the new version before it ran stably
+}
+
+const updateJournalFile = "update_journal.json"
+
+// maxUpdateStarts is how often the new version may start without running
+// stably before the update counts as crash-looping and is rolled back
+const maxUpdateStarts = 3
+
+// UpdateStableAfter is how long the new version must run before ConfirmUpdate
+// is called and the update counts as successful
+var UpdateStableAfter = 2 * time.Minute
+
+// ErrUpdateRolledBack is returned by ReconcileUpdateJournal after the previous
+// binary was restored; the client must exit so it restarts on that binary
+var ErrUpdateRolledBack = errors.New("update rolled back")
+
+// BackupSuffix is appended to the executable path for the pre-update backup
+const BackupSuffix = ".old"
+
+// getStateDir returns the directory holding the state files
+func getStateDir() string {
+	return filepath.Dir(getStatePath())
+}
+
+// SaveUpdateJournal writes the update journal to the state directory
+func SaveUpdateJournal(journal UpdateJournal) error {
+	dir := getStateDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, updateJournalFile), data, 0600)
+}
+
+// LoadUpdateJournal reads the update journal from the state directory
+func LoadUpdateJournal() (UpdateJournal, error) {
+	var journal UpdateJournal
+	data, err := os.ReadFile(filepath.Join(getStateDir(), updateJournalFile))
+	if err != nil {
+		return journal, err
+	}
+	err = json.Unmarshal(data, &journal)
+	return journal, err
+}
+
+// ReconcileUpdateJournal checks a pending update journal at startup. A start
+// on a version other than the new one records the update as failed. A start on
+// the new version is counted, and the update stays pending until
+// ConfirmUpdate; after more than maxUpdateStarts starts the new version is
+// crash-looping, the backup is restored over executablePath and
+// ErrUpdateRolledBack is returned. Returns nil if no update journal exists.
+func ReconcileUpdateJournal(currentVersion, executablePath string) (*UpdateJournal, error) {
+	journal, err := LoadUpdateJournal()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if journal.Result != UpdateResultPending {
+		return &journal, nil
+	}
+
+	if currentVersion != journal.ToVersion {
+		journal.Result = UpdateResultFailed
+		journal.CompletedAt = time.Now()
+		return &journal, SaveUpdateJournal(journal)
+	}
+
+	journal.Starts++
+	if journal.Starts <= maxUpdateStarts {
+		return &journal, SaveUpdateJournal(journal)
+	}
+
+	// The new version keeps restarting before it runs stably, restore the previous binary
+	if err := os.Rename(updateBackupPath(journal, executablePath), executablePath); err != nil {
+		return &journal, fmt.Errorf("failed to restore update backup: %w", err)
+	}
+	journal.Result = UpdateResultRolledBack
+	journal.CompletedAt = time.Now()
+	if err := SaveUpdateJournal(journal); err != nil {
+		return &journal, err
+	}
+	return &journal, ErrUpdateRolledBack
+}
+
+// ConfirmUpdate records a pending update as successful once the new version
+// has run for UpdateStableAfter, and removes the backup of the previous binary
+func ConfirmUpdate(executablePath string) error {
+	journal, err := LoadUpdateJournal()
+	if err != nil || journal.Result != UpdateResultPending {
+		return err
+	}
+
+	journal.Result = UpdateResultSuccess
+	journal.CompletedAt = time.Now()
+	if err := SaveUpdateJournal(journal); err != nil {
+		return err
+	}
+	if err := os.Remove(updateBackupPath(journal, executablePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove update backup: %w", err)
+	}
+	return nil
+}
+
+// updateBackupPath returns the backup recorded in journal, or the default
+// backup next to executablePath for journals without one
+func updateBackupPath(journal UpdateJournal, executablePath string) string {
+	if journal.BackupPath != "" {
+		return journal.BackupPath
+	}
+	return executablePath + BackupSuffix
+}
+
+// GetLastUpdate returns the last update summary for status reporting, or nil if none is recorded
+func GetLastUpdate() map[string]any {
+	journal, err := LoadUpdateJournal()
+	if err != nil {
+		return nil
+	}
+
+	at := journal.CompletedAt
+	if at.IsZero() {
+		at = journal.StagedAt
+	}
+
+	return map[string]any{
+		"from":   journal.FromVersion,
+		"to":     journal.ToVersion,
+		"result": string(journal.Result),
+		"at":     at.Format(time.RFC3339),
+	}
+}
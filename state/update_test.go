@@ -0,0 +1,198 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileUpdateJournal(t *testing.T) {
+	testCases := []struct {
+		name           string
+		journal        *UpdateJournal
+		currentVersion string
+		expectedResult UpdateResult
+		expectedErr    error
+		expectBinary   string // Expected executable contents after reconcile
+		expectBackup   bool   // Whether the .old backup should still exist
+	}{
+		{
+			name:           "No journal",
+			journal:        nil,
+			currentVersion: "1.1.0",
+			expectBinary:   "new",
+			expectBackup:   true,
+		},
+		{
+			name:           "Pending update started on new version",
+			journal:        &UpdateJournal{FromVersion: "1.0.0", ToVersion: "1.1.0", Result: UpdateResultPending},
+			currentVersion: "1.1.0",
+			expectedResult: UpdateResultPending,
+			expectBinary:   "new",
+			expectBackup:   true,
+		},
+		{
+			name:           "Pending update started on old version",
+			journal:        &UpdateJournal{FromVersion: "1.0.0", ToVersion: "1.1.0", Result: UpdateResultPending},
+			currentVersion: "1.0.0",
+			expectedResult: UpdateResultFailed,
+			expectBinary:   "new",
+			expectBackup:   true,
+		},
+		{
+			name:           "Pending update crash-looping",
+			journal:        &UpdateJournal{FromVersion: "1.0.0", ToVersion: "1.1.0", Result: UpdateResultPending, Starts: maxUpdateStarts},
+			currentVersion: "1.1.0",
+			expectedResult: UpdateResultRolledBack,
+			expectedErr:    ErrUpdateRolledBack,
+			expectBinary:   "old",
+			expectBackup:   false,
+		},
+		{
+			name:           "Completed update left untouched",
+			journal:        &UpdateJournal{FromVersion: "1.0.0", ToVersion: "1.1.0", Result: UpdateResultSuccess},
+			currentVersion: "1.1.0",
+			expectedResult: UpdateResultSuccess,
+			expectBinary:   "new",
+			expectBackup:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			t.Setenv("MSC_STATE_PATH", tempDir)
+			executablePath := writeUpdatedBinary(t, tempDir)
+
+			if tc.journal != nil {
+				tc.journal.StagedAt = time.Now().Add(-time.Minute)
+				if err := SaveUpdateJournal(*tc.journal); err != nil {
+					t.Fatalf("Failed to save journal: %v", err)
+				}
+			}
+
+			journal, err := ReconcileUpdateJournal(tc.currentVersion, executablePath)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if tc.journal == nil {
+				if journal != nil {
+					t.Errorf("Expected nil journal, got %+v", journal)
+				}
+			} else {
+				if journal == nil {
+					t.Fatal("Expected journal, got nil")
+				}
+				if journal.Result != tc.expectedResult {
+					t.Errorf("Expected result %s, got %s", tc.expectedResult, journal.Result)
+				}
+
+				// Result should be persisted
+				saved, err := LoadUpdateJournal()
+				if err != nil {
+					t.Fatalf("Failed to reload journal: %v", err)
+				}
+				if saved.Result != tc.expectedResult {
+					t.Errorf("Expected persisted result %s, got %s", tc.expectedResult, saved.Result)
+				}
+			}
+
+			binary, err := os.ReadFile(executablePath)
+			if err != nil {
+				t.Fatalf("Failed to read executable: %v", err)
+			}
+			if string(binary) != tc.expectBinary {
+				t.Errorf("Expected executable contents %q, got %q", tc.expectBinary, binary)
+			}
+
+			_, err = os.Stat(executablePath + BackupSuffix)
+			if hasBackup := err == nil; hasBackup != tc.expectBackup {
+				t.Errorf("Expected backup present=%v, got %v", tc.expectBackup, hasBackup)
+			}
+		})
+	}
+}
+
+func TestUpdateCrashLoopAndConfirm(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("MSC_STATE_PATH", tempDir)
+	executablePath := writeUpdatedBinary(t, tempDir)
+
+	journal := UpdateJournal{FromVersion: "1.0.0", ToVersion: "1.1.0", BackupPath: executablePath + BackupSuffix, Result: UpdateResultPending}
+	if err := SaveUpdateJournal(journal); err != nil {
+		t.Fatalf("Failed to save journal: %v", err)
+	}
+
+	// Starts that end before ConfirmUpdate count towards a rollback
+	for start := 1; start <= maxUpdateStarts; start++ {
+		reconciled, err := ReconcileUpdateJournal("1.1.0", executablePath)
+		if err != nil || reconciled.Result != UpdateResultPending || reconciled.Starts != start {
+			t.Fatalf("Start %d: expected a pending update, got %+v (%v)", start, reconciled, err)
+		}
+	}
+
+	if err := ConfirmUpdate(executablePath); err != nil {
+		t.Fatalf("ConfirmUpdate failed: %v", err)
+	}
+	saved, err := LoadUpdateJournal()
+	if err != nil || saved.Result != UpdateResultSuccess || saved.CompletedAt.IsZero() {
+		t.Errorf("Expected a confirmed update, got %+v (%v)", saved, err)
+	}
+	if _, err := os.Stat(executablePath + BackupSuffix); !os.IsNotExist(err) {
+		t.Error("Expected the backup removed once the update is confirmed")
+	}
+
+	// A confirmed update is not rolled back by later starts
+	if reconciled, err := ReconcileUpdateJournal("1.1.0", executablePath); err != nil || reconciled.Result != UpdateResultSuccess {
+		t.Errorf("Expected the confirmed update left untouched, got %+v (%v)", reconciled, err)
+	}
+}
+
+// writeUpdatedBinary simulates an installed binary with its pre-update backup
+func writeUpdatedBinary(t *testing.T, dir string) string {
+	t.Helper()
+	executablePath := filepath.Join(dir, "msm-client")
+	if err := os.WriteFile(executablePath, []byte("new"), 0755); err != nil {
+		t.Fatalf("Failed to write executable: %v", err)
+	}
+	if err := os.WriteFile(executablePath+BackupSuffix, []byte("old"), 0755); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	return executablePath
+}
+
+func TestGetLastUpdate(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalPath := os.Getenv("MSC_STATE_PATH")
+	os.Setenv("MSC_STATE_PATH", tempDir)
+	defer os.Setenv("MSC_STATE_PATH", originalPath)
+
+	if lastUpdate := GetLastUpdate(); lastUpdate != nil {
+		t.Errorf("Expected nil last update without journal, got %v", lastUpdate)
+	}
+
+	journal := UpdateJournal{
+		FromVersion: "1.0.0",
+		ToVersion:   "1.1.0",
+		StagedAt:    time.Now(),
+		Result:      UpdateResultSuccess,
+	}
+	if err := SaveUpdateJournal(journal); err != nil {
+		t.Fatalf("Failed to save journal: %v", err)
+	}
+
+	lastUpdate := GetLastUpdate()
+	if lastUpdate == nil {
+		t.Fatal("Expected last update, got nil")
+	}
+	if lastUpdate["from"] != "1.0.0" || lastUpdate["to"] != "1.1.0" || lastUpdate["result"] != "success" {
+		t.Errorf("Unexpected last update: %v", lastUpdate)
+	}
+	if at, ok := lastUpdate["at"].(string); !ok || at == "" {
+		t.Error("Last update should contain a timestamp")
+	}
+}
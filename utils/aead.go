@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sealAEAD encrypts data with aead and returns the nonce followed by the ciphertext
+func sealAEAD(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// openAEAD decrypts a nonce-prefixed ciphertext produced by sealAEAD
+func openAEAD(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// encryptAESGCM encrypts data with AES-GCM
+func encryptAESGCM(data []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return sealAEAD(aead, data)
+}
+
+// decryptAESGCM decrypts data produced by encryptAESGCM
+func decryptAESGCM(data []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return openAEAD(aead, data)
+}
+
+// encryptChaCha20 encrypts data with ChaCha20-Poly1305
+func encryptChaCha20(data []byte, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return sealAEAD(aead, data)
+}
+
+// decryptChaCha20 decrypts data produced by encryptChaCha20
+func decryptChaCha20(data []byte, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return openAEAD(aead, data)
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeAlgorithms(t *testing.T) {
+	mc := NewMessageCrypto()
+	sessionKeyB64 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	message := map[string]interface{}{"type": "test", "content": "hello world"}
+
+	for _, alg := range SupportedAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			envelope, err := mc.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, alg)
+			if err != nil {
+				t.Fatalf("CreateEncryptedEnvelopeWithAlgorithm failed: %v", err)
+			}
+			if envelope["alg"] != alg {
+				t.Errorf("Expected alg %s, got %v", alg, envelope["alg"])
+			}
+
+			decrypted, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64)
+			if err != nil {
+				t.Fatalf("ExtractFromEncryptedEnvelope failed: %v", err)
+			}
+			if !reflect.DeepEqual(decrypted, message) {
+				t.Errorf("Expected %v, got %v", message, decrypted)
+			}
+		})
+	}
+
+	t.Run("Missing alg treated as aes-cbc", func(t *testing.T) {
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		delete(envelope, "alg")
+
+		if _, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64); err != nil {
+			t.Errorf("Expected envelope without alg to decrypt, got: %v", err)
+		}
+	})
+
+	t.Run("Downgrade to negotiated algorithm rejected", func(t *testing.T) {
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		delete(envelope, "alg")
+
+		_, err := mc.ExtractFromEncryptedEnvelopeWithAlgorithm(envelope, sessionKeyB64, AlgAESGCM)
+		if !errors.Is(err, ErrAlgorithmMismatch) {
+			t.Errorf("Expected ErrAlgorithmMismatch for an envelope without alg, got %v", err)
+		}
+
+		envelope["alg"] = AlgAESCBC
+		_, err = mc.ExtractFromEncryptedEnvelopeWithAlgorithm(envelope, sessionKeyB64, AlgAESGCM)
+		if !errors.Is(err, ErrAlgorithmMismatch) {
+			t.Errorf("Expected ErrAlgorithmMismatch for aes-cbc, got %v", err)
+		}
+
+		gcm, _ := mc.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, AlgAESGCM)
+		if _, err := mc.ExtractFromEncryptedEnvelopeWithAlgorithm(gcm, sessionKeyB64, AlgAESGCM); err != nil {
+			t.Errorf("Expected negotiated algorithm to decrypt, got %v", err)
+		}
+		if _, err := mc.ExtractFromEncryptedEnvelopeWithAlgorithm(envelope, sessionKeyB64, AlgAESCBC); err != nil {
+			t.Errorf("Expected aes-cbc envelope to decrypt when aes-cbc was negotiated, got %v", err)
+		}
+	})
+
+	t.Run("Unknown alg", func(t *testing.T) {
+		if _, err := mc.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, "rot13"); err == nil {
+			t.Error("Expected error encrypting with unknown algorithm")
+		}
+
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		envelope["alg"] = "rot13"
+		if _, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64); err == nil {
+			t.Error("Expected error decrypting with unknown algorithm")
+		}
+	})
+}
+
+func TestAEADTamperDetection(t *testing.T) {
+	key := make([]byte, 32)
+	data := []byte(`{"type":"test"}`)
+
+	ciphers := map[string]struct {
+		encrypt func([]byte, []byte) ([]byte, error)
+		decrypt func([]byte, []byte) ([]byte, error)
+	}{
+		"aes-gcm":           {encryptAESGCM, decryptAESGCM},
+		"chacha20-poly1305": {encryptChaCha20, decryptChaCha20},
+	}
+
+	for name, c := range ciphers {
+		t.Run(name, func(t *testing.T) {
+			encrypted, err := c.encrypt(data, key)
+			if err != nil {
+				t.Fatalf("Encrypt failed: %v", err)
+			}
+
+			encrypted[len(encrypted)-1] ^= 0x01
+			if _, err := c.decrypt(encrypted, key); err != ErrDecryptionFailed {
+				t.Errorf("Expected ErrDecryptionFailed for tampered ciphertext, got %v", err)
+			}
+
+			if _, err := c.decrypt([]byte("short"), key); err != ErrDecryptionFailed {
+				t.Errorf("Expected ErrDecryptionFailed for short ciphertext, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNegotiateAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		preferred string
+		server    []string
+		expected  string
+	}{
+		{"Legacy server", AlgChaCha20Poly1305, nil, AlgAESCBC},
+		{"Preferred supported", AlgChaCha20Poly1305, []string{AlgAESCBC, AlgChaCha20Poly1305}, AlgChaCha20Poly1305},
+		{"Preferred unsupported", AlgChaCha20Poly1305, []string{AlgAESGCM, AlgAESCBC}, AlgAESGCM},
+		{"Unknown server algorithms", AlgAESGCM, []string{"xchacha"}, AlgAESCBC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := NegotiateAlgorithm(tt.preferred, tt.server); result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func BenchmarkEncryptionAlgorithms(b *testing.B) {
+	mc := NewMessageCrypto()
+	key := make([]byte, 32)
+	rand.Read(key)
+	sessionKeyB64 := base64.StdEncoding.EncodeToString(key)
+
+	// 1 KB payload
+	message := map[string]interface{}{
+		"type": "benchmark",
+		"data": strings.Repeat("x", 1000),
+	}
+
+	for _, alg := range SupportedAlgorithms {
+		b.Run(alg, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				envelope, err := mc.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, alg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
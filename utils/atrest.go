@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// machineIDPaths lists the files the machine identifier is read from, in order
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// atRestKeyInfo is the HKDF info for the key sealing secrets stored on disk
+const atRestKeyInfo = "msm-client-at-rest"
+
+// machineKey derives a 32-byte key bound to this machine's identifier
+func machineKey() ([]byte, error) {
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(string(data))
+		if id == "" {
+			continue
+		}
+
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(id), nil, []byte(atRestKeyInfo)), key); err != nil {
+			return nil, fmt.Errorf("failed to derive machine key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no machine identifier available")
+}
+
+// SealAtRest encrypts data with AES-GCM under a key bound to this machine, so
+// the result can only be opened on the same machine
+func SealAtRest(data []byte) ([]byte, error) {
+	key, err := machineKey()
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(key)
+	return encryptAESGCM(data, key)
+}
+
+// OpenAtRest decrypts data produced by SealAtRest
+func OpenAtRest(data []byte) ([]byte, error) {
+	key, err := machineKey()
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(key)
+	return decryptAESGCM(data, key)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withMachineID points machineIDPaths at a temporary file containing id
+func withMachineID(t *testing.T, id string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(path, []byte(id+"\n"), 0444); err != nil {
+		t.Fatalf("Failed to write machine id: %v", err)
+	}
+	original := machineIDPaths
+	machineIDPaths = []string{path}
+	t.Cleanup(func() { machineIDPaths = original })
+}
+
+func TestSealAtRestRoundTrip(t *testing.T) {
+	withMachineID(t, "0123456789abcdef0123456789abcdef")
+
+	secret := []byte("client private key")
+	sealed, err := SealAtRest(secret)
+	if err != nil {
+		t.Fatalf("SealAtRest failed: %v", err)
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Error("Sealed data contains the plaintext")
+	}
+
+	opened, err := OpenAtRest(sealed)
+	if err != nil {
+		t.Fatalf("OpenAtRest failed: %v", err)
+	}
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("Expected %q, got %q", secret, opened)
+	}
+
+	// Another machine cannot open it
+	withMachineID(t, "fedcba9876543210fedcba9876543210")
+	if _, err := OpenAtRest(sealed); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed on another machine, got %v", err)
+	}
+}
+
+func TestSealAtRestWithoutMachineID(t *testing.T) {
+	original := machineIDPaths
+	machineIDPaths = []string{filepath.Join(t.TempDir(), "missing")}
+	t.Cleanup(func() { machineIDPaths = original })
+
+	if _, err := SealAtRest([]byte("secret")); err == nil {
+		t.Error("Expected an error without a machine identifier")
+	}
+}
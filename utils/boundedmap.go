@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"container/list"
+	"time"
+)
+
+// BoundedMap is a map holding at most a fixed number of entries. Storing a new
+// key in a full map evicts the oldest entry, where storing a key again makes it
+// the newest. Each entry carries an expiry deadline so stale entries can be
+// dropped with Expire. It is not safe for concurrent use.
+type BoundedMap[K comparable, V any] struct {
+	max     int
+	entries map[K]*list.Element
+	order   *list.List // *boundedEntry, oldest first
+}
+
+type boundedEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time // Zero when the entry never expires
+}
+
+// NewBoundedMap creates a map holding at most max entries, unbounded when max <= 0
+func NewBoundedMap[K comparable, V any](max int) *BoundedMap[K, V] {
+	return &BoundedMap[K, V]{
+		max:     max,
+		entries: make(map[K]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the value stored for key
+func (m *BoundedMap[K, V]) Get(key K) (V, bool) {
+	if element, ok := m.entries[key]; ok {
+		return element.Value.(*boundedEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores value for key as the newest entry, expiring at expires (zero for
+// never), and evicts the oldest entries beyond the limit. It returns the number
+// of entries evicted.
+func (m *BoundedMap[K, V]) Set(key K, value V, expires time.Time) int {
+	if element, ok := m.entries[key]; ok {
+		entry := element.Value.(*boundedEntry[K, V])
+		entry.value = value
+		entry.expires = expires
+		m.order.MoveToBack(element)
+		return 0
+	}
+
+	m.entries[key] = m.order.PushBack(&boundedEntry[K, V]{key: key, value: value, expires: expires})
+
+	evicted := 0
+	for m.max > 0 && m.order.Len() > m.max {
+		m.remove(m.order.Front())
+		evicted++
+	}
+	return evicted
+}
+
+// Delete removes key
+func (m *BoundedMap[K, V]) Delete(key K) {
+	if element, ok := m.entries[key]; ok {
+		m.remove(element)
+	}
+}
+
+// Len returns the number of entries
+func (m *BoundedMap[K, V]) Len() int {
+	return m.order.Len()
+}
+
+// Clear removes all entries
+func (m *BoundedMap[K, V]) Clear() {
+	m.entries = make(map[K]*list.Element)
+	m.order.Init()
+}
+
+// Expire removes the entries whose deadline expired reports as reached, such as
+// Clock.Expired, and returns the number removed
+func (m *BoundedMap[K, V]) Expire(expired func(deadline time.Time) bool) int {
+	removed := 0
+	for element := m.order.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*boundedEntry[K, V])
+		if !entry.expires.IsZero() && expired(entry.expires) {
+			m.remove(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Range calls fn for each entry, oldest first, until fn returns false
+func (m *BoundedMap[K, V]) Range(fn func(key K, value V) bool) {
+	for element := m.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*boundedEntry[K, V])
+		if !fn(entry.key, entry.value) {
+			return
+		}
+	}
+}
+
+// Newest returns up to n values, newest first
+func (m *BoundedMap[K, V]) Newest(n int) []V {
+	values := make([]V, 0, min(n, m.order.Len()))
+	for element := m.order.Back(); element != nil && len(values) < n; element = element.Prev() {
+		values = append(values, element.Value.(*boundedEntry[K, V]).value)
+	}
+	return values
+}
+
+func (m *BoundedMap[K, V]) remove(element *list.Element) {
+	delete(m.entries, element.Value.(*boundedEntry[K, V]).key)
+	m.order.Remove(element)
+}
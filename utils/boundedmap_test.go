@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBoundedMapEvictsOldest(t *testing.T) {
+	m := NewBoundedMap[string, int](3)
+
+	for i := 0; i < 5; i++ {
+		m.Set(fmt.Sprintf("key-%d", i), i, time.Time{})
+	}
+	if m.Len() != 3 {
+		t.Fatalf("Expected 3 entries, got %d", m.Len())
+	}
+	if _, ok := m.Get("key-1"); ok {
+		t.Error("key-1 should have been evicted")
+	}
+
+	// Storing a key again makes it the newest
+	if evicted := m.Set("key-2", 20, time.Time{}); evicted != 0 {
+		t.Errorf("Replacing a key should not evict, evicted %d", evicted)
+	}
+	if evicted := m.Set("key-5", 5, time.Time{}); evicted != 1 {
+		t.Errorf("Expected 1 eviction, got %d", evicted)
+	}
+	if _, ok := m.Get("key-3"); ok {
+		t.Error("key-3 should have been evicted before the refreshed key-2")
+	}
+	if value, ok := m.Get("key-2"); !ok || value != 20 {
+		t.Errorf("Expected key-2 = 20, got %d, %v", value, ok)
+	}
+
+	newest := m.Newest(2)
+	if len(newest) != 2 || newest[0] != 5 || newest[1] != 20 {
+		t.Errorf("Unexpected newest values %v", newest)
+	}
+
+	var keys []string
+	m.Range(func(key string, _ int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if fmt.Sprint(keys) != "[key-4 key-2 key-5]" {
+		t.Errorf("Unexpected order %v", keys)
+	}
+
+	m.Delete("key-4")
+	m.Clear()
+	if m.Len() != 0 || len(m.Newest(5)) != 0 {
+		t.Errorf("Expected an empty map after Clear, got %d entries", m.Len())
+	}
+}
+
+func TestBoundedMapExpire(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := NewBoundedMap[string, int](0)
+
+	m.Set("short", 1, clock.Deadline(time.Minute))
+	m.Set("long", 2, clock.Deadline(time.Hour))
+	m.Set("forever", 3, time.Time{})
+
+	if removed := m.Expire(clock.Expired); removed != 0 {
+		t.Errorf("Nothing should expire yet, removed %d", removed)
+	}
+
+	clock.JumpWall(2 * time.Hour)
+	if removed := m.Expire(clock.Expired); removed != 0 {
+		t.Errorf("A wall clock jump should not expire entries, removed %d", removed)
+	}
+
+	clock.Advance(time.Minute)
+	if removed := m.Expire(clock.Expired); removed != 1 {
+		t.Errorf("Expected 1 expired entry, removed %d", removed)
+	}
+	if _, ok := m.Get("short"); ok {
+		t.Error("short should have expired")
+	}
+
+	clock.Advance(time.Hour)
+	m.Expire(clock.Expired)
+	if m.Len() != 1 {
+		t.Errorf("Only the entry without a deadline should remain, got %d entries", m.Len())
+	}
+}
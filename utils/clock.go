@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source for expiry timers. Deadlines are created and checked
+// through it so they are measured on the monotonic clock: a wall-clock jump,
+// such as an NTP sync on a board without an RTC, neither expires nor extends them.
+type Clock interface {
+	// Now returns the current time, for timestamps and display
+	Now() time.Time
+	// Deadline returns the time d from now
+	Deadline(d time.Duration) time.Time
+	// Remaining returns how long until deadline, negative once it has passed
+	Remaining(deadline time.Time) time.Duration
+	// Expired reports whether deadline has been reached
+	Expired(deadline time.Time) bool
+}
+
+// SystemClock is the real clock. Times from time.Now carry a monotonic reading,
+// which time.Until uses as long as it is not stripped (by Round(0), UTC,
+// serialization and the like); times without one fall back to the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) Deadline(d time.Duration) time.Time { return time.Now().Add(d) }
+
+func (SystemClock) Remaining(deadline time.Time) time.Duration { return time.Until(deadline) }
+
+func (c SystemClock) Expired(deadline time.Time) bool { return c.Remaining(deadline) <= 0 }
+
+// FakeClock is a manually advanced Clock for tests. Like the system clock, it
+// measures deadlines it created on its monotonic timeline, so JumpWall changes
+// Now without affecting them; other times are compared against the wall clock.
+type FakeClock struct {
+	mu        sync.Mutex
+	wall      time.Time
+	monotonic time.Duration
+	deadlines map[time.Time]time.Duration // Deadline -> monotonic reading it falls on
+}
+
+// NewFakeClock creates a fake clock reading start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{wall: start, deadlines: make(map[time.Time]time.Duration)}
+}
+
+// Advance moves both the wall and monotonic clocks forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+	c.monotonic += d
+}
+
+// JumpWall moves only the wall clock by d, as a clock adjustment does
+func (c *FakeClock) JumpWall(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall
+}
+
+func (c *FakeClock) Deadline(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := c.wall.Add(d)
+	c.deadlines[deadline] = c.monotonic + d
+	return deadline
+}
+
+func (c *FakeClock) Remaining(deadline time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if monotonic, ok := c.deadlines[deadline]; ok {
+		return monotonic - c.monotonic
+	}
+	return deadline.Sub(c.wall)
+}
+
+func (c *FakeClock) Expired(deadline time.Time) bool { return c.Remaining(deadline) <= 0 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockIgnoresWallJumps(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	deadline := clock.Deadline(time.Minute)
+
+	clock.JumpWall(10 * 365 * 24 * time.Hour)
+	if remaining := clock.Remaining(deadline); remaining != time.Minute {
+		t.Errorf("Expected 1m remaining after a forward jump, got %v", remaining)
+	}
+	clock.JumpWall(-20 * 365 * 24 * time.Hour)
+	if remaining := clock.Remaining(deadline); remaining != time.Minute {
+		t.Errorf("Expected 1m remaining after a backward jump, got %v", remaining)
+	}
+
+	clock.Advance(30 * time.Second)
+	if remaining := clock.Remaining(deadline); remaining != 30*time.Second {
+		t.Errorf("Expected 30s remaining, got %v", remaining)
+	}
+	clock.Advance(30 * time.Second)
+	if !clock.Expired(deadline) {
+		t.Error("Expected the deadline to expire")
+	}
+
+	// Times the clock did not create, such as loaded ones, use the wall clock
+	wall := clock.Now().Add(time.Hour)
+	clock.JumpWall(2 * time.Hour)
+	if !clock.Expired(wall) {
+		t.Error("Expected a wall-clock time to expire after the wall clock passed it")
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	var clock SystemClock
+	deadline := clock.Deadline(time.Hour)
+	if clock.Expired(deadline) {
+		t.Error("Deadline an hour away should not be expired")
+	}
+	if remaining := clock.Remaining(deadline); remaining <= 59*time.Minute || remaining > time.Hour {
+		t.Errorf("Expected about 1h remaining, got %v", remaining)
+	}
+	if !clock.Expired(time.Time{}) {
+		t.Error("The zero time should be expired")
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// maxDecompressedPayloadSize caps decompressed message size to guard against zip bombs
+const maxDecompressedPayloadSize = 16 * 1024 * 1024
+
+// ErrDecompressedTooLarge is returned when a compressed payload expands beyond the allowed size
+var ErrDecompressedTooLarge = errors.New("decompressed payload exceeds size limit")
+
+// compressionThreshold is the message size in bytes above which payloads are compressed (0 disables)
+var compressionThreshold atomic.Int64
+
+// SetCompressionThreshold sets the size in bytes above which encrypted payloads are
+// gzip-compressed. A value of 0 or less disables compression.
+func SetCompressionThreshold(threshold int) {
+	compressionThreshold.Store(int64(threshold))
+}
+
+// GetCompressionThreshold returns the current compression threshold in bytes
+func GetCompressionThreshold() int {
+	return int(compressionThreshold.Load())
+}
+
+// compressPayload gzip-compresses data
+func compressPayload(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload gunzips data, failing if the result exceeds maxSize bytes
+func decompressPayload(data []byte, maxSize int64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	defer reader.Close()
+
+	// Read one byte past the limit to detect oversized payloads without reading them fully
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	if int64(len(decompressed)) > maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return decompressed, nil
+}
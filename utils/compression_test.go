@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressedEnvelopeRoundTrip(t *testing.T) {
+	mc := NewMessageCrypto()
+	sessionKeyB64 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	original := GetCompressionThreshold()
+	defer SetCompressionThreshold(original)
+
+	message := map[string]interface{}{
+		"type": "command_response",
+		"data": strings.Repeat("screenshot-data ", 1024),
+	}
+
+	SetCompressionThreshold(0)
+	plain, err := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+	if err != nil {
+		t.Fatalf("CreateEncryptedEnvelope failed: %v", err)
+	}
+	if _, ok := plain["compressed"]; ok {
+		t.Error("Envelope should not be compressed when compression is disabled")
+	}
+
+	SetCompressionThreshold(4096)
+	compressed, err := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+	if err != nil {
+		t.Fatalf("CreateEncryptedEnvelope failed: %v", err)
+	}
+	if compressed["compressed"] != true {
+		t.Fatal("Large payload should be compressed")
+	}
+
+	plainWire, _ := json.Marshal(plain)
+	compressedWire, _ := json.Marshal(compressed)
+	if len(compressedWire) >= len(plainWire) {
+		t.Errorf("Compressed envelope (%d bytes) should be smaller than uncompressed (%d bytes)", len(compressedWire), len(plainWire))
+	}
+
+	decrypted, err := mc.ExtractFromEncryptedEnvelope(compressed, sessionKeyB64)
+	if err != nil {
+		t.Fatalf("ExtractFromEncryptedEnvelope failed: %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, message) {
+		t.Error("Decrypted message does not match original")
+	}
+
+	// Small payloads stay uncompressed
+	small, _ := mc.CreateEncryptedEnvelope(map[string]interface{}{"type": "pong"}, sessionKeyB64)
+	if _, ok := small["compressed"]; ok {
+		t.Error("Small payload should not be compressed")
+	}
+}
+
+func TestDecompressPayloadSizeCap(t *testing.T) {
+	// Highly compressible input expands far beyond its compressed size
+	bomb, err := compressPayload(bytes.Repeat([]byte{0}, 1024*1024))
+	if err != nil {
+		t.Fatalf("compressPayload failed: %v", err)
+	}
+
+	if _, err := decompressPayload(bomb, 64*1024); err != ErrDecompressedTooLarge {
+		t.Errorf("Expected ErrDecompressedTooLarge, got %v", err)
+	}
+
+	if data, err := decompressPayload(bomb, 1024*1024); err != nil || len(data) != 1024*1024 {
+		t.Errorf("Expected payload within the cap to decompress, got %d bytes, err %v", len(data), err)
+	}
+
+	if _, err := decompressPayload([]byte("not gzip"), 1024); err == nil {
+		t.Error("Expected error for invalid gzip data")
+	}
+}
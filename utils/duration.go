@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// extendedDurationUnit matches day and week components, e.g. "7d" or "1.5w"
+var extendedDurationUnit = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// ParseDurationExtended parses a duration like time.ParseDuration, additionally
+// accepting "d" (24h) and "w" (168h) units. For example, "2w3d12h" is 17.5 days.
+func ParseDurationExtended(s string) (time.Duration, error) {
+	converted := extendedDurationUnit.ReplaceAllStringFunc(s, func(component string) string {
+		match := extendedDurationUnit.FindStringSubmatch(component)
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return component // Leave it for time.ParseDuration to reject
+		}
+
+		hours := value * 24
+		if match[2] == "w" {
+			hours = value * 168
+		}
+		return strconv.FormatFloat(hours, 'f', -1, 64) + "h"
+	})
+	return time.ParseDuration(converted)
+}
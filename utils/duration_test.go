@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDurationExtended(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"1d", 24 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1w", 168 * time.Hour, false},
+		{"2w3d", 17 * 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"0d", 0, false},
+		{"-1d", -24 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"invalid", 0, true},
+		{"", 0, true},
+		{"d", 0, true},
+		{"1x", 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			duration, err := ParseDurationExtended(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %v", tc.input, duration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for %q: %v", tc.input, err)
+			}
+			if duration != tc.expected {
+				t.Errorf("Expected %v for %q, got %v", tc.expected, tc.input, duration)
+			}
+		})
+	}
+}
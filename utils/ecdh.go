@@ -51,11 +51,44 @@ func GetECDHPublicKey() string {
 	return base64.StdEncoding.EncodeToString(ecdhPublicKey)
 }
 
-// ClearECDHKeys clears the stored ECDH keys
+// ExportECDHPrivateKey returns the raw bytes of the current ECDH private key,
+// or nil when no key pair has been generated
+func ExportECDHPrivateKey() []byte {
+	ecdhMutex.RLock()
+	defer ecdhMutex.RUnlock()
+
+	if ecdhPrivateKey == nil {
+		return nil
+	}
+	return ecdhPrivateKey.Bytes()
+}
+
+// RestoreECDHPrivateKey reinstates a key pair from bytes returned by ExportECDHPrivateKey
+func RestoreECDHPrivateKey(raw []byte) error {
+	privateKey, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return fmt.Errorf("failed to restore ECDH private key: %w", err)
+	}
+
+	ecdhMutex.Lock()
+	defer ecdhMutex.Unlock()
+
+	ecdhPrivateKey = privateKey
+	ecdhPublicKey = privateKey.PublicKey().Bytes()
+
+	return nil
+}
+
+// ClearECDHKeys zeroes and clears the stored ECDH keys.
+// The private key is opaque and can only be released to the garbage collector.
 func ClearECDHKeys() {
 	ecdhMutex.Lock()
 	defer ecdhMutex.Unlock()
 
+	Zeroize(sharedSecret)
+	Zeroize(sessionKey)
+	Zeroize(ecdhPublicKey)
+
 	ecdhPrivateKey = nil
 	ecdhPublicKey = nil
 	sharedSecret = nil
@@ -85,11 +118,15 @@ func DeriveSharedSecret(serverPublicKeyB64 string) error {
 	}
 
 	// Perform ECDH key exchange
-	sharedSecret, err = ecdhPrivateKey.ECDH(serverPublicKey)
+	secret, err := ecdhPrivateKey.ECDH(serverPublicKey)
 	if err != nil {
 		return fmt.Errorf("ECDH key exchange failed: %w", err)
 	}
 
+	// Zero any previous secret before replacing it
+	Zeroize(sharedSecret)
+	sharedSecret = secret
+
 	return nil
 }
 
@@ -104,11 +141,16 @@ func DeriveSessionKey(info string) error {
 
 	// Use HKDF to derive a 32-byte session key
 	hkdf := hkdf.New(sha256.New, sharedSecret, nil, []byte(info))
-	sessionKey = make([]byte, 32)
-	if _, err := hkdf.Read(sessionKey); err != nil {
+	key := make([]byte, 32)
+	if _, err := hkdf.Read(key); err != nil {
+		Zeroize(key)
 		return fmt.Errorf("failed to derive session key: %w", err)
 	}
 
+	// Zero any previous session key before replacing it
+	Zeroize(sessionKey)
+	sessionKey = key
+
 	return nil
 }
 
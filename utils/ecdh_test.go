@@ -167,6 +167,34 @@ func TestClearECDHKeys(t *testing.T) {
 		}
 	})
 
+	t.Run("Key material zeroed", func(t *testing.T) {
+		if err := GenerateECDHKeyPair(); err != nil {
+			t.Fatalf("Key generation failed: %v", err)
+		}
+		if err := DeriveSharedSecret(GetECDHPublicKey()); err != nil {
+			t.Fatalf("DeriveSharedSecret failed: %v", err)
+		}
+		if err := DeriveSessionKey("test"); err != nil {
+			t.Fatalf("DeriveSessionKey failed: %v", err)
+		}
+
+		// Retain references to the backing arrays before clearing
+		ecdhMutex.RLock()
+		retained := [][]byte{sharedSecret, sessionKey, ecdhPublicKey}
+		ecdhMutex.RUnlock()
+
+		ClearECDHKeys()
+
+		for i, b := range retained {
+			for _, v := range b {
+				if v != 0 {
+					t.Errorf("Key material %d was not zeroed", i)
+					break
+				}
+			}
+		}
+	})
+
 	t.Run("Clear empty keys", func(t *testing.T) {
 		ClearECDHKeys()
 		ClearECDHKeys() // Should not panic
@@ -529,3 +557,29 @@ func BenchmarkDeriveSessionKey(b *testing.B) {
 		DeriveSessionKey("test-info")
 	}
 }
+
+func TestExportRestoreECDHPrivateKey(t *testing.T) {
+	ClearECDHKeys()
+	if ExportECDHPrivateKey() != nil {
+		t.Fatal("Expected no private key after clearing")
+	}
+
+	if err := GenerateECDHKeyPair(); err != nil {
+		t.Fatalf("GenerateECDHKeyPair() failed: %v", err)
+	}
+	raw := ExportECDHPrivateKey()
+	publicKey := GetECDHPublicKey()
+
+	ClearECDHKeys()
+	if err := RestoreECDHPrivateKey(raw); err != nil {
+		t.Fatalf("RestoreECDHPrivateKey() failed: %v", err)
+	}
+	if GetECDHPublicKey() != publicKey {
+		t.Error("Restored key pair has a different public key")
+	}
+
+	if err := RestoreECDHPrivateKey([]byte("short")); err == nil {
+		t.Error("Expected an error for an invalid private key")
+	}
+	ClearECDHKeys()
+}
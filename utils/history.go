@@ -0,0 +1,37 @@
+package utils
+
+import "sync"
+
+// History keeps the most recent items up to a fixed limit, dropping the oldest
+// item when full. It is safe for concurrent use.
+type History[T any] struct {
+	mu    sync.Mutex
+	limit int
+	items []T // Oldest first
+}
+
+// NewHistory creates a history holding at most limit items, unbounded when limit <= 0
+func NewHistory[T any](limit int) *History[T] {
+	return &History[T]{limit: limit}
+}
+
+// Add records item, dropping the oldest items beyond the limit
+func (h *History[T]) Add(item T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.items = append(h.items, item)
+	if h.limit > 0 && len(h.items) > h.limit {
+		h.items = h.items[len(h.items)-h.limit:]
+	}
+}
+
+// Recent returns the recorded items, newest first
+func (h *History[T]) Recent() []T {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]T, len(h.items))
+	for i, item := range h.items {
+		result[len(h.items)-1-i] = item
+	}
+	return result
+}
@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	history := NewHistory[int](3)
+	if recent := history.Recent(); len(recent) != 0 {
+		t.Fatalf("Expected an empty history, got %v", recent)
+	}
+
+	for i := 1; i <= 5; i++ {
+		history.Add(i)
+	}
+	recent := history.Recent()
+	if len(recent) != 3 || recent[0] != 5 || recent[1] != 4 || recent[2] != 3 {
+		t.Errorf("Expected the three newest items newest first, got %v", recent)
+	}
+
+	unbounded := NewHistory[int](0)
+	for i := 0; i < 100; i++ {
+		unbounded.Add(i)
+	}
+	if got := len(unbounded.Recent()); got != 100 {
+		t.Errorf("Expected an unbounded history to keep all 100 items, got %d", got)
+	}
+}
@@ -1,17 +1,36 @@
 package utils
 
 import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // InterfaceInfo represents information about a network interface
 type InterfaceInfo struct {
-	IPAddress  string `json:"ip_address"`
-	MACAddress string `json:"mac_address"`
-	Type       string `json:"type"` // "wifi", "ethernet", "other"
-	IsUp       bool   `json:"is_up"`
+	Name        string `json:"name"`
+	IPAddress   string `json:"ip_address"`             // Primary address: IPv4Address if set, otherwise IPv6Address
+	IPv4Address string `json:"ipv4_address,omitempty"` // First IPv4 address
+	IPv6Address string `json:"ipv6_address,omitempty"` // First global IPv6 address, link-local excluded
+	MACAddress  string `json:"mac_address"`
+	Type        string `json:"type"` // "wifi", "ethernet", "other"
+	IsUp        bool   `json:"is_up"`
+	RxBytes     uint64 `json:"rx_bytes,omitempty"` // Received byte counter, Linux only
+	TxBytes     uint64 `json:"tx_bytes,omitempty"` // Transmitted byte counter, Linux only
+}
+
+// HasAddress reports whether ip is one of the interface's addresses
+func (iface InterfaceInfo) HasAddress(ip string) bool {
+	return ip != "" && (ip == iface.IPAddress || ip == iface.IPv4Address || ip == iface.IPv6Address)
 }
 
 // IPv6 regex patterns
@@ -90,7 +109,8 @@ func detectInterfaceType(name string) string {
 	return "other"
 }
 
-// GetAllInterfaces returns information about all network interfaces
+// GetAllInterfaces returns information about all network interfaces that have
+// an address, one entry per interface with its first IPv4 and IPv6 addresses
 func GetAllInterfaces() []InterfaceInfo {
 	var result []InterfaceInfo
 
@@ -106,16 +126,28 @@ func GetAllInterfaces() []InterfaceInfo {
 			continue
 		}
 
-		// Process each address on this interface
+		// Byte counters are per interface and stay zero where sysfs is unavailable
+		rxBytes, txBytes, _ := readInterfaceCounters(iface.Name)
+
+		interfaceInfo := InterfaceInfo{
+			Name:    iface.Name,
+			Type:    detectInterfaceType(iface.Name),
+			IsUp:    iface.Flags&net.FlagUp != 0,
+			RxBytes: rxBytes,
+			TxBytes: txBytes,
+		}
+
+		// Collect the first address of each family on this interface
 		for _, addr := range addrs {
-			var ipAddr string
+			var ip net.IP
 			if ipNet, ok := addr.(*net.IPNet); ok {
-				ipAddr = ipNet.IP.String()
-			} else if ip, ok := addr.(*net.IPAddr); ok {
-				ipAddr = ip.IP.String()
+				ip = ipNet.IP
+			} else if ipAddr, ok := addr.(*net.IPAddr); ok {
+				ip = ipAddr.IP
 			} else {
 				continue
 			}
+			ipAddr := ip.String()
 
 			// Skip IPv6 link-local addresses using regex
 			if IsIPv6LinkLocal(ipAddr) {
@@ -132,20 +164,34 @@ func GetAllInterfaces() []InterfaceInfo {
 				continue
 			}
 
-			macAddr := "00:00:00:00:00:00"
-			if iface.HardwareAddr != nil {
-				macAddr = iface.HardwareAddr.String()
+			// The family comes from the parsed address, which covers every
+			// IPv6 form (IsIPv6's patterns miss some compressed ones)
+			if ip.To4() == nil {
+				if interfaceInfo.IPv6Address == "" {
+					interfaceInfo.IPv6Address = ipAddr
+				}
+			} else if interfaceInfo.IPv4Address == "" {
+				interfaceInfo.IPv4Address = ipAddr
 			}
+		}
 
-			interfaceInfo := InterfaceInfo{
-				IPAddress:  ipAddr,
-				MACAddress: macAddr,
-				Type:       detectInterfaceType(iface.Name),
-				IsUp:       iface.Flags&net.FlagUp != 0,
-			}
+		// Skip interfaces left without any address
+		if interfaceInfo.IPv4Address == "" && interfaceInfo.IPv6Address == "" {
+			continue
+		}
+
+		// IPv4 is preferred as the primary address
+		interfaceInfo.IPAddress = interfaceInfo.IPv4Address
+		if interfaceInfo.IPAddress == "" {
+			interfaceInfo.IPAddress = interfaceInfo.IPv6Address
+		}
 
-			result = append(result, interfaceInfo)
+		interfaceInfo.MACAddress = "00:00:00:00:00:00"
+		if iface.HardwareAddr != nil {
+			interfaceInfo.MACAddress = iface.HardwareAddr.String()
 		}
+
+		result = append(result, interfaceInfo)
 	}
 
 	return result
@@ -166,12 +212,13 @@ func GetNetworkInterfaces() []InterfaceInfo {
 	return networkInterfaces
 }
 
-// GetInterfaceByIP returns interface information for the interface with the specified IP address
+// GetInterfaceByIP returns interface information for the interface with the specified
+// IPv4 or IPv6 address
 func GetInterfaceByIP(ip string) *InterfaceInfo {
 	interfaces := GetAllInterfaces()
 
 	for _, iface := range interfaces {
-		if iface.IPAddress == ip {
+		if iface.HasAddress(ip) {
 			return &iface
 		}
 	}
@@ -179,6 +226,44 @@ func GetInterfaceByIP(ip string) *InterfaceInfo {
 	return nil
 }
 
+// normalizeMAC returns mac in the lowercase colon-separated form used by
+// InterfaceInfo, accepting colon, hyphen and dot separated input
+func normalizeMAC(mac string) (string, bool) {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil {
+		return "", false
+	}
+	return hw.String(), true
+}
+
+// GetInterfaceByMAC returns interface information for the interface with the specified
+// MAC address, in any format accepted by net.ParseMAC, or nil if none matches
+func GetInterfaceByMAC(mac string) *InterfaceInfo {
+	normalized, ok := normalizeMAC(mac)
+	if !ok {
+		return nil
+	}
+
+	for _, iface := range GetAllInterfaces() {
+		if ifaceMAC, ok := normalizeMAC(iface.MACAddress); ok && ifaceMAC == normalized {
+			return &iface
+		}
+	}
+
+	return nil
+}
+
+// GetInterfacesByType returns the interfaces of the given type ("wifi", "ethernet" or "other")
+func GetInterfacesByType(ifaceType string) []InterfaceInfo {
+	var matching []InterfaceInfo
+	for _, iface := range GetAllInterfaces() {
+		if iface.Type == ifaceType {
+			matching = append(matching, iface)
+		}
+	}
+	return matching
+}
+
 // GetPrimaryInterface returns information about the primary network interface
 // Priority: up, with IP address (WiFi preferred over Ethernet)
 func GetPrimaryInterface() *InterfaceInfo {
@@ -225,6 +310,7 @@ func GetPrimaryInterface() *InterfaceInfo {
 
 // GetMacAddress returns the MAC address of the network interface that has the specified IP address
 // If ip is empty, returns the MAC address of the primary network interface
+// If ip is a MAC address, returns it normalized when it belongs to a local interface
 // This function is kept for backward compatibility
 func GetMacAddress(ip string) string {
 	if ip == "" {
@@ -234,6 +320,13 @@ func GetMacAddress(ip string) string {
 		return "00:00:00:00:00:00"
 	}
 
+	if _, isMAC := normalizeMAC(ip); isMAC && net.ParseIP(ip) == nil {
+		if iface := GetInterfaceByMAC(ip); iface != nil {
+			return iface.MACAddress
+		}
+		return "00:00:00:00:00:00"
+	}
+
 	if iface := GetInterfaceByIP(ip); iface != nil {
 		return iface.MACAddress
 	}
@@ -247,7 +340,7 @@ func GetIPv4Interfaces() []InterfaceInfo {
 	var ipv4Interfaces []InterfaceInfo
 
 	for _, iface := range allInterfaces {
-		if !IsIPv6(iface.IPAddress) {
+		if iface.IPv4Address != "" {
 			ipv4Interfaces = append(ipv4Interfaces, iface)
 		}
 	}
@@ -261,7 +354,7 @@ func GetIPv6Interfaces() []InterfaceInfo {
 	var ipv6Interfaces []InterfaceInfo
 
 	for _, iface := range allInterfaces {
-		if IsIPv6(iface.IPAddress) {
+		if iface.IPv6Address != "" {
 			ipv6Interfaces = append(ipv6Interfaces, iface)
 		}
 	}
@@ -269,6 +362,17 @@ func GetIPv6Interfaces() []InterfaceInfo {
 	return ipv6Interfaces
 }
 
+// GetDualStackInterfaces returns only interfaces with both an IPv4 and an IPv6 address
+func GetDualStackInterfaces() []InterfaceInfo {
+	var dualStack []InterfaceInfo
+	for _, iface := range GetAllInterfaces() {
+		if iface.IPv4Address != "" && iface.IPv6Address != "" {
+			dualStack = append(dualStack, iface)
+		}
+	}
+	return dualStack
+}
+
 // GetInterfaceIPVersion returns "ipv4", "ipv6", or "unknown" for the given IP address
 func GetInterfaceIPVersion(ip string) string {
 	if IsIPv6(ip) {
@@ -282,3 +386,156 @@ func GetInterfaceIPVersion(ip string) string {
 	
 	return "unknown"
 }
+
+// ErrNoDefaultGateway is returned when no default route is found
+var ErrNoDefaultGateway = errors.New("no default gateway found")
+
+// GetDefaultGateway returns the IPv4 address of the default gateway.
+// This function is Linux-specific, reading from /proc/net/route.
+func GetDefaultGateway() (string, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	return parseDefaultGateway(string(data))
+}
+
+// parseDefaultGateway extracts the default gateway from /proc/net/route content
+func parseDefaultGateway(routes string) (string, error) {
+	for _, line := range SplitLines(routes) {
+		fields := strings.Fields(line)
+		// Skip header and non-default routes (destination 00000000)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		// Gateway is a little-endian hex encoded IPv4 address
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gateway))
+		if ip.Equal(net.IPv4zero) {
+			continue
+		}
+		return ip.String(), nil
+	}
+	return "", ErrNoDefaultGateway
+}
+
+// ErrNotAvailable is returned when a measurement is not supported on this platform
+var ErrNotAvailable = errors.New("not available on this platform")
+
+// sysClassNetPath is the sysfs directory containing interface statistics
+var sysClassNetPath = "/sys/class/net"
+
+// NetworkSpeed represents the measured receive/transmit rate of an interface
+type NetworkSpeed struct {
+	RxBytesPerSec float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec float64 `json:"tx_bytes_per_sec"`
+}
+
+// readInterfaceCounters reads the rx/tx byte counters of an interface from sysfs
+func readInterfaceCounters(ifaceName string) (rx, tx uint64, err error) {
+	statsDir := filepath.Join(sysClassNetPath, ifaceName, "statistics")
+
+	readCounter := func(name string) (uint64, error) {
+		data, err := os.ReadFile(filepath.Join(statsDir, name))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	if rx, err = readCounter("rx_bytes"); err != nil {
+		return 0, 0, fmt.Errorf("failed to read rx_bytes for %s: %w", ifaceName, err)
+	}
+	if tx, err = readCounter("tx_bytes"); err != nil {
+		return 0, 0, fmt.Errorf("failed to read tx_bytes for %s: %w", ifaceName, err)
+	}
+	return rx, tx, nil
+}
+
+// CalculateSpeed converts two counter samples taken duration apart into a rate.
+// Counters that went backwards (wrap or interface reset) give a zero rate.
+func CalculateSpeed(rx1, tx1, rx2, tx2 uint64, duration time.Duration) NetworkSpeed {
+	seconds := duration.Seconds()
+	speed := NetworkSpeed{}
+	if seconds <= 0 {
+		return speed
+	}
+	// Counters can reset (e.g. interface restart), so ignore negative deltas
+	if rx2 >= rx1 {
+		speed.RxBytesPerSec = float64(rx2-rx1) / seconds
+	}
+	if tx2 >= tx1 {
+		speed.TxBytesPerSec = float64(tx2-tx1) / seconds
+	}
+	return speed
+}
+
+// GetNetworkSpeed measures the receive/transmit rate of an interface over duration.
+// This function is Linux-specific, reading from /sys/class/net/<iface>/statistics.
+func GetNetworkSpeed(ifaceName string, duration time.Duration) (NetworkSpeed, error) {
+	if runtime.GOOS != "linux" {
+		return NetworkSpeed{}, ErrNotAvailable
+	}
+	if duration <= 0 {
+		return NetworkSpeed{}, fmt.Errorf("invalid duration: %v", duration)
+	}
+
+	rx1, tx1, err := readInterfaceCounters(ifaceName)
+	if err != nil {
+		return NetworkSpeed{}, err
+	}
+
+	time.Sleep(duration)
+
+	rx2, tx2, err := readInterfaceCounters(ifaceName)
+	if err != nil {
+		return NetworkSpeed{}, err
+	}
+
+	return CalculateSpeed(rx1, tx1, rx2, tx2, duration), nil
+}
+
+// GetAllInterfaceSpeeds measures the receive/transmit rate of all up, non-loopback
+// interfaces over duration. Interfaces whose counters cannot be read are omitted.
+func GetAllInterfaceSpeeds(duration time.Duration) map[string]NetworkSpeed {
+	result := make(map[string]NetworkSpeed)
+	if runtime.GOOS != "linux" || duration <= 0 {
+		return result
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return result
+	}
+
+	type sample struct{ rx, tx uint64 }
+	start := make(map[string]sample)
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if rx, tx, err := readInterfaceCounters(iface.Name); err == nil {
+			start[iface.Name] = sample{rx, tx}
+		}
+	}
+	if len(start) == 0 {
+		return result
+	}
+
+	// Sample all interfaces over the same window
+	time.Sleep(duration)
+
+	for name, first := range start {
+		rx, tx, err := readInterfaceCounters(name)
+		if err != nil {
+			continue
+		}
+		result[name] = CalculateSpeed(first.rx, first.tx, rx, tx, duration)
+	}
+	return result
+}
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"math"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDetectInterfaceType(t *testing.T) {
@@ -191,6 +196,57 @@ func TestGetAllInterfaces(t *testing.T) {
 		}
 	})
 
+	t.Run("Reports both address families", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, iface := range GetAllInterfaces() {
+			if seen[iface.Name] {
+				t.Errorf("Interface %q listed more than once", iface.Name)
+			}
+			seen[iface.Name] = true
+
+			if iface.IPv4Address != "" && net.ParseIP(iface.IPv4Address).To4() == nil {
+				t.Errorf("Interface %q has IPv6 address %q in IPv4Address", iface.Name, iface.IPv4Address)
+			}
+			if iface.IPv6Address != "" && net.ParseIP(iface.IPv6Address).To4() != nil {
+				t.Errorf("Interface %q has IPv4 address %q in IPv6Address", iface.Name, iface.IPv6Address)
+			}
+
+			// IPv4 is the primary address when present
+			expected := iface.IPv4Address
+			if expected == "" {
+				expected = iface.IPv6Address
+			}
+			if iface.IPAddress != expected {
+				t.Errorf("Interface %q has primary address %q, expected %q", iface.Name, iface.IPAddress, expected)
+			}
+
+			// Both fields are populated for an interface carrying both families
+			ifc, err := net.InterfaceByName(iface.Name)
+			if err != nil {
+				continue
+			}
+			addrs, _ := ifc.Addrs()
+			var hasV4, hasV6 bool
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+					continue
+				}
+				if ipNet.IP.To4() != nil {
+					hasV4 = true
+				} else {
+					hasV6 = true
+				}
+			}
+			if hasV4 && iface.IPv4Address == "" {
+				t.Errorf("Interface %q has an IPv4 address but IPv4Address is empty", iface.Name)
+			}
+			if hasV6 && iface.IPv6Address == "" {
+				t.Errorf("Interface %q has an IPv6 address but IPv6Address is empty", iface.Name)
+			}
+		}
+	})
+
 	t.Run("Excludes loopback and invalid addresses", func(t *testing.T) {
 		interfaces := GetAllInterfaces()
 
@@ -216,6 +272,25 @@ func TestGetAllInterfaces(t *testing.T) {
 	})
 }
 
+func TestGetDualStackInterfaces(t *testing.T) {
+	dualStack := GetDualStackInterfaces()
+	if len(dualStack) == 0 {
+		t.Skip("No dual-stack interface available")
+	}
+
+	for _, iface := range dualStack {
+		if iface.IPv4Address == "" || iface.IPv6Address == "" {
+			t.Errorf("Interface %q is not dual-stack: %+v", iface.Name, iface)
+		}
+		if iface.IPAddress != iface.IPv4Address {
+			t.Errorf("Interface %q should use its IPv4 address as primary, got %q", iface.Name, iface.IPAddress)
+		}
+		if found := GetInterfaceByIP(iface.IPv6Address); found == nil || found.Name != iface.Name {
+			t.Errorf("GetInterfaceByIP(%q) should find %q", iface.IPv6Address, iface.Name)
+		}
+	}
+}
+
 func TestGetNetworkInterfaces(t *testing.T) {
 	t.Run("Returns only ethernet and wifi interfaces", func(t *testing.T) {
 		interfaces := GetNetworkInterfaces()
@@ -303,6 +378,71 @@ func TestGetInterfaceByIP(t *testing.T) {
 	})
 }
 
+func TestGetInterfaceByMAC(t *testing.T) {
+	interfaces := GetAllInterfaces()
+	if len(interfaces) == 0 {
+		t.Skip("No interfaces available for testing")
+	}
+	target := interfaces[0]
+
+	tests := []struct {
+		name string
+		mac  string
+	}{
+		{"Normalized", target.MACAddress},
+		{"Uppercase", strings.ToUpper(target.MACAddress)},
+		{"Hyphen-separated", strings.ReplaceAll(target.MACAddress, ":", "-")},
+		{"Uppercase hyphen-separated", strings.ToUpper(strings.ReplaceAll(target.MACAddress, ":", "-"))},
+		{"Surrounding whitespace", " " + target.MACAddress + " "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := GetInterfaceByMAC(tt.mac)
+			if found == nil {
+				t.Fatalf("GetInterfaceByMAC(%q) should find interface %s", tt.mac, target.Name)
+			}
+			if found.MACAddress != target.MACAddress {
+				t.Errorf("Found interface has wrong MAC: expected %q, got %q", target.MACAddress, found.MACAddress)
+			}
+		})
+	}
+
+	t.Run("Non-existent MAC", func(t *testing.T) {
+		if found := GetInterfaceByMAC("de:ad:be:ef:00:01"); found != nil {
+			t.Errorf("GetInterfaceByMAC should return nil for a MAC on no interface, got %+v", found)
+		}
+	})
+
+	t.Run("Invalid MAC", func(t *testing.T) {
+		if found := GetInterfaceByMAC("not-a-mac"); found != nil {
+			t.Error("GetInterfaceByMAC should return nil for an invalid MAC")
+		}
+	})
+}
+
+func TestGetInterfacesByType(t *testing.T) {
+	all := GetAllInterfaces()
+	for _, ifaceType := range []string{"wifi", "ethernet", "other"} {
+		var expected int
+		for _, iface := range all {
+			if iface.Type == ifaceType {
+				expected++
+			}
+		}
+
+		matching := GetInterfacesByType(ifaceType)
+		if len(matching) != expected {
+			t.Errorf("GetInterfacesByType(%q) returned %d interfaces, expected %d", ifaceType, len(matching), expected)
+		}
+		for _, iface := range matching {
+			if iface.Type != ifaceType {
+				t.Errorf("GetInterfacesByType(%q) returned %s of type %q", ifaceType, iface.Name, iface.Type)
+			}
+		}
+	}
+}
+
 func TestGetPrimaryInterface(t *testing.T) {
 	t.Run("Returns valid interface or nil", func(t *testing.T) {
 		primary := GetPrimaryInterface()
@@ -393,6 +533,23 @@ func TestGetMacAddress(t *testing.T) {
 		}
 	})
 
+	t.Run("MAC address returns normalized MAC", func(t *testing.T) {
+		interfaces := GetAllInterfaces()
+		if len(interfaces) == 0 {
+			t.Skip("No interfaces available for testing")
+		}
+
+		expectedMAC := interfaces[0].MACAddress
+		mac := GetMacAddress(strings.ToUpper(strings.ReplaceAll(expectedMAC, ":", "-")))
+		if mac != expectedMAC {
+			t.Errorf("GetMacAddress with a hyphenated MAC = %q, expected %q", mac, expectedMAC)
+		}
+
+		if mac := GetMacAddress("de:ad:be:ef:00:01"); mac != "00:00:00:00:00:00" {
+			t.Errorf("GetMacAddress with an unknown MAC should return default MAC, got %q", mac)
+		}
+	})
+
 	t.Run("Invalid IP returns default MAC", func(t *testing.T) {
 		mac := GetMacAddress("192.168.999.999")
 		if mac != "00:00:00:00:00:00" {
@@ -504,3 +661,105 @@ func BenchmarkGetMacAddress(b *testing.B) {
 		GetMacAddress(testIP)
 	}
 }
+
+func TestParseDefaultGateway(t *testing.T) {
+	routes := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	010200C0	0003	0	0	0	00000000	0	0	0
+`
+	gateway, err := parseDefaultGateway(routes)
+	if err != nil {
+		t.Fatalf("Expected default gateway, got error: %v", err)
+	}
+	if gateway != "192.0.2.1" {
+		t.Errorf("Expected gateway 192.0.2.1, got %s", gateway)
+	}
+
+	// No default route
+	noDefault := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`
+	if _, err := parseDefaultGateway(noDefault); err != ErrNoDefaultGateway {
+		t.Errorf("Expected ErrNoDefaultGateway, got %v", err)
+	}
+}
+
+func TestGetNetworkSpeed(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := GetNetworkSpeed("eth0", time.Millisecond); err != ErrNotAvailable {
+			t.Errorf("Expected ErrNotAvailable on %s, got %v", runtime.GOOS, err)
+		}
+		return
+	}
+
+	// Point sysfs lookups at fake counter files
+	originalPath := sysClassNetPath
+	sysClassNetPath = t.TempDir()
+	defer func() { sysClassNetPath = originalPath }()
+
+	statsDir := filepath.Join(sysClassNetPath, "eth0", "statistics")
+	if err := os.MkdirAll(statsDir, 0755); err != nil {
+		t.Fatalf("Failed to create stats directory: %v", err)
+	}
+	writeCounters := func(rx, tx string) {
+		if err := os.WriteFile(filepath.Join(statsDir, "rx_bytes"), []byte(rx+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write rx_bytes: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(statsDir, "tx_bytes"), []byte(tx+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write tx_bytes: %v", err)
+		}
+	}
+	writeCounters("1000", "500")
+
+	// Advance the counters while the measurement is sleeping
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writeCounters("3000", "1500")
+	}()
+
+	speed, err := GetNetworkSpeed("eth0", 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("GetNetworkSpeed failed: %v", err)
+	}
+	if speed.RxBytesPerSec != 10000 {
+		t.Errorf("Expected rx rate 10000 B/s, got %v", speed.RxBytesPerSec)
+	}
+	if speed.TxBytesPerSec != 5000 {
+		t.Errorf("Expected tx rate 5000 B/s, got %v", speed.TxBytesPerSec)
+	}
+
+	if _, err := GetNetworkSpeed("missing0", time.Millisecond); err == nil {
+		t.Error("Expected error for unknown interface")
+	}
+}
+
+func TestCalculateSpeed(t *testing.T) {
+	// Successive counter samples one second apart, including a wrap and a reset
+	samples := []struct {
+		rx, tx     uint64
+		expectedRx float64
+		expectedTx float64
+	}{
+		{rx: 1000, tx: 500},
+		{rx: 3000, tx: 1500, expectedRx: 2000, expectedTx: 1000},
+		{rx: math.MaxUint64 - 100, tx: 1600, expectedRx: float64(uint64(math.MaxUint64 - 100 - 3000)), expectedTx: 100},
+		{rx: 50, tx: 1700, expectedRx: 0, expectedTx: 100}, // rx wrapped
+		{rx: 10, tx: 0, expectedRx: 0, expectedTx: 0},      // interface reset
+		{rx: 110, tx: 20, expectedRx: 100, expectedTx: 20},
+	}
+
+	for i := 1; i < len(samples); i++ {
+		prev, curr := samples[i-1], samples[i]
+		speed := CalculateSpeed(prev.rx, prev.tx, curr.rx, curr.tx, time.Second)
+		if speed.RxBytesPerSec != curr.expectedRx || speed.TxBytesPerSec != curr.expectedTx {
+			t.Errorf("Sample %d: expected %v/%v, got %v/%v", i, curr.expectedRx, curr.expectedTx, speed.RxBytesPerSec, speed.TxBytesPerSec)
+		}
+		if speed.RxBytesPerSec < 0 || speed.TxBytesPerSec < 0 {
+			t.Errorf("Sample %d: negative rate %+v", i, speed)
+		}
+	}
+
+	if speed := CalculateSpeed(0, 0, 100, 100, 0); speed.RxBytesPerSec != 0 || speed.TxBytesPerSec != 0 {
+		t.Errorf("Expected zero rate for zero duration, got %+v", speed)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+)
+
+// recentLogLines is the number of log lines kept by RecentLogs
+const recentLogLines = 1000
+
+// RecentLogs keeps the latest client log lines once the log output is routed through it
+var RecentLogs = NewLogBuffer(recentLogLines)
+
+// LogBuffer is an io.Writer that keeps the most recent complete lines written to it
+type LogBuffer struct {
+	mu       sync.Mutex
+	maxLines int
+	lines    []string
+	partial  []byte // Text after the last newline, completed by the next write
+}
+
+// NewLogBuffer creates a buffer keeping at most maxLines lines
+func NewLogBuffer(maxLines int) *LogBuffer {
+	return &LogBuffer{maxLines: maxLines}
+}
+
+// Write records the complete lines in p
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines = append(b.lines, string(data[:i]))
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+
+	if excess := len(b.lines) - b.maxLines; excess > 0 {
+		b.lines = append([]string(nil), b.lines[excess:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns a copy of the buffered lines, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
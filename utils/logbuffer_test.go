@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"testing"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buffer := NewLogBuffer(3)
+
+	buffer.Write([]byte("one\ntw"))
+	buffer.Write([]byte("o\n"))
+	if lines := buffer.Lines(); !slices.Equal(lines, []string{"one", "two"}) {
+		t.Errorf("Expected lines joined across writes, got %q", lines)
+	}
+
+	logger := log.New(buffer, "", 0)
+	for i := 3; i <= 5; i++ {
+		logger.Print(fmt.Sprint("line ", i))
+	}
+	if lines := buffer.Lines(); !slices.Equal(lines, []string{"line 3", "line 4", "line 5"}) {
+		t.Errorf("Expected the 3 most recent lines, got %q", lines)
+	}
+}
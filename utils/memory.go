@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procMeminfoPath is a variable so tests can point it at a fixture
+var procMeminfoPath = "/proc/meminfo"
+
+// MemoryUsage describes system memory in bytes
+type MemoryUsage struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+}
+
+// GetMemoryUsage returns total and available system memory.
+// Returns ErrNotAvailable on systems without /proc/meminfo.
+func GetMemoryUsage() (MemoryUsage, error) {
+	data, err := os.ReadFile(procMeminfoPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return MemoryUsage{}, ErrNotAvailable
+		}
+		return MemoryUsage{}, err
+	}
+	return parseMeminfo(data)
+}
+
+// parseMeminfo extracts MemTotal and MemAvailable from /proc/meminfo content
+func parseMeminfo(data []byte) (MemoryUsage, error) {
+	var usage MemoryUsage
+	var haveTotal, haveAvailable bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Values are reported in kB
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			usage.TotalBytes = value
+			haveTotal = true
+		case "MemAvailable:":
+			usage.AvailableBytes = value
+			haveAvailable = true
+		}
+	}
+
+	if !haveTotal || !haveAvailable {
+		return MemoryUsage{}, fmt.Errorf("meminfo missing MemTotal or MemAvailable")
+	}
+	return usage, nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMeminfo(t *testing.T) {
+	data := []byte(`MemTotal:        8048576 kB
+MemFree:          512000 kB
+MemAvailable:    2048000 kB
+Buffers:          102400 kB
+`)
+
+	usage, err := parseMeminfo(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.TotalBytes != 8048576*1024 {
+		t.Errorf("Expected total %d, got %d", 8048576*1024, usage.TotalBytes)
+	}
+	if usage.AvailableBytes != 2048000*1024 {
+		t.Errorf("Expected available %d, got %d", 2048000*1024, usage.AvailableBytes)
+	}
+
+	if _, err := parseMeminfo([]byte("MemTotal: 1024 kB\n")); err == nil {
+		t.Error("Expected error when MemAvailable is missing")
+	}
+}
+
+func TestGetMemoryUsage(t *testing.T) {
+	original := procMeminfoPath
+	defer func() { procMeminfoPath = original }()
+
+	path := filepath.Join(t.TempDir(), "meminfo")
+	if err := os.WriteFile(path, []byte("MemTotal: 2048 kB\nMemAvailable: 1024 kB\n"), 0644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	procMeminfoPath = path
+
+	usage, err := GetMemoryUsage()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.TotalBytes != 2048*1024 || usage.AvailableBytes != 1024*1024 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+
+	procMeminfoPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := GetMemoryUsage(); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("Expected ErrNotAvailable, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -11,15 +12,95 @@ import (
 	"io"
 )
 
+// ErrInvalidPadding is returned for any malformed PKCS7 padding. The same value is
+// used for every failure so callers cannot distinguish where validation failed.
+var ErrInvalidPadding = errors.New("invalid padding")
+
+// ErrDecryptionFailed is returned by DecryptMessage when the plaintext is invalid.
+// Padding and JSON errors are collapsed into this single error to avoid a padding oracle.
+var ErrDecryptionFailed = errors.New("failed to decrypt message")
+
+// Envelope format versions
+const (
+	// EnvelopeVersion1 is AES-256-CBC with PKCS7 padding over the JSON message
+	EnvelopeVersion1 = 1
+
+	// MaxEnvelopeVersion is the highest envelope version this client understands
+	MaxEnvelopeVersion = EnvelopeVersion1
+)
+
+// Encryption algorithms for the envelope payload
+const (
+	AlgAESCBC           = "aes-cbc"
+	AlgAESGCM           = "aes-gcm"
+	AlgChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// SupportedAlgorithms lists the encryption algorithms this client can use
+var SupportedAlgorithms = []string{AlgAESCBC, AlgAESGCM, AlgChaCha20Poly1305}
+
+// ErrUnsupportedAlgorithm is returned for an unknown envelope encryption algorithm
+var ErrUnsupportedAlgorithm = errors.New("unsupported encryption algorithm")
+
+// ErrAlgorithmMismatch is returned when an envelope uses a different algorithm
+// than the one agreed during pairing, e.g. a downgrade to aes-cbc
+var ErrAlgorithmMismatch = errors.New("envelope algorithm does not match the negotiated algorithm")
+
+// IsSupportedAlgorithm reports whether alg is a known encryption algorithm
+func IsSupportedAlgorithm(alg string) bool {
+	for _, supported := range SupportedAlgorithms {
+		if alg == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateAlgorithm picks the algorithm to use with a server. The preferred
+// algorithm is used if the server supports it, otherwise the first mutually
+// supported algorithm. Servers that send no list only support AES-CBC.
+func NegotiateAlgorithm(preferred string, serverSupported []string) string {
+	if len(serverSupported) == 0 {
+		return AlgAESCBC
+	}
+	for _, alg := range serverSupported {
+		if alg == preferred && IsSupportedAlgorithm(alg) {
+			return alg
+		}
+	}
+	for _, alg := range serverSupported {
+		if IsSupportedAlgorithm(alg) {
+			return alg
+		}
+	}
+	return AlgAESCBC
+}
+
+// SupportedEnvelopeVersions lists the envelope versions this client can decrypt
+var SupportedEnvelopeVersions = []int{EnvelopeVersion1}
+
+// UnsupportedEnvelopeVersionError is returned when an envelope uses a version
+// newer than MaxEnvelopeVersion
+type UnsupportedEnvelopeVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedEnvelopeVersionError) Error() string {
+	return fmt.Sprintf("unsupported envelope version %d (max supported: %d)", e.Version, MaxEnvelopeVersion)
+}
+
 // MessageCrypto handles encryption/decryption of WebSocket messages
 type MessageCrypto struct{}
 
 // EncryptedEnvelope represents an encrypted message envelope
 type EncryptedEnvelope struct {
-	Type      string      `json:"type"`
-	Encrypted bool        `json:"encrypted"`
-	Payload   string      `json:"payload"`
-	Timestamp interface{} `json:"timestamp,omitempty"`
+	Type       string      `json:"type"`
+	Version    int         `json:"v,omitempty"`   // Envelope format version (missing means v1)
+	Algorithm  string      `json:"alg,omitempty"` // Payload encryption algorithm (missing means aes-cbc)
+	Encrypted  bool        `json:"encrypted"`
+	Payload    string      `json:"payload"`
+	Compressed bool        `json:"compressed,omitempty"` // Payload was gzip-compressed before encryption
+	Timestamp  interface{} `json:"timestamp,omitempty"`
 }
 
 // NewMessageCrypto creates a new MessageCrypto instance
@@ -35,12 +116,26 @@ func (mc *MessageCrypto) EncryptMessage(message map[string]interface{}, sessionK
 		return "", fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Decode session key
+	sessionKey, err := decodeSessionKey(sessionKeyB64)
+	if err != nil {
+		return "", err
+	}
+	defer Zeroize(sessionKey)
+
+	return mc.encryptBytes(messageJSON, sessionKey)
+}
+
+// decodeSessionKey decodes a base64 session key; the caller zeroes the result
+func decodeSessionKey(sessionKeyB64 string) ([]byte, error) {
 	sessionKey, err := base64.StdEncoding.DecodeString(sessionKeyB64)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode session key: %w", err)
+		return nil, fmt.Errorf("failed to decode session key: %w", err)
 	}
+	return sessionKey, nil
+}
 
+// encryptBytes encrypts plaintext with AES-CBC and returns the base64-encoded IV and ciphertext
+func (mc *MessageCrypto) encryptBytes(plaintext []byte, sessionKey []byte) (string, error) {
 	// Create AES cipher
 	block, err := aes.NewCipher(sessionKey)
 	if err != nil {
@@ -48,7 +143,7 @@ func (mc *MessageCrypto) EncryptMessage(message map[string]interface{}, sessionK
 	}
 
 	// Apply PKCS7 padding
-	paddedMessage := mc.applyPKCS7Padding(messageJSON, aes.BlockSize)
+	paddedMessage := mc.applyPKCS7Padding(plaintext, aes.BlockSize)
 
 	// Generate random IV
 	iv := make([]byte, aes.BlockSize)
@@ -70,21 +165,34 @@ func (mc *MessageCrypto) EncryptMessage(message map[string]interface{}, sessionK
 
 // DecryptMessage decrypts a WebSocket message using the session key
 func (mc *MessageCrypto) DecryptMessage(encryptedMessageB64, sessionKeyB64 string) (map[string]interface{}, error) {
-	// Decode encrypted message
-	encryptedMessage, err := base64.StdEncoding.DecodeString(encryptedMessageB64)
+	sessionKey, err := decodeSessionKey(sessionKeyB64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode encrypted message: %w", err)
+		return nil, err
 	}
+	defer Zeroize(sessionKey)
 
-	// Decode session key
-	sessionKey, err := base64.StdEncoding.DecodeString(sessionKeyB64)
+	messageJSON, err := mc.decryptBytes(encryptedMessageB64, sessionKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode session key: %w", err)
+		return nil, err
+	}
+
+	return unmarshalDecrypted(messageJSON)
+}
+
+// decryptBytes decrypts a base64-encoded IV and AES-CBC ciphertext and returns the plaintext
+func (mc *MessageCrypto) decryptBytes(encryptedMessageB64 string, sessionKey []byte) ([]byte, error) {
+	// Decode encrypted message
+	encryptedMessage, err := base64.StdEncoding.DecodeString(encryptedMessageB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted message: %w", err)
 	}
 
 	if len(encryptedMessage) < aes.BlockSize {
 		return nil, errors.New("encrypted message too short")
 	}
+	if len(encryptedMessage)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted message is not a multiple of the block size")
+	}
 
 	// Extract IV and encrypted data
 	iv := encryptedMessage[:aes.BlockSize]
@@ -102,32 +210,114 @@ func (mc *MessageCrypto) DecryptMessage(encryptedMessageB64, sessionKeyB64 strin
 	mode.CryptBlocks(paddedMessage, encryptedData)
 
 	// Remove PKCS7 padding
-	messageJSON, err := mc.removePKCS7Padding(paddedMessage)
+	plaintext, err := mc.removePKCS7Padding(paddedMessage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to remove padding: %w", err)
+		return nil, ErrDecryptionFailed
 	}
 
-	// Parse JSON
+	return plaintext, nil
+}
+
+// unmarshalDecrypted parses decrypted JSON, hiding the parse error from the caller
+func unmarshalDecrypted(messageJSON []byte) (map[string]interface{}, error) {
 	var message map[string]interface{}
 	if err := json.Unmarshal(messageJSON, &message); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		return nil, ErrDecryptionFailed
 	}
-
 	return message, nil
 }
 
-// CreateEncryptedEnvelope creates an encrypted envelope for a message
+// encryptWithAlgorithm encrypts plaintext with the given algorithm and returns it base64-encoded
+func (mc *MessageCrypto) encryptWithAlgorithm(alg string, plaintext []byte, sessionKey []byte) (string, error) {
+	if alg == AlgAESCBC {
+		return mc.encryptBytes(plaintext, sessionKey)
+	}
+
+	var encrypted []byte
+	var err error
+	switch alg {
+	case AlgAESGCM:
+		encrypted, err = encryptAESGCM(plaintext, sessionKey)
+	case AlgChaCha20Poly1305:
+		encrypted, err = encryptChaCha20(plaintext, sessionKey)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// decryptWithAlgorithm decrypts a base64-encoded payload with the given algorithm
+func (mc *MessageCrypto) decryptWithAlgorithm(alg string, payloadB64 string, sessionKey []byte) ([]byte, error) {
+	if alg == AlgAESCBC {
+		return mc.decryptBytes(payloadB64, sessionKey)
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(payloadB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted message: %w", err)
+	}
+
+	switch alg {
+	case AlgAESGCM:
+		return decryptAESGCM(encrypted, sessionKey)
+	case AlgChaCha20Poly1305:
+		return decryptChaCha20(encrypted, sessionKey)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// CreateEncryptedEnvelope creates an AES-CBC encrypted envelope for a message
 func (mc *MessageCrypto) CreateEncryptedEnvelope(message map[string]interface{}, sessionKeyB64 string) (map[string]interface{}, error) {
-	encryptedPayload, err := mc.EncryptMessage(message, sessionKeyB64)
+	return mc.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, AlgAESCBC)
+}
+
+// CreateEncryptedEnvelopeWithAlgorithm creates an encrypted envelope using the given algorithm.
+// Messages larger than the compression threshold are gzip-compressed before encryption.
+func (mc *MessageCrypto) CreateEncryptedEnvelopeWithAlgorithm(message map[string]interface{}, sessionKeyB64 string, alg string) (map[string]interface{}, error) {
+	sessionKey, err := decodeSessionKey(sessionKeyB64)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(sessionKey)
+
+	return mc.createEnvelope(message, sessionKey, alg)
+}
+
+// createEnvelope is CreateEncryptedEnvelopeWithAlgorithm with a decoded session key
+func (mc *MessageCrypto) createEnvelope(message map[string]interface{}, sessionKey []byte, alg string) (map[string]interface{}, error) {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	compressed := false
+	if threshold := GetCompressionThreshold(); threshold > 0 && len(messageJSON) > threshold {
+		messageJSON, err = compressPayload(messageJSON)
+		if err != nil {
+			return nil, err
+		}
+		compressed = true
+	}
+
+	encryptedPayload, err := mc.encryptWithAlgorithm(alg, messageJSON, sessionKey)
 	if err != nil {
 		return nil, err
 	}
 
 	envelope := map[string]interface{}{
 		"type":      "encrypted",
+		"v":         EnvelopeVersion1,
+		"alg":       alg,
 		"encrypted": true,
 		"payload":   encryptedPayload,
 	}
+	if compressed {
+		envelope["compressed"] = true
+	}
 
 	// Keep timestamp unencrypted for validation
 	if timestamp, exists := message["timestamp"]; exists {
@@ -138,7 +328,26 @@ func (mc *MessageCrypto) CreateEncryptedEnvelope(message map[string]interface{},
 }
 
 // ExtractFromEncryptedEnvelope extracts and decrypts a message from an envelope
+// using whichever algorithm the envelope declares
 func (mc *MessageCrypto) ExtractFromEncryptedEnvelope(envelope map[string]interface{}, sessionKeyB64 string) (map[string]interface{}, error) {
+	return mc.ExtractFromEncryptedEnvelopeWithAlgorithm(envelope, sessionKeyB64, "")
+}
+
+// ExtractFromEncryptedEnvelopeWithAlgorithm extracts and decrypts a message from
+// an envelope that must use expectedAlg. An envelope without "alg" counts as
+// aes-cbc. An empty expectedAlg accepts any supported algorithm.
+func (mc *MessageCrypto) ExtractFromEncryptedEnvelopeWithAlgorithm(envelope map[string]interface{}, sessionKeyB64 string, expectedAlg string) (map[string]interface{}, error) {
+	sessionKey, err := decodeSessionKey(sessionKeyB64)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(sessionKey)
+
+	return mc.extractEnvelope(envelope, sessionKey, expectedAlg)
+}
+
+// extractEnvelope is ExtractFromEncryptedEnvelopeWithAlgorithm with a decoded session key
+func (mc *MessageCrypto) extractEnvelope(envelope map[string]interface{}, sessionKey []byte, expectedAlg string) (map[string]interface{}, error) {
 	encrypted, ok := envelope["encrypted"].(bool)
 	if !ok || !encrypted {
 		return nil, errors.New("message is not encrypted")
@@ -149,10 +358,69 @@ func (mc *MessageCrypto) ExtractFromEncryptedEnvelope(envelope map[string]interf
 		return nil, errors.New("no encrypted payload found")
 	}
 
-	return mc.DecryptMessage(payload, sessionKeyB64)
+	version, err := envelopeVersion(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := AlgAESCBC
+	if envelopeAlg, ok := envelope["alg"].(string); ok && envelopeAlg != "" {
+		alg = envelopeAlg
+	}
+	if expectedAlg != "" && alg != expectedAlg {
+		return nil, fmt.Errorf("%w: got %s, expected %s", ErrAlgorithmMismatch, alg, expectedAlg)
+	}
+
+	var plaintext []byte
+	switch version {
+	case EnvelopeVersion1:
+		plaintext, err = mc.decryptWithAlgorithm(alg, payload, sessionKey)
+	default:
+		return nil, &UnsupportedEnvelopeVersionError{Version: version}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if compressed, _ := envelope["compressed"].(bool); compressed {
+		plaintext, err = decompressPayload(plaintext, maxDecompressedPayloadSize)
+		if err != nil {
+			// Decompression failures are reported like any other decryption failure
+			return nil, ErrDecryptionFailed
+		}
+	}
+
+	return unmarshalDecrypted(plaintext)
+}
+
+// envelopeVersion returns the envelope's "v" field, defaulting to v1 when absent
+func envelopeVersion(envelope map[string]interface{}) (int, error) {
+	raw, exists := envelope["v"]
+	if !exists || raw == nil {
+		return EnvelopeVersion1, nil
+	}
+
+	var version int
+	switch v := raw.(type) {
+	case float64: // JSON numbers
+		version = int(v)
+		if float64(version) != v {
+			return 0, fmt.Errorf("invalid envelope version: %v", v)
+		}
+	case int:
+		version = v
+	default:
+		return 0, fmt.Errorf("invalid envelope version: %v", raw)
+	}
+
+	if version < EnvelopeVersion1 {
+		return 0, fmt.Errorf("invalid envelope version: %d", version)
+	}
+	return version, nil
 }
 
-// IsEncryptedMessage checks if a message is encrypted
+// IsEncryptedMessage checks if a message is encrypted.
+// The envelope version is not checked here; see ExtractFromEncryptedEnvelope.
 func (mc *MessageCrypto) IsEncryptedMessage(message map[string]interface{}) bool {
 	msgType, typeOk := message["type"].(string)
 	encrypted, encOk := message["encrypted"].(bool)
@@ -169,26 +437,38 @@ func (mc *MessageCrypto) applyPKCS7Padding(data []byte, blockSize int) []byte {
 	return append(data, padtext...)
 }
 
-// removePKCS7Padding removes PKCS7 padding from data
+// removePKCS7Padding removes PKCS7 padding from data.
+// The final block is always checked in full using constant-time operations so
+// the time taken does not depend on where the padding is invalid.
 func (mc *MessageCrypto) removePKCS7Padding(data []byte) ([]byte, error) {
 	length := len(data)
 	if length == 0 {
-		return nil, errors.New("data is empty")
+		return nil, ErrInvalidPadding
 	}
 
-	padding := int(data[length-1])
-	if padding > length || padding == 0 {
-		return nil, errors.New("invalid padding")
+	checkLen := aes.BlockSize
+	if length < checkLen {
+		checkLen = length
 	}
 
-	// Verify padding
-	for i := length - padding; i < length; i++ {
-		if data[i] != byte(padding) {
-			return nil, errors.New("invalid padding")
-		}
+	padding := data[length-1]
+	paddingLen := int(padding)
+
+	// Padding must be between 1 and the checked length
+	good := subtle.ConstantTimeLessOrEq(1, paddingLen) & subtle.ConstantTimeLessOrEq(paddingLen, checkLen)
+
+	// Every byte within the padding must equal the padding value
+	for i := 0; i < checkLen; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i+1, paddingLen)
+		matches := subtle.ConstantTimeByteEq(data[length-1-i], padding)
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
+	}
+
+	if good != 1 {
+		return nil, ErrInvalidPadding
 	}
 
-	return data[:length-padding], nil
+	return data[:length-paddingLen], nil
 }
 
 // Global instance
@@ -199,11 +479,33 @@ func EncryptWebSocketMessage(message map[string]interface{}, sessionKeyB64 strin
 	return messageCrypto.CreateEncryptedEnvelope(message, sessionKeyB64)
 }
 
+// EncryptWebSocketMessageWithAlgorithm convenience function to encrypt a WebSocket message with a specific algorithm
+func EncryptWebSocketMessageWithAlgorithm(message map[string]interface{}, sessionKeyB64 string, alg string) (map[string]interface{}, error) {
+	return messageCrypto.CreateEncryptedEnvelopeWithAlgorithm(message, sessionKeyB64, alg)
+}
+
+// EncryptWebSocketMessageWithKey is EncryptWebSocketMessageWithAlgorithm with a
+// decoded session key, for callers that keep the key as bytes
+func EncryptWebSocketMessageWithKey(message map[string]interface{}, sessionKey []byte, alg string) (map[string]interface{}, error) {
+	return messageCrypto.createEnvelope(message, sessionKey, alg)
+}
+
 // DecryptWebSocketMessage convenience function to decrypt a WebSocket message
 func DecryptWebSocketMessage(envelope map[string]interface{}, sessionKeyB64 string) (map[string]interface{}, error) {
 	return messageCrypto.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64)
 }
 
+// DecryptWebSocketMessageWithAlgorithm convenience function to decrypt a WebSocket message that must use alg
+func DecryptWebSocketMessageWithAlgorithm(envelope map[string]interface{}, sessionKeyB64 string, alg string) (map[string]interface{}, error) {
+	return messageCrypto.ExtractFromEncryptedEnvelopeWithAlgorithm(envelope, sessionKeyB64, alg)
+}
+
+// DecryptWebSocketMessageWithKey is DecryptWebSocketMessageWithAlgorithm with a
+// decoded session key, for callers that keep the key as bytes
+func DecryptWebSocketMessageWithKey(envelope map[string]interface{}, sessionKey []byte, alg string) (map[string]interface{}, error) {
+	return messageCrypto.extractEnvelope(envelope, sessionKey, alg)
+}
+
 // IsEncryptedWebSocketMessage convenience function to check if a message is encrypted
 func IsEncryptedWebSocketMessage(message map[string]interface{}) bool {
 	return messageCrypto.IsEncryptedMessage(message)
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -113,11 +116,8 @@ func TestRemovePKCS7Padding(t *testing.T) {
 
 	t.Run("Empty data", func(t *testing.T) {
 		_, err := mc.removePKCS7Padding([]byte{})
-		if err == nil {
-			t.Error("Expected error for empty data")
-		}
-		if !strings.Contains(err.Error(), "empty") {
-			t.Errorf("Expected 'empty' error, got: %v", err)
+		if err != ErrInvalidPadding {
+			t.Errorf("Expected ErrInvalidPadding for empty data, got %v", err)
 		}
 	})
 
@@ -156,6 +156,59 @@ func TestRemovePKCS7Padding(t *testing.T) {
 	})
 }
 
+func TestRemovePKCS7PaddingUniformError(t *testing.T) {
+	mc := NewMessageCrypto()
+
+	invalidInputs := map[string][]byte{
+		"Zero padding":           append(make([]byte, 15), 0),
+		"Padding too long":       append(make([]byte, 15), 17),
+		"Mismatch first byte":    append(append(make([]byte, 12), 9, 4, 4), 4),
+		"Mismatch middle byte":   append(append(make([]byte, 12), 4, 4, 3), 4),
+		"Padding exceeds length": {5, 5, 5, 5},
+	}
+
+	for name, data := range invalidInputs {
+		t.Run(name, func(t *testing.T) {
+			_, err := mc.removePKCS7Padding(data)
+			if err != ErrInvalidPadding {
+				t.Errorf("Expected ErrInvalidPadding, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptMessageUniformError(t *testing.T) {
+	mc := NewMessageCrypto()
+	sessionKey := make([]byte, 32)
+	sessionKeyB64 := base64.StdEncoding.EncodeToString(sessionKey)
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	// encrypt builds a ciphertext for an arbitrary (already padded) plaintext
+	encrypt := func(plaintext []byte) string {
+		iv := make([]byte, aes.BlockSize)
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+		return base64.StdEncoding.EncodeToString(append(iv, ciphertext...))
+	}
+
+	badPadding := encrypt(append(make([]byte, 15), 0))
+	badJSON := encrypt(mc.applyPKCS7Padding([]byte("not json"), aes.BlockSize))
+
+	_, paddingErr := mc.DecryptMessage(badPadding, sessionKeyB64)
+	_, jsonErr := mc.DecryptMessage(badJSON, sessionKeyB64)
+
+	if paddingErr != ErrDecryptionFailed {
+		t.Errorf("Expected ErrDecryptionFailed for bad padding, got %v", paddingErr)
+	}
+	if jsonErr != ErrDecryptionFailed {
+		t.Errorf("Expected ErrDecryptionFailed for bad JSON, got %v", jsonErr)
+	}
+}
+
 func TestEncryptMessage(t *testing.T) {
 	mc := NewMessageCrypto()
 
@@ -454,6 +507,72 @@ func TestExtractFromEncryptedEnvelope(t *testing.T) {
 	})
 }
 
+func TestEnvelopeVersion(t *testing.T) {
+	mc := NewMessageCrypto()
+	sessionKeyB64 := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	message := map[string]interface{}{"type": "test", "content": "hello"}
+
+	t.Run("Round trip includes version", func(t *testing.T) {
+		envelope, err := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		if err != nil {
+			t.Fatalf("CreateEncryptedEnvelope failed: %v", err)
+		}
+		if envelope["v"] != EnvelopeVersion1 {
+			t.Errorf("Expected envelope version %d, got %v", EnvelopeVersion1, envelope["v"])
+		}
+
+		// Simulate a JSON round trip where numbers become float64
+		data, _ := json.Marshal(envelope)
+		var received map[string]interface{}
+		if err := json.Unmarshal(data, &received); err != nil {
+			t.Fatalf("Failed to unmarshal envelope: %v", err)
+		}
+		if !mc.IsEncryptedMessage(received) {
+			t.Error("Versioned envelope should be recognised as encrypted")
+		}
+
+		decrypted, err := mc.ExtractFromEncryptedEnvelope(received, sessionKeyB64)
+		if err != nil {
+			t.Fatalf("ExtractFromEncryptedEnvelope failed: %v", err)
+		}
+		if !reflect.DeepEqual(decrypted, message) {
+			t.Errorf("Expected %v, got %v", message, decrypted)
+		}
+	})
+
+	t.Run("Missing version treated as v1", func(t *testing.T) {
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		delete(envelope, "v")
+
+		if _, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64); err != nil {
+			t.Errorf("Expected unversioned envelope to decrypt, got: %v", err)
+		}
+	})
+
+	t.Run("Unknown higher version", func(t *testing.T) {
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		envelope["v"] = float64(MaxEnvelopeVersion + 1)
+
+		_, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64)
+		var versionErr *UnsupportedEnvelopeVersionError
+		if !errors.As(err, &versionErr) {
+			t.Fatalf("Expected UnsupportedEnvelopeVersionError, got %v", err)
+		}
+		if versionErr.Version != MaxEnvelopeVersion+1 {
+			t.Errorf("Expected version %d in error, got %d", MaxEnvelopeVersion+1, versionErr.Version)
+		}
+	})
+
+	t.Run("Invalid version", func(t *testing.T) {
+		envelope, _ := mc.CreateEncryptedEnvelope(message, sessionKeyB64)
+		envelope["v"] = "one"
+
+		if _, err := mc.ExtractFromEncryptedEnvelope(envelope, sessionKeyB64); err == nil {
+			t.Error("Expected error for invalid version")
+		}
+	})
+}
+
 func TestIsEncryptedMessage(t *testing.T) {
 	mc := NewMessageCrypto()
 
@@ -735,6 +854,33 @@ func BenchmarkPKCS7Padding(b *testing.B) {
 	}
 }
 
+// BenchmarkRemovePKCS7PaddingInvalid compares failures at the start and end of the
+// padding check; timings should match since there is no early exit.
+func BenchmarkRemovePKCS7PaddingInvalid(b *testing.B) {
+	mc := NewMessageCrypto()
+
+	lastByteBad := make([]byte, 64)
+	lastByteBad[63] = 0
+
+	firstByteBad := make([]byte, 64)
+	for i := 48; i < 64; i++ {
+		firstByteBad[i] = 16
+	}
+	firstByteBad[48] = 15
+
+	b.Run("FailLastByte", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mc.removePKCS7Padding(lastByteBad)
+		}
+	})
+
+	b.Run("FailFirstByte", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mc.removePKCS7Padding(firstByteBad)
+		}
+	})
+}
+
 func BenchmarkCreateEncryptedEnvelope(b *testing.B) {
 	mc := NewMessageCrypto()
 	sessionKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WritePrivateFile writes data to path readable by the owner only. A missing
+// directory is created for the owner only. The data goes to a 0600 temp file
+// that then replaces path, so it is never readable under looser permissions
+// of an existing file and readers never see a partial write.
+func WritePrivateFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	// CreateTemp creates the file with mode 0600
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// CheckPrivatePath returns an error describing the problem when others can
+// read the file at path or replace the files in its directory. A missing file
+// is not a problem.
+func CheckPrivatePath(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s has mode %04o, accessible to other users", path, perm)
+	}
+
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if perm := dirInfo.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("directory %s has mode %04o, writable by other users", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePrivateFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "private")
+	path := filepath.Join(dir, "secret")
+
+	if err := WritePrivateFile(path, []byte("one")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for p, want := range map[string]os.FileMode{path: 0600, dir: 0700} {
+		if info, err := os.Stat(p); err != nil || info.Mode().Perm() != want {
+			t.Errorf("Expected %s with mode %04o, got %v (%v)", p, want, info.Mode().Perm(), err)
+		}
+	}
+	if err := CheckPrivatePath(path); err != nil {
+		t.Errorf("Expected a private file to pass the check, got %v", err)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPrivatePath(path); err == nil {
+		t.Error("Expected a world-readable file to fail the check")
+	}
+	// A link to the loose file shows whether the new content was ever written to it
+	looseLink := filepath.Join(t.TempDir(), "loose")
+	if err := os.Link(path, looseLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePrivateFile(path, []byte("two")); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	if data, _ := os.ReadFile(looseLink); string(data) != "one" {
+		t.Errorf("Expected the new content never to be in the world-readable file, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected no temp files left behind, got %d entries", len(entries))
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the rewrite to tighten the mode to 0600, got %04o", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "two" {
+		t.Errorf("Expected the new content, got %q", data)
+	}
+
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPrivatePath(path); err == nil {
+		t.Error("Expected a world-writable directory to fail the check")
+	}
+	if err := CheckPrivatePath(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Expected a missing file to pass the check, got %v", err)
+	}
+}
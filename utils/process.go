@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procPath is a variable so tests can point it at a fixture
+var procPath = "/proc"
+
+// processCPUSampleInterval is the time between the two readings used to compute CPU usage
+var processCPUSampleInterval = 100 * time.Millisecond
+
+// clockTicksPerSecond is USER_HZ, the unit of the CPU times in /proc/<pid>/stat
+const clockTicksPerSecond = 100
+
+// TopProcessCount is the number of processes returned by GetProcessCPU when no PID is given
+const TopProcessCount = 10
+
+// ErrProcessNotFound is returned when the requested process does not exist
+var ErrProcessNotFound = errors.New("process not found")
+
+// ProcessInfo describes a running process
+type ProcessInfo struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	State      string  `json:"state"`                 // Single-letter state from /proc, e.g. R, S, Z
+	CPUPercent float64 `json:"cpu_percent,omitempty"` // Share of one CPU over the sample interval
+}
+
+// StatFields holds the fields of /proc/<pid>/stat used for process reporting
+type StatFields struct {
+	PID   int
+	Comm  string
+	State string
+	UTime uint64 // User mode CPU time in clock ticks
+	STime uint64 // Kernel mode CPU time in clock ticks
+}
+
+// parseStatFile parses the content of /proc/<pid>/stat. The command name is
+// enclosed in parentheses and may itself contain spaces and parentheses, so
+// it extends to the last closing parenthesis.
+func parseStatFile(content string) (StatFields, error) {
+	open := strings.IndexByte(content, '(')
+	end := strings.LastIndexByte(content, ')')
+	if open < 0 || end < open {
+		return StatFields{}, fmt.Errorf("malformed stat: missing command name")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(content[:open]))
+	if err != nil {
+		return StatFields{}, fmt.Errorf("malformed stat: invalid pid: %w", err)
+	}
+
+	// Fields after the command name start at field 3 (state); utime and stime are fields 14 and 15
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 13 {
+		return StatFields{}, fmt.Errorf("malformed stat: expected at least 15 fields, got %d", len(fields)+2)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return StatFields{}, fmt.Errorf("malformed stat: invalid utime: %w", err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return StatFields{}, fmt.Errorf("malformed stat: invalid stime: %w", err)
+	}
+
+	return StatFields{
+		PID:   pid,
+		Comm:  content[open+1 : end],
+		State: fields[0],
+		UTime: utime,
+		STime: stime,
+	}, nil
+}
+
+// readProcessStat reads and parses /proc/<pid>/stat
+func readProcessStat(pid int) (StatFields, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return StatFields{}, ErrProcessNotFound
+		}
+		return StatFields{}, err
+	}
+	return parseStatFile(string(data))
+}
+
+// readAllProcessStats reads the stat of every process, skipping processes that
+// exit while being read. Returns ErrNotAvailable on systems without /proc.
+func readAllProcessStats() (map[int]StatFields, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotAvailable
+		}
+		return nil, err
+	}
+
+	stats := make(map[int]StatFields)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		if stat, err := readProcessStat(pid); err == nil {
+			stats[pid] = stat
+		}
+	}
+	return stats, nil
+}
+
+// GetRunningProcesses returns the running processes ordered by PID, without CPU usage.
+// Returns ErrNotAvailable on systems without /proc.
+func GetRunningProcesses() ([]ProcessInfo, error) {
+	stats, err := readAllProcessStats()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]ProcessInfo, 0, len(stats))
+	for _, stat := range stats {
+		processes = append(processes, ProcessInfo{PID: stat.PID, Name: stat.Comm, State: stat.State})
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes, nil
+}
+
+// GetProcessCPU samples CPU times twice, processCPUSampleInterval apart, and returns
+// the CPU usage of process pid, or of the TopProcessCount busiest processes when pid is 0
+func GetProcessCPU(pid int) ([]ProcessInfo, error) {
+	readStats := readAllProcessStats
+	if pid != 0 {
+		readStats = func() (map[int]StatFields, error) {
+			stat, err := readProcessStat(pid)
+			if err != nil {
+				return nil, err
+			}
+			return map[int]StatFields{pid: stat}, nil
+		}
+	}
+
+	before, err := readStats()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	time.Sleep(processCPUSampleInterval)
+	after, err := readStats()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start).Seconds()
+
+	var processes []ProcessInfo
+	for id, stat := range after {
+		previous, ok := before[id]
+		if !ok || stat.UTime+stat.STime < previous.UTime+previous.STime {
+			continue // Started during the sample, or the PID was reused
+		}
+		ticks := (stat.UTime + stat.STime) - (previous.UTime + previous.STime)
+		processes = append(processes, ProcessInfo{
+			PID:        id,
+			Name:       stat.Comm,
+			State:      stat.State,
+			CPUPercent: float64(ticks) / clockTicksPerSecond / elapsed * 100,
+		})
+	}
+
+	sort.Slice(processes, func(i, j int) bool {
+		if processes[i].CPUPercent != processes[j].CPUPercent {
+			return processes[i].CPUPercent > processes[j].CPUPercent
+		}
+		return processes[i].PID < processes[j].PID
+	})
+	if len(processes) > TopProcessCount {
+		processes = processes[:TopProcessCount]
+	}
+	return processes, nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStatFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected StatFields
+	}{
+		{
+			name:     "Real format",
+			content:  "1234 (msm-client) S 1 1234 1234 0 -1 4194560 2210 0 0 0 152 37 0 0 20 0 9 0 4321 1265324032 3012 18446744073709551615 1 1 0 0 0 0 0 0 2143420159 0 0 0 17 2 0 0 0 0 0\n",
+			expected: StatFields{PID: 1234, Comm: "msm-client", State: "S", UTime: 152, STime: 37},
+		},
+		{
+			name:     "Name with spaces and parentheses",
+			content:  "42 (Web Content (1)) R 1 42 42 0 -1 4194304 100 0 0 0 7000 250 0 0 20 0 1 0 99 1000 50 0",
+			expected: StatFields{PID: 42, Comm: "Web Content (1)", State: "R", UTime: 7000, STime: 250},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stat, err := parseStatFile(tt.content)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stat != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, stat)
+			}
+		})
+	}
+
+	for _, malformed := range []string{"", "1234 msm-client S", "abc (x) S 1 2 3 4 5 6 7 8 9 10 11 12", "1 (x) S 1 2"} {
+		if _, err := parseStatFile(malformed); err == nil {
+			t.Errorf("Expected error for %q", malformed)
+		}
+	}
+}
+
+func TestGetProcessCPU(t *testing.T) {
+	originalPath, originalInterval := procPath, processCPUSampleInterval
+	defer func() { procPath, processCPUSampleInterval = originalPath, originalInterval }()
+
+	procPath = t.TempDir()
+	processCPUSampleInterval = 0
+	for pid, stat := range map[string]string{
+		"1":   "1 (init) S 0 1 1 0 -1 0 0 0 0 0 10 5 0 0 20 0 1 0 1 0 0",
+		"200": "200 (worker one) R 1 200 200 0 -1 0 0 0 0 0 500 20 0 0 20 0 1 0 5 0 0",
+	} {
+		dir := filepath.Join(procPath, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create fixture: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+	}
+	// Non-PID entries are skipped
+	os.WriteFile(filepath.Join(procPath, "meminfo"), nil, 0644)
+
+	processes, err := GetRunningProcesses()
+	if err != nil {
+		t.Fatalf("GetRunningProcesses failed: %v", err)
+	}
+	if len(processes) != 2 || processes[0].PID != 1 || processes[1].Name != "worker one" {
+		t.Errorf("Unexpected processes: %+v", processes)
+	}
+
+	processes, err = GetProcessCPU(200)
+	if err != nil {
+		t.Fatalf("GetProcessCPU failed: %v", err)
+	}
+	if len(processes) != 1 || processes[0].PID != 200 || processes[0].State != "R" {
+		t.Errorf("Expected only process 200, got %+v", processes)
+	}
+
+	if _, err := GetProcessCPU(999); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("Expected ErrProcessNotFound, got %v", err)
+	}
+
+	procPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := GetRunningProcesses(); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("Expected ErrNotAvailable without /proc, got %v", err)
+	}
+}
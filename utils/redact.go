@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces identifiers that cannot be parsed, so nothing leaks unmasked
+const redactedValue = "x"
+
+// RedactMAC keeps the vendor prefix of a MAC address and masks the rest,
+// e.g. aa:bb:cc:dd:ee:ff becomes aa:bb:cc:xx:xx:xx
+func RedactMAC(mac string) string {
+	if mac == "" {
+		return ""
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 3 {
+		return redactedValue
+	}
+
+	parts := strings.Split(hw.String(), ":")
+	for i := 3; i < len(parts); i++ {
+		parts[i] = "xx"
+	}
+	return strings.Join(parts, ":")
+}
+
+// RedactIP masks the host part of an IP address. IPv4 addresses keep the first
+// three octets (192.168.1.x); IPv6 addresses are truncated to their /64 prefix.
+func RedactIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return redactedValue
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		parts := strings.Split(v4.String(), ".")
+		parts[3] = "x"
+		return strings.Join(parts, ".")
+	}
+
+	prefix := parsed.Mask(net.CIDRMask(64, 128))
+	return prefix.String() + "/64"
+}
+
+// RedactInterfaceInfo returns copies of interfaces with MAC and IP addresses masked
+func RedactInterfaceInfo(interfaces []InterfaceInfo) []InterfaceInfo {
+	redacted := make([]InterfaceInfo, len(interfaces))
+	for i, iface := range interfaces {
+		iface.IPAddress = RedactIP(iface.IPAddress)
+		iface.IPv4Address = RedactIP(iface.IPv4Address)
+		iface.IPv6Address = RedactIP(iface.IPv6Address)
+		iface.MACAddress = RedactMAC(iface.MACAddress)
+		redacted[i] = iface
+	}
+	return redacted
+}
+
+// Patterns for network identifiers embedded in free text such as log lines
+var (
+	macPattern  = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?::[0-9a-f]{2}){5}\b`)
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+)
+
+// RedactText masks MAC and IPv4 addresses found in text with RedactMAC and RedactIP
+func RedactText(text string) string {
+	text = macPattern.ReplaceAllStringFunc(text, RedactMAC)
+	return ipv4Pattern.ReplaceAllStringFunc(text, RedactIP)
+}
+
+// RedactURL removes credentials from a URL: user info is dropped and query
+// values are masked, keeping the scheme, host and path
+func RedactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+
+	parsed.User = nil
+	query := parsed.Query()
+	for key := range query {
+		query.Set(key, redactedValue)
+	}
+	parsed.RawQuery = query.Encode()
+	parsed.Fragment = ""
+	return parsed.String()
+}
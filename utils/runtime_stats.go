@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"runtime"
+)
+
+// procSelfFDPath is a variable so tests can point it at a fixture
+var procSelfFDPath = "/proc/self/fd"
+
+// RuntimeStats describes the Go runtime of the client process
+type RuntimeStats struct {
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapSysBytes   uint64 `json:"heap_sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	GCPauseTotalNs uint64 `json:"gc_pause_total_ns"`
+	OpenFDs        int    `json:"open_fds"` // -1 on systems without /proc/self/fd
+
+	// Connection write health, filled in by the WebSocket manager
+	ConsecutiveSendFailures int    `json:"consecutive_send_failures"`
+	LastWriteError          string `json:"last_write_error,omitempty"`
+
+	// Recoveries by the watchdog since start, by check name
+	WatchdogViolations map[string]int `json:"watchdog_violations,omitempty"`
+
+	// Most recent error message from the server
+	LastServerError   string `json:"last_server_error,omitempty"`
+	LastServerErrorAt string `json:"last_server_error_at,omitempty"`
+}
+
+// GetRuntimeStats returns the goroutine count, heap and GC statistics and the
+// number of open file descriptors of the client process
+func GetRuntimeStats() RuntimeStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	return RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		HeapSysBytes:   memStats.HeapSys,
+		NumGC:          memStats.NumGC,
+		GCPauseTotalNs: memStats.PauseTotalNs,
+		OpenFDs:        countOpenFDs(),
+	}
+}
+
+// countOpenFDs returns the number of entries in /proc/self/fd, or -1 when it cannot be read
+func countOpenFDs() int {
+	entries, err := os.ReadDir(procSelfFDPath)
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
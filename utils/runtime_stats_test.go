@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountOpenFDs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0", "1", "2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatalf("Failed to create fixture: %v", err)
+		}
+	}
+
+	oldPath := procSelfFDPath
+	defer func() { procSelfFDPath = oldPath }()
+
+	procSelfFDPath = dir
+	if got := countOpenFDs(); got != 3 {
+		t.Errorf("Expected 3 open descriptors, got %d", got)
+	}
+
+	procSelfFDPath = filepath.Join(dir, "missing")
+	if got := countOpenFDs(); got != -1 {
+		t.Errorf("Expected -1 without /proc/self/fd, got %d", got)
+	}
+}
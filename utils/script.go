@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+)
+
+// scriptWaitDelay bounds how long RunScript waits for output after a timed-out script is killed
+const scriptWaitDelay = time.Second
+
+// RunScript runs script with "sh -c", killing it after timeout. Each line of its
+// combined output is logged, prefixed with name.
+func RunScript(name, script string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Printf("Running %s: %s", name, script)
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	// Children of the shell may keep the output pipe open after it is killed
+	cmd.WaitDelay = scriptWaitDelay
+	output, err := cmd.CombinedOutput()
+	for _, line := range SplitLines(string(output)) {
+		log.Printf("%s: %s", name, line)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+
+	if err := RunScript("startup script", "echo ok > "+marker, 5*time.Second); err != nil {
+		t.Fatalf("RunScript failed: %v", err)
+	}
+	if data, err := os.ReadFile(marker); err != nil || strings.TrimSpace(string(data)) != "ok" {
+		t.Errorf("Expected script to write marker file, got %q (%v)", data, err)
+	}
+
+	if err := RunScript("failing script", "exit 3", 5*time.Second); err == nil {
+		t.Error("Expected error for non-zero exit")
+	}
+
+	start := time.Now()
+	err := RunScript("slow script", "sleep 5", 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected script to be killed at the timeout, took %v", elapsed)
+	}
+}
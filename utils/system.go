@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCheckPortTimeout is used when the context passed to CheckPort has no deadline
+const defaultCheckPortTimeout = 5 * time.Second
+
+// CheckPort attempts to connect to host:port using the given protocol ("tcp" or "udp")
+// and returns the time taken to connect.
+// For UDP a 0-byte packet is sent and a response is awaited until the context deadline.
+func CheckPort(ctx context.Context, host string, port int, proto string) (time.Duration, error) {
+	if proto == "" {
+		proto = "tcp"
+	}
+	if proto != "tcp" && proto != "udp" {
+		return 0, fmt.Errorf("unsupported protocol: %s", proto)
+	}
+	if port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %d", port)
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCheckPortTimeout)
+		defer cancel()
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	start := time.Now()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, proto, address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if proto == "udp" {
+		// UDP is connectionless, so wait for the peer to answer
+		deadline, _ := ctx.Deadline()
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, err
+		}
+		if _, err := conn.Write([]byte{}); err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err != nil {
+			return 0, err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// FileSyncManifestLimit bounds the number of entries returned by GetFileSyncManifest
+const FileSyncManifestLimit = 100
+
+// ErrFileSyncManifestTruncated is returned with the first FileSyncManifestLimit
+// entries when more files match
+var ErrFileSyncManifestTruncated = errors.New("file sync manifest truncated")
+
+// FileSyncEntry describes a file reported in a file sync manifest
+type FileSyncEntry struct {
+	Path     string `json:"path"` // Absolute path of the file
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"` // RFC 3339 modification time
+	SHA256   string `json:"sha256"`   // Hex-encoded SHA-256 of the contents
+}
+
+// GetFileSyncManifest lists the regular files under rootPath modified after since,
+// in lexical order, with their SHA-256 hashes. Symlinks are not followed.
+func GetFileSyncManifest(rootPath string, since time.Time) ([]FileSyncEntry, error) {
+	var entries []FileSyncEntry
+	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// Removed while walking
+			return nil
+		}
+		if !info.ModTime().After(since) {
+			return nil
+		}
+		if len(entries) == FileSyncManifestLimit {
+			return ErrFileSyncManifestTruncated
+		}
+
+		hash, err := FileSHA256(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, FileSyncEntry{
+			Path:     path,
+			Size:     info.Size(),
+			Modified: info.ModTime().UTC().Format(time.RFC3339),
+			SHA256:   hash,
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// FileSHA256 returns the hex-encoded SHA-256 of the file at path
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sysfsRoot returns the sysfs mount point, overridable with MSC_SYSFS_PATH to
+// read a fake tree in tests
+func sysfsRoot() string {
+	if path := os.Getenv("MSC_SYSFS_PATH"); path != "" {
+		return path
+	}
+	return "/sys"
+}
+
+// ThermalZoneInfo is the current temperature of a thermal zone
+type ThermalZoneInfo struct {
+	Zone        string  `json:"zone"` // Zone type, e.g. "cpu-thermal"
+	TempCelsius float64 `json:"temp_celsius"`
+}
+
+// parseThermalZone reads the type and temp files of a thermal zone directory.
+// temp holds millidegrees Celsius; the result is rounded to 0.1 degree.
+func parseThermalZone(dir string) (ThermalZoneInfo, error) {
+	zoneType, err := os.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return ThermalZoneInfo{}, err
+	}
+	temp, err := os.ReadFile(filepath.Join(dir, "temp"))
+	if err != nil {
+		return ThermalZoneInfo{}, err
+	}
+	milliCelsius, err := strconv.ParseInt(strings.TrimSpace(string(temp)), 10, 64)
+	if err != nil {
+		return ThermalZoneInfo{}, fmt.Errorf("invalid temperature in %s: %w", dir, err)
+	}
+
+	return ThermalZoneInfo{
+		Zone:        strings.TrimSpace(string(zoneType)),
+		TempCelsius: math.Round(float64(milliCelsius)/100) / 10,
+	}, nil
+}
+
+// GetAllThermalZones returns the temperature of every thermal zone in zone
+// number order, skipping zones that cannot be read (some report an error while
+// their sensor is powered down). Returns ErrNotAvailable without thermal zones.
+func GetAllThermalZones() ([]ThermalZoneInfo, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsRoot(), "class", "thermal", "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, ErrNotAvailable
+	}
+
+	zoneNumber := func(dir string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "thermal_zone"))
+		return n
+	}
+	sort.Slice(dirs, func(i, j int) bool { return zoneNumber(dirs[i]) < zoneNumber(dirs[j]) })
+
+	zones := []ThermalZoneInfo{}
+	for _, dir := range dirs {
+		if zone, err := parseThermalZone(dir); err == nil {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+// IsCPUThrottled reports whether cpu0 runs below its maximum frequency. A
+// governor also lowers the frequency when idle, so this is a hint to read with
+// the temperatures rather than proof of thermal throttling.
+// Returns ErrNotAvailable without cpufreq.
+func IsCPUThrottled() (bool, error) {
+	cpufreq := filepath.Join(sysfsRoot(), "devices", "system", "cpu", "cpu0", "cpufreq")
+	readFreq := func(name string) (int64, error) {
+		data, err := os.ReadFile(filepath.Join(cpufreq, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return 0, ErrNotAvailable
+			}
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	current, err := readFreq("scaling_cur_freq")
+	if err != nil {
+		return false, err
+	}
+	maximum, err := readFreq("scaling_max_freq")
+	if err != nil {
+		return false, err
+	}
+	return current < maximum, nil
+}
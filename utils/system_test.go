@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// Accept connections in the background
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	t.Run("Reachable TCP port", func(t *testing.T) {
+		latency, err := CheckPort(ctx, "127.0.0.1", port, "tcp")
+		if err != nil {
+			t.Fatalf("Expected port %d to be reachable, got error: %v", port, err)
+		}
+		if latency <= 0 {
+			t.Errorf("Expected positive latency, got %v", latency)
+		}
+	})
+
+	t.Run("Unreachable TCP port", func(t *testing.T) {
+		listener.Close()
+
+		if _, err := CheckPort(ctx, "127.0.0.1", port, "tcp"); err == nil {
+			t.Errorf("Expected port %d to be unreachable after closing listener", port)
+		}
+	})
+
+	t.Run("Invalid arguments", func(t *testing.T) {
+		if _, err := CheckPort(ctx, "127.0.0.1", port, "icmp"); err == nil {
+			t.Error("Expected error for unsupported protocol")
+		}
+		if _, err := CheckPort(ctx, "127.0.0.1", 0, "tcp"); err == nil {
+			t.Error("Expected error for invalid port")
+		}
+	})
+}
+
+func TestGetFileSyncManifest(t *testing.T) {
+	root := t.TempDir()
+	since := time.Now().Add(-time.Hour)
+
+	old := filepath.Join(root, "old.mp4")
+	if err := os.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(old, since.Add(-time.Hour), since.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	recent := filepath.Join(root, "sub", "new.png")
+	if err := os.WriteFile(recent, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	entries, err := GetFileSyncManifest(root, since)
+	if err != nil {
+		t.Fatalf("GetFileSyncManifest failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry modified after since, got %v", entries)
+	}
+	// SHA-256 of "hello"
+	expectedHash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if entries[0].Path != recent || entries[0].Size != 5 || entries[0].SHA256 != expectedHash {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+
+	entries, err = GetFileSyncManifest(root, time.Time{})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected both files without a since time, got %v (%v)", entries, err)
+	}
+}
+
+func TestGetFileSyncManifestTruncates(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i <= FileSyncManifestLimit; i++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("%03d", i)), nil, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	entries, err := GetFileSyncManifest(root, time.Time{})
+	if !errors.Is(err, ErrFileSyncManifestTruncated) {
+		t.Fatalf("Expected ErrFileSyncManifestTruncated, got %v", err)
+	}
+	if len(entries) != FileSyncManifestLimit {
+		t.Errorf("Expected %d entries, got %d", FileSyncManifestLimit, len(entries))
+	}
+}
+
+// writeFakeSysfs creates the files under root, keyed by path relative to root
+func writeFakeSysfs(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetAllThermalZones(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("MSC_SYSFS_PATH", root)
+
+	if _, err := GetAllThermalZones(); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("Expected ErrNotAvailable without thermal zones, got %v", err)
+	}
+
+	writeFakeSysfs(t, root, map[string]string{
+		"class/thermal/thermal_zone0/type":  "cpu-thermal\n",
+		"class/thermal/thermal_zone0/temp":  "52345\n",
+		"class/thermal/thermal_zone2/type":  "battery\n",
+		"class/thermal/thermal_zone2/temp":  "-5000\n",
+		"class/thermal/thermal_zone10/type": "gpu-thermal\n",
+		"class/thermal/thermal_zone10/temp": "48100\n",
+		"class/thermal/thermal_zone3/type":  "powered-down\n", // No temp file
+	})
+
+	zones, err := GetAllThermalZones()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []ThermalZoneInfo{
+		{Zone: "cpu-thermal", TempCelsius: 52.3},
+		{Zone: "battery", TempCelsius: -5},
+		{Zone: "gpu-thermal", TempCelsius: 48.1},
+	}
+	if fmt.Sprint(zones) != fmt.Sprint(expected) {
+		t.Errorf("Expected zones %v, got %v", expected, zones)
+	}
+
+	if _, err := parseThermalZone(filepath.Join(root, "class/thermal/thermal_zone3")); err == nil {
+		t.Error("Expected an error for a zone without a temp file")
+	}
+}
+
+func TestIsCPUThrottled(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("MSC_SYSFS_PATH", root)
+
+	if _, err := IsCPUThrottled(); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("Expected ErrNotAvailable without cpufreq, got %v", err)
+	}
+
+	cpufreq := "devices/system/cpu/cpu0/cpufreq/"
+	writeFakeSysfs(t, root, map[string]string{
+		cpufreq + "scaling_cur_freq": "600000\n",
+		cpufreq + "scaling_max_freq": "1500000\n",
+	})
+	if throttled, err := IsCPUThrottled(); err != nil || !throttled {
+		t.Errorf("Expected throttled below the maximum frequency, got %v, %v", throttled, err)
+	}
+
+	writeFakeSysfs(t, root, map[string]string{cpufreq + "scaling_cur_freq": "1500000\n"})
+	if throttled, err := IsCPUThrottled(); err != nil || throttled {
+		t.Errorf("Expected not throttled at the maximum frequency, got %v, %v", throttled, err)
+	}
+}
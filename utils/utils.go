@@ -3,9 +3,11 @@ package utils
 import (
 	"crypto/rand"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 // FormatDigits formats an integer with zero-padding to the specified width.
@@ -14,6 +16,13 @@ func FormatDigits(n, width int) string {
 	return fmt.Sprintf("%0*d", width, n)
 }
 
+// Zeroize overwrites b with zeros so sensitive data does not linger in memory.
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 // GetUptime returns the system uptime in seconds.
 // Returns 0 if the uptime cannot be determined (e.g., on non-Linux systems).
 func GetUptime() int64 {
@@ -32,33 +41,86 @@ func GetUptime() int64 {
 	return int64(seconds)
 }
 
+// GetBootTime returns the ID of the current boot and the time elapsed since it.
+// Unlike the wall clock, the time since boot is not changed by clock adjustments,
+// and unlike the monotonic clock of time.Now it keeps counting across process
+// restarts; it starts over with a new ID on reboot. ok is false when either is
+// unavailable (e.g., on non-Linux systems).
+func GetBootTime() (bootID string, sinceBoot time.Duration, ok bool) {
+	id, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", 0, false
+	}
+	uptime, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return "", 0, false
+	}
+	var seconds float64
+	if _, err := fmt.Sscanf(string(uptime), "%f", &seconds); err != nil {
+		return "", 0, false
+	}
+	return strings.TrimSpace(string(id)), time.Duration(seconds * float64(time.Second)), true
+}
+
+// Pairing code character sets
+const (
+	AlphanumericCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	NumericCharset      = "0123456789"
+)
+
 // GenerateCode generates a cryptographically secure random pairing code.
 // The code consists of uppercase letters and digits (A-Z, 0-9).
 // Returns a code of the specified length, or a fallback code if crypto/rand fails.
 func GenerateCode(codeLength int) string {
-	if codeLength <= 0 {
+	return GenerateCodeUnbiased(codeLength)
+}
+
+// GenerateCodeUnbiased generates an alphanumeric code in which every character
+// of the charset is equally likely; see GenerateCodeFromCharset
+func GenerateCodeUnbiased(length int) string {
+	return GenerateCodeFromCharset(length, AlphanumericCharset)
+}
+
+// GenerateCodeFromCharset generates a cryptographically secure random code of
+// the specified length drawn from charset, or a fallback code if crypto/rand fails.
+// The charset may hold at most 256 characters.
+//
+// Mapping a random byte with b % len(charset) would favour the first 256 %
+// len(charset) characters, which get one more byte value each (for 36
+// characters, A-D are picked with probability 8/256 instead of 7/256). Instead,
+// bytes from the incomplete last round, at or above 256 - 256 % len(charset),
+// are rejected and replaced with fresh ones, so the accepted bytes map evenly.
+// randomReader supplies the random bytes of generated codes. It is a variable
+// so tests can make the codes deterministic.
+var randomReader io.Reader = rand.Reader
+
+func GenerateCodeFromCharset(codeLength int, charset string) string {
+	charsetLen := len(charset)
+	if codeLength <= 0 || charsetLen == 0 || charsetLen > 256 {
 		return ""
 	}
 
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	const charsetLen = len(charset)
-
-	result := make([]byte, codeLength)
+	// Bytes below limit cover every character the same number of times
+	limit := 256 - 256%charsetLen
 
-	// Generate random bytes
+	result := make([]byte, 0, codeLength)
 	randomBytes := make([]byte, codeLength)
-	if _, err := rand.Read(randomBytes); err != nil {
-		// Fallback to a deterministic pattern if crypto/rand fails
-		log.Printf("Warning: crypto/rand failed, using fallback pattern: %v", err)
-		for i := range result {
-			result[i] = charset[i%charsetLen]
+	for len(result) < codeLength {
+		if _, err := io.ReadFull(randomReader, randomBytes); err != nil {
+			// Fallback to a deterministic pattern if crypto/rand fails
+			log.Printf("Warning: crypto/rand failed, using fallback pattern: %v", err)
+			result = result[:codeLength]
+			for i := range result {
+				result[i] = charset[i%charsetLen]
+			}
+			return string(result)
 		}
-		return string(result)
-	}
 
-	// Map random bytes to charset
-	for i, b := range randomBytes {
-		result[i] = charset[int(b)%charsetLen]
+		for _, b := range randomBytes {
+			if int(b) < limit && len(result) < codeLength {
+				result = append(result, charset[int(b)%charsetLen])
+			}
+		}
 	}
 
 	return string(result)
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"bytes"
+	"math"
+	"math/rand/v2"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFormatDigits(t *testing.T) {
@@ -73,6 +77,25 @@ func TestFormatDigits(t *testing.T) {
 	}
 }
 
+func TestGetBootTime(t *testing.T) {
+	id, first, ok := GetBootTime()
+	if !ok {
+		t.Skip("Boot ID or uptime unavailable on this system")
+	}
+	if id == "" {
+		t.Error("Expected a boot ID")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	sameID, second, ok := GetBootTime()
+	if !ok || sameID != id {
+		t.Errorf("Expected the same boot ID %q, got %q", id, sameID)
+	}
+	if second < first {
+		t.Errorf("Time since boot went backwards: %v then %v", first, second)
+	}
+}
+
 func TestGetUptime(t *testing.T) {
 	t.Run("Valid uptime file", func(t *testing.T) {
 		// Create a temporary uptime file for testing
@@ -166,30 +189,136 @@ func TestGenerateCode(t *testing.T) {
 	})
 
 	t.Run("Character distribution", func(t *testing.T) {
-		// Test that all characters from the charset appear over many generations
-		charCount := make(map[rune]int)
+		// Chi-squared goodness of fit against a uniform distribution over the
+		// charset. A seeded stream keeps the test deterministic; with
+		// crypto/rand it would fail one run in a hundred.
+		original := randomReader
+		randomReader = rand.NewChaCha8([32]byte{1})
+		defer func() { randomReader = original }()
+
+		const samples = 10000
 		validChars := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		charCount := make(map[rune]int)
 
-		// Generate many short codes to test character distribution
-		for i := 0; i < 1000; i++ {
-			code := GenerateCode(1)
-			if len(code) == 1 {
-				charCount[rune(code[0])]++
+		code := GenerateCode(samples)
+		for _, char := range code {
+			if !strings.ContainsRune(validChars, char) {
+				t.Fatalf("Invalid character generated: %c", char)
 			}
+			charCount[char]++
 		}
 
-		// We should see at least some variety (not expecting perfect distribution)
-		if len(charCount) < 10 {
-			t.Errorf("Too few unique characters generated: %d", len(charCount))
+		expected := float64(samples) / float64(len(validChars))
+		chiSquared := 0.0
+		for _, char := range validChars {
+			diff := float64(charCount[char]) - expected
+			chiSquared += diff * diff / expected
 		}
 
-		// All generated characters should be valid
-		for char := range charCount {
-			if !strings.ContainsRune(validChars, char) {
-				t.Errorf("Invalid character generated: %c", char)
+		pValue := chiSquaredPValue(chiSquared, len(validChars)-1)
+		if pValue <= 0.01 {
+			t.Errorf("Character distribution is not uniform: chi-squared %.2f, p-value %.4f", chiSquared, pValue)
+		}
+	})
+
+	t.Run("Rejection sampling", func(t *testing.T) {
+		// Every byte value once: the bytes that are not rejected must cover
+		// each character equally often
+		cycle := make([]byte, 256)
+		for i := range cycle {
+			cycle[i] = byte(i)
+		}
+		original := randomReader
+		randomReader = bytes.NewReader(bytes.Repeat(cycle, 2))
+		defer func() { randomReader = original }()
+
+		const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		perCycle := 256 / len(charset)
+		code := GenerateCodeFromCharset(perCycle*len(charset), charset)
+		for _, char := range charset {
+			if count := strings.Count(code, string(char)); count != perCycle {
+				t.Errorf("Expected %c %d times, got %d", char, perCycle, count)
 			}
 		}
 	})
+
+	t.Run("P-value reference", func(t *testing.T) {
+		// 57.342 is the 1% critical value of the chi-squared distribution with 35 degrees of freedom
+		if pValue := chiSquaredPValue(57.342, 35); math.Abs(pValue-0.01) > 0.0005 {
+			t.Errorf("Expected p-value 0.01, got %.5f", pValue)
+		}
+		if pValue := chiSquaredPValue(35, 35); pValue < 0.4 || pValue > 0.5 {
+			t.Errorf("Expected p-value near 0.47 at the mean, got %.5f", pValue)
+		}
+	})
+}
+
+// chiSquaredPValue returns the probability that a chi-squared variable with df
+// degrees of freedom exceeds stat, the regularized upper incomplete gamma
+// function Q(df/2, stat/2)
+func chiSquaredPValue(stat float64, df int) float64 {
+	a, x := float64(df)/2, stat/2
+	if x <= 0 {
+		return 1
+	}
+	lgammaA, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lgammaA)
+
+	if x < a+1 {
+		// Series for the lower function P(a, x)
+		term := 1 / a
+		sum := term
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if term < sum*1e-15 {
+				break
+			}
+		}
+		return 1 - prefix*sum
+	}
+
+	// Continued fraction for Q(a, x), by the modified Lentz method
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < 1000; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return prefix * h
+}
+
+func TestGenerateCodeFromCharset(t *testing.T) {
+	code := GenerateCodeFromCharset(8, NumericCharset)
+	if len(code) != 8 {
+		t.Errorf("Expected code length 8, got %d", len(code))
+	}
+	for _, char := range code {
+		if char < '0' || char > '9' {
+			t.Errorf("Numeric code contains invalid character: %c", char)
+		}
+	}
+
+	if code := GenerateCodeFromCharset(8, ""); code != "" {
+		t.Errorf("Expected empty string for an empty charset, got %q", code)
+	}
 }
 
 func TestSplitLines(t *testing.T) {
@@ -288,3 +417,18 @@ func BenchmarkSplitLines(b *testing.B) {
 		SplitLines(input)
 	}
 }
+
+func TestZeroize(t *testing.T) {
+	data := []byte("secret key material")
+	Zeroize(data)
+
+	for i, b := range data {
+		if b != 0 {
+			t.Fatalf("Byte %d not zeroed: %v", i, b)
+		}
+	}
+
+	// Should not panic on nil or empty slices
+	Zeroize(nil)
+	Zeroize([]byte{})
+}
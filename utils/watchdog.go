@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// WatchdogCheck is an invariant of the running client with the recovery for
+// its violation
+type WatchdogCheck struct {
+	Name    string
+	Check   func() error // Returns the violation, nil while the invariant holds
+	Recover func()
+}
+
+// Watchdog verifies invariants periodically, so failures that leave the
+// process running but useless are recovered from without a restart
+type Watchdog struct {
+	mu         sync.Mutex
+	checks     []WatchdogCheck
+	violations map[string]int // Check name -> violations since start
+}
+
+// NewWatchdog returns a watchdog without checks
+func NewWatchdog() *Watchdog {
+	return &Watchdog{violations: make(map[string]int)}
+}
+
+// Add registers check
+func (w *Watchdog) Add(check WatchdogCheck) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.checks = append(w.checks, check)
+}
+
+// RunChecks runs every check once, recovering from each violation, and returns
+// the names of the violated checks
+func (w *Watchdog) RunChecks() []string {
+	w.mu.Lock()
+	checks := append([]WatchdogCheck(nil), w.checks...)
+	w.mu.Unlock()
+
+	var violated []string
+	for _, check := range checks {
+		err := check.Check()
+		if err == nil {
+			continue
+		}
+		log.Printf("Watchdog: %s check failed: %v, recovering", check.Name, err)
+		w.mu.Lock()
+		w.violations[check.Name]++
+		w.mu.Unlock()
+		violated = append(violated, check.Name)
+		if check.Recover != nil {
+			check.Recover()
+		}
+	}
+	return violated
+}
+
+// Run runs the checks every interval until ctx is done
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.RunChecks()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Violations returns the number of violations of each check since start
+func (w *Watchdog) Violations() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.violations)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWatchdogRunChecks(t *testing.T) {
+	watchdog := NewWatchdog()
+	healthy, failing := true, true
+	recovered := map[string]int{}
+	for _, name := range []string{"healthy", "failing"} {
+		watchdog.Add(WatchdogCheck{
+			Name: name,
+			Check: func() error {
+				if name == "healthy" && healthy || name == "failing" && !failing {
+					return nil
+				}
+				return errors.New("broken")
+			},
+			Recover: func() { recovered[name]++ },
+		})
+	}
+
+	if violated := watchdog.RunChecks(); len(violated) != 1 || violated[0] != "failing" {
+		t.Errorf("Expected only the failing check to be violated, got %v", violated)
+	}
+	failing = false
+	watchdog.RunChecks()
+	healthy = false
+	watchdog.RunChecks()
+
+	if recovered["failing"] != 1 || recovered["healthy"] != 1 {
+		t.Errorf("Expected one recovery per violation, got %v", recovered)
+	}
+	if violations := watchdog.Violations(); violations["failing"] != 1 || violations["healthy"] != 1 {
+		t.Errorf("Unexpected violation counts %v", violations)
+	}
+}
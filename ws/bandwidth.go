@@ -0,0 +1,44 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"msm-client/utils"
+)
+
+// counterSample is an interface's byte counters at a point in time
+type counterSample struct {
+	rx, tx uint64
+	at     time.Time
+}
+
+// bandwidthTracker remembers the last counters per interface to compute rates between status updates
+type bandwidthTracker struct {
+	mu   sync.Mutex
+	last map[string]counterSample
+}
+
+// rates records the counters of interfaces sampled at now and returns the rx/tx rate of
+// each interface since its previous sample. Interfaces seen for the first time are omitted.
+func (b *bandwidthTracker) rates(interfaces []utils.InterfaceInfo, now time.Time) map[string]utils.NetworkSpeed {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := make(map[string]counterSample, len(interfaces))
+	result := make(map[string]utils.NetworkSpeed)
+	for _, iface := range interfaces {
+		if _, seen := current[iface.Name]; seen {
+			continue // Same interface listed once per address
+		}
+		sample := counterSample{rx: iface.RxBytes, tx: iface.TxBytes, at: now}
+		current[iface.Name] = sample
+
+		if previous, ok := b.last[iface.Name]; ok {
+			result[iface.Name] = utils.CalculateSpeed(previous.rx, previous.tx, sample.rx, sample.tx, now.Sub(previous.at))
+		}
+	}
+
+	b.last = current
+	return result
+}
@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"msm-client/utils"
+)
+
+func TestBandwidthTrackerRates(t *testing.T) {
+	var tracker bandwidthTracker
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sample := func(offset time.Duration, rx, tx uint64) map[string]utils.NetworkSpeed {
+		// eth0 is listed twice, as it would be with an IPv4 and an IPv6 address
+		return tracker.rates([]utils.InterfaceInfo{
+			{Name: "eth0", IPAddress: "192.168.1.10", RxBytes: rx, TxBytes: tx},
+			{Name: "eth0", IPAddress: "2001:db8::10", RxBytes: rx, TxBytes: tx},
+		}, start.Add(offset))
+	}
+
+	if rates := sample(0, 1000, 1000); len(rates) != 0 {
+		t.Errorf("Expected no rates on the first sample, got %v", rates)
+	}
+
+	rates := sample(10*time.Second, 21000, 6000)
+	if rates["eth0"].RxBytesPerSec != 2000 || rates["eth0"].TxBytesPerSec != 500 {
+		t.Errorf("Expected 2000/500 bytes per second, got %+v", rates["eth0"])
+	}
+
+	// Counters reset, e.g. after the interface was restarted
+	rates = sample(20*time.Second, 500, 100)
+	if rates["eth0"].RxBytesPerSec != 0 || rates["eth0"].TxBytesPerSec != 0 {
+		t.Errorf("Expected zero rates after a counter reset, got %+v", rates["eth0"])
+	}
+
+	rates = sample(30*time.Second, 1500, 1100)
+	if rates["eth0"].RxBytesPerSec != 100 || rates["eth0"].TxBytesPerSec != 100 {
+		t.Errorf("Expected 100/100 bytes per second after the reset, got %+v", rates["eth0"])
+	}
+
+	// Interfaces that disappear are forgotten
+	tracker.rates(nil, start.Add(40*time.Second))
+	if rates := sample(50*time.Second, 2000, 2000); len(rates) != 0 {
+		t.Errorf("Expected no rates for a reappearing interface, got %v", rates)
+	}
+}
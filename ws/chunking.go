@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gorilla/websocket"
+)
+
+// Chunked command responses
+//
+// A command_response whose JSON encoding exceeds MaxMessageBytes is sent as a
+// series of command_response_chunk messages followed by a terminating
+// command_response. Every message is encrypted individually, like any other.
+//
+// Each chunk carries:
+//
+//	command_id  the command the response belongs to
+//	seq         chunk index, starting at 0
+//	total       number of chunks
+//	data        base64 of the next slice of the full response's JSON encoding
+//
+// The terminator carries command, command_id and status as usual, plus
+// "chunked": true, total, size (bytes of the full JSON) and sha256 (hex of the
+// full JSON). To assemble, a server collects the chunks for command_id, orders
+// them by seq, checks that seq 0..total-1 are all present, concatenates the
+// decoded data, verifies size and sha256, and decodes the result as the
+// command_response it replaces. AssembleChunkedResponse implements this.
+// Chunks of one response are sent in seq order, but chunks of different
+// responses may interleave.
+
+// chunkEnvelopeBytes is reserved per chunk for the chunk's other fields
+const chunkEnvelopeBytes = 512
+
+// chunkDataSize returns how many response bytes fit in one chunk so that the
+// base64-encoded chunk stays within maxMessageBytes
+func chunkDataSize(maxMessageBytes int) int {
+	size := (maxMessageBytes - chunkEnvelopeBytes) / 4 * 3
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// splitResponseChunks splits the JSON encoding of a command response into
+// chunk messages and the terminating command_response fields
+func splitResponseChunks(response map[string]interface{}, payload []byte, maxMessageBytes int) ([]map[string]interface{}, map[string]interface{}) {
+	size := chunkDataSize(maxMessageBytes)
+	total := (len(payload) + size - 1) / size
+
+	chunks := make([]map[string]interface{}, 0, total)
+	for seq := 0; seq < total; seq++ {
+		end := min((seq+1)*size, len(payload))
+		chunks = append(chunks, map[string]interface{}{
+			"command_id": response["command_id"],
+			"seq":        seq,
+			"total":      total,
+			"data":       base64.StdEncoding.EncodeToString(payload[seq*size : end]),
+		})
+	}
+
+	sum := sha256.Sum256(payload)
+	terminator := map[string]interface{}{
+		"command":    response["command"],
+		"command_id": response["command_id"],
+		"status":     response["status"],
+		"chunked":    true,
+		"total":      total,
+		"size":       len(payload),
+		"sha256":     hex.EncodeToString(sum[:]),
+	}
+	return chunks, terminator
+}
+
+// AssembleChunkedResponse rebuilds a chunked command response from its decoded
+// chunk messages, in any order, and its terminating command_response
+func AssembleChunkedResponse(chunks []map[string]interface{}, terminator map[string]interface{}) (map[string]interface{}, error) {
+	total, _ := terminator["total"].(float64)
+	if len(chunks) != int(total) {
+		return nil, fmt.Errorf("expected %d chunks, got %d", int(total), len(chunks))
+	}
+
+	ordered := append([]map[string]interface{}(nil), chunks...)
+	sort.Slice(ordered, func(i, j int) bool {
+		seqI, _ := ordered[i]["seq"].(float64)
+		seqJ, _ := ordered[j]["seq"].(float64)
+		return seqI < seqJ
+	})
+
+	var payload []byte
+	for i, chunk := range ordered {
+		if seq, _ := chunk["seq"].(float64); int(seq) != i {
+			return nil, fmt.Errorf("missing chunk %d", i)
+		}
+		if chunk["command_id"] != terminator["command_id"] {
+			return nil, fmt.Errorf("chunk %d belongs to command %v", i, chunk["command_id"])
+		}
+		encoded, _ := chunk["data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data in chunk %d: %w", i, err)
+		}
+		payload = append(payload, data...)
+	}
+
+	if size, _ := terminator["size"].(float64); len(payload) != int(size) {
+		return nil, fmt.Errorf("expected %d bytes, got %d", int(size), len(payload))
+	}
+	sum := sha256.Sum256(payload)
+	if hex.EncodeToString(sum[:]) != terminator["sha256"] {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return response, nil
+}
+
+// sendChunkedResponse sends response as chunks when its JSON encoding exceeds
+// maxMessageBytes. It reports false when the response fits in one message.
+func (wsm *WebSocketManager) sendChunkedResponse(c *websocket.Conn, response map[string]interface{}, maxMessageBytes int) (bool, error) {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode command response: %w", err)
+	}
+	if len(payload) <= maxMessageBytes {
+		return false, nil
+	}
+
+	chunks, terminator := splitResponseChunks(response, payload, maxMessageBytes)
+	for _, chunk := range chunks {
+		if err := wsm.sendEncrypted(c, MessageTypeCommandResponseChunk, chunk); err != nil {
+			return true, err
+		}
+	}
+	return true, wsm.sendEncrypted(c, MessageTypeCommandResponse, terminator)
+}
@@ -0,0 +1,132 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkedCommandResponse(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.MaxMessageBytes = 1024
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	chunks := make(chan map[string]interface{}, 1000)
+	responses := make(chan map[string]interface{}, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case message["type"] == string(MessageTypeCommandResponseChunk):
+			chunks <- message
+		case message["command"] == string(CommandSupportBundle):
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":       "command",
+		"command":    string(CommandSupportBundle),
+		"command_id": "bundle-chunked",
+	}); err != nil {
+		t.Fatalf("Failed to send support_bundle: %v", err)
+	}
+
+	var terminator map[string]interface{}
+	select {
+	case terminator = <-responses:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the terminating command_response")
+	}
+	if terminator["chunked"] != true || terminator["status"] != string(StatusSuccess) {
+		t.Fatalf("Expected a successful chunked terminator, got %v", terminator)
+	}
+
+	var received []map[string]interface{}
+	for len(chunks) > 0 {
+		received = append(received, <-chunks)
+	}
+	if len(received) < 2 {
+		t.Fatalf("Expected multiple chunks, got %d", len(received))
+	}
+	for i, chunk := range received {
+		if chunk["seq"] != float64(i) || chunk["total"] != terminator["total"] {
+			t.Errorf("Chunk %d out of order: seq %v of %v", i, chunk["seq"], chunk["total"])
+		}
+		if data, _ := json.Marshal(chunk); len(data) > env.Config.MaxMessageBytes {
+			t.Errorf("Chunk %d is %d bytes, over the %d byte limit", i, len(data), env.Config.MaxMessageBytes)
+		}
+	}
+
+	response, err := AssembleChunkedResponse(received, terminator)
+	if err != nil {
+		t.Fatalf("Failed to assemble chunks: %v", err)
+	}
+	if response["command_id"] != "bundle-chunked" || response["status"] != string(StatusSuccess) {
+		t.Errorf("Unexpected assembled response: %v", response)
+	}
+	data, _ := response["data"].(map[string]interface{})
+	if bundle, _ := data["bundle"].(string); bundle == "" {
+		t.Error("Expected the assembled response to contain the bundle")
+	}
+}
+
+func TestAssembleChunkedResponse(t *testing.T) {
+	response := map[string]interface{}{
+		"type":       "command_response",
+		"command":    "screenshot",
+		"command_id": "cmd-1",
+		"status":     "success",
+		"data":       strings.Repeat("a", 5000),
+	}
+	payload, _ := json.Marshal(response)
+	chunks, terminator := splitResponseChunks(response, payload, 1024)
+
+	// Round-trip through JSON as a server would receive the messages
+	decode := func(value interface{}) map[string]interface{} {
+		data, _ := json.Marshal(value)
+		var decoded map[string]interface{}
+		json.Unmarshal(data, &decoded)
+		return decoded
+	}
+	var received []map[string]interface{}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		received = append(received, decode(chunks[i]))
+	}
+	decodedTerminator := decode(terminator)
+
+	assembled, err := AssembleChunkedResponse(received, decodedTerminator)
+	if err != nil {
+		t.Fatalf("Failed to assemble reversed chunks: %v", err)
+	}
+	if assembled["data"] != response["data"] {
+		t.Error("Assembled data does not match the original response")
+	}
+
+	if _, err := AssembleChunkedResponse(received[1:], decodedTerminator); err == nil {
+		t.Error("Expected an error with a missing chunk")
+	}
+
+	decodedTerminator["sha256"] = strings.Repeat("0", 64)
+	if _, err := AssembleChunkedResponse(received, decodedTerminator); err == nil {
+		t.Error("Expected an error with a checksum mismatch")
+	}
+}
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+
+	"msm-client/utils"
+)
+
+// Errors of a command refused for its timestamp
+const (
+	CommandErrorStale  = "stale_command"
+	CommandErrorFuture = "future_command"
+)
+
+// SetClockSkew records how far the server clock is ahead of the local clock
+// (negative when behind). Command timestamps are accepted that much further
+// either way, so a known offset does not get valid commands refused.
+func (wsm *WebSocketManager) SetClockSkew(skew time.Duration) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.clockSkew = skew
+}
+
+func (wsm *WebSocketManager) now() time.Time {
+	if wsm.clock == nil {
+		return utils.SystemClock{}.Now()
+	}
+	return wsm.clock.Now()
+}
+
+// commandTimestamp reads the timestamp of a command message, either Unix
+// seconds or RFC 3339. It reports false when the message carries none.
+func commandTimestamp(message map[string]interface{}) (time.Time, bool) {
+	switch ts := message["timestamp"].(type) {
+	case float64:
+		seconds := int64(ts)
+		return time.Unix(seconds, int64((ts-float64(seconds))*float64(time.Second))), true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, ts)
+		return parsed, err == nil
+	}
+	return time.Time{}, false
+}
+
+// checkCommandTimestamp compares the timestamp of a command message against
+// max_command_age_seconds and max_command_future_seconds, each widened by the
+// measured clock skew. A timestamp far off usually means a broken server clock,
+// so such commands are refused rather than run. It returns the error code and a
+// description, or "" when the command may run.
+func (wsm *WebSocketManager) checkCommandTimestamp(message map[string]interface{}) (string, string) {
+	issuedAt, ok := commandTimestamp(message)
+	if !ok {
+		return "", ""
+	}
+
+	wsm.mu.RLock()
+	maxAge := wsm.clientConfig.GetMaxCommandAge()
+	maxFuture := wsm.clientConfig.GetMaxCommandFuture()
+	skew := wsm.clockSkew
+	wsm.mu.RUnlock()
+	if skew < 0 {
+		skew = -skew
+	}
+
+	offset := wsm.now().Sub(issuedAt)
+	if maxAge > 0 && offset > maxAge+skew {
+		return CommandErrorStale, fmt.Sprintf("command timestamp is %s old, more than the allowed %s", offset.Round(time.Second), maxAge+skew)
+	}
+	if maxFuture > 0 && -offset > maxFuture+skew {
+		return CommandErrorFuture, fmt.Sprintf("command timestamp is %s in the future, more than the allowed %s", (-offset).Round(time.Second), maxFuture+skew)
+	}
+	return "", ""
+}
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestCheckCommandTimestamp(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	wsm := NewWebSocketManager()
+	wsm.clock = utils.NewFakeClock(now)
+
+	at := func(offset time.Duration) map[string]interface{} {
+		return map[string]interface{}{"timestamp": float64(now.Add(offset).Unix())}
+	}
+
+	tests := []struct {
+		name      string
+		maxAge    int
+		maxFuture int
+		skew      time.Duration
+		message   map[string]interface{}
+		expected  string
+	}{
+		{"Within the window", 60, 60, 0, at(-30 * time.Second), ""},
+		{"Stale", 60, 60, 0, at(-2 * time.Minute), CommandErrorStale},
+		{"Future", 60, 60, 0, at(2 * time.Minute), CommandErrorFuture},
+		{"Stale RFC 3339", 60, 60, 0, map[string]interface{}{"timestamp": now.Add(-time.Hour).Format(time.RFC3339)}, CommandErrorStale},
+		{"Age check disabled", 0, 60, 0, at(-24 * time.Hour), ""},
+		{"Future check disabled", 60, 0, 0, at(24 * time.Hour), ""},
+		{"No timestamp", 60, 60, 0, map[string]interface{}{}, ""},
+		{"Skew widens the age window", 60, 60, -2 * time.Minute, at(-2 * time.Minute), ""},
+		{"Skew widens the future window", 60, 60, 2 * time.Minute, at(2 * time.Minute), ""},
+		{"Beyond the widened window", 60, 60, 2 * time.Minute, at(-4 * time.Minute), CommandErrorStale},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wsm.clientConfig = config.ClientConfig{MaxCommandAgeSeconds: tt.maxAge, MaxCommandFutureSeconds: tt.maxFuture}
+			wsm.SetClockSkew(tt.skew)
+
+			reason, detail := wsm.checkCommandTimestamp(tt.message)
+			if reason != tt.expected {
+				t.Errorf("Expected %q, got %q (%s)", tt.expected, reason, detail)
+			}
+		})
+	}
+}
+
+func TestStaleCommandRefused(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.MaxCommandAgeSeconds = 60
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] == string(MessageTypeCommandResponse) {
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !env.WSManager.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !env.WSManager.IsConnected() {
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":       string(MessageTypeCommand),
+		"command":    string(CommandStatus),
+		"command_id": "stale-1",
+		"timestamp":  time.Now().Add(-time.Hour).Unix(),
+	}); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	select {
+	case response := <-responses:
+		if response["status"] != string(StatusError) || response["error"] != CommandErrorStale {
+			t.Errorf("Expected a stale_command error, got %v", response)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for command response")
+	}
+}
@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"msm-client/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Command result cache limits
+const (
+	maxCommandResults      = 100 // Results kept for get_result lookups
+	recentCommandsInStatus = 5   // Results included in periodic status messages
+)
+
+// CommandResult is the recorded outcome of a command
+type CommandResult struct {
+	Command    string         `json:"command"`
+	CommandID  string         `json:"command_id"`
+	Status     ResponseStatus `json:"status"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+// commandResultCache keeps the most recent command results, bounded by maxCommandResults
+type commandResultCache struct {
+	mu      sync.Mutex
+	results *utils.BoundedMap[string, CommandResult] // Keyed by command ID, oldest first
+}
+
+// record stores result, replacing any earlier result with the same command ID
+func (c *commandResultCache) record(result CommandResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.results == nil {
+		c.results = utils.NewBoundedMap[string, CommandResult](maxCommandResults)
+	}
+	c.results.Set(result.CommandID, result, time.Time{})
+}
+
+// get returns the result recorded for commandID
+func (c *commandResultCache) get(commandID string) (CommandResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		return CommandResult{}, false
+	}
+	return c.results.Get(commandID)
+}
+
+// recent returns up to n results, newest first
+func (c *commandResultCache) recent(n int) []CommandResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		return []CommandResult{}
+	}
+	return c.results.Newest(n)
+}
+
+// recordCommandResponse caches the outcome carried by a command_response payload.
+// It runs before the response is sent so the outcome survives a lost response.
+func (wsm *WebSocketManager) recordCommandResponse(data map[string]interface{}) {
+	commandID, _ := data["command_id"].(string)
+	if commandID == "" || data["command"] == nil {
+		return
+	}
+	command := fmt.Sprint(data["command"])
+	if CommandType(command) == CommandGetResult {
+		return
+	}
+
+	status, _ := data["status"].(ResponseStatus)
+	if dryRun, _ := data["dry_run"].(bool); dryRun {
+		status = StatusDryRun
+	}
+	wsm.commandResults.record(CommandResult{
+		Command:    command,
+		CommandID:  commandID,
+		Status:     status,
+		FinishedAt: time.Now(),
+	})
+}
+
+// GetCommandResult returns the cached outcome of the command with commandID
+func (wsm *WebSocketManager) GetCommandResult(commandID string) (CommandResult, bool) {
+	return wsm.commandResults.get(commandID)
+}
+
+// handleGetResult replies with the cached outcome of params.command_id, or status "unknown"
+func (wsm *WebSocketManager) handleGetResult(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	targetID, _ := params["command_id"].(string)
+	if targetID == "" {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandGetResult,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Params field command_id missing",
+		})
+		return
+	}
+
+	var data interface{} = map[string]interface{}{"command_id": targetID, "status": "unknown"}
+	if result, ok := wsm.GetCommandResult(targetID); ok {
+		data = result
+	} else {
+		log.Printf("No cached result for command %s", targetID)
+	}
+
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandGetResult,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       data,
+	})
+}
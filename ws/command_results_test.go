@@ -0,0 +1,130 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCommandResultCache(t *testing.T) {
+	var cache commandResultCache
+
+	for i := 0; i < maxCommandResults+10; i++ {
+		cache.record(CommandResult{Command: "status", CommandID: fmt.Sprintf("cmd-%d", i), Status: StatusSuccess})
+	}
+
+	if cache.results.Len() != maxCommandResults {
+		t.Errorf("Expected cache bounded at %d, got %d results", maxCommandResults, cache.results.Len())
+	}
+	if _, ok := cache.get("cmd-0"); ok {
+		t.Error("Oldest result should have been evicted")
+	}
+	if _, ok := cache.get(fmt.Sprintf("cmd-%d", maxCommandResults+9)); !ok {
+		t.Error("Newest result should be cached")
+	}
+
+	// Re-recording an ID replaces it and moves it to the front
+	cache.record(CommandResult{Command: "reboot", CommandID: "cmd-50", Status: StatusError})
+	recent := cache.recent(2)
+	if len(recent) != 2 || recent[0].CommandID != "cmd-50" || recent[0].Status != StatusError {
+		t.Errorf("Expected cmd-50 to be the most recent result, got %+v", recent)
+	}
+	if cache.results.Len() != maxCommandResults {
+		t.Errorf("Re-recording should not grow the cache, got %d results", cache.results.Len())
+	}
+}
+
+func TestGetResultAfterLostResponse(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	switchHandled := make(chan bool, 1)
+	results := make(chan map[string]interface{}, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case message["command"] == string(CommandScreenSwitch):
+			// Drop the response, as if the server restarted before processing it
+			switchHandled <- true
+		case message["command"] == string(CommandGetResult):
+			results <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":       "command",
+		"command":    "screen_switch",
+		"command_id": "switch-1",
+		"params":     map[string]interface{}{"screen_id": "2"},
+	}); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	select {
+	case <-switchHandled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for screen switch")
+	}
+
+	queryResult := func(commandID string) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    "get_result",
+			"command_id": "query-" + commandID,
+			"params":     map[string]interface{}{"command_id": commandID},
+		}); err != nil {
+			t.Fatalf("Failed to send get_result: %v", err)
+		}
+
+		select {
+		case message := <-results:
+			data, ok := message["data"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("Expected data in get_result response, got %v", message)
+			}
+			return data
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for get_result response")
+		}
+		return nil
+	}
+
+	data := queryResult("switch-1")
+	if data["command"] != string(CommandScreenSwitch) || data["status"] != string(StatusSuccess) {
+		t.Errorf("Expected cached screen_switch success, got %v", data)
+	}
+	if _, ok := data["finished_at"].(string); !ok {
+		t.Errorf("Expected finished_at in cached result, got %v", data)
+	}
+
+	if data := queryResult("never-sent"); data["status"] != "unknown" {
+		t.Errorf("Expected status unknown for an unseen command, got %v", data)
+	}
+
+	status := env.WSManager.generateStatusData()
+	recent, ok := status["recent_commands"].([]CommandResult)
+	if !ok || len(recent) == 0 || recent[0].CommandID != "switch-1" {
+		t.Errorf("Expected switch-1 in recent_commands, got %v", status["recent_commands"])
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
@@ -0,0 +1,210 @@
+package ws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"msm-client/config"
+	"msm-client/state"
+	"msm-client/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Statuses of a config_push_ack
+const (
+	ConfigPushApplied  = "applied"
+	ConfigPushRejected = "rejected"
+)
+
+// SetOnConfigPushed sets a callback run with the new configuration after a
+// config push is applied, so components outside the WebSocket manager can pick
+// it up
+func (wsm *WebSocketManager) SetOnConfigPushed(callback func(cfg config.ClientConfig)) {
+	wsm.callbackMutex.Lock()
+	defer wsm.callbackMutex.Unlock()
+	wsm.onConfigPushed = callback
+}
+
+func (wsm *WebSocketManager) triggerOnConfigPushed(cfg config.ClientConfig) {
+	wsm.callbackMutex.RLock()
+	callback := wsm.onConfigPushed
+	wsm.callbackMutex.RUnlock()
+	if callback != nil {
+		callback(cfg)
+	}
+}
+
+// Config returns the configuration in use, including config pushes applied
+// since ConnectWebSocket was called
+func (wsm *WebSocketManager) Config() config.ClientConfig {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.clientConfig
+}
+
+// SignConfigPush returns the signature of a config_push message: "sha256="
+// followed by the hex HMAC-SHA256, keyed with the session key, of the revision
+// in decimal, a ".", and the config document. The document is signed in its
+// compact JSON form with keys sorted, as encoding/json writes a map.
+func SignConfigPush(sessionKeyB64 string, revision int64, document []byte) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(sessionKeyB64)
+	if err != nil {
+		return "", fmt.Errorf("invalid session key: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(revision, 10) + "."))
+	mac.Write(document)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseConfigPush reads the revision and config document of a config_push
+// message and returns the document also in the form it is signed in
+func parseConfigPush(message map[string]interface{}) (int64, []byte, config.ClientConfig, error) {
+	rawRevision, ok := message["revision"].(float64)
+	if !ok || rawRevision < 1 || rawRevision != float64(int64(rawRevision)) {
+		return 0, nil, config.ClientConfig{}, fmt.Errorf("revision must be a positive integer")
+	}
+
+	document, ok := message["config"].(map[string]interface{})
+	if !ok {
+		return 0, nil, config.ClientConfig{}, fmt.Errorf("config document missing")
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return 0, nil, config.ClientConfig{}, fmt.Errorf("invalid config document: %w", err)
+	}
+	cfg, err := config.ParseConfig(data)
+	if err != nil {
+		return 0, nil, config.ClientConfig{}, fmt.Errorf("invalid config document: %w", err)
+	}
+	return int64(rawRevision), data, cfg, nil
+}
+
+// verifyConfigPush checks the signature of a config_push message, see SignConfigPush
+func verifyConfigPush(message map[string]interface{}, revision int64, document []byte) error {
+	signature, _ := message["signature"].(string)
+	if signature == "" {
+		return fmt.Errorf("config document is not signed")
+	}
+	expected, err := SignConfigPush(state.GetSessionKey(), revision, document)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("invalid config document signature")
+	}
+	return nil
+}
+
+// handleConfigPush replaces the whole configuration with the document of a
+// config_push message. Documents without a valid signature are refused. The
+// document is validated and saved like a config file, with the client ID and
+// the security settings of config.KeepProtectedFields kept from the saved
+// config; environment and runtime overrides apply on top of it in memory only.
+// Revisions lower than the last one applied are refused. The config_push_ack
+// lists the fields that changed, the fields validation, the environment or
+// protection replaced, and the changed fields that only take effect after a
+// restart.
+func (wsm *WebSocketManager) handleConfigPush(c *websocket.Conn, message map[string]interface{}) {
+	ack := map[string]interface{}{}
+	reject := func(reason string) {
+		log.Printf("Config push rejected: %s", reason)
+		ack["status"] = ConfigPushRejected
+		ack["error"] = reason
+		wsm.sendResponse(c, MessageTypeConfigPushAck, ack)
+	}
+
+	revision, document, pushed, err := parseConfigPush(message)
+	if rawRevision, ok := message["revision"]; ok {
+		ack["revision"] = rawRevision
+	}
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+	if err := verifyConfigPush(message, revision, document); err != nil {
+		reject(err.Error())
+		return
+	}
+
+	if lastRevision := state.GetConfigRevision(); revision < lastRevision {
+		ack["last_revision"] = lastRevision
+		reject(fmt.Sprintf("stale revision %d, last applied revision is %d", revision, lastRevision))
+		return
+	}
+
+	current := wsm.Config()
+	local, err := config.LoadPersistedConfig(current.ClientID)
+	if err != nil {
+		reject("failed to load saved config: " + err.Error())
+		return
+	}
+
+	// The client ID identifies the device to the server and is never pushed
+	kept := pushed
+	kept.ClientID = current.ClientID
+	if protected := config.KeepProtectedFields(&kept, local); len(protected) > 0 {
+		log.Printf("Warning: config push tried to change protected fields, keeping local values: %s", strings.Join(protected, ", "))
+	}
+	persisted, err := config.ValidateConfig(kept)
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+	validated, err := persisted.EffectiveConfig()
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+
+	// The revision is recorded first, so a saved config is never left without it
+	lastRevision := state.GetConfigRevision()
+	if err := state.SetConfigRevision(revision); err != nil {
+		reject("failed to record config revision: " + err.Error())
+		return
+	}
+	if err := config.SaveConfig(persisted); err != nil {
+		if err := state.SetConfigRevision(lastRevision); err != nil {
+			log.Printf("Warning: failed to restore config revision %d: %v", lastRevision, err)
+		}
+		reject("failed to save config: " + err.Error())
+		return
+	}
+
+	applied := config.ChangedFields(current, validated)
+	restartRequired := []string{}
+	for _, field := range applied {
+		if config.RequiresRestart(field) {
+			restartRequired = append(restartRequired, field)
+		}
+	}
+
+	wsm.mu.Lock()
+	wsm.clientConfig = validated
+	wsm.mu.Unlock()
+	utils.SetCompressionThreshold(validated.GetCompressPayloadsOverBytes())
+	wsm.triggerOnConfigPushed(validated)
+
+	log.Printf("Applied config revision %d: %d fields changed, %d need a restart", revision, len(applied), len(restartRequired))
+
+	corrected := config.ChangedFields(pushed, validated)
+	if applied == nil {
+		applied = []string{}
+	}
+	if corrected == nil {
+		corrected = []string{}
+	}
+	ack["status"] = ConfigPushApplied
+	ack["applied_fields"] = applied
+	ack["corrected_fields"] = corrected
+	ack["restart_required_fields"] = restartRequired
+	wsm.sendResponse(c, MessageTypeConfigPushAck, ack)
+}
@@ -0,0 +1,273 @@
+package ws
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/state"
+)
+
+func TestConfigPush(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	t.Setenv("MSC_CONFIG_PATH", env.TempDir)
+
+	// Start from a validated config, as loaded on start, so only pushed changes are reported
+	env.Config.ClientID = "5b0c6f4e-3a53-4f0e-9b8e-2f1a4d7c9e10"
+	env.Config.RestoreDisplayOrientationsOnStart = true
+	env.Config.PairingRetryOnDisconnect = true
+	validated, err := config.ValidateConfig(env.Config)
+	if err != nil {
+		t.Fatalf("Failed to validate config: %v", err)
+	}
+	env.Config = validated
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	acks := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case string(MessageTypeConfigPushAck):
+			acks <- message
+		}
+	})
+
+	pushed := make(chan config.ClientConfig, 10)
+	env.WSManager.SetOnConfigPushed(func(cfg config.ClientConfig) { pushed <- cfg })
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	sendPush := func(message map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		message["type"] = string(MessageTypeConfigPush)
+		if err := env.MockServer.SendMessage(message); err != nil {
+			t.Fatalf("Failed to send config push: %v", err)
+		}
+		select {
+		case ack := <-acks:
+			return ack
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for config_push_ack")
+			return nil
+		}
+	}
+
+	// sign signs document like the server, or returns "" for revisions the client rejects anyway
+	sign := func(revision interface{}, document map[string]interface{}) string {
+		t.Helper()
+		number, ok := revision.(int)
+		if !ok {
+			return ""
+		}
+		data, err := json.Marshal(document)
+		if err != nil {
+			t.Fatalf("Failed to encode document: %v", err)
+		}
+		signature, err := SignConfigPush(state.GetSessionKey(), int64(number), data)
+		if err != nil {
+			t.Fatalf("Failed to sign document: %v", err)
+		}
+		return signature
+	}
+
+	push := func(revision interface{}, document map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		return sendPush(map[string]interface{}{
+			"revision":  revision,
+			"config":    document,
+			"signature": sign(revision, document),
+		})
+	}
+
+	fields := func(ack map[string]interface{}, key string) []string {
+		values, _ := ack[key].([]interface{})
+		result := make([]string, 0, len(values))
+		for _, value := range values {
+			result = append(result, value.(string))
+		}
+		return result
+	}
+
+	t.Run("Valid document", func(t *testing.T) {
+		ack := push(5, map[string]interface{}{
+			"client_id":         "pushed-client-id",
+			"device_name":       "Lobby",
+			"remote_log_level":  "error",
+			"max_ip_violations": -1,
+		})
+		if ack["status"] != ConfigPushApplied {
+			t.Fatalf("Expected the push to be applied, got %v", ack)
+		}
+		if ack["revision"] != float64(5) {
+			t.Errorf("Expected revision 5 in the ack, got %v", ack["revision"])
+		}
+
+		applied := fields(ack, "applied_fields")
+		if !slices.Contains(applied, "device_name") || slices.Contains(applied, "client_id") {
+			t.Errorf("Unexpected applied fields %v", applied)
+		}
+		if corrected := fields(ack, "corrected_fields"); !slices.Contains(corrected, "max_ip_violations") {
+			t.Errorf("Expected max_ip_violations to be corrected, got %v", corrected)
+		}
+		if restart := fields(ack, "restart_required_fields"); !slices.Equal(restart, []string{"remote_log_level"}) {
+			t.Errorf("Expected remote_log_level to need a restart, got %v", restart)
+		}
+
+		cfg := env.WSManager.Config()
+		if cfg.DeviceName != "Lobby" || cfg.ClientID != env.Config.ClientID {
+			t.Errorf("Expected the pushed device name and the original client ID, got %q and %q", cfg.DeviceName, cfg.ClientID)
+		}
+		select {
+		case cfg := <-pushed:
+			if cfg.DeviceName != "Lobby" {
+				t.Errorf("Expected the callback to get the pushed config, got %+v", cfg)
+			}
+		default:
+			t.Error("Expected the config pushed callback to run")
+		}
+		if saved, err := config.LoadOrCreateConfig(); err != nil || saved.DeviceName != "Lobby" {
+			t.Errorf("Expected the pushed config to be saved, got %q (%v)", saved.DeviceName, err)
+		}
+		if revision := state.GetConfigRevision(); revision != 5 {
+			t.Errorf("Expected revision 5 to be persisted, got %d", revision)
+		}
+	})
+
+	t.Run("Stale revision", func(t *testing.T) {
+		ack := push(4, map[string]interface{}{"device_name": "Foyer"})
+		if ack["status"] != ConfigPushRejected || ack["last_revision"] != float64(5) {
+			t.Errorf("Expected a stale revision to be rejected, got %v", ack)
+		}
+		if name := env.WSManager.Config().DeviceName; name != "Lobby" {
+			t.Errorf("A rejected push must not change the config, got device name %q", name)
+		}
+	})
+
+	t.Run("Invalid documents", func(t *testing.T) {
+		if ack := push(6, map[string]interface{}{"device_nmae": "Foyer"}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected an unknown field to be rejected, got %v", ack)
+		}
+		if ack := push(6, map[string]interface{}{"status_update_interval": "soon"}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected a mistyped field to be rejected, got %v", ack)
+		}
+		if ack := push(1.5, map[string]interface{}{"device_name": "Foyer"}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected a fractional revision to be rejected, got %v", ack)
+		}
+		if ack := push(6, nil); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected a missing document to be rejected, got %v", ack)
+		}
+		if revision := state.GetConfigRevision(); revision != 5 {
+			t.Errorf("Rejected pushes must not change the revision, got %d", revision)
+		}
+	})
+
+	t.Run("Signature", func(t *testing.T) {
+		document := map[string]interface{}{"device_name": "Foyer"}
+		if ack := sendPush(map[string]interface{}{"revision": 6, "config": document}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected an unsigned document to be rejected, got %v", ack)
+		}
+		if ack := sendPush(map[string]interface{}{
+			"revision":  7,
+			"config":    document,
+			"signature": sign(6, document),
+		}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected a signature for another revision to be rejected, got %v", ack)
+		}
+		if ack := sendPush(map[string]interface{}{
+			"revision":  6,
+			"config":    map[string]interface{}{"device_name": "Attacker"},
+			"signature": sign(6, document),
+		}); ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected a modified document to be rejected, got %v", ack)
+		}
+		if name := env.WSManager.Config().DeviceName; name != "Lobby" {
+			t.Errorf("A rejected push must not change the config, got device name %q", name)
+		}
+	})
+
+	t.Run("Protected fields", func(t *testing.T) {
+		ack := push(6, map[string]interface{}{
+			"device_name":       "Foyer",
+			"allow_file_browse": true,
+			"startup_script":    "/tmp/evil.sh",
+			"reboot_command":    []interface{}{"sh", "-c", "id"},
+			"exec_policy":       map[string]interface{}{"run_as_user": "root"},
+		})
+		if ack["status"] != ConfigPushApplied {
+			t.Fatalf("Expected the push to be applied, got %v", ack)
+		}
+		corrected := fields(ack, "corrected_fields")
+		for _, field := range []string{"allow_file_browse", "startup_script", "reboot_command", "exec_policy"} {
+			if !slices.Contains(corrected, field) {
+				t.Errorf("Expected %s to be reported as corrected, got %v", field, corrected)
+			}
+			if slices.Contains(fields(ack, "applied_fields"), field) {
+				t.Errorf("Protected field %s must not be applied", field)
+			}
+		}
+
+		cfg := env.WSManager.Config()
+		if cfg.DeviceName != "Foyer" {
+			t.Errorf("Expected the other fields to be applied, got device name %q", cfg.DeviceName)
+		}
+		if cfg.AllowFileBrowse || cfg.StartupScript != "" || cfg.ExecPolicy.RunAsUser != "" || slices.Contains(cfg.RebootCommand, "id") {
+			t.Errorf("Expected the local security settings to be kept, got %+v", cfg)
+		}
+		if saved, err := config.LoadPersistedConfig(""); err != nil || saved.AllowFileBrowse || saved.StartupScript != "" {
+			t.Errorf("Expected the protected fields not to be saved, got %+v (%v)", saved, err)
+		}
+	})
+
+	t.Run("Revision not recorded", func(t *testing.T) {
+		// A directory in the way of the state file's temp file makes saving it fail
+		blocker := state.FilePath() + ".tmp"
+		if err := os.Mkdir(blocker, 0700); err != nil {
+			t.Fatalf("Failed to block the state file: %v", err)
+		}
+		ack := push(7, map[string]interface{}{"device_name": "Hall"})
+		if err := os.Remove(blocker); err != nil {
+			t.Fatalf("Failed to unblock the state file: %v", err)
+		}
+
+		if ack["status"] != ConfigPushRejected {
+			t.Errorf("Expected the push to be rejected when its revision cannot be recorded, got %v", ack)
+		}
+		if name := env.WSManager.Config().DeviceName; name != "Foyer" {
+			t.Errorf("A rejected push must not change the config, got device name %q", name)
+		}
+		if saved, err := config.LoadPersistedConfig(""); err != nil || saved.DeviceName != "Foyer" {
+			t.Errorf("A rejected push must not be saved, got %q (%v)", saved.DeviceName, err)
+		}
+	})
+
+	t.Run("Environment overrides", func(t *testing.T) {
+		t.Setenv("MSM_DISABLE_COMMANDS", "true")
+		if ack := push(8, map[string]interface{}{"device_name": "Foyer"}); ack["status"] != ConfigPushApplied {
+			t.Fatalf("Expected the push to be applied, got %v", ack)
+		}
+		if !env.WSManager.Config().DisableCommands {
+			t.Error("Expected the env override in the config in use")
+		}
+		if saved, err := config.LoadPersistedConfig(""); err != nil || saved.DisableCommands || saved.DeviceName != "Foyer" {
+			t.Errorf("Expected the pushed document saved without the env override, got %+v (%v)", saved, err)
+		}
+	})
+}
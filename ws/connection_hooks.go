@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// Connection hook events, passed to the scripts in MSM_EVENT
+const (
+	HookEventConnected    = "connected"
+	HookEventDisconnected = "disconnected"
+	HookEventDeactivated  = "deactivated"
+)
+
+// connectionHookMinInterval is the minimum time between two runs of the same
+// hook script, so a flapping connection does not start a storm of scripts.
+// It is a variable so tests can shorten it.
+var connectionHookMinInterval = 10 * time.Second
+
+// connectionHookLimiter tracks when each hook script last started and whether
+// it is still running. A run that may not start yet is kept as the script's
+// trailing run and started once it may, so the script always sees the latest
+// event. The connect and disconnect hooks share an entry when they use the
+// same script.
+type connectionHookLimiter struct {
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+	running map[string]bool
+	pending map[string]func()      // Trailing run of each script, the latest event wins
+	timers  map[string]*time.Timer // Waits out connectionHookMinInterval for the trailing run
+}
+
+// schedule starts run for script in the background if the script is not
+// running and last started at least connectionHookMinInterval ago. Otherwise
+// run replaces the script's trailing run and starts once the current run has
+// finished and the interval has passed. It reports whether run started now.
+func (l *connectionHookLimiter) schedule(script string, run func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lastRun == nil {
+		l.lastRun = make(map[string]time.Time)
+		l.running = make(map[string]bool)
+		l.pending = make(map[string]func())
+		l.timers = make(map[string]*time.Timer)
+	}
+	if last, ok := l.lastRun[script]; l.running[script] || (ok && time.Since(last) < connectionHookMinInterval) {
+		l.pending[script] = run
+		l.startPendingLocked(script)
+		return false
+	}
+	delete(l.pending, script)
+	l.startLocked(script, run)
+	return true
+}
+
+// startLocked records the start of script and runs it; l.mu must be held
+func (l *connectionHookLimiter) startLocked(script string, run func()) {
+	l.lastRun[script] = time.Now()
+	l.running[script] = true
+	go func() {
+		defer l.release(script)
+		run()
+	}()
+}
+
+// startPendingLocked starts the trailing run of script if it may start now, or
+// sets a timer for when it may; l.mu must be held. A running script starts its
+// trailing run from release.
+func (l *connectionHookLimiter) startPendingLocked(script string) {
+	run, ok := l.pending[script]
+	if !ok || l.running[script] || l.timers[script] != nil {
+		return
+	}
+	if wait := connectionHookMinInterval - time.Since(l.lastRun[script]); wait > 0 {
+		l.timers[script] = time.AfterFunc(wait, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			delete(l.timers, script)
+			l.startPendingLocked(script)
+		})
+		return
+	}
+	delete(l.pending, script)
+	l.startLocked(script, run)
+}
+
+// release marks script as finished and schedules its trailing run, if any
+func (l *connectionHookLimiter) release(script string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.running, script)
+	l.startPendingLocked(script)
+}
+
+// runConnectionHook runs the connect or disconnect script of cfg for event in
+// the background. The script gets MSM_EVENT, MSM_SERVER_URL (without
+// credentials) and MSM_REASON in its environment. While the previous run of
+// the script is active or started less than connectionHookMinInterval ago the
+// run is deferred, and only the latest deferred event runs; failures are only
+// logged.
+func (wsm *WebSocketManager) runConnectionHook(cfg config.ClientConfig, event, serverWs, reason string) {
+	script := cfg.OnDisconnectScriptPath
+	if event == HookEventConnected {
+		script = cfg.OnConnectScriptPath
+	}
+	if script == "" {
+		return
+	}
+	if wsm.isTestMode() {
+		log.Printf("Test mode: %s hook %s not run", event, script)
+		return
+	}
+
+	executor := wsm.executorFor(cfg)
+	run := func() {
+		// env sets the variables on top of the environment the executor allows
+		output, err := executor.CombinedOutput("env",
+			"MSM_EVENT="+event,
+			"MSM_SERVER_URL="+utils.RedactURL(serverWs),
+			"MSM_REASON="+reason,
+			script)
+		if err != nil {
+			log.Printf("Warning: %s hook %s failed: %v (output: %s)", event, script, err, output)
+			return
+		}
+		log.Printf("Ran %s hook %s", event, script)
+	}
+	if !wsm.connectionHooks.schedule(script, run) {
+		log.Printf("Deferring %s hook %s: it ran less than %v ago or is still running", event, script, connectionHookMinInterval)
+	}
+}
+
+// runDisconnectHook runs the disconnect script for a connection that ended for
+// reason, or as a deactivation when the server deactivated the device. An
+// encrypted deactivated message is handled by handleMessage, so the connection
+// may end through any path after it.
+func (wsm *WebSocketManager) runDisconnectHook(cfg config.ClientConfig, serverWs, reason string) {
+	if message, ok := wsm.takeDeactivation(); ok {
+		wsm.runConnectionHook(cfg, HookEventDeactivated, serverWs, message)
+		return
+	}
+	wsm.runConnectionHook(cfg, HookEventDisconnected, serverWs, reason)
+}
+
+// takeDeactivation returns and clears the message of the last deactivation,
+// reporting whether the server deactivated the device since it was last taken
+func (wsm *WebSocketManager) takeDeactivation() (string, bool) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	if wsm.deactivation == nil {
+		return "", false
+	}
+	reason := *wsm.deactivation
+	wsm.deactivation = nil
+	return reason, true
+}
@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// hookExecutor delivers each command it is asked to run on calls
+type hookExecutor struct {
+	calls chan []string
+}
+
+func (h *hookExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	h.calls <- append([]string{name}, args...)
+	return nil, nil
+}
+
+func TestConnectionHookLimiter(t *testing.T) {
+	originalInterval := connectionHookMinInterval
+	connectionHookMinInterval = 100 * time.Millisecond
+	defer func() { connectionHookMinInterval = originalInterval }()
+
+	var limiter connectionHookLimiter
+	runs := make(chan string, 10)
+	unblock := make(chan struct{})
+	run := func(event string) func() {
+		return func() {
+			runs <- event
+			if event == "connected" {
+				<-unblock
+			}
+		}
+	}
+	expectRun := func(expected string) {
+		t.Helper()
+		select {
+		case event := <-runs:
+			if event != expected {
+				t.Errorf("Expected the %s run, got %s", expected, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for the %s run", expected)
+		}
+	}
+
+	if !limiter.schedule("/opt/hook.sh", run("connected")) {
+		t.Fatal("The first run should start")
+	}
+	expectRun("connected")
+	if !limiter.schedule("/opt/other.sh", run("other")) {
+		t.Error("Other scripts should not be limited")
+	}
+	expectRun("other")
+
+	// Runs while the script is active are deferred, only the latest is kept
+	if limiter.schedule("/opt/hook.sh", run("disconnected")) {
+		t.Error("A run should be deferred while the previous one is active")
+	}
+	if limiter.schedule("/opt/hook.sh", run("deactivated")) {
+		t.Error("A run should be deferred while the previous one is active")
+	}
+	started := time.Now()
+	close(unblock)
+	expectRun("deactivated")
+	if elapsed := time.Since(started); elapsed < connectionHookMinInterval/2 {
+		t.Errorf("Expected the trailing run to wait for the minimum interval, it started after %v", elapsed)
+	}
+
+	// A run within the minimum interval is deferred rather than dropped
+	if limiter.schedule("/opt/hook.sh", run("disconnected")) {
+		t.Error("A run within the minimum interval should be deferred")
+	}
+	expectRun("disconnected")
+
+	select {
+	case event := <-runs:
+		t.Errorf("Unexpected %s run", event)
+	case <-time.After(2 * connectionHookMinInterval):
+	}
+}
+
+func TestConnectionHooks(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	originalInterval := connectionHookMinInterval
+	connectionHookMinInterval = 0
+	defer func() { connectionHookMinInterval = originalInterval }()
+
+	env.Config.OnConnectScriptPath = "/opt/on-connect.sh"
+	env.Config.OnDisconnectScriptPath = "/opt/on-disconnect.sh"
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Leave test mode so the hooks reach the executor
+	env.WSManager.SetTestMode(false)
+	executor := &hookExecutor{calls: make(chan []string, 10)}
+	env.WSManager.SetCommandExecutor(executor)
+
+	serverURL := env.MockServer.GetURL()
+	expectHook := func(event, reason, script string) {
+		t.Helper()
+		var call []string
+		select {
+		case call = <-executor.calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for the %s hook", event)
+		}
+		expected := []string{"env", "MSM_EVENT=" + event, "MSM_SERVER_URL=" + serverURL, "MSM_REASON=" + reason, script}
+		if strings.Join(call, " ") != strings.Join(expected, " ") {
+			t.Errorf("Expected %q, got %q", expected, call)
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		env.WSManager.ConnectWebSocket(env.Config, serverURL)
+	}()
+
+	expectHook(HookEventConnected, "", "/opt/on-connect.sh")
+
+	// Dropping the connection runs the disconnect hook, then the client reconnects
+	env.WSManager.GetConnection().Close()
+	expectHook(HookEventDisconnected, "connection_lost", "/opt/on-disconnect.sh")
+	expectHook(HookEventConnected, "", "/opt/on-connect.sh")
+
+	// Wait until the reconnected client is registered with the server
+	deadline := time.Now().Add(5 * time.Second)
+	for env.MockServer.GetConnectionCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":    "deactivated",
+		"message": "Removed from venue",
+	}); err != nil {
+		t.Fatalf("Failed to send deactivated message: %v", err)
+	}
+	expectHook(HookEventDeactivated, "Removed from venue", "/opt/on-disconnect.sh")
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectWebSocket should return after deactivation")
+	}
+
+	select {
+	case call := <-executor.calls:
+		t.Errorf("Unexpected hook run %q", call)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
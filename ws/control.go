@@ -0,0 +1,173 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"msm-client/control"
+)
+
+// Control socket verbs served by the WebSocket manager
+const (
+	ControlVerbReconnect  = "connection.reconnect"
+	ControlVerbDisconnect = "connection.disconnect"
+	ControlVerbResume     = "connection.resume"
+)
+
+// ErrConnectionHeld is returned by RequestReconnect while the connection is
+// held down by Hold
+var ErrConnectionHeld = errors.New("connection is held disconnected until resumed")
+
+// heldPollInterval is how often a held connection loop checks for shutdown
+const heldPollInterval = time.Second
+
+// DisconnectParams are the params of the connection.disconnect verb
+type DisconnectParams struct {
+	UntilRestart bool `json:"until_restart"` // Must be set; the connection stays down until resumed or restarted
+}
+
+// ConnectionControlResult reports the connection after a connection verb
+type ConnectionControlResult struct {
+	Connected bool `json:"connected"`
+	Held      bool `json:"held"` // Disconnected by connection.disconnect and not yet resumed
+}
+
+// Hold closes the connection, telling the server the client is disconnecting,
+// and keeps ConnectWebSocket from dialling again until Resume is called or the
+// client restarts
+func (wsm *WebSocketManager) Hold() error {
+	wsm.mu.Lock()
+	if !wsm.held {
+		wsm.held = true
+		wsm.resumed = make(chan struct{})
+	}
+	wsm.mu.Unlock()
+	log.Println("Disconnect requested, holding the WebSocket down until resumed")
+
+	// A retry waiting out its backoff goes straight to the hold
+	wsm.wakeRedial()
+
+	if conn := wsm.GetConnection(); conn != nil && wsm.IsConnected() {
+		return wsm.DisconnectWebSocket(conn, true)
+	}
+	return nil
+}
+
+// Resume lets ConnectWebSocket dial again after Hold. It reports whether the
+// connection was held.
+func (wsm *WebSocketManager) Resume() bool {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	if !wsm.held {
+		return false
+	}
+	wsm.held = false
+	close(wsm.resumed)
+	wsm.resumed = nil
+	log.Println("Resume requested, reconnecting WebSocket")
+	return true
+}
+
+// IsHeld reports whether the connection is held down by Hold
+func (wsm *WebSocketManager) IsHeld() bool {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.held
+}
+
+// waitWhileHeld blocks while the connection is held. It returns false when
+// shutdown is initiated while waiting.
+func (wsm *WebSocketManager) waitWhileHeld() bool {
+	wsm.mu.RLock()
+	held, resumed := wsm.held, wsm.resumed
+	wsm.mu.RUnlock()
+	if !held {
+		return true
+	}
+
+	log.Println("WebSocket held disconnected, waiting for resume")
+	ticker := time.NewTicker(heldPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-resumed:
+			return true
+		case <-ticker.C:
+			if wsm.IsShutdown() {
+				return false
+			}
+		}
+	}
+}
+
+// wakeRedial ends the backoff wait of a failed dial, if one is in progress
+func (wsm *WebSocketManager) wakeRedial() {
+	select {
+	case wsm.redial <- struct{}{}:
+	default:
+	}
+}
+
+// waitBackoff waits for delay before the next dial. It returns true when a
+// reconnect request cut the wait short.
+func (wsm *WebSocketManager) waitBackoff(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-wsm.redial:
+		return true
+	}
+}
+
+// clearRedial drops a reconnect request that was not needed to cut a backoff short
+func (wsm *WebSocketManager) clearRedial() {
+	select {
+	case <-wsm.redial:
+	default:
+	}
+}
+
+// connectionControlResult describes the connection for a control verb reply
+func (wsm *WebSocketManager) connectionControlResult() ConnectionControlResult {
+	return ConnectionControlResult{
+		Connected: wsm.IsConnected(),
+		Held:      wsm.IsHeld(),
+	}
+}
+
+// RegisterControlHandlers serves the connection verbs on the control server
+func (wsm *WebSocketManager) RegisterControlHandlers(server *control.Server) {
+	server.Handle(ControlVerbReconnect, func(_ json.RawMessage) (interface{}, error) {
+		if err := wsm.RequestReconnect(); err != nil {
+			return nil, err
+		}
+		return wsm.connectionControlResult(), nil
+	})
+
+	server.Handle(ControlVerbDisconnect, func(raw json.RawMessage) (interface{}, error) {
+		var params DisconnectParams
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &params); err != nil {
+				return nil, err
+			}
+		}
+		if !params.UntilRestart {
+			return nil, errors.New("disconnect keeps the connection down until resumed or restarted and requires until_restart; use reconnect to re-dial")
+		}
+		if err := wsm.Hold(); err != nil {
+			return nil, err
+		}
+		return wsm.connectionControlResult(), nil
+	})
+
+	server.Handle(ControlVerbResume, func(_ json.RawMessage) (interface{}, error) {
+		if !wsm.Resume() {
+			return nil, errors.New("connection is not held")
+		}
+		return wsm.connectionControlResult(), nil
+	})
+}
@@ -0,0 +1,131 @@
+package ws
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msm-client/control"
+)
+
+func TestConnectionControlVerbs(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	server := control.NewServer(socketPath)
+	env.WSManager.RegisterControlHandlers(server)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+
+	call := func(verb string, params interface{}) (ConnectionControlResult, error) {
+		var result ConnectionControlResult
+		err := control.CallPath(socketPath, verb, params, &result)
+		return result, err
+	}
+
+	waitFor := func(condition func() bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if condition() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	if _, err := call(ControlVerbReconnect, nil); err == nil {
+		t.Error("Expected reconnect to fail before the client connects")
+	}
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	if !waitFor(func() bool { return env.WSManager.IsConnected() && env.MockServer.GetConnectionCount() == 1 }) {
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	if _, err := call(ControlVerbReconnect, nil); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	if !waitFor(func() bool { return env.MockServer.GetConnectionCount() == 2 && env.WSManager.IsConnected() }) {
+		t.Fatalf("Timeout waiting for reconnection (connections: %d)", env.MockServer.GetConnectionCount())
+	}
+	if count := env.WSManager.ReconnectCount(); count != 1 {
+		t.Errorf("Expected reconnect count 1, got %d", count)
+	}
+
+	if _, err := call(ControlVerbDisconnect, nil); err == nil {
+		t.Error("Expected disconnect without until_restart to fail")
+	}
+	if _, err := call(ControlVerbResume, nil); err == nil {
+		t.Error("Expected resume to fail while the connection is not held")
+	}
+
+	result, err := call(ControlVerbDisconnect, DisconnectParams{UntilRestart: true})
+	if err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	if !result.Held {
+		t.Errorf("Expected the connection to be held, got %+v", result)
+	}
+	if !waitFor(func() bool { return !env.WSManager.IsConnected() }) {
+		t.Fatal("Timeout waiting for the connection to close")
+	}
+
+	// Held connections stay down and refuse reconnect
+	time.Sleep(500 * time.Millisecond)
+	if count := env.MockServer.GetConnectionCount(); count != 2 {
+		t.Errorf("Expected no new connections while held, got %d", count)
+	}
+	if _, err := call(ControlVerbReconnect, nil); err == nil {
+		t.Error("Expected reconnect to fail while held")
+	}
+	if env.WSManager.IsShutdown() {
+		t.Error("Disconnect must not set the shutdown flag")
+	}
+
+	if _, err := call(ControlVerbResume, nil); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if !waitFor(func() bool { return env.MockServer.GetConnectionCount() == 3 && env.WSManager.IsConnected() }) {
+		t.Fatalf("Timeout waiting for reconnection after resume (connections: %d)", env.MockServer.GetConnectionCount())
+	}
+	if env.WSManager.IsHeld() {
+		t.Error("Expected the connection not to be held after resume")
+	}
+}
+
+func TestReconnectCutsBackoffShort(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.connectLoopActive.Store(true)
+
+	woken := make(chan bool, 1)
+	go func() { woken <- wsm.waitBackoff(time.Minute) }()
+
+	if err := wsm.RequestReconnect(); err != nil {
+		t.Fatalf("RequestReconnect failed while retrying: %v", err)
+	}
+	select {
+	case cut := <-woken:
+		if !cut {
+			t.Error("Expected the backoff to report it was cut short")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reconnect did not cut the backoff short")
+	}
+
+	// A request that arrives while connected does not shorten a later backoff
+	wsm.wakeRedial()
+	wsm.clearRedial()
+	if wsm.waitBackoff(10 * time.Millisecond) {
+		t.Error("Expected a cleared reconnect request not to cut the backoff short")
+	}
+}
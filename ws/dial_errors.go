@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrorCategory classifies a dial failure to decide whether reconnecting can succeed
+type ErrorCategory int
+
+const (
+	// CategoryTransient errors may clear up on their own; keep retrying with backoff
+	CategoryTransient ErrorCategory = iota
+	// CategoryPermanent errors will not clear up by retrying; the client must pair again
+	CategoryPermanent
+	// CategoryAuthFailure means the server rejected the session credentials at its URL.
+	// The session key is cleared and the dial retried once; if the server still
+	// rejects the client it must pair again, as for CategoryPermanent.
+	CategoryAuthFailure
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryPermanent:
+		return "permanent"
+	case CategoryAuthFailure:
+		return "auth_failure"
+	default:
+		return "transient"
+	}
+}
+
+// dialWebSocket opens the WebSocket connection with dialer; tests replace it to simulate dial errors
+var dialWebSocket = func(dialer *websocket.Dialer, url string, headers http.Header) (*websocket.Conn, *http.Response, error) {
+	return dialer.Dial(url, headers)
+}
+
+// SetCustomDialer sets the dialer used by ConnectWebSocket, for example one with
+// a Proxy or NetDialContext, and headers added to every handshake, such as a
+// User-Agent. The client's protocol headers are always set on top of them. A
+// nil dialer restores websocket.DefaultDialer.
+func (wsm *WebSocketManager) SetCustomDialer(d *websocket.Dialer, headers http.Header) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.dialer = d
+	wsm.dialHeaders = headers.Clone()
+}
+
+// dialSettings returns the dialer and a copy of the custom handshake headers
+func (wsm *WebSocketManager) dialSettings() (*websocket.Dialer, http.Header) {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	dialer := wsm.dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	headers := wsm.dialHeaders.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	return dialer, headers
+}
+
+// handshakeStatusError records the HTTP status of a rejected WebSocket handshake
+type handshakeStatusError struct {
+	StatusCode int
+}
+
+func (e *handshakeStatusError) Error() string {
+	return fmt.Sprintf("%v: HTTP %d", websocket.ErrBadHandshake, e.StatusCode)
+}
+
+func (e *handshakeStatusError) Unwrap() error {
+	return websocket.ErrBadHandshake
+}
+
+// withHandshakeStatus attaches the response status to a bad handshake error
+func withHandshakeStatus(err error, resp *http.Response) error {
+	if resp != nil && errors.Is(err, websocket.ErrBadHandshake) {
+		return &handshakeStatusError{StatusCode: resp.StatusCode}
+	}
+	return err
+}
+
+// categorizeDialError classifies an error returned while dialling the server.
+// Certificate errors and 403 Forbidden are permanent, 401 Unauthorized is an
+// auth failure and everything else, including refused or timed out
+// connections, is transient.
+func categorizeDialError(err error) ErrorCategory {
+	var statusErr *handshakeStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized:
+			return CategoryAuthFailure
+		case http.StatusForbidden:
+			return CategoryPermanent
+		}
+		return CategoryTransient
+	}
+
+	var (
+		verificationErr *tls.CertificateVerificationError
+		unknownAuthErr  x509.UnknownAuthorityError
+		hostnameErr     x509.HostnameError
+		invalidCertErr  x509.CertificateInvalidError
+	)
+	if errors.As(err, &verificationErr) || errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidCertErr) {
+		return CategoryPermanent
+	}
+	return CategoryTransient
+}
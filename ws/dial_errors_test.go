@@ -0,0 +1,177 @@
+package ws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"msm-client/state"
+)
+
+func TestCategorizeDialError(t *testing.T) {
+	handshake := func(status int) error {
+		return withHandshakeStatus(websocket.ErrBadHandshake, &http.Response{StatusCode: status})
+	}
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorCategory
+	}{
+		{"connection refused", dialErr(syscall.ECONNREFUSED), CategoryTransient},
+		{"connection timed out", dialErr(syscall.ETIMEDOUT), CategoryTransient},
+		{"server error", handshake(http.StatusBadGateway), CategoryTransient},
+		{"bad handshake without response", websocket.ErrBadHandshake, CategoryTransient},
+		{"unauthorized", handshake(http.StatusUnauthorized), CategoryAuthFailure},
+		{"forbidden", handshake(http.StatusForbidden), CategoryPermanent},
+		{"unknown certificate authority", &url.Error{Op: "Get", URL: "wss://server", Err: x509.UnknownAuthorityError{}}, CategoryPermanent},
+		{"certificate verification", &tls.CertificateVerificationError{Err: x509.HostnameError{Host: "server"}}, CategoryPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if category := categorizeDialError(tt.err); category != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, category)
+			}
+		})
+	}
+}
+
+// mockDial replaces dialWebSocket with dial for the duration of the test
+func mockDial(t *testing.T, dial func(url string, headers http.Header) (*websocket.Conn, *http.Response, error)) {
+	t.Helper()
+	original := dialWebSocket
+	dialWebSocket = func(_ *websocket.Dialer, url string, headers http.Header) (*websocket.Conn, *http.Response, error) {
+		return dial(url, headers)
+	}
+	t.Cleanup(func() { dialWebSocket = original })
+}
+
+func TestConnectWebSocketStopsOnPermanentError(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	dials := 0
+	mockDial(t, func(string, http.Header) (*websocket.Conn, *http.Response, error) {
+		dials++
+		return nil, nil, &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		env.WSManager.SetShutdown()
+		t.Fatal("ConnectWebSocket kept retrying after a permanent error")
+	}
+	if dials != 1 {
+		t.Errorf("Expected a single dial, got %d", dials)
+	}
+	if state.HasState() {
+		t.Error("Expected state to be deleted so pairing restarts")
+	}
+}
+
+func TestConnectWebSocketAuthFailureClearsSessionKey(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	serverWs := env.MockServer.GetURL()
+	if err := state.SaveState(state.PairedState{ServerWs: serverWs, SessionKey: state.GetSessionKey()}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	mockDial(t, func(string, http.Header) (*websocket.Conn, *http.Response, error) {
+		// Stop after this attempt
+		env.WSManager.SetShutdown()
+		return nil, &http.Response{StatusCode: http.StatusUnauthorized}, websocket.ErrBadHandshake
+	})
+
+	done := make(chan struct{})
+	go func() {
+		env.WSManager.ConnectWebSocket(env.Config, serverWs)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for ConnectWebSocket to return")
+	}
+
+	saved, err := state.LoadState()
+	if err != nil {
+		t.Fatalf("Expected state to be kept: %v", err)
+	}
+	if saved.SessionKey != "" {
+		t.Error("Expected the rejected session key to be cleared")
+	}
+	if saved.ServerWs != serverWs {
+		t.Errorf("Expected server URL %s to be kept, got %s", serverWs, saved.ServerWs)
+	}
+}
+
+func TestConnectWebSocketRepeatedAuthFailureUnpairs(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	var attempts atomic.Int32
+	mockDial(t, func(string, http.Header) (*websocket.Conn, *http.Response, error) {
+		if attempts.Add(1) > 2 {
+			env.WSManager.SetShutdown()
+		}
+		return nil, &http.Response{StatusCode: http.StatusUnauthorized}, websocket.ErrBadHandshake
+	})
+
+	done := make(chan struct{})
+	go func() {
+		env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for ConnectWebSocket to return")
+	}
+
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("Expected 2 dial attempts, got %d", got)
+	}
+	if state.HasState() {
+		t.Error("Expected the state to be deleted once the client was rejected without a session key")
+	}
+}
+
+func TestHandshakeStatusErrorUnwraps(t *testing.T) {
+	err := withHandshakeStatus(websocket.ErrBadHandshake, &http.Response{StatusCode: http.StatusForbidden})
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Errorf("Expected %v to wrap websocket.ErrBadHandshake", err)
+	}
+}
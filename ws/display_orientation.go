@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+
+	"msm-client/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// applyDisplayOrientation rotates screenID to angle degrees with the screen switch script
+func (wsm *WebSocketManager) applyDisplayOrientation(cfg config.ClientConfig, screenID string, angle int) error {
+	output, err := wsm.executeScreenCommandWith(cfg, "rotate", screenID, strconv.Itoa(angle))
+	if err != nil {
+		log.Printf("Failed to rotate screen %s to %d degrees: %v", screenID, angle, err)
+		log.Printf("Command output: %s", output)
+		return err
+	}
+	log.Printf("ms-switch rotate output: %s", output)
+	return nil
+}
+
+// RestoreDisplayOrientations reapplies the orientations saved in cfg, unless
+// RestoreDisplayOrientationsOnStart is off. Failures are logged and skipped.
+func (wsm *WebSocketManager) RestoreDisplayOrientations(cfg config.ClientConfig) {
+	if !cfg.RestoreDisplayOrientationsOnStart {
+		return
+	}
+	for _, screenID := range slices.Sorted(maps.Keys(cfg.DisplayOrientations)) {
+		angle := cfg.DisplayOrientations[screenID]
+		log.Printf("Restoring orientation of screen %s to %d degrees", screenID, angle)
+		_ = wsm.applyDisplayOrientation(cfg, screenID, angle)
+	}
+}
+
+// handleSetDisplayOrientation rotates params.screen_id to params.angle and saves
+// the orientation in the config so it is restored after a reboot
+func (wsm *WebSocketManager) handleSetDisplayOrientation(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	screenID, _ := params["screen_id"].(string)
+	angle, ok := params["angle"].(float64)
+	if screenID == "" || !ok || angle != float64(int(angle)) || !config.IsValidDisplayAngle(int(angle)) {
+		log.Printf("Set display orientation command has invalid params: %v", params)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSetDisplayOrientation,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Invalid params: screen_id and angle (0, 90, 180 or 270) are required",
+		})
+		return
+	}
+
+	log.Printf("Setting orientation of screen %s to %d degrees", screenID, int(angle))
+	wsm.mu.RLock()
+	cfg := wsm.clientConfig
+	wsm.mu.RUnlock()
+
+	if wsm.isTestMode() {
+		log.Println("Test mode: Display orientation saved but not applied")
+	} else if err := wsm.applyDisplayOrientation(cfg, screenID, int(angle)); err != nil {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSetDisplayOrientation,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Failed to execute ms-switch rotate command",
+		})
+		return
+	}
+
+	// The map is copied so the config handed to ConnectWebSocket is not modified
+	wsm.mu.Lock()
+	orientations := maps.Clone(wsm.clientConfig.DisplayOrientations)
+	if orientations == nil {
+		orientations = make(map[string]int)
+	}
+	orientations[screenID] = int(angle)
+	wsm.clientConfig.DisplayOrientations = orientations
+	wsm.mu.Unlock()
+
+	// Only the orientation is written; the in-memory config carries overrides
+	persisted, err := config.LoadPersistedConfig(cfg.ClientID)
+	if err == nil {
+		persisted.DisplayOrientations = maps.Clone(persisted.DisplayOrientations)
+		if persisted.DisplayOrientations == nil {
+			persisted.DisplayOrientations = make(map[string]int)
+		}
+		persisted.DisplayOrientations[screenID] = int(angle)
+		err = config.SaveConfig(persisted)
+	}
+	if err != nil {
+		log.Printf("Failed to save display orientation: %v", err)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSetDisplayOrientation,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Orientation applied but could not be saved: " + err.Error(),
+		})
+		return
+	}
+
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandSetDisplayOrientation,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       map[string]interface{}{"screen_id": screenID, "angle": int(angle)},
+	})
+}
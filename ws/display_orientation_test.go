@@ -0,0 +1,118 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+)
+
+func TestSetDisplayOrientation(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	t.Setenv("MSC_CONFIG_PATH", env.TempDir)
+
+	env.Config.DisableCommands = false
+	env.Config.RestoreDisplayOrientationsOnStart = true
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Leave test mode so the rotation reaches the executor
+	env.WSManager.SetTestMode(false)
+	recorder := &recordingExecutor{}
+	env.WSManager.SetCommandExecutor(recorder)
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	setOrientation := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    string(CommandSetDisplayOrientation),
+			"command_id": "orientation-1",
+			"params":     params,
+		}); err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for command response")
+			return nil
+		}
+	}
+
+	response := setOrientation(map[string]interface{}{"screen_id": "2", "angle": 45})
+	if response["status"] != string(StatusError) {
+		t.Errorf("Expected an error for a 45 degree rotation, got %v", response)
+	}
+
+	response = setOrientation(map[string]interface{}{"screen_id": "2", "angle": 90})
+	if response["status"] != string(StatusSuccess) {
+		t.Fatalf("Expected success, got %v", response)
+	}
+	if len(recorder.calls) != 1 || strings.Join(recorder.calls[0], " ") != env.Config.ScreenSwitchPath+" rotate 2 90" {
+		t.Errorf("Unexpected executor calls: %v", recorder.calls)
+	}
+
+	// The orientation survives a restart
+	cfg, err := config.LoadOrCreateConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.DisplayOrientations["2"] != 90 {
+		t.Errorf("Expected saved orientation 90 for screen 2, got %v", cfg.DisplayOrientations)
+	}
+	if env.Config.DisplayOrientations != nil {
+		t.Error("The config passed to ConnectWebSocket should not be modified")
+	}
+}
+
+func TestRestoreDisplayOrientations(t *testing.T) {
+	wsm := NewWebSocketManager()
+	recorder := &recordingExecutor{}
+	wsm.SetCommandExecutor(recorder)
+
+	cfg := config.ClientConfig{
+		ScreenSwitchPath:                  "/opt/screen-switch.sh",
+		DisplayOrientations:               map[string]int{"2": 90, "1": 180},
+		RestoreDisplayOrientationsOnStart: true,
+	}
+	wsm.RestoreDisplayOrientations(cfg)
+
+	if len(recorder.calls) != 2 ||
+		strings.Join(recorder.calls[0], " ") != "/opt/screen-switch.sh rotate 1 180" ||
+		strings.Join(recorder.calls[1], " ") != "/opt/screen-switch.sh rotate 2 90" {
+		t.Errorf("Unexpected executor calls: %v", recorder.calls)
+	}
+
+	recorder.calls = nil
+	cfg.RestoreDisplayOrientationsOnStart = false
+	wsm.RestoreDisplayOrientations(cfg)
+	if len(recorder.calls) != 0 {
+		t.Errorf("Orientations should not be restored when disabled, got %v", recorder.calls)
+	}
+}
@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"msm-client/config"
+)
+
+// CommandExecutor runs external commands on behalf of the client
+type CommandExecutor interface {
+	// CombinedOutput runs the command and returns its combined stdout and stderr
+	CombinedOutput(name string, args ...string) ([]byte, error)
+}
+
+// policyExecutor runs commands under a config.ExecPolicy
+type policyExecutor struct {
+	policy config.ExecPolicy
+}
+
+// NewPolicyExecutor returns a CommandExecutor that applies policy to every command:
+// a scrubbed environment, optional working directory and user, and resource limits.
+func NewPolicyExecutor(policy config.ExecPolicy) CommandExecutor {
+	return &policyExecutor{policy: policy}
+}
+
+// ExecHelperArg, as the first argument, makes the client binary act as the
+// resource limit helper instead of starting; main hands it to RunExecHelper
+const ExecHelperArg = "__exec-with-limits"
+
+// command builds the exec.Cmd for name with the policy's environment, directory,
+// credentials and resource limits
+func (e *policyExecutor) command(name string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.Command(name, args...)
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	cmd.Env = scrubEnvironment(os.Environ(), e.policy.GetEnvAllowlist())
+	cmd.Dir = e.policy.WorkingDir
+	if err := applyCredential(cmd, e.policy.RunAsUser); err != nil {
+		return nil, err
+	}
+	if err := wrapWithLimits(cmd, e.policy); err != nil {
+		return nil, err
+	}
+	// A process group of its own lets the watchdog kill the command with its children
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+func (e *policyExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	cmd, err := e.command(name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	id := runningCommands.add(name, cmd.Process)
+	defer runningCommands.remove(id)
+
+	err = cmd.Wait()
+	return output.Bytes(), err
+}
+
+// scrubEnvironment returns the entries of environ whose names are in allowlist
+func scrubEnvironment(environ []string, allowlist []string) []string {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, name := range allowlist {
+		allowed[name] = true
+	}
+
+	env := []string{}
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if allowed[name] {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// dryRunExecutor logs commands instead of running them
+type dryRunExecutor struct{}
+
+func (dryRunExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	log.Printf("Dry run: would execute %q", append([]string{name}, args...))
+	return nil, nil
+}
+
+// commandUsesExecutor lists the commands whose responses are flagged in dry-run mode
+var commandUsesExecutor = map[CommandType]bool{
+	CommandReboot:                true,
+	CommandShutdown:              true,
+	CommandScreenList:            true,
+	CommandScreenSwitch:          true,
+	CommandScreenReload:          true,
+	CommandSetDisplayOrientation: true,
+	CommandSendTestNotification:  true,
+}
+
+// isDryRun returns whether external commands are logged instead of executed
+func (wsm *WebSocketManager) isDryRun() bool {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.clientConfig.DryRun
+}
+
+// markDryRun flags successful responses to executor commands so the server can
+// tell nothing was executed
+func (wsm *WebSocketManager) markDryRun(data map[string]interface{}) {
+	if !wsm.isDryRun() || !commandUsesExecutor[CommandType(fmt.Sprint(data["command"]))] {
+		return
+	}
+	if status, _ := data["status"].(ResponseStatus); status == StatusError {
+		return
+	}
+	data["dry_run"] = true
+}
+
+// commandExecutor returns a dry-run executor in dry-run mode, otherwise the executor
+// set with SetCommandExecutor or one built from the current configuration's exec policy
+func (wsm *WebSocketManager) commandExecutor() CommandExecutor {
+	wsm.mu.RLock()
+	cfg := wsm.clientConfig
+	wsm.mu.RUnlock()
+	return wsm.executorFor(cfg)
+}
+
+// executorFor returns the executor commandExecutor would use under cfg
+func (wsm *WebSocketManager) executorFor(cfg config.ClientConfig) CommandExecutor {
+	if cfg.DryRun {
+		return dryRunExecutor{}
+	}
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	if wsm.executor != nil {
+		return wsm.executor
+	}
+	return NewPolicyExecutor(cfg.ExecPolicy)
+}
+
+// SetCommandExecutor overrides how external commands are run; nil restores the policy executor
+func (wsm *WebSocketManager) SetCommandExecutor(executor CommandExecutor) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.executor = executor
+}
@@ -0,0 +1,140 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"msm-client/config"
+)
+
+// System hooks, overridable in tests
+var (
+	geteuid       = os.Geteuid
+	lookupUser    = user.Lookup
+	setrlimitFunc = unix.Setrlimit
+)
+
+// applyCredential makes cmd run as username when the client runs as root
+func applyCredential(cmd *exec.Cmd, username string) error {
+	if username == "" {
+		return nil
+	}
+	if geteuid() != 0 {
+		log.Printf("Not running as root, ignoring exec policy user %s", username)
+		return nil
+	}
+
+	u, err := lookupUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to look up exec policy user %s: %w", username, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid for user %s: %w", username, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid for user %s: %w", username, err)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	return nil
+}
+
+// setProcessGroup starts cmd in a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the process group led by process
+func killProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
+
+// wrapWithLimits makes cmd start through the client binary acting as the limit
+// helper. The helper sets the policy's limits on itself and then execs the
+// command, so the limits are in place before the command's first instruction.
+// Setting them with prlimit after Start would leave a window in which the
+// command, or children it forks, run unlimited.
+func wrapWithLimits(cmd *exec.Cmd, policy config.ExecPolicy) error {
+	if !policy.HasLimits() {
+		return nil
+	}
+	helper, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("failed to locate limit helper: %w", err)
+	}
+
+	args := []string{
+		helper, ExecHelperArg,
+		strconv.FormatUint(policy.CPUSeconds, 10),
+		strconv.FormatUint(policy.MaxMemoryBytes, 10),
+		strconv.FormatUint(policy.MaxProcesses, 10),
+		cmd.Path,
+	}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = helper
+	return nil
+}
+
+// RunExecHelper is the limit helper started by wrapWithLimits. args are the CPU,
+// memory and process limits, the program path and its argv. It never returns:
+// on success the process is replaced by the program, otherwise it exits with 126.
+func RunExecHelper(args []string) {
+	if len(args) < 5 {
+		fmt.Fprintln(os.Stderr, "exec helper: missing arguments")
+		os.Exit(126)
+	}
+	var limits [3]uint64
+	for i := range limits {
+		value, err := strconv.ParseUint(args[i], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "exec helper: invalid limit %q\n", args[i])
+			os.Exit(126)
+		}
+		limits[i] = value
+	}
+	policy := config.ExecPolicy{CPUSeconds: limits[0], MaxMemoryBytes: limits[1], MaxProcesses: limits[2]}
+	if err := applyRlimits(policy); err != nil {
+		fmt.Fprintf(os.Stderr, "exec helper: %v\n", err)
+		os.Exit(126)
+	}
+
+	err := syscall.Exec(args[3], args[4:], os.Environ())
+	fmt.Fprintf(os.Stderr, "exec helper: %s: %v\n", args[3], err)
+	os.Exit(126)
+}
+
+// applyRlimits sets the policy's CPU, memory and process limits on the current process
+func applyRlimits(policy config.ExecPolicy) error {
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"cpu", unix.RLIMIT_CPU, policy.CPUSeconds},
+		{"memory", unix.RLIMIT_AS, policy.MaxMemoryBytes},
+		{"process", unix.RLIMIT_NPROC, policy.MaxProcesses},
+	}
+	for _, l := range limits {
+		if l.value == 0 {
+			continue
+		}
+		if err := setrlimitFunc(l.resource, &unix.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("%s limit: %w", l.name, err)
+		}
+	}
+	return nil
+}
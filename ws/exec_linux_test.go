@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestApplyCredential(t *testing.T) {
+	origGeteuid, origLookupUser := geteuid, lookupUser
+	defer func() { geteuid, lookupUser = origGeteuid, origLookupUser }()
+
+	lookupUser = func(name string) (*user.User, error) {
+		if name != "mediascreen" {
+			return nil, user.UnknownUserError(name)
+		}
+		return &user.User{Username: name, Uid: "1001", Gid: "1002"}, nil
+	}
+
+	t.Run("Root drops to user", func(t *testing.T) {
+		geteuid = func() int { return 0 }
+		cmd := exec.Command("true")
+		if err := applyCredential(cmd, "mediascreen"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+			t.Fatal("Expected credential to be set")
+		}
+		if cred := cmd.SysProcAttr.Credential; cred.Uid != 1001 || cred.Gid != 1002 {
+			t.Errorf("Expected uid 1001 gid 1002, got uid %d gid %d", cred.Uid, cred.Gid)
+		}
+	})
+
+	t.Run("Non-root ignores user", func(t *testing.T) {
+		geteuid = func() int { return 1000 }
+		cmd := exec.Command("true")
+		if err := applyCredential(cmd, "mediascreen"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cmd.SysProcAttr != nil {
+			t.Error("Credential should not be set when not running as root")
+		}
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		geteuid = func() int { return 0 }
+		if err := applyCredential(exec.Command("true"), "nobody-here"); err == nil {
+			t.Error("Expected error for unknown user")
+		}
+	})
+
+	t.Run("No user configured", func(t *testing.T) {
+		geteuid = func() int { return 0 }
+		cmd := exec.Command("true")
+		if err := applyCredential(cmd, ""); err != nil || cmd.SysProcAttr != nil {
+			t.Errorf("Expected no credential, got %v (err %v)", cmd.SysProcAttr, err)
+		}
+	})
+}
+
+func TestMain(m *testing.M) {
+	// Commands with resource limits re-execute the test binary as the limit helper
+	if len(os.Args) > 1 && os.Args[1] == ExecHelperArg {
+		RunExecHelper(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func TestApplyRlimits(t *testing.T) {
+	origSetrlimit := setrlimitFunc
+	defer func() { setrlimitFunc = origSetrlimit }()
+
+	type call struct {
+		resource int
+		limit    unix.Rlimit
+	}
+	var calls []call
+	setrlimitFunc = func(resource int, limit *unix.Rlimit) error {
+		calls = append(calls, call{resource, *limit})
+		return nil
+	}
+
+	if err := applyRlimits(config.ExecPolicy{CPUSeconds: 10, MaxMemoryBytes: 256 << 20, MaxProcesses: 64}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []call{
+		{unix.RLIMIT_CPU, unix.Rlimit{Cur: 10, Max: 10}},
+		{unix.RLIMIT_AS, unix.Rlimit{Cur: 256 << 20, Max: 256 << 20}},
+		{unix.RLIMIT_NPROC, unix.Rlimit{Cur: 64, Max: 64}},
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %d setrlimit calls, got %d", len(expected), len(calls))
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Call %d: expected %+v, got %+v", i, expected[i], calls[i])
+		}
+	}
+
+	calls = nil
+	if err := applyRlimits(config.ExecPolicy{}); err != nil || len(calls) != 0 {
+		t.Errorf("Expected no setrlimit calls without limits, got %d (err %v)", len(calls), err)
+	}
+
+	setrlimitFunc = func(int, *unix.Rlimit) error { return errors.New("not permitted") }
+	if err := applyRlimits(config.ExecPolicy{CPUSeconds: 1}); err == nil {
+		t.Error("Expected setrlimit error to be returned")
+	}
+}
+
+func TestPolicyExecutorLimits(t *testing.T) {
+	policy := config.ExecPolicy{CPUSeconds: 7, MaxProcesses: 4096}
+
+	t.Run("Limits set before the command runs", func(t *testing.T) {
+		output, err := NewPolicyExecutor(policy).CombinedOutput("cat", "/proc/self/limits")
+		if err != nil {
+			t.Fatalf("Command failed: %v (%s)", err, output)
+		}
+		limits := map[string][]string{}
+		for _, line := range strings.Split(string(output), "\n") {
+			if name, values, ok := strings.Cut(line, "  "); ok {
+				limits[name] = strings.Fields(values)
+			}
+		}
+		if got := limits["Max cpu time"]; len(got) < 2 || got[0] != "7" || got[1] != "7" {
+			t.Errorf("Expected cpu time limit 7, got %v", got)
+		}
+		if got := limits["Max processes"]; len(got) < 2 || got[0] != "4096" || got[1] != "4096" {
+			t.Errorf("Expected process limit 4096, got %v", got)
+		}
+	})
+
+	t.Run("Arguments passed through", func(t *testing.T) {
+		output, err := NewPolicyExecutor(policy).CombinedOutput("echo", "hello", "world")
+		if err != nil {
+			t.Fatalf("Command failed: %v", err)
+		}
+		if strings.TrimSpace(string(output)) != "hello world" {
+			t.Errorf("Expected %q, got %q", "hello world", output)
+		}
+	})
+
+	t.Run("Unknown command", func(t *testing.T) {
+		if _, err := NewPolicyExecutor(policy).CombinedOutput("no-such-command-here"); err == nil {
+			t.Error("Expected an error for an unknown command")
+		}
+	})
+}
+
+func TestWatchdogKillsStuckCommand(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.clientConfig.CommandTimeout = 10 * time.Millisecond
+
+	// The shell leaves a child behind, which must be killed with it
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewPolicyExecutor(config.ExecPolicy{}).CombinedOutput("sh", "-c", "sleep 60 & sleep 60")
+		done <- err
+	}()
+
+	watchdog := utils.NewWatchdog()
+	for _, check := range wsm.WatchdogChecks() {
+		watchdog.Add(check)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		violated := watchdog.RunChecks()
+		if len(violated) == 1 && violated[0] == config.WatchdogCheckCommands {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the commands check to fail")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the killed command to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The stuck command was not killed")
+	}
+	if err := wsm.checkRunningCommands(); err != nil {
+		t.Errorf("Expected no stuck commands after the kill, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+
+package ws
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+
+	"msm-client/config"
+)
+
+// applyCredential is a no-op on platforms without setuid support in this client
+func applyCredential(_ *exec.Cmd, username string) error {
+	if username != "" {
+		log.Printf("Exec policy user %s is only supported on Linux, ignoring", username)
+	}
+	return nil
+}
+
+// setProcessGroup is a no-op on platforms without process groups in this client
+func setProcessGroup(_ *exec.Cmd) {}
+
+// killProcessGroup kills process; its children are not tracked on this platform
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
+
+// wrapWithLimits is a no-op on platforms without setrlimit in this client
+func wrapWithLimits(_ *exec.Cmd, policy config.ExecPolicy) error {
+	if policy.HasLimits() {
+		log.Println("Exec policy resource limits are only supported on Linux, ignoring")
+	}
+	return nil
+}
+
+// RunExecHelper exits with an error; wrapWithLimits never starts the helper on this platform
+func RunExecHelper(_ []string) {
+	fmt.Fprintln(os.Stderr, "exec helper: resource limits are only supported on Linux")
+	os.Exit(126)
+}
@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"msm-client/config"
+)
+
+func TestScrubEnvironment(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "SECRET_TOKEN=abc", "HOME=/root", "EMPTY=", "MALFORMED"}
+
+	env := scrubEnvironment(environ, []string{"PATH", "HOME", "EMPTY"})
+	expected := []string{"PATH=/usr/bin", "HOME=/root", "EMPTY="}
+	if strings.Join(env, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+
+	if env := scrubEnvironment(environ, []string{}); len(env) != 0 {
+		t.Errorf("Expected empty environment for empty allowlist, got %v", env)
+	}
+}
+
+func TestPolicyExecutorScrubsEnvironment(t *testing.T) {
+	os.Setenv("MSM_TEST_SECRET", "should-not-leak")
+	defer os.Unsetenv("MSM_TEST_SECRET")
+	os.Setenv("MSM_TEST_ALLOWED", "passed-through")
+	defer os.Unsetenv("MSM_TEST_ALLOWED")
+
+	executor := NewPolicyExecutor(config.ExecPolicy{
+		EnvAllowlist: []string{"PATH", "MSM_TEST_ALLOWED"},
+		WorkingDir:   t.TempDir(),
+	})
+
+	output, err := executor.CombinedOutput("/usr/bin/env")
+	if err != nil {
+		t.Fatalf("Failed to run env: %v", err)
+	}
+
+	childEnv := string(output)
+	if strings.Contains(childEnv, "MSM_TEST_SECRET") {
+		t.Errorf("Child environment should not contain MSM_TEST_SECRET:\n%s", childEnv)
+	}
+	if !strings.Contains(childEnv, "MSM_TEST_ALLOWED=passed-through") {
+		t.Errorf("Child environment should contain MSM_TEST_ALLOWED:\n%s", childEnv)
+	}
+}
+
+func TestPolicyExecutorDefaultAllowlistKeepsDisplay(t *testing.T) {
+	t.Setenv("DISPLAY", ":0")
+	t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	t.Setenv("MSM_TEST_SECRET", "should-not-leak")
+
+	output, err := NewPolicyExecutor(config.ExecPolicy{}).CombinedOutput("/usr/bin/env")
+	if err != nil {
+		t.Fatalf("Failed to run env: %v", err)
+	}
+
+	childEnv := string(output)
+	for _, variable := range []string{"DISPLAY=:0", "WAYLAND_DISPLAY=wayland-0", "XDG_RUNTIME_DIR=/run/user/1000"} {
+		if !strings.Contains(childEnv, variable) {
+			t.Errorf("Child environment should contain %s:\n%s", variable, childEnv)
+		}
+	}
+	if strings.Contains(childEnv, "MSM_TEST_SECRET") {
+		t.Errorf("Child environment should not contain MSM_TEST_SECRET:\n%s", childEnv)
+	}
+}
+
+func TestPolicyExecutorWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	executor := NewPolicyExecutor(config.ExecPolicy{WorkingDir: dir})
+
+	output, err := executor.CombinedOutput("/bin/pwd")
+	if err != nil {
+		t.Fatalf("Failed to run pwd: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != dir {
+		t.Errorf("Expected working directory %s, got %s", dir, output)
+	}
+}
+
+type recordingExecutor struct {
+	calls [][]string
+}
+
+func (r *recordingExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return []byte("ok"), nil
+}
+
+func TestSetCommandExecutor(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.clientConfig = config.ClientConfig{ScreenSwitchPath: "/opt/screen-switch.sh"}
+
+	recorder := &recordingExecutor{}
+	wsm.SetCommandExecutor(recorder)
+
+	if _, err := wsm.executeScreenCommand("switch", "2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(recorder.calls) != 1 || strings.Join(recorder.calls[0], " ") != "/opt/screen-switch.sh switch 2" {
+		t.Errorf("Unexpected executor calls: %v", recorder.calls)
+	}
+
+	wsm.SetCommandExecutor(nil)
+	if _, ok := wsm.commandExecutor().(*policyExecutor); !ok {
+		t.Error("Expected policy executor after clearing the override")
+	}
+}
+
+func TestDryRunSkipsExecutor(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.DryRun = true
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Leave test mode so commands reach the executor
+	env.WSManager.SetTestMode(false)
+	recorder := &recordingExecutor{}
+	env.WSManager.SetCommandExecutor(recorder)
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	commands := []struct {
+		command        string
+		params         map[string]interface{}
+		expectedStatus ResponseStatus
+		expectDryRun   bool
+	}{
+		{"reboot", nil, StatusAcknowledged, true},
+		{"screen_list", nil, StatusSuccess, true},
+		{"screen_switch", map[string]interface{}{"screen_id": "2"}, StatusSuccess, true},
+		{"screen_reload", map[string]interface{}{"screen_id": "2"}, StatusSuccess, true},
+		{"status", nil, StatusSuccess, false},
+	}
+
+	for _, tc := range commands {
+		t.Run(tc.command, func(t *testing.T) {
+			message := map[string]interface{}{
+				"type":       "command",
+				"command":    tc.command,
+				"command_id": "dry-" + tc.command,
+			}
+			if tc.params != nil {
+				message["params"] = tc.params
+			}
+			if err := env.MockServer.SendMessage(message); err != nil {
+				t.Fatalf("Failed to send command: %v", err)
+			}
+
+			select {
+			case response := <-responses:
+				if response["command"] != tc.command || response["command_id"] != "dry-"+tc.command {
+					t.Errorf("Unexpected response: %v", response)
+				}
+				if response["status"] != string(tc.expectedStatus) {
+					t.Errorf("Expected status %s, got %v", tc.expectedStatus, response["status"])
+				}
+				if dryRun, _ := response["dry_run"].(bool); dryRun != tc.expectDryRun {
+					t.Errorf("Expected dry_run %v, got %v", tc.expectDryRun, response["dry_run"])
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timeout waiting for command response")
+			}
+
+			if tc.expectDryRun {
+				result, ok := env.WSManager.GetCommandResult("dry-" + tc.command)
+				if !ok || result.Status != StatusDryRun {
+					t.Errorf("Expected recorded status dry_run, got %+v", result)
+				}
+			}
+		})
+	}
+
+	if len(recorder.calls) != 0 {
+		t.Errorf("Expected no executor invocations in dry-run mode, got %v", recorder.calls)
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxListFilesEntries bounds the number of entries returned by list_files
+const maxListFilesEntries = 200
+
+// errPathNotAllowed is reported when list_files targets a path outside the allowed roots
+var errPathNotAllowed = errors.New("path not allowed")
+
+// FileEntry describes a directory entry returned by list_files
+type FileEntry struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	IsDir    bool   `json:"is_dir"`
+	Modified string `json:"modified"`
+}
+
+// hasParentReference reports whether path contains a ".." component
+func hasParentReference(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveBrowsePath validates that path is absolute, has no ".." components and,
+// after resolving symlinks, lies under one of allowedRoots. It returns the resolved path.
+func resolveBrowsePath(path string, allowedRoots []string) (string, error) {
+	if !filepath.IsAbs(path) || hasParentReference(path) {
+		return "", errPathNotAllowed
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range allowedRoots {
+		if !filepath.IsAbs(root) {
+			continue
+		}
+		resolvedRoot, err := filepath.EvalSymlinks(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		if resolved == resolvedRoot || strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", errPathNotAllowed
+}
+
+// listDirectory returns up to limit entries of dir and whether the listing was truncated
+func listDirectory(dir string, limit int) ([]FileEntry, bool, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := len(dirEntries) > limit
+	if truncated {
+		dirEntries = dirEntries[:limit]
+	}
+
+	entries := make([]FileEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		entry := FileEntry{Name: dirEntry.Name(), IsDir: dirEntry.IsDir()}
+		// Entries removed between ReadDir and Info are still listed, without size
+		if info, err := dirEntry.Info(); err == nil {
+			entry.Size = info.Size()
+			entry.Modified = info.ModTime().UTC().Format(time.RFC3339)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, truncated, nil
+}
+
+// handleListFiles lists the directory at params.path if it is under an allowed browse path
+func (wsm *WebSocketManager) handleListFiles(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	browseAllowed := wsm.clientConfig.AllowFileBrowse
+	allowedRoots := wsm.clientConfig.AllowedBrowsePaths
+	wsm.mu.RUnlock()
+
+	if !browseAllowed {
+		log.Println("File browsing disabled, rejecting list_files command")
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandListFiles,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "File browsing is disabled on this client",
+		})
+		return
+	}
+
+	path, _ := params["path"].(string)
+	if path == "" {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandListFiles,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Params field path missing",
+		})
+		return
+	}
+
+	dir, err := resolveBrowsePath(path, allowedRoots)
+	if err != nil {
+		log.Printf("List files rejected for %s: %v", path, err)
+		message := "Failed to resolve path"
+		if errors.Is(err, errPathNotAllowed) {
+			message = errPathNotAllowed.Error()
+		}
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandListFiles,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    message,
+		})
+		return
+	}
+
+	entries, truncated, err := listDirectory(dir, maxListFilesEntries)
+	if err != nil {
+		log.Printf("Failed to list %s: %v", dir, err)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandListFiles,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Failed to list directory",
+		})
+		return
+	}
+
+	log.Printf("Listed %d entries in %s", len(entries), dir)
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandListFiles,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       entries,
+		"truncated":  truncated,
+	})
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListFilesCommand(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	mediaDir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.png", "c.html"} {
+		if err := os.WriteFile(filepath.Join(mediaDir, name), []byte("content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	// A sibling directory outside the allowed root
+	outsideDir := t.TempDir()
+
+	env.Config.DisableCommands = false
+	env.Config.AllowFileBrowse = true
+	env.Config.AllowedBrowsePaths = []string{mediaDir}
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 5)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case message["command"] == string(CommandListFiles):
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	listFiles := func(path string) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    "list_files",
+			"command_id": "list-" + path,
+			"params":     map[string]interface{}{"path": path},
+		}); err != nil {
+			t.Fatalf("Failed to send list_files: %v", err)
+		}
+
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for list_files response")
+		}
+		return nil
+	}
+
+	response := listFiles(mediaDir)
+	if response["status"] != string(StatusSuccess) {
+		t.Fatalf("Expected success, got %v", response)
+	}
+	entries, ok := response["data"].([]interface{})
+	if !ok || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", response["data"])
+	}
+	first, _ := entries[0].(map[string]interface{})
+	if first["name"] != "a.mp4" || first["size"] != float64(len("content")) || first["is_dir"] != false {
+		t.Errorf("Unexpected first entry: %v", first)
+	}
+
+	for _, path := range []string{
+		filepath.Join(mediaDir, "..", filepath.Base(outsideDir)),
+		outsideDir,
+		"relative/path",
+	} {
+		response := listFiles(path)
+		if response["status"] != string(StatusError) || response["message"] != "path not allowed" {
+			t.Errorf("Expected %s to be rejected, got %v", path, response)
+		}
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestListDirectoryTruncates(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(dir, string(rune('a'+i))), nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	entries, truncated, err := listDirectory(dir, 3)
+	if err != nil {
+		t.Fatalf("listDirectory failed: %v", err)
+	}
+	if len(entries) != 3 || !truncated {
+		t.Errorf("Expected 3 entries and truncation, got %d (truncated %v)", len(entries), truncated)
+	}
+}
+
+func TestResolveBrowsePathRejectsSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	link := filepath.Join(root, "escape")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	if _, err := resolveBrowsePath(link, []string{root}); err != errPathNotAllowed {
+		t.Errorf("Expected symlink out of the allowed root to be rejected, got %v", err)
+	}
+}
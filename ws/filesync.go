@@ -0,0 +1,239 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"msm-client/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// fileDownloadTimeout bounds the time spent downloading a single file
+const fileDownloadTimeout = 5 * time.Minute
+
+// maxDownloadFileBytes bounds the size of a file fetched by download_file
+const maxDownloadFileBytes = 1 << 30
+
+// resolveDownloadPath validates that path is absolute, has no ".." components and
+// that its nearest existing ancestor, after resolving symlinks, lies under one of
+// allowedRoots. It returns the path rebased onto the resolved ancestor.
+func resolveDownloadPath(path string, allowedRoots []string) (string, error) {
+	if !filepath.IsAbs(path) || hasParentReference(path) {
+		return "", errPathNotAllowed
+	}
+	path = filepath.Clean(path)
+
+	existing := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", errPathNotAllowed
+		}
+		existing = parent
+	}
+
+	resolved, err := resolveBrowsePath(existing, allowedRoots)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(existing, path)
+	if err != nil {
+		return "", errPathNotAllowed
+	}
+	return filepath.Join(resolved, rel), nil
+}
+
+// downloadToPath fetches url into path through a temporary file in the same
+// directory, verifying expectedSHA256 when set. It returns the file's hash and size.
+func downloadToPath(url, path, expectedSHA256 string) (string, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	client := &http.Client{Timeout: fileDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(temp, hash), io.LimitReader(resp.Body, maxDownloadFileBytes+1))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	if size > maxDownloadFileBytes {
+		return "", 0, fmt.Errorf("file exceeds %d bytes", maxDownloadFileBytes)
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && actual != strings.ToLower(strings.TrimPrefix(expectedSHA256, "sha256:")) {
+		return "", 0, fmt.Errorf("checksum mismatch: expected %s, got %s", expectedSHA256, actual)
+	}
+
+	if err := temp.Chmod(0644); err != nil {
+		return "", 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return "", 0, fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return actual, size, nil
+}
+
+// handleDownloadFile downloads params.url to params.path, under an allowed download
+// path, in the background and reports the outcome when it finishes
+func (wsm *WebSocketManager) handleDownloadFile(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	allowedRoots := wsm.clientConfig.AllowedDownloadPaths
+	wsm.mu.RUnlock()
+
+	url, _ := params["url"].(string)
+	path, _ := params["path"].(string)
+	expectedSHA256, _ := params["sha256"].(string)
+	if url == "" || path == "" {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandDownloadFile,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Invalid params: url and path are required",
+		})
+		return
+	}
+
+	target, err := resolveDownloadPath(path, allowedRoots)
+	if err != nil {
+		log.Printf("Download rejected for %s: %v", path, err)
+		message := "Failed to resolve path"
+		if errors.Is(err, errPathNotAllowed) {
+			message = errPathNotAllowed.Error()
+		}
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandDownloadFile,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    message,
+		})
+		return
+	}
+
+	// Download in the background so the read loop is not blocked
+	go func() {
+		log.Printf("Downloading %s to %s", url, target)
+		hash, size, err := downloadToPath(url, target, expectedSHA256)
+		if err != nil {
+			log.Printf("Download to %s failed: %v", target, err)
+			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+				"command":    CommandDownloadFile,
+				"command_id": commandID,
+				"status":     StatusError,
+				"message":    err.Error(),
+			})
+			return
+		}
+
+		log.Printf("Downloaded %d bytes to %s", size, target)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandDownloadFile,
+			"command_id": commandID,
+			"status":     StatusSuccess,
+			"data": map[string]interface{}{
+				"path":   target,
+				"size":   size,
+				"sha256": hash,
+			},
+		})
+	}()
+}
+
+// handleFileSyncRequest answers with a manifest of the files under message.path,
+// which must be under an allowed download path, modified after message.since.
+// Like the commands it is refused while commands are disabled, and the manifest
+// is built in the background so hashing does not block the read loop.
+func (wsm *WebSocketManager) handleFileSyncRequest(c *websocket.Conn, message map[string]interface{}) {
+	wsm.mu.RLock()
+	allowedRoots := wsm.clientConfig.AllowedDownloadPaths
+	commandsDisabled := wsm.clientConfig.DisableCommands
+	wsm.mu.RUnlock()
+
+	path, _ := message["path"].(string)
+	sinceParam, _ := message["since"].(string)
+	response := map[string]interface{}{"path": path}
+	if requestID, ok := message["request_id"].(string); ok {
+		response["request_id"] = requestID
+	}
+
+	if commandsDisabled {
+		log.Printf("Command execution disabled, rejecting file sync request for %s", path)
+		response["error"] = "Command execution is disabled on this client"
+		wsm.sendResponse(c, MessageTypeFileSyncManifest, response)
+		return
+	}
+
+	var since time.Time
+	if sinceParam != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			response["error"] = "invalid since timestamp, expected RFC 3339"
+			wsm.sendResponse(c, MessageTypeFileSyncManifest, response)
+			return
+		}
+		since = parsed
+	}
+
+	root, err := resolveBrowsePath(path, allowedRoots)
+	if err != nil {
+		log.Printf("File sync rejected for %s: %v", path, err)
+		response["error"] = "Failed to resolve path"
+		if errors.Is(err, errPathNotAllowed) {
+			response["error"] = errPathNotAllowed.Error()
+		}
+		wsm.sendResponse(c, MessageTypeFileSyncManifest, response)
+		return
+	}
+
+	go func() {
+		entries, err := utils.GetFileSyncManifest(root, since)
+		truncated := errors.Is(err, utils.ErrFileSyncManifestTruncated)
+		if err != nil && !truncated {
+			log.Printf("Failed to build file sync manifest for %s: %v", root, err)
+			response["error"] = "Failed to build manifest"
+			wsm.sendResponse(c, MessageTypeFileSyncManifest, response)
+			return
+		}
+		if entries == nil {
+			entries = []utils.FileSyncEntry{}
+		}
+
+		log.Printf("File sync manifest for %s: %d files (truncated %v)", root, len(entries), truncated)
+		response["files"] = entries
+		response["truncated"] = truncated
+		wsm.sendResponse(c, MessageTypeFileSyncManifest, response)
+	}()
+}
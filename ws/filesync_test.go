@@ -0,0 +1,238 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSyncCycle(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	mediaDir := t.TempDir()
+	current := filepath.Join(mediaDir, "current.png")
+	stale := filepath.Join(mediaDir, "stale.mp4")
+	for path, content := range map[string]string{current: "unchanged", stale: "old content"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// The server's copy of the media directory
+	serverFiles := map[string]string{"current.png": "unchanged", "stale.mp4": "new content", "added.html": "added"}
+	serverHash := func(name string) string {
+		sum := sha256.Sum256([]byte(serverFiles[name]))
+		return hex.EncodeToString(sum[:])
+	}
+	contentServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := serverFiles[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer contentServer.Close()
+
+	env.Config.DisableCommands = false
+	env.Config.AllowedDownloadPaths = []string{mediaDir}
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	manifests := make(chan map[string]interface{}, 5)
+	downloads := make(chan map[string]interface{}, 5)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case message["type"] == string(MessageTypeFileSyncManifest):
+			manifests <- message
+		case message["command"] == string(CommandDownloadFile):
+			downloads <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	requestManifest := func(path string) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       string(MessageTypeFileSyncRequest),
+			"request_id": "sync-1",
+			"path":       path,
+			"since":      time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+		}); err != nil {
+			t.Fatalf("Failed to send file_sync_request: %v", err)
+		}
+		select {
+		case manifest := <-manifests:
+			return manifest
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for file sync manifest")
+		}
+		return nil
+	}
+
+	// Manifest of the client's files
+	manifest := requestManifest(mediaDir)
+	if manifest["request_id"] != "sync-1" || manifest["truncated"] != false {
+		t.Fatalf("Unexpected manifest: %v", manifest)
+	}
+	clientHashes := make(map[string]string)
+	files, _ := manifest["files"].([]interface{})
+	for _, file := range files {
+		entry, _ := file.(map[string]interface{})
+		path, _ := entry["path"].(string)
+		hash, _ := entry["sha256"].(string)
+		clientHashes[filepath.Base(path)] = hash
+	}
+	if len(clientHashes) != 2 {
+		t.Fatalf("Expected 2 files in the manifest, got %v", files)
+	}
+
+	// Download whatever differs from the server's copy
+	var pending int
+	for name := range serverFiles {
+		if clientHashes[name] == serverHash(name) {
+			continue
+		}
+		pending++
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    string(CommandDownloadFile),
+			"command_id": "download-" + name,
+			"params": map[string]interface{}{
+				"url":    contentServer.URL + "/" + name,
+				"path":   filepath.Join(mediaDir, name),
+				"sha256": serverHash(name),
+			},
+		}); err != nil {
+			t.Fatalf("Failed to send download_file: %v", err)
+		}
+	}
+	if pending != 2 {
+		t.Fatalf("Expected 2 files to differ, got %d", pending)
+	}
+	for i := 0; i < pending; i++ {
+		select {
+		case response := <-downloads:
+			if response["status"] != string(StatusSuccess) {
+				t.Errorf("Expected download success, got %v", response)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for download_file response")
+		}
+	}
+
+	// The client now matches the server
+	manifest = requestManifest(mediaDir)
+	files, _ = manifest["files"].([]interface{})
+	if len(files) != len(serverFiles) {
+		t.Fatalf("Expected %d files after sync, got %v", len(serverFiles), files)
+	}
+	for _, file := range files {
+		entry, _ := file.(map[string]interface{})
+		path, _ := entry["path"].(string)
+		if entry["sha256"] != serverHash(filepath.Base(path)) {
+			t.Errorf("File %s still differs after sync", path)
+		}
+	}
+
+	// Paths outside the allowed download paths are rejected
+	if manifest := requestManifest(t.TempDir()); manifest["error"] != "path not allowed" {
+		t.Errorf("Expected sync outside allowed paths to be rejected, got %v", manifest)
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestFileSyncRequestCommandsDisabled(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	mediaDir := t.TempDir()
+	env.Config.AllowedDownloadPaths = []string{mediaDir}
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	manifests := make(chan map[string]interface{}, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case string(MessageTypeFileSyncManifest):
+			manifests <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":       string(MessageTypeFileSyncRequest),
+		"request_id": "sync-1",
+		"path":       mediaDir,
+	}); err != nil {
+		t.Fatalf("Failed to send file_sync_request: %v", err)
+	}
+	select {
+	case manifest := <-manifests:
+		if manifest["error"] != "Command execution is disabled on this client" || manifest["files"] != nil {
+			t.Errorf("Expected the request to be refused, got %v", manifest)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for file sync manifest")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestResolveDownloadPath(t *testing.T) {
+	root := t.TempDir()
+
+	target, err := resolveDownloadPath(filepath.Join(root, "new", "dir", "file.mp4"), []string{root})
+	if err != nil {
+		t.Fatalf("Expected a new file under the root to be allowed, got %v", err)
+	}
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	if target != filepath.Join(resolvedRoot, "new", "dir", "file.mp4") {
+		t.Errorf("Unexpected target %s", target)
+	}
+
+	for _, path := range []string{
+		filepath.Join(root, "..", "escape.mp4"),
+		filepath.Join(t.TempDir(), "file.mp4"),
+		"relative/file.mp4",
+	} {
+		if _, err := resolveDownloadPath(path, []string{root}); err != errPathNotAllowed {
+			t.Errorf("Expected %s to be rejected, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"msm-client/state"
+)
+
+func TestEncodeDecodeFrame(t *testing.T) {
+	payload := []byte(`{"type":"ping"}`)
+
+	for messageType, code := range frameTypeCodes {
+		frame := encodeFrame(messageType, payload)
+		if frame[0] != code {
+			t.Errorf("%s: expected type byte 0x%02x, got 0x%02x", messageType, code, frame[0])
+		}
+
+		decodedType, decodedPayload, err := decodeFrame(frame)
+		if err != nil {
+			t.Fatalf("%s: decodeFrame failed: %v", messageType, err)
+		}
+		if decodedType != messageType {
+			t.Errorf("Expected type %s, got %s", messageType, decodedType)
+		}
+		if !bytes.Equal(decodedPayload, payload) {
+			t.Errorf("%s: payload changed in round trip: %q", messageType, decodedPayload)
+		}
+	}
+
+	// Types without a code are sent as unknown and read from the payload
+	frame := encodeFrame("custom", payload)
+	if frame[0] != frameTypeUnknown {
+		t.Errorf("Expected unknown type byte, got 0x%02x", frame[0])
+	}
+	if decodedType, _, _ := decodeFrame(frame); decodedType != "" {
+		t.Errorf("Expected empty type for unknown byte, got %s", decodedType)
+	}
+
+	if _, _, err := decodeFrame(nil); err == nil {
+		t.Error("Expected error for empty frame")
+	}
+}
+
+func TestBinaryFramingNegotiated(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	env.MockServer.EnableBinaryFraming()
+	env.Config.UseBinaryFraming = true
+
+	messages := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		messages <- message
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	if got := env.MockServer.GetHeaders().Get(BinaryFramingHeader); got != binaryFramingVersion {
+		t.Errorf("Expected client to offer binary framing, got header %q", got)
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":      "ping",
+		"timestamp": time.Now().Unix(),
+	}); err != nil {
+		t.Fatalf("Failed to send ping: %v", err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case message := <-messages:
+			if message["type"] != string(MessageTypePong) {
+				continue
+			}
+			if env.MockServer.GetBinaryFrameCount() < 2 {
+				t.Errorf("Expected status and pong as binary frames, got %d", env.MockServer.GetBinaryFrameCount())
+			}
+			env.WSManager.ShutdownWebSocket(false)
+			return
+		case <-deadline:
+			t.Fatal("Timeout waiting for pong to framed ping")
+		}
+	}
+}
+
+func TestBinaryFramingRequiresServerEcho(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// The client offers framing but the server does not accept it
+	env.Config.UseBinaryFraming = true
+
+	messages := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		messages <- message
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	if count := env.MockServer.GetBinaryFrameCount(); count != 0 {
+		t.Errorf("Expected JSON text frames without server echo, got %d binary frames", count)
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestFramedPingRequiresEncryption(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	env.MockServer.EnableBinaryFraming()
+	env.Config.UseBinaryFraming = true
+
+	messages := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		messages <- message
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	// The ping type byte alone must not be trusted; the payload is not encrypted
+	frame := encodeFrame(MessageTypePing, []byte(`{"type":"ping"}`))
+	if err := env.MockServer.SendRawFrame(websocket.BinaryMessage, frame); err != nil {
+		t.Fatalf("Failed to send framed ping: %v", err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for state.HasState() {
+		select {
+		case message := <-messages:
+			if message["type"] == string(MessageTypePong) {
+				t.Fatal("Expected no pong to an unencrypted framed ping")
+			}
+		case <-deadline:
+			t.Fatal("Expected an unencrypted framed ping to be rejected like any unencrypted message")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// latencySampleSize is the number of round trips kept for the latency stats
+const latencySampleSize = 100
+
+// LatencyStats summarizes the round trips of client pings, in milliseconds
+type LatencyStats struct {
+	LastMs     float64 `json:"last"`
+	AvgMs      float64 `json:"avg"`
+	P95Ms      float64 `json:"p95"`
+	Samples    int     `json:"samples"`
+	Unanswered int     `json:"unanswered"` // Consecutive client pings the server has not answered
+}
+
+// pendingPing is a client ping waiting for its pong
+type pendingPing struct {
+	nonce string
+	sent  time.Time
+}
+
+// latencyTracker matches pongs to client pings and keeps the latest round trips
+type latencyTracker struct {
+	mu      sync.Mutex
+	pending []pendingPing // Oldest first
+	samples []time.Duration
+	next    int // Index of the sample to overwrite once samples is full
+	last    time.Duration
+	missed  int
+}
+
+// reset forgets the pings of a previous connection; round trips are kept
+func (lt *latencyTracker) reset() {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.pending = nil
+	lt.missed = 0
+}
+
+// sent records a ping sent with nonce at the given time
+func (lt *latencyTracker) sent(nonce string, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.pending = append(lt.pending, pendingPing{nonce: nonce, sent: at})
+}
+
+// answered records the pong for nonce and returns the round trip. An empty
+// nonce answers the oldest pending ping.
+func (lt *latencyTracker) answered(nonce string, at time.Time) (time.Duration, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	index := 0
+	if nonce != "" {
+		index = slices.IndexFunc(lt.pending, func(p pendingPing) bool { return p.nonce == nonce })
+	}
+	if index < 0 || index >= len(lt.pending) {
+		return 0, false
+	}
+
+	rtt := at.Sub(lt.pending[index].sent)
+	lt.pending = slices.Delete(lt.pending, index, index+1)
+	lt.missed = 0
+	lt.last = rtt
+	if len(lt.samples) < latencySampleSize {
+		lt.samples = append(lt.samples, rtt)
+	} else {
+		lt.samples[lt.next] = rtt
+		lt.next = (lt.next + 1) % latencySampleSize
+	}
+	return rtt, true
+}
+
+// expire counts pings pending for longer than timeout as missed and returns
+// the number of consecutive missed pings
+func (lt *latencyTracker) expire(at time.Time, timeout time.Duration) int {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for len(lt.pending) > 0 && at.Sub(lt.pending[0].sent) >= timeout {
+		lt.pending = lt.pending[1:]
+		lt.missed++
+	}
+	return lt.missed
+}
+
+// stats returns the latency stats and false when no round trip was measured yet
+func (lt *latencyTracker) stats() (LatencyStats, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(lt.samples) == 0 {
+		return LatencyStats{Unanswered: lt.missed}, false
+	}
+
+	sorted := slices.Clone(lt.samples)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+
+	return LatencyStats{
+		LastMs:     milliseconds(lt.last),
+		AvgMs:      milliseconds(total / time.Duration(len(sorted))),
+		P95Ms:      milliseconds(p95),
+		Samples:    len(sorted),
+		Unanswered: lt.missed,
+	}, true
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// sendClientPing counts pings unanswered for a full interval as missed and
+// sends a ping with a fresh nonce. It returns false when too many pings in a
+// row went unanswered and the connection should be re-dialled.
+func (wsm *WebSocketManager) sendClientPing(c *websocket.Conn, interval time.Duration, maxMissed int) bool {
+	now := time.Now()
+	if missed := wsm.latency.expire(now, interval); missed >= maxMissed {
+		log.Printf("%d consecutive client pings unanswered, closing WebSocket connection to reconnect", missed)
+		return false
+	}
+
+	nonce := uuid.New().String()
+	wsm.latency.sent(nonce, now)
+	if err := wsm.sendResponse(c, MessageTypePing, map[string]interface{}{
+		"nonce":     nonce,
+		"timestamp": now.Unix(),
+	}); err != nil {
+		log.Printf("Failed to send client ping: %v", err)
+	}
+	return true
+}
+
+// handlePong records the round trip of the client ping a server pong answers
+func (wsm *WebSocketManager) handlePong(message map[string]interface{}) {
+	nonce, _ := message["nonce"].(string)
+	if nonce == "" {
+		// Pongs to server pings, or from servers that don't echo the nonce
+		return
+	}
+	if _, ok := wsm.latency.answered(nonce, time.Now()); !ok {
+		log.Printf("Received pong for unknown ping %q", nonce)
+	}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientPingLatencyInStatus(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.ClientPingInterval = 50 * time.Millisecond
+
+	latencies := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] != "status" {
+			return
+		}
+		if latency, ok := message["latency_ms"].(map[string]interface{}); ok {
+			select {
+			case latencies <- latency:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	var latency map[string]interface{}
+	select {
+	case latency = <-latencies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a status with latency stats")
+	}
+
+	if samples, _ := latency["samples"].(float64); samples < 1 {
+		t.Errorf("Expected round trip samples, got %v", latency)
+	}
+	avg, _ := latency["avg"].(float64)
+	p95, _ := latency["p95"].(float64)
+	if avg <= 0 || p95 < avg {
+		t.Errorf("Expected a positive average no larger than the 95th percentile, got %v", latency)
+	}
+	if latency["unanswered"] != float64(0) {
+		t.Errorf("Expected every ping answered, got %v", latency)
+	}
+
+	pings := 0
+	for _, message := range env.MockServer.GetMessages() {
+		if message["type"] == "ping" {
+			pings++
+			if nonce, _ := message["nonce"].(string); nonce == "" {
+				t.Errorf("Expected client pings to carry a nonce, got %v", message)
+			}
+		}
+	}
+	if pings == 0 {
+		t.Error("Expected the client to send pings")
+	}
+}
+
+func TestUnansweredClientPingsReconnect(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.ClientPingInterval = 50 * time.Millisecond
+	env.Config.ClientPingMaxMissed = 2
+	env.MockServer.SetAnswerPings(false)
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for env.MockServer.GetConnectionCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected unanswered pings to close the connection and reconnect")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	var tracker latencyTracker
+	start := time.Now()
+
+	if _, ok := tracker.stats(); ok {
+		t.Error("Expected no stats before any round trip")
+	}
+
+	for i := 1; i <= 20; i++ {
+		nonce := string(rune('a' + i))
+		tracker.sent(nonce, start)
+		if _, ok := tracker.answered(nonce, start.Add(time.Duration(i)*time.Millisecond)); !ok {
+			t.Fatalf("Expected the pong for %q to match", nonce)
+		}
+	}
+	if _, ok := tracker.answered("unknown", start); ok {
+		t.Error("Expected a pong for an unknown nonce not to match")
+	}
+
+	stats, ok := tracker.stats()
+	if !ok || stats.Samples != 20 || stats.LastMs != 20 || stats.AvgMs != 10.5 || stats.P95Ms != 19 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Pongs without a nonce answer the oldest ping
+	tracker.sent("first", start)
+	tracker.sent("second", start.Add(time.Millisecond))
+	if rtt, ok := tracker.answered("", start.Add(5*time.Millisecond)); !ok || rtt != 5*time.Millisecond {
+		t.Errorf("Expected the oldest ping answered after 5ms, got %v (%v)", rtt, ok)
+	}
+
+	if missed := tracker.expire(start.Add(2*time.Second), time.Second); missed != 1 {
+		t.Errorf("Expected one missed ping, got %d", missed)
+	}
+	tracker.reset()
+	if stats, _ := tracker.stats(); stats.Unanswered != 0 || stats.Samples != 21 {
+		t.Errorf("Expected reset to clear missed pings and keep samples, got %+v", stats)
+	}
+}
@@ -0,0 +1,38 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// readLimitFactor is how many times max_message_bytes an incoming message may be
+const readLimitFactor = 2
+
+// ErrorMalformedMessage is the error code sent to the server for a message that could not be decoded
+const ErrorMalformedMessage = "malformed_message"
+
+// handleMalformedMessage reports a frame that could not be decoded to the
+// server and returns whether the connection should be kept. An isolated bad
+// frame is skipped; threshold consecutive ones close the connection, since a
+// server stuck sending them would otherwise be talked to forever.
+func (wsm *WebSocketManager) handleMalformedMessage(c *websocket.Conn, err error, consecutive, threshold int) bool {
+	log.Printf("Discarding undecodable message (%d/%d in a row): %v", consecutive, threshold, err)
+	keep := consecutive < threshold
+
+	message := fmt.Sprintf("Could not decode message: %v", err)
+	if !keep {
+		message = fmt.Sprintf("Closing connection after %d consecutive undecodable messages, last: %v", consecutive, err)
+	}
+	wsm.sendResponse(c, MessageTypeError, map[string]interface{}{
+		"error":       ErrorMalformedMessage,
+		"message":     message,
+		"consecutive": consecutive,
+	})
+
+	if !keep {
+		log.Printf("%d consecutive undecodable messages, closing WebSocket connection to reconnect", consecutive)
+	}
+	return keep
+}
@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMalformedMessages(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.MalformedMessageThreshold = 3
+	env.Config.MaxMessageBytes = 1024
+
+	errorsReported := make(chan map[string]interface{}, 10)
+	pongs := make(chan bool, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case string(MessageTypeError):
+			errorsReported <- message
+		case string(MessageTypePong):
+			pongs <- true
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	waitForConnections := func(count int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !env.WSManager.IsConnected() || env.MockServer.GetConnectionCount() != count {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for connection %d (connections: %d)", count, env.MockServer.GetConnectionCount())
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	sendBad := func(frame string) {
+		t.Helper()
+		if err := env.MockServer.SendRawFrame(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("Failed to send malformed frame: %v", err)
+		}
+		select {
+		case reported := <-errorsReported:
+			if reported["error"] != ErrorMalformedMessage {
+				t.Errorf("Expected a malformed_message error, got %v", reported)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for the malformed message error")
+		}
+	}
+	ping := func() {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{"type": "ping"}); err != nil {
+			t.Fatalf("Failed to send ping: %v", err)
+		}
+		select {
+		case <-pongs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for pong")
+		}
+	}
+
+	waitForConnections(1)
+
+	t.Run("Isolated bad frames", func(t *testing.T) {
+		sendBad(`{"type": "ping"`)
+		sendBad(`null`)
+		ping()
+		// The count restarts after a good message
+		sendBad(`not json`)
+		sendBad(`[1, 2, 3]`)
+		ping()
+		// One frame carries one message, so trailing data is malformed too
+		sendBad(`{"type": "ping"} {"type": "ping"}`)
+		sendBad(`{"type": "ping"}garbage`)
+		ping()
+		if count := env.MockServer.GetConnectionCount(); count != 1 {
+			t.Errorf("Expected the connection to survive isolated bad frames, got %d connections", count)
+		}
+	})
+
+	t.Run("Consecutive bad frames", func(t *testing.T) {
+		sendBad(`{`)
+		sendBad(`{`)
+		sendBad(`{`)
+		waitForConnections(2)
+	})
+
+	t.Run("Read limit", func(t *testing.T) {
+		oversized := `{"type": "ping", "padding": "` + strings.Repeat("x", 4096) + `"}`
+		if err := env.MockServer.SendRawFrame(websocket.TextMessage, []byte(oversized)); err != nil {
+			t.Fatalf("Failed to send oversized frame: %v", err)
+		}
+		waitForConnections(3)
+	})
+}
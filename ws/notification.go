@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+)
+
+// Limits of send_test_notification params
+const (
+	maxNotificationTitleLength   = 256
+	maxNotificationMessageLength = 1024
+	defaultNotificationSeconds   = 5
+	maxNotificationSeconds       = 300
+)
+
+// sanitizeNotificationText drops control characters other than newlines and
+// truncates text to max runes
+func sanitizeNotificationText(text string, max int) string {
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	if runes := []rune(text); len(runes) > max {
+		text = string(runes[:max])
+	}
+	return text
+}
+
+// notifySendArgs returns the notify-send arguments for a notification. Title and
+// message are passed as single arguments after "--", so neither a shell nor
+// notify-send's option parsing interprets them.
+func notifySendArgs(title, message string, seconds int) []string {
+	return []string{
+		"--urgency=normal",
+		"--expire-time=" + strconv.Itoa(seconds*1000),
+		"--",
+		title,
+		message,
+	}
+}
+
+// handleSendTestNotification shows params.title and params.message on the
+// device for params.duration_seconds through notify-send, so operators can
+// check notifications reach the screen. The exec policy's environment
+// allowlist must pass DBUS_SESSION_BUS_ADDRESS for notify-send to reach the
+// notification daemon.
+func (wsm *WebSocketManager) handleSendTestNotification(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	allowed := wsm.clientConfig.AllowNotifications
+	wsm.mu.RUnlock()
+
+	sendError := func(message string) {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSendTestNotification,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    message,
+		})
+	}
+
+	if !allowed {
+		log.Println("Notifications disabled, rejecting send_test_notification command")
+		sendError("Notifications are disabled on this client")
+		return
+	}
+
+	title, _ := params["title"].(string)
+	message, _ := params["message"].(string)
+	title = sanitizeNotificationText(title, maxNotificationTitleLength)
+	message = sanitizeNotificationText(message, maxNotificationMessageLength)
+	if strings.TrimSpace(title) == "" {
+		sendError("Invalid params: title is required")
+		return
+	}
+
+	seconds := defaultNotificationSeconds
+	if raw, ok := params["duration_seconds"]; ok {
+		value, ok := raw.(float64)
+		if !ok || value != float64(int(value)) || value < 1 || value > maxNotificationSeconds {
+			sendError("Invalid params: duration_seconds must be a whole number from 1 to " + strconv.Itoa(maxNotificationSeconds))
+			return
+		}
+		seconds = int(value)
+	}
+
+	args := notifySendArgs(title, message, seconds)
+	if wsm.isTestMode() {
+		log.Printf("Test mode: would run notify-send %q", args)
+	} else if output, err := wsm.commandExecutor().CombinedOutput("notify-send", args...); err != nil {
+		log.Printf("Failed to send test notification: %v", err)
+		log.Printf("Command output: %s", output)
+		sendError("Failed to execute notify-send")
+		return
+	}
+
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandSendTestNotification,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       map[string]interface{}{"title": title, "message": message, "duration_seconds": seconds},
+	})
+}
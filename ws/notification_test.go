@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendTestNotification(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.AllowNotifications = true
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	sendNotification := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    string(CommandSendTestNotification),
+			"command_id": "notify-1",
+			"params":     params,
+		}); err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for command response")
+			return nil
+		}
+	}
+
+	t.Run("Test mode", func(t *testing.T) {
+		response := sendNotification(map[string]interface{}{"title": "Hello", "message": "World"})
+		if response["status"] != string(StatusSuccess) {
+			t.Fatalf("Expected success in test mode, got %v", response)
+		}
+		data, _ := response["data"].(map[string]interface{})
+		if data["duration_seconds"] != float64(defaultNotificationSeconds) {
+			t.Errorf("Expected the default duration, got %v", data["duration_seconds"])
+		}
+	})
+
+	t.Run("Invalid params", func(t *testing.T) {
+		if response := sendNotification(map[string]interface{}{"message": "No title"}); response["status"] != string(StatusError) {
+			t.Errorf("Expected an error without a title, got %v", response)
+		}
+		if response := sendNotification(map[string]interface{}{"title": "Hi", "duration_seconds": 0.5}); response["status"] != string(StatusError) {
+			t.Errorf("Expected an error for a fractional duration, got %v", response)
+		}
+	})
+
+	t.Run("Shell metacharacters stay a single argument", func(t *testing.T) {
+		env.WSManager.SetTestMode(false)
+		defer env.WSManager.SetTestMode(true)
+		recorder := &recordingExecutor{}
+		env.WSManager.SetCommandExecutor(recorder)
+		defer env.WSManager.SetCommandExecutor(nil)
+
+		title := "Alert; rm -rf /"
+		response := sendNotification(map[string]interface{}{"title": title, "message": "$(reboot)\x07", "duration_seconds": 3})
+		if response["status"] != string(StatusSuccess) {
+			t.Fatalf("Expected success, got %v", response)
+		}
+
+		expected := []string{"notify-send", "--urgency=normal", "--expire-time=3000", "--", title, "$(reboot)"}
+		if len(recorder.calls) != 1 || strings.Join(recorder.calls[0], "\x00") != strings.Join(expected, "\x00") {
+			t.Errorf("Expected %q, got %q", expected, recorder.calls)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		env.WSManager.mu.Lock()
+		env.WSManager.clientConfig.AllowNotifications = false
+		env.WSManager.mu.Unlock()
+
+		response := sendNotification(map[string]interface{}{"title": "Hello"})
+		if response["status"] != string(StatusError) {
+			t.Errorf("Expected an error when notifications are disabled, got %v", response)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// handlePowerCommand acknowledges a reboot or shutdown command and runs argv,
+// the configured command, through the command executor. action describes it
+// in messages, e.g. "reboot".
+func (wsm *WebSocketManager) handlePowerCommand(c *websocket.Conn, command CommandType, commandID, action string, argv []string) {
+	name := strings.ToUpper(string(command[:1])) + string(command[1:])
+	log.Printf("%s command received - would %s system (%q)", name, action, argv)
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    command,
+		"command_id": commandID,
+		"status":     StatusAcknowledged,
+		"message":    fmt.Sprintf("%s command received, system would %s", name, action),
+	})
+
+	// Only execute the actual command if not in test environment
+	if wsm.isTestMode() {
+		log.Printf("Test mode: %s command acknowledged but not executed", name)
+		return
+	}
+
+	if _, err := wsm.commandExecutor().CombinedOutput(argv[0], argv[1:]...); err != nil {
+		log.Printf("Failed to execute %s command %q: %v", command, argv, err)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    command,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    fmt.Sprintf("Failed to execute %s command", command),
+		})
+	}
+}
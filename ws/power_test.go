@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// channelExecutor sends the argv of every command it runs
+type channelExecutor chan []string
+
+func (e channelExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	e <- append([]string{name}, args...)
+	return nil, nil
+}
+
+func TestPowerCommandsRunConfiguredArgv(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  CommandType
+		reboot   []string
+		shutdown []string
+		expected []string
+	}{
+		{"Default reboot", CommandReboot, nil, nil, []string{"reboot"}},
+		{"Custom reboot", CommandReboot, []string{"systemctl", "reboot"}, nil, []string{"systemctl", "reboot"}},
+		{"Default shutdown", CommandShutdown, nil, nil, []string{"poweroff"}},
+		{"Custom shutdown", CommandShutdown, nil, []string{"/sbin/poweroff", "-f"}, []string{"/sbin/poweroff", "-f"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := SetupTestEnvironment(t)
+			defer env.Cleanup()
+
+			env.Config.DisableCommands = false
+			env.Config.RebootCommand = tt.reboot
+			env.Config.ShutdownCommand = tt.shutdown
+			if err := env.CreateTestState(); err != nil {
+				t.Fatalf("Failed to create test state: %v", err)
+			}
+
+			// Leave test mode so the command reaches the executor
+			env.WSManager.SetTestMode(false)
+			executed := make(channelExecutor, 10)
+			env.WSManager.SetCommandExecutor(executed)
+
+			responses := make(chan map[string]interface{}, 10)
+			env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+				if message["type"] == string(MessageTypeCommandResponse) {
+					responses <- message
+				}
+			})
+
+			go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+			defer env.WSManager.ShutdownWebSocket(false)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !env.WSManager.IsConnected() && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if !env.WSManager.IsConnected() {
+				t.Fatal("Timeout waiting for connection")
+			}
+
+			if err := env.MockServer.SendMessage(map[string]interface{}{
+				"type":       string(MessageTypeCommand),
+				"command":    string(tt.command),
+				"command_id": "power-1",
+			}); err != nil {
+				t.Fatalf("Failed to send command: %v", err)
+			}
+
+			select {
+			case response := <-responses:
+				if response["status"] != string(StatusAcknowledged) {
+					t.Errorf("Expected the command to be acknowledged, got %v", response)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timeout waiting for command response")
+			}
+
+			select {
+			case argv := <-executed:
+				if !slices.Equal(argv, tt.expected) {
+					t.Errorf("Expected the executor to run %q, got %q", tt.expected, argv)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timeout waiting for %q to run", tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"runtime/pprof"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxRuntimeProfileBytes caps the size of a runtime_profile result before
+// base64 encoding; larger responses are sent in chunks
+const maxRuntimeProfileBytes = 4 * 1024 * 1024
+
+// runtimeProfiles are the profiles runtime_profile can return
+var runtimeProfiles = map[string]bool{
+	"heap":      true,
+	"goroutine": true,
+}
+
+// handleRuntimeProfile returns the params.profile pprof profile ("heap" by
+// default, or "goroutine") of the client process, in the gzipped protobuf
+// format go tool pprof reads, encoded as base64. It is only available while
+// debug_runtime_stats is enabled.
+func (wsm *WebSocketManager) handleRuntimeProfile(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	enabled := wsm.clientConfig.DebugRuntimeStats
+	wsm.mu.RUnlock()
+
+	sendError := func(message string) {
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandRuntimeProfile,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    message,
+		})
+	}
+
+	if !enabled {
+		log.Println("Runtime debugging disabled, rejecting runtime_profile command")
+		sendError("Runtime debugging is disabled on this client")
+		return
+	}
+
+	name := "heap"
+	if raw, ok := params["profile"]; ok {
+		name, _ = raw.(string)
+	}
+	if !runtimeProfiles[name] {
+		sendError("Invalid params: profile must be heap or goroutine")
+		return
+	}
+
+	var profile bytes.Buffer
+	if err := pprof.Lookup(name).WriteTo(&profile, 0); err != nil {
+		log.Printf("Failed to write %s profile: %v", name, err)
+		sendError("Failed to write profile")
+		return
+	}
+	if profile.Len() > maxRuntimeProfileBytes {
+		sendError(fmt.Sprintf("%s profile is %d bytes, over the %d byte limit", name, profile.Len(), maxRuntimeProfileBytes))
+		return
+	}
+
+	log.Printf("Sending %s profile (%d bytes)", name, profile.Len())
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandRuntimeProfile,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data": map[string]interface{}{
+			"profile": name,
+			"size":    profile.Len(),
+			"pprof":   base64.StdEncoding.EncodeToString(profile.Bytes()),
+		},
+	})
+}
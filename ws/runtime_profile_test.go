@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// isProtobufMessage reports whether data is a sequence of well-formed protobuf fields
+func isProtobufMessage(data []byte) bool {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 {
+			return false
+		}
+		data = data[n:]
+		switch tag & 7 {
+		case 0:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return false
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if tag&7 == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return false
+			}
+			data = data[size:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return false
+			}
+			data = data[n+int(length):]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func TestRuntimeStatsInStatus(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.clientConfig = config.ClientConfig{ClientID: "test-client"}
+
+	if _, ok := wsm.generateStatusData()["runtime"]; ok {
+		t.Error("Status should omit runtime stats unless debug_runtime_stats is enabled")
+	}
+
+	wsm.clientConfig.DebugRuntimeStats = true
+	stats, ok := wsm.generateStatusData()["runtime"].(utils.RuntimeStats)
+	if !ok {
+		t.Fatal("Expected runtime stats in status when debug_runtime_stats is enabled")
+	}
+	if stats.Goroutines <= 0 || stats.HeapAllocBytes == 0 {
+		t.Errorf("Unexpected runtime stats %+v", stats)
+	}
+}
+
+func TestRuntimeProfile(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.DebugRuntimeStats = true
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	requestProfile := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    string(CommandRuntimeProfile),
+			"command_id": "profile-1",
+			"params":     params,
+		}); err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for command response")
+			return nil
+		}
+	}
+
+	for _, name := range []string{"heap", "goroutine"} {
+		t.Run(name, func(t *testing.T) {
+			response := requestProfile(map[string]interface{}{"profile": name})
+			if response["status"] != string(StatusSuccess) {
+				t.Fatalf("Expected success, got %v", response)
+			}
+			data, _ := response["data"].(map[string]interface{})
+			encoded, _ := data["pprof"].(string)
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("Profile is not valid base64: %v", err)
+			}
+
+			// pprof profiles are gzipped protobuf messages
+			reader, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Profile is not gzipped: %v", err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed to decompress profile: %v", err)
+			}
+			if len(decoded) == 0 || !isProtobufMessage(decoded) {
+				t.Errorf("Expected a pprof protobuf message, got %d bytes", len(decoded))
+			}
+		})
+	}
+
+	t.Run("Unknown profile", func(t *testing.T) {
+		if response := requestProfile(map[string]interface{}{"profile": "cpu"}); response["status"] != string(StatusError) {
+			t.Errorf("Expected an error for an unsupported profile, got %v", response)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		env.WSManager.mu.Lock()
+		env.WSManager.clientConfig.DebugRuntimeStats = false
+		env.WSManager.mu.Unlock()
+
+		if response := requestProfile(nil); response["status"] != string(StatusError) {
+			t.Errorf("Expected an error when runtime debugging is disabled, got %v", response)
+		}
+	})
+}
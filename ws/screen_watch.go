@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// activeVTPath reports the active virtual terminal on Linux, e.g. "tty2"
+const activeVTPath = "/sys/class/tty/tty0/active"
+
+// readActiveVT returns the active virtual terminal number, matching screen_list IDs
+func readActiveVT() (string, error) {
+	data, err := os.ReadFile(activeVTPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "tty"), nil
+}
+
+// SetScreenReader overrides how the active screen is read; nil restores the sysfs reader
+func (wsm *WebSocketManager) SetScreenReader(reader func() (string, error)) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.screenReader = reader
+}
+
+// CurrentScreen returns the last observed active screen, or "" if unknown
+func (wsm *WebSocketManager) CurrentScreen() string {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.currentScreen
+}
+
+func (wsm *WebSocketManager) readScreen() (string, error) {
+	wsm.mu.RLock()
+	reader := wsm.screenReader
+	wsm.mu.RUnlock()
+	if reader == nil {
+		reader = readActiveVT
+	}
+	return reader()
+}
+
+// pollScreen reads the active screen and queues a current_screen event when it changed
+// since the last reading. It returns false if the active screen cannot be read, which
+// disables the watcher on non-Linux systems or without sysfs.
+func (wsm *WebSocketManager) pollScreen() bool {
+	screen, err := wsm.readScreen()
+	if err != nil || screen == "" {
+		return false
+	}
+
+	wsm.mu.Lock()
+	previous := wsm.currentScreen
+	wsm.currentScreen = screen
+	wsm.mu.Unlock()
+
+	if previous != "" && previous != screen {
+		log.Printf("Active screen changed from %s to %s", previous, screen)
+		if err := wsm.QueueEvent(EventCurrentScreen, map[string]interface{}{
+			"current_screen":  screen,
+			"previous_screen": previous,
+		}); err != nil {
+			log.Printf("Failed to queue %s event: %v", EventCurrentScreen, err)
+		}
+	}
+	return true
+}
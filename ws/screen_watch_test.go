@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequenceReader returns each screen in turn, then keeps returning the last one
+type sequenceReader struct {
+	mu      sync.Mutex
+	screens []string
+}
+
+func (s *sequenceReader) read() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	screen := s.screens[0]
+	if len(s.screens) > 1 {
+		s.screens = s.screens[1:]
+	}
+	return screen, nil
+}
+
+func TestScreenWatcherSendsChangeEvent(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	reader := &sequenceReader{screens: []string{"1", "1", "2"}}
+	env.WSManager.SetScreenReader(reader.read)
+	env.Config.ScreenWatchInterval = 50 * time.Millisecond
+
+	events := make(chan map[string]interface{}, 5)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] == string(MessageTypeEvent) {
+			events <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case event := <-events:
+		if event["event"] != EventCurrentScreen {
+			t.Errorf("Expected %s event, got %v", EventCurrentScreen, event["event"])
+		}
+		if event["current_screen"] != "2" || event["previous_screen"] != "1" {
+			t.Errorf("Expected change from 1 to 2, got %v", event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for current_screen event")
+	}
+
+	if screen := env.WSManager.CurrentScreen(); screen != "2" {
+		t.Errorf("Expected current screen 2, got %q", screen)
+	}
+	if status := env.WSManager.generateStatusData(); status["current_screen"] != "2" {
+		t.Errorf("Expected current_screen 2 in status, got %v", status["current_screen"])
+	}
+
+	// No further events while the screen stays the same
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected event without a screen change: %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestScreenWatcherDisabledWithoutReader(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.SetScreenReader(func() (string, error) {
+		return "", errors.New("no such file or directory")
+	})
+
+	if wsm.pollScreen() {
+		t.Error("Expected watcher to be disabled when the active screen cannot be read")
+	}
+	if screen := wsm.CurrentScreen(); screen != "" {
+		t.Errorf("Expected no current screen, got %q", screen)
+	}
+	if _, ok := wsm.generateStatusData()["current_screen"]; ok {
+		t.Error("Status should omit current_screen when it is unknown")
+	}
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordSendResult tracks consecutive write failures on the current connection.
+// A connection whose reads still work can fail every write for minutes before
+// the read loop notices, so once the configured threshold is reached the
+// connection is closed and ConnectWebSocket re-dials. A successful write
+// resets the count. Writes on a connection that is already closing are ignored.
+func (wsm *WebSocketManager) recordSendResult(c *websocket.Conn, err error) {
+	if errors.Is(err, ErrConnectionClosed) {
+		return
+	}
+
+	wsm.mu.Lock()
+	if c == nil || c != wsm.Connection {
+		wsm.mu.Unlock()
+		return
+	}
+	if err == nil {
+		wsm.sendFailures = 0
+		wsm.mu.Unlock()
+		return
+	}
+	wsm.sendFailures++
+	wsm.lastWriteError = err.Error()
+	failures := wsm.sendFailures
+	threshold := wsm.clientConfig.GetSendFailureThreshold()
+	wsm.mu.Unlock()
+
+	if failures < threshold {
+		return
+	}
+	log.Printf("%d consecutive sends failed (last error: %v), closing WebSocket connection to reconnect", failures, err)
+	wsm.clearConnectionIfCurrent(c)
+}
+
+// sendFailureStats returns the consecutive failed writes on the current
+// connection and the most recent write error
+func (wsm *WebSocketManager) sendFailureStats() (int, string) {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.sendFailures, wsm.lastWriteError
+}
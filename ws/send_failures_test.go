@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"msm-client/utils"
+)
+
+func TestSendFailuresCycleConnection(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.SendFailureThreshold = 3
+	env.Config.DebugRuntimeStats = true
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	waitFor := func(timeout time.Duration, condition func() bool) bool {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			if condition() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	if !waitFor(5*time.Second, func() bool { return env.WSManager.IsConnected() && env.MockServer.GetConnectionCount() == 1 }) {
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	// Reads keep working while every write fails, as on a half-dead socket
+	conn := env.WSManager.GetConnection()
+	if err := conn.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to break the client's writes: %v", err)
+	}
+
+	// Each ping is answered with a pong the client cannot write
+	for i := 0; i < env.Config.SendFailureThreshold; i++ {
+		if err := env.MockServer.SendMessage(map[string]interface{}{"type": string(MessageTypePing)}); err != nil {
+			t.Fatalf("Failed to send ping: %v", err)
+		}
+	}
+
+	if !waitFor(3*time.Second, func() bool { return env.MockServer.GetConnectionCount() == 2 && env.WSManager.IsConnected() }) {
+		t.Fatalf("Expected the client to re-dial after %d failed sends (connections: %d)", env.Config.SendFailureThreshold, env.MockServer.GetConnectionCount())
+	}
+	if env.WSManager.GetConnection() == conn {
+		t.Error("Expected a new connection after cycling")
+	}
+	if count := env.WSManager.ReconnectCount(); count != 1 {
+		t.Errorf("Expected reconnect count 1, got %d", count)
+	}
+
+	stats, ok := env.WSManager.generateStatusData()["runtime"].(utils.RuntimeStats)
+	if !ok {
+		t.Fatal("Expected runtime stats in status")
+	}
+	if stats.ConsecutiveSendFailures != 0 {
+		t.Errorf("Expected the failure count to reset on the new connection, got %d", stats.ConsecutiveSendFailures)
+	}
+	if stats.LastWriteError == "" {
+		t.Error("Expected the last write error in the runtime stats")
+	}
+}
@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"unicode/utf8"
+
+	"msm-client/state"
+)
+
+// maxServerErrors bounds the server error messages kept for GetRecentServerErrors
+const maxServerErrors = 20
+
+// maxServerErrorMessage bounds the characters kept of each server error
+// message, so a misbehaving server cannot grow the history or the logs
+const maxServerErrorMessage = 512
+
+// truncateServerErrorMessage shortens message to maxServerErrorMessage characters
+func truncateServerErrorMessage(message string) string {
+	if utf8.RuneCountInString(message) <= maxServerErrorMessage {
+		return message
+	}
+	return string([]rune(message)[:maxServerErrorMessage]) + "..."
+}
+
+// GetRecentServerErrors returns up to the last 20 error messages from the
+// server, newest first
+func (wsm *WebSocketManager) GetRecentServerErrors() []state.ServerError {
+	return wsm.serverErrors.Recent()
+}
+
+// SetOnServerError sets a callback run with every error message from the server
+func (wsm *WebSocketManager) SetOnServerError(callback func(serverError state.ServerError)) {
+	wsm.callbackMutex.Lock()
+	defer wsm.callbackMutex.Unlock()
+	wsm.onServerError = callback
+}
+
+// recordServerError keeps serverError for GetRecentServerErrors and the
+// client status, and passes it to the server error callback
+func (wsm *WebSocketManager) recordServerError(serverError state.ServerError) {
+	wsm.serverErrors.Add(serverError)
+	if tracker := wsm.getModeTracker(); tracker != nil {
+		tracker.RecordServerError(serverError)
+	}
+
+	wsm.callbackMutex.RLock()
+	callback := wsm.onServerError
+	wsm.callbackMutex.RUnlock()
+	if callback != nil {
+		callback(serverError)
+	}
+}
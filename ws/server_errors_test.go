@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"msm-client/state"
+)
+
+func TestRecentServerErrors(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] == "status" {
+			select {
+			case connected <- true:
+			default:
+			}
+		}
+	})
+
+	tracker := state.NewModeTracker()
+	env.WSManager.SetModeTracker(tracker)
+	received := make(chan state.ServerError, maxServerErrors+5)
+	env.WSManager.SetOnServerError(func(serverError state.ServerError) { received <- serverError })
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if recent := env.WSManager.GetRecentServerErrors(); len(recent) != 0 {
+		t.Fatalf("Expected no server errors yet, got %v", recent)
+	}
+
+	sendError := func(i int) {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":      "error",
+			"message":   fmt.Sprintf("error %d", i),
+			"timestamp": 1700000000 + i,
+		}); err != nil {
+			t.Fatalf("Failed to send error message: %v", err)
+		}
+		select {
+		case serverError := <-received:
+			if serverError.Message != fmt.Sprintf("error %d", i) {
+				t.Errorf("Expected the callback to get error %d, got %+v", i, serverError)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for the server error callback for error %d", i)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		sendError(i)
+	}
+	recent := env.WSManager.GetRecentServerErrors()
+	if len(recent) != 3 || recent[0].Message != "error 3" || recent[2].Message != "error 1" {
+		t.Fatalf("Expected the three errors newest first, got %+v", recent)
+	}
+	if recent[0].ServerTime != time.Unix(1700000003, 0).Format(time.RFC3339) || recent[0].ReceivedAt.IsZero() {
+		t.Errorf("Expected server and receive times, got %+v", recent[0])
+	}
+	if status := tracker.Status(); status.LastServerError == nil || status.LastServerError.Message != "error 3" {
+		t.Errorf("Expected the last server error in the client status, got %+v", status.LastServerError)
+	}
+
+	for i := 4; i <= maxServerErrors+2; i++ {
+		sendError(i)
+	}
+	recent = env.WSManager.GetRecentServerErrors()
+	if len(recent) != maxServerErrors {
+		t.Fatalf("Expected the history bounded to %d errors, got %d", maxServerErrors, len(recent))
+	}
+	if recent[0].Message != fmt.Sprintf("error %d", maxServerErrors+2) || recent[maxServerErrors-1].Message != "error 3" {
+		t.Errorf("Expected the oldest errors dropped, got newest %q and oldest %q", recent[0].Message, recent[maxServerErrors-1].Message)
+	}
+}
+
+func TestHandleErrorTruncatesMessage(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.handleError(nil, map[string]interface{}{
+		"type":    "error",
+		"message": strings.Repeat("é", maxServerErrorMessage+100),
+	})
+
+	recent := wsm.GetRecentServerErrors()
+	if len(recent) != 1 {
+		t.Fatalf("Expected one server error, got %d", len(recent))
+	}
+	if want := strings.Repeat("é", maxServerErrorMessage) + "..."; recent[0].Message != want {
+		t.Errorf("Expected the message truncated to %d characters, got %d", maxServerErrorMessage, utf8.RuneCountInString(recent[0].Message))
+	}
+}
@@ -0,0 +1,48 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// statusRequest asks the status goroutine for a status update outside the ticker
+type statusRequest struct {
+	params map[string]interface{} // Field selection as for statusFields, nil for the configured fields
+}
+
+// SendStatusNow sends a status update with the configured fields without
+// waiting for the next tick. It returns false when a requested update is
+// still pending.
+func (wsm *WebSocketManager) SendStatusNow() bool {
+	return wsm.requestStatus(statusRequest{})
+}
+
+// requestStatus queues request for the status goroutine of the connection
+func (wsm *WebSocketManager) requestStatus(request statusRequest) bool {
+	select {
+	case wsm.statusRequests <- request:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleStatusRequest answers a status_request message with an ordinary
+// status message, limited to the "fields" of the request when given
+func (wsm *WebSocketManager) handleStatusRequest(_ *websocket.Conn, message map[string]interface{}) {
+	request := statusRequest{}
+	if fields, ok := message["fields"].([]interface{}); ok {
+		include := make([]interface{}, len(fields))
+		for i, field := range fields {
+			if name, ok := field.(string); ok {
+				field = wsm.canonicalStatusField(name)
+			}
+			include[i] = field
+		}
+		request.params = map[string]interface{}{"include": include}
+	}
+	if !wsm.requestStatus(request) {
+		log.Printf("Status request ignored: a status update is already pending")
+	}
+}
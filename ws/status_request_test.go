@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusRequest(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	statuses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			statuses <- message
+		case "command_response":
+			t.Errorf("Expected no command_response for a status request, got %v", message)
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	// The ticker sends a status every second in test mode; requests are sent
+	// right after a tick so the next status is the requested one
+	request := func(message map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		select {
+		case <-statuses:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for a periodic status")
+		}
+		sent := time.Now()
+		if err := env.MockServer.SendMessage(message); err != nil {
+			t.Fatalf("Failed to send status request: %v", err)
+		}
+		select {
+		case status := <-statuses:
+			if elapsed := time.Since(sent); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected the status right after the request, got it after %v", elapsed)
+			}
+			return status
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for the requested status")
+			return nil
+		}
+	}
+
+	status := request(map[string]interface{}{"type": "status_request"})
+	if _, ok := status["interfaces"]; !ok {
+		t.Errorf("Expected a full status without a fields filter, got %v", status)
+	}
+
+	status = request(map[string]interface{}{
+		"type":   "status_request",
+		"fields": []interface{}{"interfaces"},
+	})
+	if _, ok := status["interfaces"]; !ok {
+		t.Errorf("Expected the requested field, got %v", status)
+	}
+	if _, ok := status["clientId"]; !ok {
+		t.Errorf("Expected clientId in every status, got %v", status)
+	}
+	if _, ok := status["uptime"]; ok {
+		t.Errorf("Expected fields outside the filter to be left out, got %v", status)
+	}
+}
@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// messageSubscriptions holds channels that receive copies of incoming messages
+type messageSubscriptions struct {
+	mu     sync.RWMutex
+	byType map[MessageType][]chan<- map[string]interface{}
+	all    []chan<- map[string]interface{}
+}
+
+// SubscribeToMessages delivers a copy of every incoming message of msgType to ch.
+// Delivery never blocks; messages are dropped when ch is full.
+func (wsm *WebSocketManager) SubscribeToMessages(msgType MessageType, ch chan<- map[string]interface{}) {
+	subs := &wsm.subscriptions
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if subs.byType == nil {
+		subs.byType = make(map[MessageType][]chan<- map[string]interface{})
+	}
+	if !slices.Contains(subs.byType[msgType], ch) {
+		subs.byType[msgType] = append(subs.byType[msgType], ch)
+	}
+}
+
+// UnsubscribeFromMessages stops delivering messages of msgType to ch
+func (wsm *WebSocketManager) UnsubscribeFromMessages(msgType MessageType, ch chan<- map[string]interface{}) {
+	subs := &wsm.subscriptions
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	remaining := slices.DeleteFunc(subs.byType[msgType], func(sub chan<- map[string]interface{}) bool {
+		return sub == ch
+	})
+	if len(remaining) == 0 {
+		delete(subs.byType, msgType)
+	} else {
+		subs.byType[msgType] = remaining
+	}
+}
+
+// SubscribeToAllMessages delivers a copy of every incoming message to ch
+func (wsm *WebSocketManager) SubscribeToAllMessages(ch chan<- map[string]interface{}) {
+	subs := &wsm.subscriptions
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if !slices.Contains(subs.all, ch) {
+		subs.all = append(subs.all, ch)
+	}
+}
+
+// UnsubscribeFromAllMessages removes a channel registered with SubscribeToAllMessages
+func (wsm *WebSocketManager) UnsubscribeFromAllMessages(ch chan<- map[string]interface{}) {
+	subs := &wsm.subscriptions
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	subs.all = slices.DeleteFunc(subs.all, func(sub chan<- map[string]interface{}) bool {
+		return sub == ch
+	})
+}
+
+// publishMessage posts a copy of message to the subscribers of msgType and of all messages
+func (wsm *WebSocketManager) publishMessage(msgType MessageType, message map[string]interface{}) {
+	subs := &wsm.subscriptions
+	subs.mu.RLock()
+	defer subs.mu.RUnlock()
+
+	for _, ch := range subs.byType[msgType] {
+		deliverMessage(ch, msgType, message)
+	}
+	for _, ch := range subs.all {
+		deliverMessage(ch, msgType, message)
+	}
+}
+
+// deliverMessage sends a copy of message to ch without blocking
+func deliverMessage(ch chan<- map[string]interface{}, msgType MessageType, message map[string]interface{}) {
+	select {
+	case ch <- maps.Clone(message):
+	default:
+		log.Printf("Warning: subscriber channel full, dropping %s message", msgType)
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"msm-client/state"
+	"msm-client/utils"
+)
+
+// setupSubscriptionTest returns a manager with a paired state and a function that
+// feeds it an encrypted message, as the read loop would
+func setupSubscriptionTest(t *testing.T) (*WebSocketManager, func(message map[string]interface{})) {
+	t.Helper()
+
+	env := SetupTestEnvironment(t)
+	t.Cleanup(env.Cleanup)
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	receive := func(message map[string]interface{}) {
+		t.Helper()
+		encrypted, err := utils.EncryptWebSocketMessage(message, state.GetSessionKey())
+		if err != nil {
+			t.Fatalf("Failed to encrypt message: %v", err)
+		}
+		env.WSManager.handleMessage(nil, encrypted)
+	}
+	return env.WSManager, receive
+}
+
+func TestSubscribeToMessages(t *testing.T) {
+	wsm, receive := setupSubscriptionTest(t)
+
+	notices := make(chan map[string]interface{}, 5)
+	everything := make(chan map[string]interface{}, 5)
+	wsm.SubscribeToMessages("notice", notices)
+	wsm.SubscribeToAllMessages(everything)
+
+	receive(map[string]interface{}{"type": "notice", "text": "hello"})
+	receive(map[string]interface{}{"type": "other"})
+
+	if len(notices) != 1 {
+		t.Fatalf("Expected 1 notice, got %d", len(notices))
+	}
+	notice := <-notices
+	if notice["text"] != "hello" {
+		t.Errorf("Unexpected notice: %v", notice)
+	}
+	if len(everything) != 2 {
+		t.Fatalf("Expected 2 messages for the all-types subscriber, got %d", len(everything))
+	}
+
+	// Each subscriber gets its own copy
+	notice["text"] = "changed"
+	if first := <-everything; first["text"] != "hello" {
+		t.Errorf("Expected an independent copy, got %v", first)
+	}
+}
+
+func TestSubscribeToMessagesDropsWhenFull(t *testing.T) {
+	wsm, receive := setupSubscriptionTest(t)
+
+	ch := make(chan map[string]interface{}, 1)
+	wsm.SubscribeToMessages("notice", ch)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			receive(map[string]interface{}{"type": "notice", "seq": i})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleMessage blocked on a full subscriber channel")
+	}
+
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 buffered message, got %d", len(ch))
+	}
+	if first := <-ch; first["seq"] != float64(0) {
+		t.Errorf("Expected the first message to be kept, got %v", first)
+	}
+}
+
+func TestUnsubscribeFromMessages(t *testing.T) {
+	wsm, receive := setupSubscriptionTest(t)
+
+	ch := make(chan map[string]interface{}, 5)
+	wsm.SubscribeToMessages("notice", ch)
+	wsm.SubscribeToMessages("notice", ch) // Subscribing twice delivers once
+	wsm.SubscribeToAllMessages(ch)
+
+	receive(map[string]interface{}{"type": "notice"})
+	if len(ch) != 2 {
+		t.Fatalf("Expected one typed and one all-types delivery, got %d", len(ch))
+	}
+	<-ch
+	<-ch
+
+	wsm.UnsubscribeFromMessages("notice", ch)
+	wsm.UnsubscribeFromAllMessages(ch)
+
+	receive(map[string]interface{}{"type": "notice"})
+	if len(ch) != 0 {
+		t.Errorf("Expected no delivery after unsubscribing, got %d", len(ch))
+	}
+}
@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"msm-client/config"
+	"msm-client/state"
+	"msm-client/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Support bundle limits
+const (
+	maxSupportBundleBytes      = 5 << 20   // Bundles growing past this are abandoned
+	maxInlineSupportBundleSize = 256 << 10 // Larger bundles require params.upload_url
+	supportBundleUploadTimeout = 2 * time.Minute
+)
+
+// errSupportBundleTooLarge is returned when a bundle exceeds maxSupportBundleBytes
+var errSupportBundleTooLarge = fmt.Errorf("support bundle exceeds %d bytes", maxSupportBundleBytes)
+
+// cappedWriter fails writes that would take the total past limit
+type cappedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.written+int64(len(p)) > c.limit {
+		return 0, errSupportBundleTooLarge
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// supportBundleState is the paired state included in a bundle, without key material
+type supportBundleState struct {
+	Paired              bool              `json:"paired"`
+	ServerWs            string            `json:"server_ws,omitempty"`
+	HasSessionKey       bool              `json:"has_session_key"`
+	EncryptionAlgorithm string            `json:"encryption_algorithm,omitempty"`
+	Metadata            map[string]string `json:"metadata,omitempty"`
+}
+
+// supportBundleFiles returns the bundle contents by file name. Network
+// identifiers are always masked and the session key is never included.
+func (wsm *WebSocketManager) supportBundleFiles(cfg config.ClientConfig) (map[string][]byte, error) {
+	bundleState := supportBundleState{}
+	var sessionKey string
+	if pairedState, err := state.LoadState(); err == nil {
+		sessionKey = pairedState.SessionKey
+		bundleState = supportBundleState{
+			Paired:              true,
+			ServerWs:            utils.RedactURL(pairedState.ServerWs),
+			HasSessionKey:       pairedState.SessionKey != "",
+			EncryptionAlgorithm: pairedState.EncryptionAlgorithm,
+			Metadata:            pairedState.CustomMetadata,
+		}
+	}
+
+	system := map[string]interface{}{
+		"uptime":          utils.GetUptime(),
+		"recent_commands": wsm.commandResults.recent(maxCommandResults),
+	}
+	if memory, err := utils.GetMemoryUsage(); err == nil {
+		system["memory"] = memory
+	}
+
+	var logs strings.Builder
+	for _, line := range utils.RecentLogs.Lines() {
+		if sessionKey != "" {
+			line = strings.ReplaceAll(line, sessionKey, "[redacted]")
+		}
+		logs.WriteString(utils.RedactText(line))
+		logs.WriteByte('\n')
+	}
+
+	// The admin token grants access to the pairing admin view and the webhook
+	// secret signs pairing events; the webhook URL may carry credentials too
+	cfg.DisplayAdminToken = ""
+	cfg.PairingWebhookSecret = ""
+	cfg.PairingWebhookURL = utils.RedactURL(cfg.PairingWebhookURL)
+
+	files := map[string][]byte{"logs.txt": []byte(logs.String())}
+	for name, value := range map[string]interface{}{
+		"manifest.json": map[string]interface{}{
+			"client_id":  cfg.ClientID,
+			"created_at": time.Now().UTC().Format(time.RFC3339),
+		},
+		"config.json":     cfg,
+		"state.json":      bundleState,
+		"interfaces.json": utils.RedactInterfaceInfo(utils.GetNetworkInterfaces()),
+		"system.json":     system,
+	} {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		files[name] = data
+	}
+	if record, err := state.LoadBootRecord(); err == nil {
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode last_boot.json: %w", err)
+		}
+		files["last_boot.json"] = data
+	}
+	return files, nil
+}
+
+// buildSupportBundle writes the support bundle as a tar.gz to a temporary file
+// and returns its path, size and hex-encoded SHA-256. The caller removes the file.
+func (wsm *WebSocketManager) buildSupportBundle(cfg config.ClientConfig) (string, int64, string, error) {
+	files, err := wsm.supportBundleFiles(cfg)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	temp, err := os.CreateTemp("", "msm-support-*.tar.gz")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	fail := func(err error) (string, int64, string, error) {
+		temp.Close()
+		os.Remove(temp.Name())
+		return "", 0, "", err
+	}
+
+	hash := sha256.New()
+	output := &cappedWriter{w: io.MultiWriter(temp, hash), limit: maxSupportBundleBytes}
+	gz := gzip.NewWriter(output)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range []string{"manifest.json", "config.json", "state.json", "interfaces.json", "system.json", "last_boot.json", "logs.txt"} {
+		if _, ok := files[name]; !ok {
+			continue
+		}
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return fail(err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fail(err)
+	}
+	if err := gz.Close(); err != nil {
+		return fail(err)
+	}
+	if err := temp.Close(); err != nil {
+		return fail(err)
+	}
+	return temp.Name(), output.written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uploadSupportBundle PUTs the bundle at path to uploadURL
+func uploadSupportBundle(path, uploadURL string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, uploadURL, file)
+	if err != nil {
+		return fmt.Errorf("invalid upload_url: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+
+	client := &http.Client{Timeout: supportBundleUploadTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL error repeats the presigned URL, so report only the cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to upload support bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to upload support bundle: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleSupportBundle assembles a support bundle in the background and either
+// uploads it to params.upload_url or returns it base64-encoded when small enough
+func (wsm *WebSocketManager) handleSupportBundle(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	cfg := wsm.clientConfig
+	wsm.mu.RUnlock()
+
+	if cfg.DisableDiagnosticCommands {
+		log.Println("Diagnostic commands disabled, rejecting support_bundle command")
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSupportBundle,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Diagnostic commands are disabled on this client",
+		})
+		return
+	}
+
+	uploadURL, _ := params["upload_url"].(string)
+
+	go func() {
+		sendError := func(message string) {
+			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+				"command":    CommandSupportBundle,
+				"command_id": commandID,
+				"status":     StatusError,
+				"message":    message,
+			})
+		}
+
+		path, size, hash, err := wsm.buildSupportBundle(cfg)
+		if err != nil {
+			log.Printf("Failed to build support bundle: %v", err)
+			sendError(err.Error())
+			return
+		}
+		defer os.Remove(path)
+
+		data := map[string]interface{}{
+			"size":   size,
+			"sha256": hash,
+		}
+		switch {
+		case uploadURL != "":
+			if err := uploadSupportBundle(path, uploadURL); err != nil {
+				log.Printf("Support bundle upload to %s failed: %v", utils.RedactURL(uploadURL), err)
+				sendError(err.Error())
+				return
+			}
+			data["uploaded"] = true
+		case size <= maxInlineSupportBundleSize:
+			content, err := os.ReadFile(path)
+			if err != nil {
+				sendError(err.Error())
+				return
+			}
+			data["bundle"] = base64.StdEncoding.EncodeToString(content)
+		default:
+			sendError(fmt.Sprintf("support bundle is %d bytes, provide upload_url for bundles over %d bytes", size, maxInlineSupportBundleSize))
+			return
+		}
+
+		log.Printf("Support bundle ready: %d bytes, sha256 %s", size, hash)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandSupportBundle,
+			"command_id": commandID,
+			"status":     StatusSuccess,
+			"data":       data,
+		})
+	}()
+}
@@ -0,0 +1,254 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"msm-client/state"
+
+	"github.com/gorilla/websocket"
+)
+
+// UpdateInfo describes a client update announced by the server
+type UpdateInfo struct {
+	Version      string `json:"version"`
+	URL          string `json:"url"`
+	Checksum     string `json:"checksum"` // Hex-encoded SHA-256, optionally prefixed with "sha256:"
+	ReleaseNotes string `json:"release_notes"`
+}
+
+// updateDownloadTimeout bounds the time spent downloading an update
+const updateDownloadTimeout = 5 * time.Minute
+
+// maxUpdateDownloadBytes bounds the size of a downloaded update. It is a
+// variable so tests can shorten it.
+var maxUpdateDownloadBytes int64 = 512 << 20
+
+// executablePath returns the path of the running binary; a variable so tests
+// can point updates at a copy
+var executablePath = os.Executable
+
+// SetVersion sets the version of the running client, recorded as the origin
+// of self-updates
+func (wsm *WebSocketManager) SetVersion(version string) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.version = version
+}
+
+// RegisterUpdateAvailableHandler sets a callback for update_available messages
+func (wsm *WebSocketManager) RegisterUpdateAvailableHandler(handler func(info UpdateInfo)) {
+	wsm.callbackMutex.Lock()
+	defer wsm.callbackMutex.Unlock()
+	wsm.onUpdateAvailable = handler
+}
+
+func (wsm *WebSocketManager) triggerOnUpdateAvailable(info UpdateInfo) {
+	wsm.callbackMutex.RLock()
+	callback := wsm.onUpdateAvailable
+	wsm.callbackMutex.RUnlock()
+	if callback != nil {
+		callback(info)
+	}
+}
+
+// shouldAutoUpdate returns whether announced updates are installed automatically
+func (wsm *WebSocketManager) shouldAutoUpdate() bool {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.clientConfig.IsAutoUpdateEnabled()
+}
+
+func (wsm *WebSocketManager) handleUpdateAvailable(_ *websocket.Conn, message map[string]interface{}) {
+	info := UpdateInfo{}
+	info.Version, _ = message["version"].(string)
+	info.URL, _ = message["url"].(string)
+	info.Checksum, _ = message["checksum"].(string)
+	info.ReleaseNotes, _ = message["release_notes"].(string)
+
+	log.Printf("Update available: version %s (%s)", info.Version, info.URL)
+	if info.ReleaseNotes != "" {
+		log.Printf("Release notes: %s", info.ReleaseNotes)
+	}
+
+	wsm.triggerOnUpdateAvailable(info)
+
+	if !wsm.shouldAutoUpdate() {
+		log.Println("Auto-update disabled, skipping installation")
+		return
+	}
+
+	if wsm.isTestMode() {
+		log.Println("Test mode: Auto-update acknowledged but not executed")
+		return
+	}
+
+	// Install in the background so the read loop is not blocked
+	go func() {
+		if err := wsm.installUpdate(info); err != nil {
+			log.Printf("Auto-update to %s failed: %v", info.Version, err)
+		}
+	}()
+}
+
+// installUpdate downloads the update, verifies its checksum and runs the update script
+func (wsm *WebSocketManager) installUpdate(info UpdateInfo) error {
+	if info.URL == "" || info.Checksum == "" {
+		return fmt.Errorf("update is missing url or checksum")
+	}
+
+	tempDir, err := os.MkdirTemp("", "msm-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	downloadPath := filepath.Join(tempDir, updateFileName(info.URL))
+	log.Printf("Downloading update %s to %s", info.Version, downloadPath)
+	if err := downloadFile(info.URL, downloadPath); err != nil {
+		return err
+	}
+	log.Println("Update downloaded, verifying checksum...")
+
+	if err := verifyChecksum(downloadPath, info.Checksum); err != nil {
+		return err
+	}
+	log.Println("Update checksum verified")
+
+	wsm.mu.RLock()
+	scriptPath := wsm.clientConfig.GetUpdateScriptPath()
+	currentVersion := wsm.version
+	wsm.mu.RUnlock()
+
+	// Keep the running binary and journal the update, so the next start can
+	// record the result and roll back a crash-looping version
+	binaryPath, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	journal := state.UpdateJournal{
+		FromVersion: currentVersion,
+		ToVersion:   info.Version,
+		BackupPath:  binaryPath + state.BackupSuffix,
+		StagedAt:    time.Now(),
+		Result:      state.UpdateResultPending,
+	}
+	if err := copyFile(binaryPath, journal.BackupPath); err != nil {
+		return fmt.Errorf("failed to back up the running binary: %w", err)
+	}
+	if err := state.SaveUpdateJournal(journal); err != nil {
+		return fmt.Errorf("failed to save update journal: %w", err)
+	}
+
+	log.Printf("Running update script %s", scriptPath)
+	output, err := wsm.commandExecutor().CombinedOutput(scriptPath, downloadPath, info.Version)
+	log.Printf("Update script output: %s", output)
+	if err != nil {
+		journal.Result = state.UpdateResultFailed
+		journal.CompletedAt = time.Now()
+		if saveErr := state.SaveUpdateJournal(journal); saveErr != nil {
+			log.Printf("Failed to save update journal: %v", saveErr)
+		}
+		return fmt.Errorf("update script failed: %w", err)
+	}
+
+	log.Printf("Update to %s installed successfully", info.Version)
+	return nil
+}
+
+// copyFile copies the file at src to dst with the mode of src
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// updateFileName returns the last element of the path of the update URL, without
+// its query or fragment, or "update" if the path has none
+func updateFileName(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "update"
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return "update"
+	}
+	return name
+}
+
+// downloadFile downloads url to path, failing if the update exceeds maxUpdateDownloadBytes
+func downloadFile(url, path string) error {
+	client := &http.Client{Timeout: updateDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download update: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download update: HTTP %d", resp.StatusCode)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create update file: %w", err)
+	}
+	defer file.Close()
+
+	size, err := io.Copy(file, io.LimitReader(resp.Body, maxUpdateDownloadBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to write update file: %w", err)
+	}
+	if size > maxUpdateDownloadBytes {
+		return fmt.Errorf("update exceeds %d bytes", maxUpdateDownloadBytes)
+	}
+	return nil
+}
+
+// verifyChecksum compares the SHA-256 of the file at path with the expected hex checksum
+func verifyChecksum(path, expected string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	expected = strings.ToLower(strings.TrimPrefix(expected, "sha256:"))
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
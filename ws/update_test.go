@@ -0,0 +1,234 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/state"
+)
+
+func TestUpdateAvailableHandler(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	// Create test state
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	updates := make(chan UpdateInfo, 1)
+	env.WSManager.RegisterUpdateAvailableHandler(func(info UpdateInfo) {
+		updates <- info
+	})
+
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if msgType, ok := message["type"].(string); ok && msgType == "status" {
+			select {
+			case connected <- true:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+		// Connected
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	updateMessage := map[string]interface{}{
+		"type":          "update_available",
+		"version":       "2.0.0",
+		"url":           "https://example.com/msm-client-2.0.0.tar.gz",
+		"checksum":      "sha256:abcdef",
+		"release_notes": "Bug fixes",
+	}
+	if err := env.MockServer.SendMessage(updateMessage); err != nil {
+		t.Fatalf("Failed to send update message: %v", err)
+	}
+
+	select {
+	case info := <-updates:
+		if info.Version != "2.0.0" {
+			t.Errorf("Expected version 2.0.0, got %s", info.Version)
+		}
+		if info.URL != "https://example.com/msm-client-2.0.0.tar.gz" {
+			t.Errorf("Unexpected URL: %s", info.URL)
+		}
+		if info.Checksum != "sha256:abcdef" {
+			t.Errorf("Unexpected checksum: %s", info.Checksum)
+		}
+		if info.ReleaseNotes != "Bug fixes" {
+			t.Errorf("Unexpected release notes: %s", info.ReleaseNotes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for update handler")
+	}
+
+	// AutoUpdate is not set in the test config, so installation must be skipped
+	if env.WSManager.shouldAutoUpdate() {
+		t.Error("Auto-update should be skipped when AutoUpdate is false")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestShouldAutoUpdate(t *testing.T) {
+	testCases := []struct {
+		name            string
+		autoUpdate      bool
+		allowAutoUpdate bool
+		expected        bool
+	}{
+		{"Disabled", false, false, false},
+		{"Requested but not allowed", true, false, false},
+		{"Allowed but not requested", false, true, false},
+		{"Requested and allowed", true, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wsm := NewWebSocketManager()
+			wsm.clientConfig = config.ClientConfig{
+				AutoUpdate:      tc.autoUpdate,
+				AllowAutoUpdate: tc.allowAutoUpdate,
+			}
+
+			if result := wsm.shouldAutoUpdate(); result != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "update.bin")
+	content := []byte("update contents")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	if err := verifyChecksum(path, checksum); err != nil {
+		t.Errorf("Expected checksum to match: %v", err)
+	}
+	if err := verifyChecksum(path, "sha256:"+checksum); err != nil {
+		t.Errorf("Expected prefixed checksum to match: %v", err)
+	}
+	if err := verifyChecksum(path, "deadbeef"); err == nil {
+		t.Error("Expected checksum mismatch error")
+	}
+}
+
+func TestUpdateFileName(t *testing.T) {
+	tests := map[string]string{
+		"https://updates.example/msm-client-1.2.0.tar.gz":                  "msm-client-1.2.0.tar.gz",
+		"https://updates.example/msm-client.bin?token=abc&expires=1700000": "msm-client.bin",
+		"https://updates.example/builds/client#sha256":                     "client",
+		"https://updates.example/":                                         "update",
+		"https://updates.example":                                          "update",
+		"://invalid":                                                       "update",
+	}
+	for rawURL, expected := range tests {
+		if got := updateFileName(rawURL); got != expected {
+			t.Errorf("updateFileName(%q) = %q, expected %q", rawURL, got, expected)
+		}
+	}
+}
+
+func TestDownloadFileLimit(t *testing.T) {
+	original := maxUpdateDownloadBytes
+	maxUpdateDownloadBytes = 16
+	defer func() { maxUpdateDownloadBytes = original }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "update.bin")
+	if err := downloadFile(server.URL+"/update.bin?body=0123456789abcdef", path); err != nil {
+		t.Errorf("Expected an update of exactly the limit to download: %v", err)
+	}
+	if err := downloadFile(server.URL+"/update.bin?body=0123456789abcdefX", path); err == nil {
+		t.Error("Expected an update over the limit to be rejected")
+	}
+}
+
+// journalCheckingExecutor records the update journal present when the update script runs
+type journalCheckingExecutor struct {
+	journal state.UpdateJournal
+	err     error
+	fail    bool
+}
+
+func (e *journalCheckingExecutor) CombinedOutput(name string, args ...string) ([]byte, error) {
+	e.journal, e.err = state.LoadUpdateJournal()
+	if e.fail {
+		return []byte("failed"), fmt.Errorf("exit status 1")
+	}
+	return []byte("ok"), nil
+}
+
+func TestInstallUpdateJournal(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MSC_STATE_PATH", dir)
+
+	binaryPath := filepath.Join(dir, "msm-client")
+	if err := os.WriteFile(binaryPath, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	originalExecutablePath := executablePath
+	executablePath = func() (string, error) { return binaryPath, nil }
+	defer func() { executablePath = originalExecutablePath }()
+
+	payload := []byte("new binary")
+	sum := sha256.Sum256(payload)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+	info := UpdateInfo{Version: "2.0.0", URL: server.URL + "/msm-client", Checksum: hex.EncodeToString(sum[:])}
+
+	wsm := NewWebSocketManager()
+	wsm.SetVersion("1.0.0")
+	executor := &journalCheckingExecutor{}
+	wsm.SetCommandExecutor(executor)
+
+	if err := wsm.installUpdate(info); err != nil {
+		t.Fatalf("installUpdate failed: %v", err)
+	}
+	if executor.err != nil {
+		t.Fatalf("Expected the journal saved before the update script ran: %v", executor.err)
+	}
+	journal := executor.journal
+	if journal.FromVersion != "1.0.0" || journal.ToVersion != "2.0.0" || journal.Result != state.UpdateResultPending || journal.BackupPath != binaryPath+state.BackupSuffix {
+		t.Errorf("Unexpected journal %+v", journal)
+	}
+	if backup, err := os.ReadFile(binaryPath + state.BackupSuffix); err != nil || string(backup) != "old binary" {
+		t.Errorf("Expected the running binary backed up, got %q (%v)", backup, err)
+	}
+
+	// A failing update script leaves the old version running
+	executor.fail = true
+	if err := wsm.installUpdate(info); err == nil {
+		t.Fatal("Expected installUpdate to fail with the update script")
+	}
+	if saved, err := state.LoadUpdateJournal(); err != nil || saved.Result != state.UpdateResultFailed {
+		t.Errorf("Expected the update recorded as failed, got %+v (%v)", saved, err)
+	}
+}
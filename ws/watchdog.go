@@ -0,0 +1,166 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+// statusStallFactor is how many status intervals may pass without a status
+// being sent before the watchdog cycles the connection
+const statusStallFactor = 3
+
+// commandStuckFactor is how many times command_timeout an external command may
+// run before the watchdog kills it
+const commandStuckFactor = 3
+
+// runningCommand is an external command started by a policy executor
+type runningCommand struct {
+	name    string
+	started time.Time
+	process *os.Process
+}
+
+// commandTracker records the external commands that are running
+type commandTracker struct {
+	mu       sync.Mutex
+	next     int
+	commands map[int]runningCommand
+}
+
+// runningCommands tracks the commands of every policy executor, which are
+// built per command
+var runningCommands = &commandTracker{commands: make(map[int]runningCommand)}
+
+// add records a started command and returns its ID for remove
+func (t *commandTracker) add(name string, process *os.Process) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.commands[t.next] = runningCommand{name: name, started: time.Now(), process: process}
+	return t.next
+}
+
+// remove forgets a command that exited
+func (t *commandTracker) remove(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.commands, id)
+}
+
+// runningLongerThan returns the commands started more than d ago
+func (t *commandTracker) runningLongerThan(d time.Duration) []runningCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var stuck []runningCommand
+	for _, command := range t.commands {
+		if time.Since(command.started) > d {
+			stuck = append(stuck, command)
+		}
+	}
+	return stuck
+}
+
+// SetWatchdog sets the watchdog whose violation counts are reported in the
+// runtime status stats
+func (wsm *WebSocketManager) SetWatchdog(watchdog *utils.Watchdog) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.watchdog = watchdog
+}
+
+// watchdogViolations returns the violation counts of the watchdog, nil without one
+func (wsm *WebSocketManager) watchdogViolations() map[string]int {
+	wsm.mu.RLock()
+	watchdog := wsm.watchdog
+	wsm.mu.RUnlock()
+	if watchdog == nil {
+		return nil
+	}
+	return watchdog.Violations()
+}
+
+// WatchdogChecks returns the watchdog checks of the WebSocket manager: a
+// connection whose status updates stopped is cycled, and external commands
+// running far past command_timeout are killed with their process group
+func (wsm *WebSocketManager) WatchdogChecks() []utils.WatchdogCheck {
+	return []utils.WatchdogCheck{
+		{Name: config.WatchdogCheckStatus, Check: wsm.checkStatusSent, Recover: wsm.cycleConnection},
+		{Name: config.WatchdogCheckCommands, Check: wsm.checkRunningCommands, Recover: wsm.killStuckCommands},
+	}
+}
+
+// statusUpdateInterval returns how often status updates are sent, shorter in test mode
+func (wsm *WebSocketManager) statusUpdateInterval(cfg config.ClientConfig) time.Duration {
+	if wsm.isTestMode() {
+		return 1 * time.Second
+	}
+	return cfg.GetStatusUpdateInterval()
+}
+
+// recordStatusSent notes that a status update was sent on the current connection
+func (wsm *WebSocketManager) recordStatusSent() {
+	now := wsm.now()
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.lastStatusSent = now
+}
+
+// checkStatusSent fails when connected and no status was sent for several status intervals
+func (wsm *WebSocketManager) checkStatusSent() error {
+	wsm.mu.RLock()
+	connected := wsm.connected
+	lastSent := wsm.lastStatusSent
+	cfg := wsm.clientConfig
+	wsm.mu.RUnlock()
+	if !connected {
+		return nil
+	}
+
+	limit := statusStallFactor * wsm.statusUpdateInterval(cfg)
+	if silent := wsm.now().Sub(lastSent); silent > limit {
+		return fmt.Errorf("no status sent for %v while connected", silent.Round(time.Second))
+	}
+	return nil
+}
+
+// cycleConnection closes the current connection so ConnectWebSocket re-dials
+func (wsm *WebSocketManager) cycleConnection() {
+	if conn := wsm.GetConnection(); conn != nil {
+		wsm.clearConnectionIfCurrent(conn)
+	}
+}
+
+// stuckCommandAge returns how long a command may run before it counts as stuck
+func (wsm *WebSocketManager) stuckCommandAge() time.Duration {
+	cfg := wsm.Config()
+	return commandStuckFactor * cfg.GetCommandTimeout()
+}
+
+// checkRunningCommands fails when an external command runs far past command_timeout
+func (wsm *WebSocketManager) checkRunningCommands() error {
+	stuck := runningCommands.runningLongerThan(wsm.stuckCommandAge())
+	if len(stuck) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(stuck))
+	for _, command := range stuck {
+		names = append(names, command.name)
+	}
+	return fmt.Errorf("%d commands running longer than %v: %s", len(stuck), wsm.stuckCommandAge(), strings.Join(names, ", "))
+}
+
+// killStuckCommands kills the process groups of commands running far past command_timeout
+func (wsm *WebSocketManager) killStuckCommands() {
+	for _, command := range runningCommands.runningLongerThan(wsm.stuckCommandAge()) {
+		if err := killProcessGroup(command.process); err != nil {
+			log.Printf("Failed to kill stuck command %s (pid %d): %v", command.name, command.process.Pid, err)
+		}
+	}
+}
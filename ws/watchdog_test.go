@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/utils"
+)
+
+func TestWatchdogCyclesStalledConnection(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	clock := utils.NewFakeClock(time.Now())
+	env.WSManager.clock = clock
+
+	watchdog := utils.NewWatchdog()
+	for _, check := range env.WSManager.WatchdogChecks() {
+		watchdog.Add(check)
+	}
+	env.WSManager.SetWatchdog(watchdog)
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	waitForConnections := func(count int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !env.WSManager.IsConnected() || env.MockServer.GetConnectionCount() != count {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for connection %d (connections: %d)", count, env.MockServer.GetConnectionCount())
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitForConnections(1)
+
+	if violated := watchdog.RunChecks(); len(violated) != 0 {
+		t.Fatalf("Expected a fresh connection to pass, got %v", violated)
+	}
+
+	// No status for more than three status intervals
+	clock.Advance(10 * time.Second)
+	if violated := watchdog.RunChecks(); len(violated) != 1 || violated[0] != config.WatchdogCheckStatus {
+		t.Fatalf("Expected the status check to fail, got %v", violated)
+	}
+	waitForConnections(2)
+
+	if violated := watchdog.RunChecks(); len(violated) != 0 {
+		t.Errorf("Expected the new connection to pass, got %v", violated)
+	}
+	if violations := env.WSManager.watchdogViolations(); violations[config.WatchdogCheckStatus] != 1 {
+		t.Errorf("Expected one status violation, got %v", violations)
+	}
+}
@@ -1,19 +1,27 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"msm-client/config"
+	"msm-client/logger"
 	"msm-client/state"
 	"msm-client/utils"
 )
@@ -29,8 +37,92 @@ type WebSocketManager struct {
 	TestMode bool
 	// Current client configuration
 	clientConfig config.ClientConfig
+	// executor runs external commands; nil uses the configured exec policy
+	executor CommandExecutor
+	// dialer opens the connection, websocket.DefaultDialer when nil; dialHeaders
+	// are sent with every handshake. Both are set with SetCustomDialer.
+	dialer      *websocket.Dialer
+	dialHeaders http.Header
+	// Outbound message queue drained by the per-connection writer goroutine
+	outboxQueue   chan outboundMessage
+	outboxPending atomic.Int64 // Messages queued or in flight
+	draining      bool         // Set by DrainAndClose to reject new queued messages
+	// writeMu serializes writes; gorilla/websocket supports one concurrent writer.
+	// Every frame, including the close frame, is written through writeFrame.
+	writeMu sync.Mutex
+	// closedWriter is the connection whose close frame has been sent; guarded by writeMu
+	closedWriter *websocket.Conn
+	// connectionID identifies the current connection; guarded by mu
+	connectionID string
+	// connections maps connection IDs to active connections for SendEncryptedBroadcast.
+	// It holds at most the current connection until multiple servers are supported.
+	connections sync.Map
+	// readerDone is closed when the read loop of the current connection exits
+	readerDone chan struct{}
+	// reconnectCount counts successful connections after the first one
+	reconnectCount atomic.Int64
+	// connectionGoroutines counts the running per-connection goroutines
+	connectionGoroutines atomic.Int64
+	// sendFailures counts consecutive failed writes on the current connection
+	// and lastWriteError is the most recent one; guarded by mu
+	sendFailures   int
+	lastWriteError string
+	// latency measures the round trips of client pings
+	latency latencyTracker
+	// lastStatusSent is when the last status update was sent on the current
+	// connection and watchdog counts recoveries; guarded by mu
+	lastStatusSent time.Time
+	watchdog       *utils.Watchdog
+	// connectLoopActive is set while ConnectWebSocket is connecting or connected
+	connectLoopActive atomic.Bool
+	// version of the running client, recorded in update journals; guarded by mu
+	version string
+	// redial cuts the backoff after a failed dial short
+	redial chan struct{}
+	// statusRequests asks for a status update before the next tick
+	statusRequests chan statusRequest
+	// held keeps ConnectWebSocket from dialling until resumed is closed; guarded by mu
+	held    bool
+	resumed chan struct{}
+	// commandResults caches recent command outcomes for get_result and status
+	commandResults commandResultCache
+	// framedConn is the connection that negotiated binary framing, if any
+	framedConn *websocket.Conn
+	// clock checks command timestamps, the system clock when nil; clockSkew is
+	// the measured offset of the server clock, guarded by mu
+	clock     utils.Clock
+	clockSkew time.Duration
+	// Active screen tracking; screenReader nil uses the sysfs reader
+	currentScreen string
+	screenReader  func() (string, error)
+	// modeTracker records transitions into connected mode for the first status
+	modeTracker *state.ModeTracker
+	// subscriptions receive copies of incoming messages
+	subscriptions messageSubscriptions
+	// serverErrors keeps the latest error messages from the server
+	serverErrors *utils.History[state.ServerError]
+	// bandwidth remembers interface counters between status updates
+	bandwidth bandwidthTracker
+	// connectionHooks rate-limits the connect and disconnect scripts
+	connectionHooks connectionHookLimiter
+	// deactivation is the message of a deactivation not yet reported to the hooks; guarded by mu
+	deactivation *string
+	// Callback functions for external use
+	onUpdateAvailable func(info UpdateInfo)
+	onConfigPushed    func(cfg config.ClientConfig)
+	onServerError     func(serverError state.ServerError)
+	callbackMutex     sync.RWMutex
 }
 
+// outboundMessage is a message waiting in the outbox queue
+type outboundMessage struct {
+	messageType MessageType
+	data        map[string]interface{}
+}
+
+// outboxQueueSize is the maximum number of messages that can be queued
+const outboxQueueSize = 64
+
 // MessageType represents the type of WebSocket message
 type MessageType string
 
@@ -39,6 +131,14 @@ const (
 	MessageTypePing        MessageType = "ping"
 	MessageTypeCommand     MessageType = "command"
 	MessageTypeDeactivated MessageType = "deactivated"
+	// MessageTypeUpdateAvailable announces a new client version
+	MessageTypeUpdateAvailable MessageType = "update_available"
+	// MessageTypeFileSyncRequest asks for the files under a path changed since a time
+	MessageTypeFileSyncRequest MessageType = "file_sync_request"
+	// MessageTypeConfigPush replaces the whole configuration with a revisioned document
+	MessageTypeConfigPush MessageType = "config_push"
+	// MessageTypeStatusRequest asks for a status message right away
+	MessageTypeStatusRequest MessageType = "status_request"
 
 	// Outgoing message types
 	MessageTypePong            MessageType = "pong"
@@ -46,17 +146,43 @@ const (
 	MessageTypeCommandResponse MessageType = "command_response"
 	MessageTypeError           MessageType = "error"
 	MessageTypeDisconnect      MessageType = "disconnect"
+	// MessageTypeEvent reports an unprompted client-side change, named by its "event" field
+	MessageTypeEvent MessageType = "event"
+	// MessageTypeFileSyncManifest answers a file sync request with file hashes
+	MessageTypeFileSyncManifest MessageType = "file_sync_manifest"
+	// MessageTypeCommandResponseChunk carries one slice of a chunked command response
+	MessageTypeCommandResponseChunk MessageType = "command_response_chunk"
+	// MessageTypeLogEntry forwards a client log entry when remote logging is enabled
+	MessageTypeLogEntry MessageType = "log_entry"
+	// MessageTypeConfigPushAck reports the outcome of a config push
+	MessageTypeConfigPushAck MessageType = "config_push_ack"
+)
+
+// Event names sent with MessageTypeEvent
+const (
+	EventCurrentScreen = "current_screen"
 )
 
 // CommandType represents the type of command
 type CommandType string
 
 const (
-	CommandReboot       CommandType = "reboot"
-	CommandStatus       CommandType = "status"
-	CommandScreenList   CommandType = "screen_list"
-	CommandScreenSwitch CommandType = "screen_switch"
-	CommandScreenReload CommandType = "screen_reload"
+	CommandReboot                CommandType = "reboot"
+	CommandShutdown              CommandType = "shutdown"
+	CommandStatus                CommandType = "status"
+	CommandScreenList            CommandType = "screen_list"
+	CommandScreenSwitch          CommandType = "screen_switch"
+	CommandScreenReload          CommandType = "screen_reload"
+	CommandCheckPort             CommandType = "check_port"
+	CommandGetResult             CommandType = "get_result"
+	CommandListFiles             CommandType = "list_files"
+	CommandDownloadFile          CommandType = "download_file"
+	CommandSupportBundle         CommandType = "support_bundle"
+	CommandGetProcessCPU         CommandType = "get_process_cpu"
+	CommandSetDisplayOrientation CommandType = "set_display_orientation"
+	CommandGetThermalStatus      CommandType = "get_thermal_status"
+	CommandSendTestNotification  CommandType = "send_test_notification"
+	CommandRuntimeProfile        CommandType = "runtime_profile"
 )
 
 // ResponseStatus represents the status of a command response
@@ -66,18 +192,48 @@ const (
 	StatusAcknowledged ResponseStatus = "acknowledged"
 	StatusSuccess      ResponseStatus = "success"
 	StatusError        ResponseStatus = "error"
+	// StatusDryRun records commands that were logged but not executed
+	StatusDryRun ResponseStatus = "dry_run"
 )
 
+// EnvelopeVersionsHeader advertises supported encrypted envelope versions during the handshake
+const EnvelopeVersionsHeader = "X-Envelope-Versions"
+
+// envelopeVersionsHeaderValue formats the supported envelope versions as a comma-separated list
+func envelopeVersionsHeaderValue() string {
+	versions := make([]string, len(utils.SupportedEnvelopeVersions))
+	for i, v := range utils.SupportedEnvelopeVersions {
+		versions[i] = strconv.Itoa(v)
+	}
+	return strings.Join(versions, ",")
+}
+
+// networkSpeedSampleDuration is how long interface counters are sampled for status updates
+const networkSpeedSampleDuration = 1 * time.Second
+
 // NewWebSocketManager creates a new WebSocketManager instance
 func NewWebSocketManager() *WebSocketManager {
 	return &WebSocketManager{
-		TestMode: isTestEnvironment(),
+		TestMode:    isTestEnvironment(),
+		outboxQueue: make(chan outboundMessage, outboxQueueSize),
+		redial:      make(chan struct{}, 1),
+
+		statusRequests: make(chan statusRequest, 1),
+		serverErrors:   utils.NewHistory[state.ServerError](maxServerErrors),
 	}
 }
 
 // executeScreenCommand executes a screen command with the configured path
 func (wsm *WebSocketManager) executeScreenCommand(args ...string) ([]byte, error) {
-	screenSwitchPath := wsm.clientConfig.GetScreenSwitchPath()
+	wsm.mu.RLock()
+	cfg := wsm.clientConfig
+	wsm.mu.RUnlock()
+	return wsm.executeScreenCommandWith(cfg, args...)
+}
+
+// executeScreenCommandWith executes a screen command with the path and executor of cfg
+func (wsm *WebSocketManager) executeScreenCommandWith(cfg config.ClientConfig, args ...string) ([]byte, error) {
+	screenSwitchPath := cfg.GetScreenSwitchPath()
 
 	if _, err := os.Stat(screenSwitchPath); err == nil {
 		log.Printf("ms-switch binary found at %s", screenSwitchPath)
@@ -87,29 +243,96 @@ func (wsm *WebSocketManager) executeScreenCommand(args ...string) ([]byte, error
 		log.Printf("Error checking ms-switch binary: %v", err)
 	}
 
-	cmd := exec.Command(screenSwitchPath, args...)
-	return cmd.CombinedOutput()
+	return wsm.executorFor(cfg).CombinedOutput(screenSwitchPath, args...)
 }
 
 // generateStatusData creates a status data map with current client information
 func (wsm *WebSocketManager) generateStatusData() map[string]any {
 	wsm.mu.RLock()
 	clientID := wsm.clientConfig.ClientID
+	measureSpeed := wsm.clientConfig.MeasureNetworkSpeed
+	redact := wsm.clientConfig.RedactNetworkIdentifiers
+	debugRuntime := wsm.clientConfig.DebugRuntimeStats
 	wsm.mu.RUnlock()
 
-	return map[string]any{
+	// Each interface reports ipv4_address and ipv6_address when it has them
+	interfaces := utils.GetNetworkInterfaces()
+	if redact {
+		interfaces = utils.RedactInterfaceInfo(interfaces)
+	}
+
+	statusData := map[string]any{
 		"clientId":   clientID,
 		"uptime":     utils.GetUptime(),
-		"interfaces": utils.GetNetworkInterfaces(),
+		"interfaces": interfaces,
 		"timestamp":  time.Now().Format(time.RFC3339),
 	}
+
+	// Rates since the previous status; empty on the first one
+	if rates := wsm.bandwidth.rates(interfaces, time.Now()); len(rates) > 0 {
+		statusData["bandwidth"] = rates
+	}
+
+	// Include the result of the most recent self-update if one was recorded
+	if lastUpdate := state.GetLastUpdate(); lastUpdate != nil {
+		statusData["last_update"] = lastUpdate
+	}
+
+	// Include operator-defined metadata from the pairing state
+	if metadata := state.Metadata(); len(metadata) > 0 {
+		statusData["metadata"] = metadata
+	}
+
+	if screen := wsm.CurrentScreen(); screen != "" {
+		statusData["current_screen"] = screen
+	}
+
+	if recent := wsm.commandResults.recent(recentCommandsInStatus); len(recent) > 0 {
+		statusData["recent_commands"] = recent
+	}
+
+	if measureSpeed {
+		statusData["network_speed"] = utils.GetAllInterfaceSpeeds(networkSpeedSampleDuration)
+	}
+
+	if latency, ok := wsm.latency.stats(); ok {
+		statusData["latency_ms"] = latency
+	}
+
+	if debugRuntime {
+		runtimeStats := utils.GetRuntimeStats()
+		runtimeStats.ConsecutiveSendFailures, runtimeStats.LastWriteError = wsm.sendFailureStats()
+		runtimeStats.WatchdogViolations = wsm.watchdogViolations()
+		if recent := wsm.GetRecentServerErrors(); len(recent) > 0 {
+			runtimeStats.LastServerError = recent[0].Message
+			runtimeStats.LastServerErrorAt = recent[0].ReceivedAt.UTC().Format(time.RFC3339)
+		}
+		statusData["runtime"] = runtimeStats
+	}
+
+	return statusData
 }
 
-// isTestEnvironment checks if we're running in a test environment
+// isTestEnvironment checks the GO_TEST_MODE environment variable.
+// It only provides the default for NewWebSocketManager; use SetTestMode to change it.
 func isTestEnvironment() bool {
 	return os.Getenv("GO_TEST_MODE") == "1"
 }
 
+// SetTestMode enables or disables test mode, which prevents actual command execution
+func (wsm *WebSocketManager) SetTestMode(enabled bool) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.TestMode = enabled
+}
+
+// isTestMode returns whether test mode is enabled (thread-safe)
+func (wsm *WebSocketManager) isTestMode() bool {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.TestMode
+}
+
 // GetConnection returns the current WebSocket connection (thread-safe)
 func (wsm *WebSocketManager) GetConnection() *websocket.Conn {
 	wsm.mu.RLock()
@@ -145,13 +368,36 @@ func (wsm *WebSocketManager) ResetShutdown() {
 	wsm.shutdown = false
 }
 
-// setConnection sets the global connection and headers (thread-safe)
-func (wsm *WebSocketManager) setConnection(conn *websocket.Conn, headers http.Header) {
+// setConnection sets the global connection, headers and read loop signal (thread-safe)
+func (wsm *WebSocketManager) setConnection(conn *websocket.Conn, headers http.Header, readerDone chan struct{}) {
 	wsm.mu.Lock()
 	defer wsm.mu.Unlock()
+	wsm.forgetConnectionLocked()
 	wsm.Connection = conn
 	wsm.Headers = headers
+	wsm.readerDone = readerDone
 	wsm.connected = true
+	wsm.sendFailures = 0
+	wsm.latency.reset()
+	wsm.lastStatusSent = wsm.now()
+	wsm.connectionID = uuid.New().String()
+	wsm.connections.Store(wsm.connectionID, conn)
+}
+
+// forgetConnectionLocked removes the current connection from the broadcast set;
+// the caller must hold mu
+func (wsm *WebSocketManager) forgetConnectionLocked() {
+	if wsm.connectionID != "" {
+		wsm.connections.Delete(wsm.connectionID)
+		wsm.connectionID = ""
+	}
+}
+
+// ConnectionID returns the ID of the current connection, or "" when not connected
+func (wsm *WebSocketManager) ConnectionID() string {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.connectionID
 }
 
 // clearConnection clears the global connection and headers (thread-safe)
@@ -161,27 +407,201 @@ func (wsm *WebSocketManager) clearConnection() {
 	if wsm.Connection != nil {
 		wsm.Connection.Close()
 	}
+	wsm.forgetConnectionLocked()
 	wsm.Connection = nil
 	wsm.Headers = nil
+	wsm.readerDone = nil
 	wsm.connected = false
 }
 
+// clearConnectionIfCurrent clears the global connection only if it is still c,
+// so a connection established by a reconnect is left alone (thread-safe)
+func (wsm *WebSocketManager) clearConnectionIfCurrent(c *websocket.Conn) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	if wsm.Connection != c {
+		return
+	}
+	wsm.Connection.Close()
+	wsm.forgetConnectionLocked()
+	wsm.Connection = nil
+	wsm.Headers = nil
+	wsm.readerDone = nil
+	wsm.connected = false
+}
+
+// ErrNotConnected is returned when an operation requires an active connection
+var ErrNotConnected = errors.New("websocket not connected")
+
+// ErrConnectionClosed is returned by sends on a connection that is closing or closed
+var ErrConnectionClosed = errors.New("websocket connection closed")
+
+// Causes that end the goroutines of a connection
+var (
+	errConnectionLost = errors.New("connection lost")
+	errStateDeleted   = errors.New("state file deleted")
+	errDeactivated    = errors.New("device deactivated")
+)
+
+// goConnection runs fn in a per-connection goroutine tracked by wg and the
+// connection goroutine gauge
+func (wsm *WebSocketManager) goConnection(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	wsm.connectionGoroutines.Add(1)
+	go func() {
+		defer wg.Done()
+		defer wsm.connectionGoroutines.Add(-1)
+		fn()
+	}()
+}
+
+// ConnectionGoroutines returns the number of running per-connection goroutines.
+// It drops back to zero whenever a connection ends.
+func (wsm *WebSocketManager) ConnectionGoroutines() int {
+	return int(wsm.connectionGoroutines.Load())
+}
+
+// ReconnectCount returns the number of times the connection has been re-established
+func (wsm *WebSocketManager) ReconnectCount() int {
+	return int(wsm.reconnectCount.Load())
+}
+
+// RequestReconnect closes the current connection without setting the shutdown
+// flag, so ConnectWebSocket establishes a new connection. While a failed dial
+// is waiting out its backoff, the wait is cut short and the backoff reset.
+func (wsm *WebSocketManager) RequestReconnect() error {
+	if wsm.IsHeld() {
+		return ErrConnectionHeld
+	}
+
+	conn := wsm.GetConnection()
+	if conn == nil || !wsm.IsConnected() {
+		if !wsm.connectLoopActive.Load() {
+			return ErrNotConnected
+		}
+		log.Println("Reconnect requested, retrying the connection now")
+		wsm.wakeRedial()
+		return nil
+	}
+
+	log.Println("Reconnect requested, closing current WebSocket connection")
+	return wsm.DisconnectWebSocket(conn, false)
+}
+
+// ResetConnection unpairs the device while connected: it sends a disconnect
+// message carrying reason, runs deleteState and then closes the connection.
+// Deleting the state before closing keeps ConnectWebSocket from reconnecting.
+// It returns whether the server was sent the message; the state is deleted
+// either way.
+func (wsm *WebSocketManager) ResetConnection(reason string, deleteState func() error) (bool, error) {
+	notified := false
+	if conn := wsm.GetConnection(); conn != nil && wsm.IsConnected() {
+		err := wsm.sendResponse(conn, MessageTypeDisconnect, map[string]interface{}{
+			"message": "client_disconnecting",
+			"reason":  reason,
+		})
+		if err != nil {
+			log.Printf("Failed to send %s disconnect message: %v", reason, err)
+		} else {
+			log.Printf("Told the server the device is disconnecting: %s", reason)
+			notified = true
+		}
+	}
+
+	if err := deleteState(); err != nil {
+		return notified, err
+	}
+
+	if conn := wsm.GetConnection(); conn != nil {
+		if err := wsm.DisconnectWebSocket(conn, false); err != nil {
+			log.Printf("Failed to close WebSocket after %s: %v", reason, err)
+		}
+	}
+	return notified, nil
+}
+
 // SendMessage sends a message using the global connection (thread-safe)
 func (wsm *WebSocketManager) SendMessage(messageType MessageType, data map[string]interface{}) error {
 	conn := wsm.GetConnection()
 	if conn == nil {
-		return websocket.ErrCloseSent // Connection not available
+		return ErrNotConnected
 	}
 
 	return wsm.sendResponse(conn, messageType, data)
 }
 
+// SendLogEntry forwards a log entry to the server as a log_entry message
+func (wsm *WebSocketManager) SendLogEntry(entry logger.Entry) error {
+	data := map[string]interface{}{
+		"level":     entry.Level,
+		"message":   entry.Message,
+		"timestamp": entry.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+	if len(entry.Fields) > 0 {
+		data["fields"] = entry.Fields
+	}
+	return wsm.SendMessage(MessageTypeLogEntry, data)
+}
+
+// SendEncryptedBroadcast sends the message on every active connection and returns
+// how many sends succeeded along with the errors of those that failed. With a
+// single server it behaves like SendMessage, reporting sent=0 when not connected.
+func (wsm *WebSocketManager) SendEncryptedBroadcast(messageType MessageType, data map[string]interface{}) (sent int, errs []error) {
+	wsm.connections.Range(func(key, value any) bool {
+		// Each send gets its own copy, since sendResponse adds fields to the message
+		message := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			message[k] = v
+		}
+		if err := wsm.sendResponse(value.(*websocket.Conn), messageType, message); err != nil {
+			errs = append(errs, fmt.Errorf("connection %s: %w", key, err))
+		} else {
+			sent++
+		}
+		return true
+	})
+	return sent, errs
+}
+
+// QueueMessage adds a message to the outbox queue to be sent by the connection writer.
+// Queued messages survive reconnects and are flushed by DrainAndClose on shutdown.
+func (wsm *WebSocketManager) QueueMessage(messageType MessageType, data map[string]interface{}) error {
+	wsm.mu.RLock()
+	draining := wsm.draining
+	wsm.mu.RUnlock()
+
+	if draining {
+		return fmt.Errorf("outbox is draining, cannot queue %s message", messageType)
+	}
+
+	wsm.outboxPending.Add(1)
+	select {
+	case wsm.outboxQueue <- outboundMessage{messageType: messageType, data: data}:
+		return nil
+	default:
+		wsm.outboxPending.Add(-1)
+		return fmt.Errorf("outbox queue full, dropping %s message", messageType)
+	}
+}
+
+// QueueEvent queues an event message named event with data merged into it
+func (wsm *WebSocketManager) QueueEvent(event string, data map[string]interface{}) error {
+	message := map[string]interface{}{"event": event}
+	for key, value := range data {
+		message[key] = value
+	}
+	return wsm.QueueMessage(MessageTypeEvent, message)
+}
+
 func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs string) {
 	// Store config globally for use in command handling
 	wsm.mu.Lock()
 	wsm.clientConfig = cfg
 	wsm.mu.Unlock()
 
+	// Large outgoing payloads are compressed before encryption
+	utils.SetCompressionThreshold(cfg.GetCompressPayloadsOverBytes())
+
 	// Parse WebSocket URL and add client_id as query parameter
 	wsURL, err := url.Parse(serverWs)
 	if err != nil {
@@ -194,9 +614,19 @@ func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs
 	query.Set("client_id", cfg.ClientID)
 	wsURL.RawQuery = query.Encode()
 
-	headers := make(http.Header)
+	dialer, headers := wsm.dialSettings()
+
+	// Advertise the envelope versions this client can decrypt
+	headers.Set(EnvelopeVersionsHeader, envelopeVersionsHeaderValue())
+	if cfg.UseBinaryFraming {
+		headers.Set(BinaryFramingHeader, binaryFramingVersion)
+	}
+
+	wsm.connectLoopActive.Store(true)
+	defer wsm.connectLoopActive.Store(false)
 
 	backoff := time.Second
+	connectedBefore := false
 	for {
 		// Check if shutdown has been initiated
 		if wsm.IsShutdown() {
@@ -204,16 +634,50 @@ func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs
 			return
 		}
 
+		// Stay down while an operator holds the connection
+		if !wsm.waitWhileHeld() {
+			log.Println("Shutdown initiated while held, stopping WebSocket connection attempts")
+			return
+		}
+
 		// Check if state file still exists before attempting connection
-		if !state.HasState() {
+		if !state.HasState() && state.ConfirmStateMissing(context.Background(), cfg.GetStateMissingGracePeriod()) {
 			log.Println("State file no longer exists, stopping WebSocket connection")
 			return
 		}
 
-		c, _, err := websocket.DefaultDialer.Dial(wsURL.String(), headers)
+		if tracker := wsm.getModeTracker(); tracker != nil {
+			tracker.EnterConnecting()
+		}
+
+		c, resp, err := dialWebSocket(dialer, wsURL.String(), headers)
 		if err != nil {
+			err = withHandshakeStatus(err, resp)
+			category := categorizeDialError(err)
+			if category == CategoryAuthFailure {
+				if !state.HasSessionKey() {
+					// Rejected again without a session key, retrying will not help
+					category = CategoryPermanent
+				} else {
+					log.Printf("WebSocket connection failed: %v: session key rejected, clearing it", err)
+					if err := state.ClearSessionKey(); err != nil {
+						log.Printf("Failed to clear session key: %v", err)
+					}
+				}
+			}
+			if category == CategoryPermanent {
+				log.Printf("WebSocket connection failed: %v: permanent error, stopping reconnection", err)
+				if err := state.DeleteState(); err != nil {
+					log.Printf("Failed to delete state file: %v", err)
+				}
+				return // Exit so the pairing server restarts
+			}
 			log.Printf("WebSocket connection failed: %v (retrying in %s)", err, backoff)
-			time.Sleep(backoff)
+			if wsm.waitBackoff(backoff) {
+				log.Println("Reconnect requested, skipping the remaining backoff")
+				backoff = time.Second
+				continue
+			}
 			backoff *= 2
 			if backoff > 30*time.Second {
 				backoff = 30 * time.Second
@@ -222,85 +686,176 @@ func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs
 		}
 
 		log.Printf("Connected to %s", serverWs)
+
+		// Binary framing is only used when the server accepted it in the handshake
+		framed := cfg.UseBinaryFraming && resp.Header.Get(BinaryFramingHeader) == binaryFramingVersion
+		wsm.setBinaryFraming(c, framed)
+		if framed {
+			log.Println("Binary message framing enabled")
+		}
+		modeSummary := wsm.enterConnectedMode()
 		backoff = time.Second
+		wsm.clearRedial()
+		if connectedBefore {
+			wsm.reconnectCount.Add(1)
+		}
+		connectedBefore = true
+		wsm.runConnectionHook(cfg, HookEventConnected, serverWs, "")
+
+		// Incoming messages are encrypted envelopes, so allow more than outgoing ones
+		c.SetReadLimit(readLimitFactor * int64(cfg.GetMaxMessageBytes()))
 
 		// Set global connection variables
-		wsm.setConnection(c, headers)
+		readerDone := make(chan struct{})
+		wsm.setConnection(c, headers, readerDone)
 
-		// Channel to signal when connection should close
-		done := make(chan struct{})
-		stateDeleted := make(chan struct{})
-		deactivated := make(chan struct{})
-		var closeOnce sync.Once
+		// The connection's goroutines stop when ctx is cancelled; the first cause wins
+		ctx, endConnection := context.WithCancelCause(context.Background())
+		var goroutines sync.WaitGroup
 
 		// Goroutine to listen for incoming messages
-		go func() {
-			defer closeOnce.Do(func() { close(done) })
+		wsm.goConnection(&goroutines, func() {
+			defer close(readerDone)
+			malformed := 0
 			for {
 				if wsm.IsShutdown() {
+					endConnection(errConnectionLost)
 					return
 				}
 
-				var message map[string]interface{}
-				err := c.ReadJSON(&message)
+				message, err := wsm.readMessage(c)
+				if errors.Is(err, errMalformedMessage) {
+					malformed++
+					if !wsm.handleMalformedMessage(c, err, malformed, cfg.GetMalformedMessageThreshold()) {
+						endConnection(errConnectionLost)
+						return
+					}
+					continue
+				}
+				malformed = 0
 				if err != nil {
 					log.Printf("Read failed: %v", err)
+					endConnection(errConnectionLost)
 					return
 				}
 
 				// Check if this is a deactivated message
 				if msgType, ok := message["type"].(string); ok && MessageType(msgType) == MessageTypeDeactivated {
 					wsm.handleDeactivated(c, message)
-					closeOnce.Do(func() { close(deactivated) })
+					endConnection(errDeactivated)
 					return
 				}
 
 				// Handle other incoming messages
 				wsm.handleMessage(c, message)
 			}
-		}()
+		})
 
-		// Goroutine to send periodic status updates
-		go func() {
-			// Use shorter interval in test mode for faster test execution
-			interval := cfg.GetStatusUpdateInterval()
-			if isTestEnvironment() {
-				interval = 1 * time.Second
+		// Goroutine to flush the outbox queue
+		wsm.goConnection(&goroutines, func() {
+			for {
+				select {
+				case msg := <-wsm.outboxQueue:
+					if err := wsm.sendResponse(c, msg.messageType, msg.data); err != nil {
+						log.Printf("Failed to send queued %s message: %v", msg.messageType, err)
+					}
+					wsm.outboxPending.Add(-1)
+				case <-ctx.Done():
+					return
+				}
 			}
+		})
 
-			ticker := time.NewTicker(interval)
+		// Goroutine to send periodic status updates
+		wsm.goConnection(&goroutines, func() {
+			// Use shorter interval in test mode for faster test execution
+			ticker := time.NewTicker(wsm.statusUpdateInterval(cfg))
 			defer ticker.Stop()
 
+			firstStatus := true
+			sendStatus := func(params map[string]interface{}) error {
+				statusData := wsm.generateStatusData()
+				// The first status of a session explains how the client got here
+				if firstStatus && modeSummary != nil {
+					statusData["mode"] = modeSummary
+				}
+				firstStatus = false
+				statusData = selectStatusFields(statusData, wsm.statusFields(params))
+
+				if err := wsm.sendResponse(c, MessageTypeStatus, statusData); err != nil {
+					return err
+				}
+				wsm.recordStatusSent()
+				return nil
+			}
+
 			for {
 				select {
 				case <-ticker.C:
 					if wsm.IsShutdown() {
-						closeOnce.Do(func() { close(done) })
+						endConnection(errConnectionLost)
 						return
 					}
 
-					statusData := wsm.generateStatusData()
-
-					err := wsm.sendResponse(c, MessageTypeStatus, statusData)
-					if err != nil {
+					if err := sendStatus(nil); err != nil {
 						log.Printf("Write failed: %v", err)
 						return
 					}
-				case <-done:
-					return
-				case <-stateDeleted:
+				case request := <-wsm.statusRequests:
+					if err := sendStatus(request.params); err != nil {
+						log.Printf("Write failed: %v", err)
+						return
+					}
+				case <-ctx.Done():
 					return
-				case <-deactivated:
+				}
+			}
+		})
+
+		// Goroutine to ping the server and measure latency when enabled
+		if pingInterval := cfg.ClientPingInterval; pingInterval > 0 {
+			wsm.goConnection(&goroutines, func() {
+				ticker := time.NewTicker(pingInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+						if !wsm.sendClientPing(c, pingInterval, cfg.GetClientPingMaxMissed()) {
+							endConnection(errConnectionLost)
+							return
+						}
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+		}
+
+		// Goroutine to watch the active screen; exits immediately where it can't be read
+		wsm.goConnection(&goroutines, func() {
+			if !wsm.pollScreen() {
+				return
+			}
+
+			ticker := time.NewTicker(cfg.GetScreenWatchInterval())
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					wsm.pollScreen()
+				case <-ctx.Done():
 					return
 				}
 			}
-		}()
+		})
 
 		// Goroutine to check if state file still exists
-		go func() {
+		wsm.goConnection(&goroutines, func() {
 			// Use shorter interval in test mode for faster test execution
 			interval := 5 * time.Second
-			if isTestEnvironment() {
+			if wsm.isTestMode() {
 				interval = 500 * time.Millisecond
 			}
 
@@ -311,43 +866,48 @@ func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs
 				select {
 				case <-ticker.C:
 					if wsm.IsShutdown() {
-						closeOnce.Do(func() { close(done) })
+						endConnection(errConnectionLost)
 						return
 					}
 
-					if !state.HasState() {
+					// A file that vanishes only briefly, as on a flaky mount, keeps the connection
+					if !state.HasState() && state.ConfirmStateMissing(ctx, cfg.GetStateMissingGracePeriod()) {
 						log.Println("State file no longer exists, closing WebSocket connection to restart pairing")
-						closeOnce.Do(func() { close(stateDeleted) })
+						endConnection(errStateDeleted)
 						return
 					}
-				case <-done:
-					return
-				case <-stateDeleted:
-					return
-				case <-deactivated:
+				case <-ctx.Done():
 					return
 				}
 			}
-		}()
+		})
 
-		// Wait for either goroutine to finish
-		select {
-		case <-done:
-			wsm.clearConnection()
+		// Wait for any goroutine to end the connection, then close it so the
+		// reader unblocks and wait for all of them before reconnecting
+		<-ctx.Done()
+		wsm.clearConnection()
+		goroutines.Wait()
+
+		// Zero the decoded session key between connections
+		state.ZeroizeCachedKey()
+
+		switch context.Cause(ctx) {
+		case errStateDeleted:
+			log.Println("State file deleted, closing WebSocket to restart pairing server")
+			wsm.runDisconnectHook(cfg, serverWs, "state_deleted")
+			return // Exit function to allow pairing server restart
+		case errDeactivated:
+			log.Println("Device deactivated by server, exiting WebSocket connection")
+			wsm.runDisconnectHook(cfg, serverWs, "")
+			return // Exit function to stop WebSocket and allow pairing restart
+		default:
 			// Check if shutdown has been initiated before attempting reconnect
 			if wsm.IsShutdown() {
 				log.Println("WebSocket connection closed during shutdown, not reconnecting")
 				return
 			}
+			wsm.runDisconnectHook(cfg, serverWs, "connection_lost")
 			log.Println("WebSocket connection closed, attempting to reconnect...")
-		case <-stateDeleted:
-			wsm.clearConnection()
-			log.Println("State file deleted, closing WebSocket to restart pairing server")
-			return // Exit function to allow pairing server restart
-		case <-deactivated:
-			wsm.clearConnection()
-			log.Println("Device deactivated by server, exiting WebSocket connection")
-			return // Exit function to stop WebSocket and allow pairing restart
 		}
 	}
 }
@@ -355,12 +915,39 @@ func (wsm *WebSocketManager) ConnectWebSocket(cfg config.ClientConfig, serverWs
 func (wsm *WebSocketManager) handleMessage(c *websocket.Conn, message map[string]interface{}) {
 	// Check if message is encrypted and decrypt if necessary
 	if utils.IsEncryptedWebSocketMessage(message) {
-		sessionKey := state.GetSessionKey()
-		if sessionKey != "" {
-			decryptedMessage, err := utils.DecryptWebSocketMessage(message, sessionKey)
+		var decryptedMessage map[string]interface{}
+		alg := state.GetEncryptionAlgorithm()
+		err := state.WithSessionKey(func(key []byte) error {
+			var err error
+			decryptedMessage, err = utils.DecryptWebSocketMessageWithKey(message, key, alg)
+			return err
+		})
+		if !errors.Is(err, state.ErrNoSessionKey) {
+			if errors.Is(err, utils.ErrAlgorithmMismatch) {
+				// Refuse a downgrade without dropping the pairing; the server
+				// must keep using the algorithm agreed during pairing
+				log.Printf("Rejected message: %v", err)
+				wsm.sendResponse(c, MessageTypeError, map[string]interface{}{
+					"message":   err.Error(),
+					"timestamp": time.Now().Unix(),
+				})
+				return
+			}
+			var versionErr *utils.UnsupportedEnvelopeVersionError
+			if errors.As(err, &versionErr) {
+				// A newer server format is not a key problem, so keep the pairing
+				log.Printf("Received message with %v", err)
+				wsm.sendResponse(c, MessageTypeError, map[string]interface{}{
+					"message":              err.Error(),
+					"max_envelope_version": utils.MaxEnvelopeVersion,
+					"timestamp":            time.Now().Unix(),
+				})
+				return
+			}
 			if err != nil {
 				log.Printf("Failed to decrypt message: %v.", err)
-				wsm.ShutdownWebSocket(false)
+				wsm.SetShutdown()
+				wsm.disconnect(c, false, true)
 				state.DeleteState() // Clear state on decryption failure
 				return
 			}
@@ -375,11 +962,14 @@ func (wsm *WebSocketManager) handleMessage(c *websocket.Conn, message map[string
 		}
 	} else {
 		log.Println("Received unencrypted message, disconnecting from server")
-		wsm.ShutdownWebSocket(false)
+		wsm.SetShutdown()
+		wsm.disconnect(c, false, true)
 		state.DeleteState() // Clear state on decryption failure
 		return
 	}
 
+	wsm.unaliasIncoming(message)
+
 	msgType, ok := message["type"].(string)
 	if !ok {
 		log.Printf("Received message without type: %v", message)
@@ -388,20 +978,28 @@ func (wsm *WebSocketManager) handleMessage(c *websocket.Conn, message map[string
 
 	switch MessageType(msgType) {
 	case MessageTypePing:
-		log.Println("Received ping from server")
-		// Respond to ping with pong
-		wsm.sendResponse(c, MessageTypePong, map[string]interface{}{
-			"timestamp": time.Now().Unix(),
-		})
+		wsm.handlePing(c)
+	case MessageTypePong:
+		wsm.handlePong(message)
 	case MessageTypeCommand:
 		wsm.handleCommand(c, message)
 	case MessageTypeDeactivated:
 		wsm.handleDeactivated(c, message)
 	case MessageTypeError:
 		wsm.handleError(c, message)
+	case MessageTypeUpdateAvailable:
+		wsm.handleUpdateAvailable(c, message)
+	case MessageTypeFileSyncRequest:
+		wsm.handleFileSyncRequest(c, message)
+	case MessageTypeConfigPush:
+		wsm.handleConfigPush(c, message)
+	case MessageTypeStatusRequest:
+		wsm.handleStatusRequest(c, message)
 	default:
 		log.Printf("Received unknown message type '%s': %v", msgType, message)
 	}
+
+	wsm.publishMessage(MessageType(msgType), message)
 }
 
 func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string]interface{}) {
@@ -442,41 +1040,37 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 		return
 	}
 
-	params, hasParams := message["params"].(map[string]interface{})
-
-	switch CommandType(command) {
-	case CommandReboot:
-		log.Println("Reboot command received - would reboot system")
+	if reason, detail := wsm.checkCommandTimestamp(message); reason != "" {
+		log.Printf("Refusing command %s (ID: %s): %s", command, commandID, detail)
 		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
-			"command":    CommandReboot,
+			"command":    command,
 			"command_id": commandID,
-			"status":     StatusAcknowledged,
-			"message":    "Reboot command received, system would reboot",
+			"status":     StatusError,
+			"error":      reason,
+			"message":    detail,
 		})
+		return
+	}
 
-		// Only execute actual reboot command if not in test environment
-		if !isTestEnvironment() {
-			cmd := exec.Command("reboot")
-			err := cmd.Run()
-			if err != nil {
-				log.Printf("Failed to execute reboot command: %v", err)
-				wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
-					"command":    CommandReboot,
-					"command_id": commandID,
-					"status":     StatusError,
-					"message":    "Failed to execute reboot command",
-				})
-				return
-			}
-		} else {
-			log.Println("Test mode: Reboot command acknowledged but not executed")
-		}
+	params, hasParams := message["params"].(map[string]interface{})
+
+	switch CommandType(command) {
+	case CommandReboot:
+		wsm.mu.RLock()
+		argv := wsm.clientConfig.GetRebootCommand()
+		wsm.mu.RUnlock()
+		wsm.handlePowerCommand(c, CommandReboot, commandID, "reboot", argv)
+	case CommandShutdown:
+		wsm.mu.RLock()
+		argv := wsm.clientConfig.GetShutdownCommand()
+		wsm.mu.RUnlock()
+		wsm.handlePowerCommand(c, CommandShutdown, commandID, "shut down", argv)
 	case CommandStatus:
 		log.Println("Status request received")
 
 		// Generate status data
 		statusData := make(map[string]interface{})
-		statusData["data"] = wsm.generateStatusData()
+		statusData["data"] = selectStatusFields(wsm.generateStatusData(), wsm.statusFields(params))
 		statusData["command"] = CommandStatus
 		statusData["command_id"] = commandID
 		statusData["status"] = StatusSuccess
@@ -485,7 +1079,7 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 	case CommandScreenList:
 		log.Println("Screen list command received - would return list of screens")
 
-		if isTestEnvironment() {
+		if wsm.isTestMode() {
 			log.Println("Test mode: Screen list command acknowledged but not executed")
 			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
 				"command":    CommandScreenList,
@@ -605,7 +1199,7 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 		}
 
 		log.Printf("Switching to screen: %s", screenID)
-		if isTestEnvironment() {
+		if wsm.isTestMode() {
 			log.Println("Test mode: Screen switch command acknowledged but not executed")
 			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
 				"command":    CommandScreenSwitch,
@@ -661,7 +1255,7 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 			return
 		}
 		log.Printf("Refreshing screen: %s", screenID)
-		if isTestEnvironment() {
+		if wsm.isTestMode() {
 			log.Println("Test mode: Screen refresh command acknowledged but not executed")
 			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
 				"command":    CommandScreenReload,
@@ -691,6 +1285,26 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 			"status":     StatusSuccess,
 			"message":    "Screen refresh command executed successfully",
 		})
+	case CommandCheckPort:
+		wsm.handleCheckPort(c, commandID, params)
+	case CommandGetResult:
+		wsm.handleGetResult(c, commandID, params)
+	case CommandListFiles:
+		wsm.handleListFiles(c, commandID, params)
+	case CommandDownloadFile:
+		wsm.handleDownloadFile(c, commandID, params)
+	case CommandSupportBundle:
+		wsm.handleSupportBundle(c, commandID, params)
+	case CommandGetProcessCPU:
+		wsm.handleGetProcessCPU(c, commandID, params)
+	case CommandSetDisplayOrientation:
+		wsm.handleSetDisplayOrientation(c, commandID, params)
+	case CommandGetThermalStatus:
+		wsm.handleGetThermalStatus(c, commandID)
+	case CommandSendTestNotification:
+		wsm.handleSendTestNotification(c, commandID, params)
+	case CommandRuntimeProfile:
+		wsm.handleRuntimeProfile(c, commandID, params)
 	default:
 		log.Printf("Unknown command: %s", command)
 		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
@@ -702,6 +1316,174 @@ func (wsm *WebSocketManager) handleCommand(c *websocket.Conn, message map[string
 	}
 }
 
+// handleCheckPort tests reachability of params.host:params.port over params.proto
+func (wsm *WebSocketManager) handleCheckPort(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	diagnosticsDisabled := wsm.clientConfig.DisableDiagnosticCommands
+	wsm.mu.RUnlock()
+
+	if diagnosticsDisabled {
+		log.Println("Diagnostic commands disabled, rejecting check_port command")
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandCheckPort,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Diagnostic commands are disabled on this client",
+		})
+		return
+	}
+
+	host, _ := params["host"].(string)
+	port, _ := params["port"].(float64)
+	proto, _ := params["proto"].(string)
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	if host == "" || port <= 0 || (proto != "tcp" && proto != "udp") {
+		log.Printf("Check port command has invalid params: %v", params)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandCheckPort,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "Invalid params: host, port and proto (tcp or udp) are required",
+		})
+		return
+	}
+
+	log.Printf("Checking port %s:%d/%s", host, int(port), proto)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := map[string]interface{}{"reachable": false, "latency_ms": 0}
+	latency, err := utils.CheckPort(ctx, host, int(port), proto)
+	if err != nil {
+		log.Printf("Port %s:%d/%s unreachable: %v", host, int(port), proto, err)
+		result["error"] = err.Error()
+	} else {
+		result["reachable"] = true
+		result["latency_ms"] = latency.Milliseconds()
+	}
+
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandCheckPort,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       result,
+	})
+}
+
+// handleGetProcessCPU reports the CPU usage of params.pid, or of the busiest
+// processes when no PID is given
+func (wsm *WebSocketManager) handleGetProcessCPU(c *websocket.Conn, commandID string, params map[string]interface{}) {
+	wsm.mu.RLock()
+	systemInfoDisabled := wsm.clientConfig.DisableSystemInfo
+	wsm.mu.RUnlock()
+
+	if systemInfoDisabled {
+		log.Println("System info disabled, rejecting get_process_cpu command")
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandGetProcessCPU,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "System info commands are disabled on this client",
+		})
+		return
+	}
+
+	// An absent pid reports the busiest processes; anything else must be a pid
+	var pid float64
+	if rawPID, present := params["pid"]; present {
+		var ok bool
+		pid, ok = rawPID.(float64)
+		if !ok || pid <= 0 || pid != float64(int(pid)) {
+			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+				"command":    CommandGetProcessCPU,
+				"command_id": commandID,
+				"status":     StatusError,
+				"message":    "Invalid params: pid must be a positive integer",
+			})
+			return
+		}
+	}
+
+	// Sampling takes a while, so keep it off the read loop
+	go func() {
+		processes, err := utils.GetProcessCPU(int(pid))
+		if err != nil {
+			log.Printf("Failed to read process CPU usage: %v", err)
+			wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+				"command":    CommandGetProcessCPU,
+				"command_id": commandID,
+				"status":     StatusError,
+				"message":    err.Error(),
+			})
+			return
+		}
+
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandGetProcessCPU,
+			"command_id": commandID,
+			"status":     StatusSuccess,
+			"data":       map[string]interface{}{"processes": processes},
+		})
+	}()
+}
+
+// handleGetThermalStatus reports the temperature of every thermal zone and
+// whether the CPU runs below its maximum frequency
+func (wsm *WebSocketManager) handleGetThermalStatus(c *websocket.Conn, commandID string) {
+	wsm.mu.RLock()
+	systemInfoDisabled := wsm.clientConfig.DisableSystemInfo
+	wsm.mu.RUnlock()
+
+	if systemInfoDisabled {
+		log.Println("System info disabled, rejecting get_thermal_status command")
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandGetThermalStatus,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    "System info commands are disabled on this client",
+		})
+		return
+	}
+
+	zones, err := utils.GetAllThermalZones()
+	if err != nil {
+		log.Printf("Failed to read thermal zones: %v", err)
+		wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+			"command":    CommandGetThermalStatus,
+			"command_id": commandID,
+			"status":     StatusError,
+			"message":    err.Error(),
+		})
+		return
+	}
+
+	data := map[string]interface{}{"zones": zones}
+	// Throttling is left out where cpufreq is unavailable
+	if throttled, err := utils.IsCPUThrottled(); err == nil {
+		data["throttling_status"] = throttled
+	} else {
+		log.Printf("Failed to read CPU frequency: %v", err)
+	}
+
+	wsm.sendResponse(c, MessageTypeCommandResponse, map[string]interface{}{
+		"command":    CommandGetThermalStatus,
+		"command_id": commandID,
+		"status":     StatusSuccess,
+		"data":       data,
+	})
+}
+
+// handlePing responds to a server ping with a pong
+func (wsm *WebSocketManager) handlePing(c *websocket.Conn) {
+	log.Println("Received ping from server")
+	wsm.sendResponse(c, MessageTypePong, map[string]interface{}{
+		"timestamp": time.Now().Unix(),
+	})
+}
+
 func (wsm *WebSocketManager) handleDeactivated(_ *websocket.Conn, message map[string]interface{}) {
 	deactivatedMessage := "Device deactivated by server"
 	if msg, ok := message["message"].(string); ok {
@@ -709,11 +1491,17 @@ func (wsm *WebSocketManager) handleDeactivated(_ *websocket.Conn, message map[st
 	}
 
 	log.Printf("DEACTIVATED: %s", deactivatedMessage)
+	wsm.mu.Lock()
+	wsm.deactivation = &deactivatedMessage
+	wsm.mu.Unlock()
+	if tracker := wsm.getModeTracker(); tracker != nil {
+		tracker.EnterDeactivated()
+	}
 	log.Println("Device has been deactivated by the server. Resetting pairing state...")
 
 	// Close the WebSocket connection immediately
 	if wsm.IsConnected() {
-		if err := wsm.DisconnectWebSocket(nil, false); err != nil {
+		if err := wsm.disconnect(nil, false, true); err != nil {
 			log.Printf("Failed to disconnect WebSocket: %v", err)
 		} else {
 			log.Println("WebSocket disconnected successfully")
@@ -733,22 +1521,139 @@ func (wsm *WebSocketManager) handleDeactivated(_ *websocket.Conn, message map[st
 func (wsm *WebSocketManager) handleError(_ *websocket.Conn, message map[string]interface{}) {
 	errorMessage := "Unknown error from server"
 	if msg, ok := message["message"].(string); ok {
-		errorMessage = msg
+		errorMessage = truncateServerErrorMessage(msg)
 	}
 
 	timestamp := "unknown"
+	serverTime := ""
 	if ts, ok := message["timestamp"]; ok {
 		if tsInt, ok := ts.(float64); ok {
 			timestamp = time.Unix(int64(tsInt), 0).Format(time.RFC3339)
+			serverTime = timestamp
 		} else if tsStr, ok := ts.(string); ok {
 			timestamp = tsStr
+			serverTime = tsStr
 		}
 	}
 
 	log.Printf("ERROR from server: %s (timestamp: %s)", errorMessage, timestamp)
+	wsm.recordServerError(state.ServerError{
+		Message:    errorMessage,
+		ServerTime: serverTime,
+		ReceivedAt: wsm.now(),
+	})
+}
+
+// statusDropOrder lists optional status fields in the order they are dropped when the payload is too large
+var statusDropOrder = []string{"runtime", "latency_ms", "network_speed", "bandwidth", "recent_commands", "mode", "metadata", "current_screen", "interfaces"}
+
+// statusMandatoryFields are always kept in the status payload
+var statusMandatoryFields = map[string]bool{
+	"clientId":  true,
+	"uptime":    true,
+	"timestamp": true,
+}
+
+// statusAlwaysIncluded are sent regardless of the status field selection
+var statusAlwaysIncluded = map[string]bool{
+	"clientId":  true,
+	"timestamp": true,
+}
+
+// selectStatusFields keeps only the selected top-level keys of status data.
+// A nil selection keeps every field.
+func selectStatusFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	if fields == nil {
+		return data
+	}
+
+	selected := make(map[string]interface{}, len(fields)+len(statusAlwaysIncluded))
+	for key, value := range data {
+		if statusAlwaysIncluded[key] || slices.Contains(fields, key) {
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+// statusFields returns the configured status field selection, or nil for all fields.
+// A non-empty params.include list overrides the configuration.
+func (wsm *WebSocketManager) statusFields(params map[string]interface{}) []string {
+	if include, ok := params["include"].([]interface{}); ok && len(include) > 0 {
+		fields := make([]string, 0, len(include))
+		for _, item := range include {
+			field, _ := item.(string)
+			if field == config.StatusFieldsAll {
+				return nil
+			}
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+		return fields
+	}
+
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.clientConfig.GetStatusFields()
+}
+
+// limitStatusPayload drops optional fields from status data until it fits within maxSize bytes
+func limitStatusPayload(data map[string]interface{}, maxSize int) map[string]interface{} {
+	encoded, err := json.Marshal(data)
+	if err != nil || len(encoded) <= maxSize {
+		return data
+	}
+	originalSize := len(encoded)
+
+	limited := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		limited[key] = value
+	}
+
+	var dropped []string
+	fits := func() bool {
+		encoded, err := json.Marshal(limited)
+		return err == nil && len(encoded) <= maxSize
+	}
+
+	for _, field := range statusDropOrder {
+		if _, ok := limited[field]; !ok {
+			continue
+		}
+		delete(limited, field)
+		dropped = append(dropped, field)
+		if fits() {
+			break
+		}
+	}
+
+	// Still too large, keep only the mandatory fields
+	if !fits() {
+		for key := range limited {
+			if !statusMandatoryFields[key] {
+				delete(limited, key)
+				dropped = append(dropped, key)
+			}
+		}
+	}
+
+	log.Printf("Warning: status payload of %d bytes exceeds limit of %d bytes, dropped fields: %s", originalSize, maxSize, strings.Join(dropped, ", "))
+	return limited
 }
 
 func (wsm *WebSocketManager) sendResponse(c *websocket.Conn, messageType MessageType, data map[string]interface{}) error {
+	if messageType == MessageTypeStatus {
+		wsm.mu.RLock()
+		maxSize := wsm.clientConfig.GetMaxStatusPayloadSize()
+		wsm.mu.RUnlock()
+		data = limitStatusPayload(data, maxSize)
+	}
+	if messageType == MessageTypeCommandResponse {
+		wsm.markDryRun(data)
+		wsm.recordCommandResponse(data)
+	}
+
 	response := map[string]interface{}{
 		"type": string(messageType),
 	}
@@ -763,28 +1668,62 @@ func (wsm *WebSocketManager) sendResponse(c *websocket.Conn, messageType Message
 		response["timestamp"] = time.Now().Unix()
 	}
 
-	// Check if we have a session key for encryption
-	sessionKey := state.GetSessionKey()
+	if messageType == MessageTypeCommandResponse {
+		wsm.mu.RLock()
+		maxMessageBytes := wsm.clientConfig.GetMaxMessageBytes()
+		wsm.mu.RUnlock()
+		if chunked, err := wsm.sendChunkedResponse(c, response, maxMessageBytes); chunked || err != nil {
+			return err
+		}
+	}
+
+	return wsm.sendEncrypted(c, messageType, response)
+}
 
-	if sessionKey == "" {
-		return fmt.Errorf("no session key available, cannot send %s message", messageType)
+// sendEncrypted encrypts a complete message with the session key and writes it
+func (wsm *WebSocketManager) sendEncrypted(c *websocket.Conn, messageType MessageType, response map[string]interface{}) error {
+	response["type"] = string(messageType)
+	if _, hasTimestamp := response["timestamp"]; !hasTimestamp {
+		response["timestamp"] = time.Now().Unix()
 	}
 
-	// Encrypt the message
-	encryptedResponse, err := utils.EncryptWebSocketMessage(response, sessionKey)
+	// Encrypt the message under the names the server expects
+	response = wsm.aliasOutgoing(messageType, response)
+	alg := state.GetEncryptionAlgorithm()
+	var encryptedResponse map[string]interface{}
+	err := state.WithSessionKey(func(key []byte) error {
+		var err error
+		encryptedResponse, err = utils.EncryptWebSocketMessageWithKey(response, key, alg)
+		return err
+	})
+	if errors.Is(err, state.ErrNoSessionKey) {
+		return fmt.Errorf("no session key available, cannot send %s message", messageType)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to encrypt %s message: %w", messageType, err)
 	}
 
-	log.Printf("Sending encrypted %s message", messageType)
-	err = c.WriteJSON(encryptedResponse)
+	// Logging log_entry sends would forward the line in turn, endlessly
+	if messageType != MessageTypeLogEntry {
+		log.Printf("Sending encrypted %s message", messageType)
+	}
+	err = wsm.writeEnvelope(c, messageType, encryptedResponse)
+	wsm.recordSendResult(c, err)
 	if err != nil {
 		return fmt.Errorf("failed to send %s message: %w", messageType, err)
 	}
 	return nil
 }
 
+// DisconnectWebSocket sends a close frame and waits for the peer's close frame,
+// bounded by the configured close timeout, before closing the connection
 func (wsm *WebSocketManager) DisconnectWebSocket(c *websocket.Conn, sendMessage bool) error {
+	return wsm.disconnect(c, sendMessage, false)
+}
+
+// disconnect closes the connection. ownsReader must be true when called from the
+// connection's read loop, in which case the close frame is read here directly.
+func (wsm *WebSocketManager) disconnect(c *websocket.Conn, sendMessage bool, ownsReader bool) error {
 	// If no connection provided, use global connection
 	if c == nil {
 		c = wsm.GetConnection()
@@ -812,33 +1751,83 @@ func (wsm *WebSocketManager) DisconnectWebSocket(c *websocket.Conn, sendMessage
 		}
 	}
 
-	// Send close message
-	err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnecting"))
+	wsm.mu.RLock()
+	closeTimeout := wsm.clientConfig.GetCloseTimeout()
+	readerDone := wsm.readerDone
+	isCurrent := c == wsm.Connection
+	wsm.mu.RUnlock()
+
+	deadline := time.Now().Add(closeTimeout)
+
+	// Send close message; later writes on c fail with ErrConnectionClosed
+	err := wsm.writeFrame(c, func() error {
+		wsm.closedWriter = c
+		return c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnecting"), deadline)
+	})
 	if err != nil {
 		log.Printf("Failed to send close message: %v", err)
 	}
 
-	// Wait for the close acknowledgment
-	time.Sleep(5 * time.Second)
+	// Wait for the peer's close frame, bounded by the close timeout
+	_ = c.SetReadDeadline(deadline)
+	if ownsReader || !isCurrent || readerDone == nil {
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				break
+			}
+		}
+	} else {
+		// The connection's read loop receives the close frame and exits
+		select {
+		case <-readerDone:
+		case <-time.After(time.Until(deadline)):
+		}
+	}
 
-	if !wsm.IsConnected() {
+	// The connection loop may already have cleared the connection or replaced it on reconnect
+	if isCurrent && wsm.GetConnection() != c {
 		log.Println("WebSocket connection already closed or not connected")
-		wsm.clearConnection()
 		return nil
 	}
 
 	err = c.Close()
-	if err != nil {
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		log.Printf("Failed to close WebSocket connection: %v", err)
+	} else {
+		err = nil
 	}
 
 	// Clear global connection variables
-	wsm.clearConnection()
+	wsm.clearConnectionIfCurrent(c)
 
 	log.Println("WebSocket connection closed successfully")
 	return err
 }
 
+// DrainAndClose stops accepting queued messages, waits up to timeout for the outbox
+// queue to be flushed, then disconnects and prevents reconnection
+func (wsm *WebSocketManager) DrainAndClose(timeout time.Duration) error {
+	log.Println("Draining outbound message queue...")
+
+	wsm.mu.Lock()
+	wsm.draining = true
+	wsm.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for wsm.outboxPending.Load() > 0 && wsm.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if dropped := wsm.outboxPending.Load(); dropped > 0 {
+		log.Printf("Outbox did not drain before close, dropping %d queued message(s)", dropped)
+	}
+
+	// Set shutdown flag to prevent reconnection
+	wsm.SetShutdown()
+
+	return wsm.DisconnectWebSocket(nil, true)
+}
+
 // ShutdownWebSocket gracefully disconnects and prevents reconnection
 func (wsm *WebSocketManager) ShutdownWebSocket(sendMessage bool) error {
 	log.Println("Initiating WebSocket shutdown...")
@@ -849,3 +1838,29 @@ func (wsm *WebSocketManager) ShutdownWebSocket(sendMessage bool) error {
 	// Disconnect the current connection
 	return wsm.DisconnectWebSocket(nil, sendMessage)
 }
+
+// SetModeTracker sets the tracker notified when the client starts connecting,
+// connects or is deactivated
+func (wsm *WebSocketManager) SetModeTracker(tracker *state.ModeTracker) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.modeTracker = tracker
+}
+
+// getModeTracker returns the tracker set with SetModeTracker, or nil
+func (wsm *WebSocketManager) getModeTracker() *state.ModeTracker {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.modeTracker
+}
+
+// enterConnectedMode records the transition into connected mode and returns
+// its summary, or nil when no tracker is set
+func (wsm *WebSocketManager) enterConnectedMode() *state.ModeSummary {
+	tracker := wsm.getModeTracker()
+	if tracker == nil {
+		return nil
+	}
+	summary := tracker.EnterConnected()
+	return &summary
+}
@@ -1,13 +1,19 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
@@ -16,6 +22,9 @@ import (
 	"github.com/gorilla/websocket"
 
 	"msm-client/config"
+	"msm-client/control"
+	"msm-client/logger"
+	"msm-client/pairing"
 	"msm-client/state"
 	"msm-client/utils"
 )
@@ -29,6 +38,15 @@ type MockWebSocketServer struct {
 	messages   []map[string]interface{}
 	onMessage  func(map[string]interface{})
 	sessionKey string
+	headers    http.Header // Handshake headers of the most recent client
+
+	connectionCount int // Number of accepted connections
+
+	binaryFraming bool                     // Accept binary framing when the client offers it
+	framed        map[*websocket.Conn]bool // Clients that negotiated binary framing
+	binaryFrames  int                      // Number of binary frames received
+
+	ignorePings bool // Leave client pings unanswered
 }
 
 // NewMockWebSocketServer creates a new mock WebSocket server
@@ -40,6 +58,7 @@ func NewMockWebSocketServer() *MockWebSocketServer {
 			},
 		},
 		clients:  make(map[*websocket.Conn]bool),
+		framed:   make(map[*websocket.Conn]bool),
 		messages: make([]map[string]interface{}, 0),
 	}
 
@@ -75,6 +94,61 @@ func (m *MockWebSocketServer) SetSessionKey(key string) {
 	m.sessionKey = key
 }
 
+// EnableBinaryFraming makes the server accept binary framing for clients that offer it
+func (m *MockWebSocketServer) EnableBinaryFraming() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.binaryFraming = true
+}
+
+// GetBinaryFrameCount returns the number of binary frames received
+func (m *MockWebSocketServer) GetBinaryFrameCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.binaryFrames
+}
+
+// writeToClient sends message to conn, as a binary frame if conn negotiated framing
+func (m *MockWebSocketServer) writeToClient(conn *websocket.Conn, messageType MessageType, message map[string]interface{}) error {
+	if !m.framed[conn] {
+		return conn.WriteJSON(message)
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, encodeFrame(messageType, payload))
+}
+
+// SetAnswerPings sets whether client pings are answered with a pong echoing their nonce
+func (m *MockWebSocketServer) SetAnswerPings(answer bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ignorePings = !answer
+}
+
+// answerPing sends the pong for a client ping to conn
+func (m *MockWebSocketServer) answerPing(conn *websocket.Conn, ping map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ignorePings {
+		return
+	}
+
+	pong := map[string]interface{}{"type": "pong", "nonce": ping["nonce"]}
+	if m.sessionKey != "" {
+		encrypted, err := utils.EncryptWebSocketMessage(pong, m.sessionKey)
+		if err != nil {
+			log.Printf("Failed to encrypt pong: %v", err)
+			return
+		}
+		pong = encrypted
+	}
+	if err := m.writeToClient(conn, MessageTypePong, pong); err != nil {
+		log.Printf("Error sending pong to client: %v", err)
+	}
+}
+
 // SetOnMessage sets a callback for when messages are received
 func (m *MockWebSocketServer) SetOnMessage(callback func(map[string]interface{})) {
 	m.onMessage = callback
@@ -116,8 +190,9 @@ func (m *MockWebSocketServer) SendMessage(message map[string]interface{}) error
 		messageToSend = message
 	}
 
+	messageType, _ := message["type"].(string)
 	for conn := range m.clients {
-		if err := conn.WriteJSON(messageToSend); err != nil {
+		if err := m.writeToClient(conn, MessageType(messageType), messageToSend); err != nil {
 			log.Printf("Error sending message to client: %v", err)
 			conn.Close()
 			delete(m.clients, conn)
@@ -126,9 +201,58 @@ func (m *MockWebSocketServer) SendMessage(message map[string]interface{}) error
 	return nil
 }
 
+// SendRawMessage sends a message to all connected clients without encrypting it
+func (m *MockWebSocketServer) SendRawMessage(message map[string]interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for conn := range m.clients {
+		if err := conn.WriteJSON(message); err != nil {
+			return fmt.Errorf("failed to send raw message: %w", err)
+		}
+	}
+	return nil
+}
+
+// SendRawFrame sends data to all connected clients as a single frame of wireType, as is
+func (m *MockWebSocketServer) SendRawFrame(wireType int, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for conn := range m.clients {
+		if err := conn.WriteMessage(wireType, data); err != nil {
+			return fmt.Errorf("failed to send raw frame: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetHeaders returns the handshake headers of the most recent client
+func (m *MockWebSocketServer) GetHeaders() http.Header {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.headers
+}
+
+// GetConnectionCount returns the number of connections accepted so far
+func (m *MockWebSocketServer) GetConnectionCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.connectionCount
+}
+
 // handleWebSocket handles WebSocket connections
 func (m *MockWebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := m.upgrader.Upgrade(w, r, nil)
+	m.mu.RLock()
+	framed := m.binaryFraming && r.Header.Get(BinaryFramingHeader) == binaryFramingVersion
+	m.mu.RUnlock()
+
+	var responseHeader http.Header
+	if framed {
+		responseHeader = http.Header{BinaryFramingHeader: []string{binaryFramingVersion}}
+	}
+
+	conn, err := m.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
@@ -136,18 +260,21 @@ func (m *MockWebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Req
 
 	m.mu.Lock()
 	m.clients[conn] = true
+	m.framed[conn] = framed
+	m.headers = r.Header.Clone()
+	m.connectionCount++
 	m.mu.Unlock()
 
 	defer func() {
 		m.mu.Lock()
 		delete(m.clients, conn)
+		delete(m.framed, conn)
 		m.mu.Unlock()
 		conn.Close()
 	}()
 
 	for {
-		var message map[string]interface{}
-		err := conn.ReadJSON(&message)
+		wireType, data, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -155,6 +282,21 @@ func (m *MockWebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Req
 			break
 		}
 
+		if wireType == websocket.BinaryMessage {
+			if _, data, err = decodeFrame(data); err != nil {
+				continue
+			}
+			m.mu.Lock()
+			m.binaryFrames++
+			m.mu.Unlock()
+		}
+
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			log.Printf("Failed to parse message: %v", err)
+			continue
+		}
+
 		// Decrypt message if session key is available
 		if m.sessionKey != "" && utils.IsEncryptedWebSocketMessage(message) {
 			decryptedMessage, err := utils.DecryptWebSocketMessage(message, m.sessionKey)
@@ -170,6 +312,10 @@ func (m *MockWebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Req
 		m.messages = append(m.messages, message)
 		m.mu.Unlock()
 
+		if message["type"] == "ping" {
+			m.answerPing(conn, message)
+		}
+
 		// Call callback if set
 		if m.onMessage != nil {
 			m.onMessage(message)
@@ -192,9 +338,6 @@ type TestEnvironment struct {
 func SetupTestEnvironment(t *testing.T) *TestEnvironment {
 	t.Helper()
 
-	// Set test mode environment variable
-	os.Setenv("GO_TEST_MODE", "1")
-
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "wstest-*")
 	if err != nil {
@@ -250,7 +393,7 @@ func SetupTestEnvironment(t *testing.T) *TestEnvironment {
 
 	// Create WebSocket manager
 	wsManager := NewWebSocketManager()
-	wsManager.TestMode = true
+	wsManager.SetTestMode(true)
 
 	return &TestEnvironment{
 		TempDir:     tempDir,
@@ -284,9 +427,6 @@ func (te *TestEnvironment) Cleanup() {
 	if te.TempDir != "" {
 		os.RemoveAll(te.TempDir)
 	}
-
-	// Clear test mode environment variable
-	os.Unsetenv("GO_TEST_MODE")
 }
 
 // CreateTestState creates a test state file with session key
@@ -342,6 +482,20 @@ func TestNewWebSocketManager(t *testing.T) {
 	}
 }
 
+func TestSetTestMode(t *testing.T) {
+	wsm := NewWebSocketManager()
+
+	wsm.SetTestMode(true)
+	if !wsm.isTestMode() {
+		t.Error("Test mode should be enabled after SetTestMode(true)")
+	}
+
+	wsm.SetTestMode(false)
+	if wsm.isTestMode() {
+		t.Error("Test mode should be disabled after SetTestMode(false)")
+	}
+}
+
 func TestWebSocketManagerBasicOperations(t *testing.T) {
 	wsm := NewWebSocketManager()
 
@@ -573,6 +727,56 @@ func TestCommandHandling(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "Check Port Command",
+			command: map[string]interface{}{
+				"type":       "command",
+				"command":    "check_port",
+				"command_id": "test-check-port-1",
+				"params": map[string]interface{}{
+					"host":  "localhost",
+					"port":  49174,
+					"proto": "tcp",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Get Process CPU Command",
+			command: map[string]interface{}{
+				"type":       "command",
+				"command":    "get_process_cpu",
+				"command_id": "test-process-cpu-1",
+				"params": map[string]interface{}{
+					"pid": os.Getpid(),
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Get Process CPU Invalid PID",
+			command: map[string]interface{}{
+				"type":       "command",
+				"command":    "get_process_cpu",
+				"command_id": "test-process-cpu-2",
+				"params": map[string]interface{}{
+					"pid": -1,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Get Process CPU Non-Numeric PID",
+			command: map[string]interface{}{
+				"type":       "command",
+				"command":    "get_process_cpu",
+				"command_id": "test-process-cpu-3",
+				"params": map[string]interface{}{
+					"pid": "init",
+				},
+			},
+			expectError: true,
+		},
 		{
 			name: "Unknown Command",
 			command: map[string]interface{}{
@@ -716,6 +920,84 @@ func TestDeactivatedMessage(t *testing.T) {
 	}
 }
 
+func TestLocalResetNotifiesServer(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+	t.Setenv("MSC_PAIRING_PATH", env.TempDir)
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Serve pairing.reset the way the daemon does
+	pm := pairing.NewPairingManager()
+	socketPath := filepath.Join(env.TempDir, "control.sock")
+	server := control.NewServer(socketPath)
+	pm.RegisterControlHandlers(server)
+	pm.SetStateResetter(env.WSManager.ResetConnection)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	defer server.Stop()
+
+	connected := make(chan bool, 1)
+	disconnects := make(chan map[string]interface{}, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "disconnect":
+			disconnects <- message
+		}
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	}()
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	var result pairing.ResetResult
+	if err := control.CallPath(socketPath, pairing.ControlVerbReset, nil, &result); err != nil {
+		t.Fatalf("pairing.reset failed: %v", err)
+	}
+	if !result.StateDeleted || !result.ServerNotified {
+		t.Errorf("Expected the state deleted and the server notified, got %+v", result)
+	}
+
+	select {
+	case message := <-disconnects:
+		if message["reason"] != pairing.ResetReasonLocal {
+			t.Errorf("Expected reason %q, got %v", pairing.ResetReasonLocal, message["reason"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the disconnect message")
+	}
+
+	if state.HasState() {
+		t.Error("State should be deleted after pairing.reset")
+	}
+
+	// The connection closes without reconnecting
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectWebSocket should return after a local reset")
+	}
+	if count := env.MockServer.GetConnectionCount(); count != 1 {
+		t.Errorf("Expected no reconnection after a local reset, got %d connections", count)
+	}
+}
+
 func TestEncryptionDecryption(t *testing.T) {
 	env := SetupTestEnvironment(t)
 	defer env.Cleanup()
@@ -784,89 +1066,613 @@ func TestEncryptionDecryption(t *testing.T) {
 	env.WSManager.ShutdownWebSocket(false)
 }
 
-func TestErrorHandling(t *testing.T) {
+func TestRequestReconnect(t *testing.T) {
 	env := SetupTestEnvironment(t)
 	defer env.Cleanup()
 
-	// Create test state
+	if err := env.WSManager.RequestReconnect(); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected before connecting, got %v", err)
+	}
+
 	if err := env.CreateTestState(); err != nil {
 		t.Fatalf("Failed to create test state: %v", err)
 	}
 
-	// Verify state is loaded
-	if !state.HasState() {
-		t.Fatal("State should exist after creation")
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	waitFor := func(condition func() bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if condition() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
 	}
 
-	// Start WebSocket connection
-	messageReceived := make(chan bool, 10)
+	if !waitFor(func() bool { return env.WSManager.IsConnected() && env.MockServer.GetConnectionCount() == 1 }) {
+		t.Fatal("Timeout waiting for initial connection")
+	}
+	if count := env.WSManager.ReconnectCount(); count != 0 {
+		t.Errorf("Expected reconnect count 0 after first connection, got %d", count)
+	}
+
+	if err := env.WSManager.RequestReconnect(); err != nil {
+		t.Fatalf("RequestReconnect failed: %v", err)
+	}
+
+	if !waitFor(func() bool { return env.MockServer.GetConnectionCount() == 2 && env.WSManager.IsConnected() }) {
+		t.Fatalf("Timeout waiting for reconnection (connections: %d)", env.MockServer.GetConnectionCount())
+	}
+	if count := env.WSManager.ReconnectCount(); count != 1 {
+		t.Errorf("Expected reconnect count 1, got %d", count)
+	}
+	if env.WSManager.IsShutdown() {
+		t.Error("RequestReconnect should not set the shutdown flag")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestUnsupportedEnvelopeVersion(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	errorReceived := make(chan map[string]interface{}, 1)
+	connected := make(chan bool, 1)
 	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
-		messageReceived <- true
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "error":
+			select {
+			case errorReceived <- message:
+			default:
+			}
+		}
 	})
 
 	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
 
-	// Wait for initial connection
 	select {
-	case <-messageReceived:
-		// Connected
+	case <-connected:
 	case <-time.After(5 * time.Second):
-		t.Fatal("Timeout waiting for initial connection")
+		t.Fatal("Timeout waiting for connection")
 	}
 
-	// Send error message
-	errorMessage := map[string]interface{}{
-		"type":      "error",
-		"message":   "Test error message",
-		"timestamp": time.Now().Unix(),
+	if versions := env.MockServer.GetHeaders().Get(EnvelopeVersionsHeader); versions != "1" {
+		t.Errorf("Expected %s header \"1\", got %q", EnvelopeVersionsHeader, versions)
 	}
 
-	if err := env.MockServer.SendMessage(errorMessage); err != nil {
-		t.Fatalf("Failed to send error message: %v", err)
+	// Build an envelope from a future protocol version
+	envelope, err := utils.EncryptWebSocketMessage(map[string]interface{}{"type": "ping"}, state.GetSessionKey())
+	if err != nil {
+		t.Fatalf("Failed to encrypt message: %v", err)
+	}
+	envelope["v"] = utils.MaxEnvelopeVersion + 1
+	if err := env.MockServer.SendRawMessage(envelope); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
 	}
 
-	// Wait a moment for message to be processed
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case message := <-errorReceived:
+		if maxVersion, ok := message["max_envelope_version"].(float64); !ok || int(maxVersion) != utils.MaxEnvelopeVersion {
+			t.Errorf("Expected max_envelope_version %d, got %v", utils.MaxEnvelopeVersion, message["max_envelope_version"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for error response")
+	}
 
-	// The error message should be logged but not cause disconnection
+	// Unknown versions must not unpair the device
+	if !state.HasState() {
+		t.Error("State should be kept after an unsupported envelope version")
+	}
 	if !env.WSManager.IsConnected() {
-		t.Error("Connection should remain active after error message")
+		t.Error("Connection should remain open after an unsupported envelope version")
 	}
 
 	env.WSManager.ShutdownWebSocket(false)
 }
 
-// Benchmark tests
-func BenchmarkWebSocketConnection(b *testing.B) {
-	// Set up temporary directory for state
-	tmpDir := b.TempDir()
-	oldPath := os.Getenv("MSC_STATE_PATH")
-	os.Setenv("MSC_STATE_PATH", tmpDir)
-	defer os.Setenv("MSC_STATE_PATH", oldPath)
+func TestEnvelopeAlgorithmDowngrade(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
 
-	// Create test state directly for benchmark (without mock server)
-	testState := state.PairedState{
-		ServerWs:   "ws://localhost:8080/ws",
-		SessionKey: "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=", // Base64 encoded 32-byte key
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
 	}
-
-	if err := state.SaveState(testState); err != nil {
-		b.Fatalf("Failed to save test state: %v", err)
+	st, err := state.LoadState()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
 	}
-
-	// Verify state is loaded
-	if !state.HasState() {
-		b.Fatal("State should exist after creation")
+	st.EncryptionAlgorithm = utils.AlgAESGCM
+	if err := state.SaveState(st); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		// Note: This is a simplified benchmark
-		// In practice, you'd want to test specific operations
-		wsm := NewWebSocketManager()
-		wsm.TestMode = true
+	errorReceived := make(chan map[string]interface{}, 1)
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "error":
+			select {
+			case errorReceived <- message:
+			default:
+			}
+		}
+	})
 
-		// Test basic operations
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// aes-gcm was negotiated, so an aes-cbc envelope is a downgrade
+	envelope, err := utils.EncryptWebSocketMessage(map[string]interface{}{"type": "ping"}, state.GetSessionKey())
+	if err != nil {
+		t.Fatalf("Failed to encrypt message: %v", err)
+	}
+	delete(envelope, "alg")
+	if err := env.MockServer.SendRawMessage(envelope); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	select {
+	case message := <-errorReceived:
+		if msg, _ := message["message"].(string); !strings.Contains(msg, "negotiated algorithm") {
+			t.Errorf("Expected an algorithm mismatch error, got %v", message["message"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for error response")
+	}
+
+	if !state.HasState() {
+		t.Error("State should be kept after a rejected downgrade")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestSetCustomDialer(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Route every connection to the mock server, whatever host the URL names
+	mockAddr := env.MockServer.server.Listener.Addr().String()
+	dialer := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, mockAddr)
+		},
+		HandshakeTimeout: 5 * time.Second,
+	}
+	env.WSManager.SetCustomDialer(dialer, http.Header{"User-Agent": []string{"msm-client-test/1.0"}})
+
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] == "status" {
+			select {
+			case connected <- true:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, "ws://msm-server.invalid/ws")
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection through the custom dialer")
+	}
+
+	headers := env.MockServer.GetHeaders()
+	if userAgent := headers.Get("User-Agent"); userAgent != "msm-client-test/1.0" {
+		t.Errorf("Expected the custom User-Agent, got %q", userAgent)
+	}
+	if versions := headers.Get(EnvelopeVersionsHeader); versions != "1" {
+		t.Errorf("Protocol headers should still be sent, got %s %q", EnvelopeVersionsHeader, versions)
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestErrorHandling(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	// Create test state
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	// Verify state is loaded
+	if !state.HasState() {
+		t.Fatal("State should exist after creation")
+	}
+
+	// Start WebSocket connection
+	messageReceived := make(chan bool, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		messageReceived <- true
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	// Wait for initial connection
+	select {
+	case <-messageReceived:
+		// Connected
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for initial connection")
+	}
+
+	// Send error message
+	errorMessage := map[string]interface{}{
+		"type":      "error",
+		"message":   "Test error message",
+		"timestamp": time.Now().Unix(),
+	}
+
+	if err := env.MockServer.SendMessage(errorMessage); err != nil {
+		t.Fatalf("Failed to send error message: %v", err)
+	}
+
+	// Wait a moment for message to be processed
+	time.Sleep(100 * time.Millisecond)
+
+	// The error message should be logged but not cause disconnection
+	if !env.WSManager.IsConnected() {
+		t.Error("Connection should remain active after error message")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestDrainAndClose(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	// Create test state
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if msgType, ok := message["type"].(string); ok && msgType == "status" {
+			select {
+			case connected <- true:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	// Wait for connection
+	select {
+	case <-connected:
+		// Connected
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// Queue messages, then drain and close
+	for i := 1; i <= 3; i++ {
+		err := env.WSManager.QueueMessage(MessageTypeCommandResponse, map[string]interface{}{
+			"command_id": fmt.Sprintf("drain-%d", i),
+		})
+		if err != nil {
+			t.Fatalf("Failed to queue message %d: %v", i, err)
+		}
+	}
+
+	if err := env.WSManager.DrainAndClose(2 * time.Second); err != nil {
+		t.Errorf("DrainAndClose returned error: %v", err)
+	}
+
+	// All queued messages should have reached the server
+	received := make(map[string]bool)
+	for _, msg := range env.MockServer.GetMessages() {
+		if commandID, ok := msg["command_id"].(string); ok {
+			received[commandID] = true
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		commandID := fmt.Sprintf("drain-%d", i)
+		if !received[commandID] {
+			t.Errorf("Queued message %s was not delivered before close", commandID)
+		}
+	}
+
+	if env.WSManager.IsConnected() {
+		t.Error("WebSocket should be disconnected after DrainAndClose")
+	}
+
+	// New messages should be rejected once draining has started
+	if err := env.WSManager.QueueMessage(MessageTypeStatus, nil); err == nil {
+		t.Error("QueueMessage should fail after DrainAndClose")
+	}
+}
+
+func TestConcurrentSends(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	var eventMu sync.Mutex
+	events := make(map[string]bool)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case string(MessageTypeEvent):
+			eventMu.Lock()
+			events[fmt.Sprint(message["event"])] = true
+			eventMu.Unlock()
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+	conn := env.WSManager.GetConnection()
+
+	// Mix SendMessage callers with direct sendResponse calls, as async command
+	// workers make, racing the status ticker
+	const senders, perSender = 20, 25
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				data := map[string]interface{}{"event": fmt.Sprintf("stress-%d-%d", sender, j)}
+				var err error
+				if sender%2 == 0 {
+					err = env.WSManager.SendMessage(MessageTypeEvent, data)
+				} else {
+					err = env.WSManager.sendResponse(conn, MessageTypeEvent, data)
+				}
+				if err != nil {
+					t.Errorf("Send %d-%d failed: %v", sender, j, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		eventMu.Lock()
+		received := len(events)
+		eventMu.Unlock()
+		if received == senders*perSender {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	eventMu.Lock()
+	if len(events) != senders*perSender {
+		t.Errorf("Expected %d events, server received %d", senders*perSender, len(events))
+	}
+	eventMu.Unlock()
+
+	// Once the close frame is sent, sends fail instead of writing
+	env.WSManager.SetShutdown()
+	if err := env.WSManager.DisconnectWebSocket(conn, false); err != nil {
+		t.Errorf("DisconnectWebSocket returned error: %v", err)
+	}
+	if err := env.WSManager.sendResponse(conn, MessageTypeEvent, map[string]interface{}{"event": "late"}); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Expected ErrConnectionClosed after close, got %v", err)
+	}
+	if err := env.WSManager.SendMessage(MessageTypeEvent, nil); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected without a connection, got %v", err)
+	}
+}
+
+func TestSendEncryptedBroadcast(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	if sent, errs := env.WSManager.SendEncryptedBroadcast(MessageTypeEvent, nil); sent != 0 || errs != nil {
+		t.Errorf("Expected sent=0, errs=nil before connecting, got sent=%d, errs=%v", sent, errs)
+	}
+
+	connected := make(chan bool, 1)
+	broadcasts := make(chan map[string]interface{}, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case message["event"] == "broadcast":
+			broadcasts <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if id := env.WSManager.ConnectionID(); len(id) != 36 {
+		t.Errorf("Expected a UUID connection ID, got %q", id)
+	}
+
+	sent, errs := env.WSManager.SendEncryptedBroadcast(MessageTypeEvent, map[string]interface{}{"event": "broadcast"})
+	if sent != 1 || errs != nil {
+		t.Errorf("Expected sent=1, errs=nil, got sent=%d, errs=%v", sent, errs)
+	}
+	select {
+	case <-broadcasts:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the broadcast message")
+	}
+}
+
+func TestStatusPayloadLimit(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	// Limit is too small for anything but the mandatory fields
+	env.Config.MaxStatusPayloadSize = 100
+
+	// Create test state
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	statusReceived := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if msgType, ok := message["type"].(string); ok && msgType == "status" {
+			statusReceived <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	var status map[string]interface{}
+	select {
+	case status = <-statusReceived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for status message")
+	}
+
+	allowed := map[string]bool{"type": true, "clientId": true, "uptime": true, "timestamp": true}
+	for key := range status {
+		if !allowed[key] {
+			t.Errorf("Unexpected field %q in limited status payload", key)
+		}
+	}
+	for key := range allowed {
+		if _, ok := status[key]; !ok {
+			t.Errorf("Mandatory field %q missing from limited status payload", key)
+		}
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestDisconnectCompletesQuickly(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.CloseTimeout = 2 * time.Second
+
+	// Create test state
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if msgType, ok := message["type"].(string); ok && msgType == "status" {
+			select {
+			case connected <- true:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+		// Connected
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// The mock server answers the close frame, so disconnect should not wait for the timeout
+	start := time.Now()
+	if err := env.WSManager.ShutdownWebSocket(true); err != nil {
+		t.Errorf("ShutdownWebSocket returned error: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if elapsed >= env.Config.CloseTimeout {
+		t.Errorf("Disconnect took %v, expected less than close timeout %v", elapsed, env.Config.CloseTimeout)
+	}
+
+	if env.WSManager.IsConnected() {
+		t.Error("WebSocket should be disconnected after shutdown")
+	}
+}
+
+// Benchmark tests
+func BenchmarkWebSocketConnection(b *testing.B) {
+	// Set up temporary directory for state
+	tmpDir := b.TempDir()
+	oldPath := os.Getenv("MSC_STATE_PATH")
+	os.Setenv("MSC_STATE_PATH", tmpDir)
+	defer os.Setenv("MSC_STATE_PATH", oldPath)
+
+	// Create test state directly for benchmark (without mock server)
+	testState := state.PairedState{
+		ServerWs:   "ws://localhost:8080/ws",
+		SessionKey: "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=", // Base64 encoded 32-byte key
+	}
+
+	if err := state.SaveState(testState); err != nil {
+		b.Fatalf("Failed to save test state: %v", err)
+	}
+
+	// Verify state is loaded
+	if !state.HasState() {
+		b.Fatal("State should exist after creation")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Note: This is a simplified benchmark
+		// In practice, you'd want to test specific operations
+		wsm := NewWebSocketManager()
+		wsm.SetTestMode(true)
+
+		// Test basic operations
 		wsm.SetShutdown()
 		wsm.ResetShutdown()
 		wsm.IsConnected()
@@ -921,3 +1727,497 @@ func BenchmarkMessageProcessing(b *testing.B) {
 		}
 	}
 }
+
+func TestFirstStatusIncludesModeSummary(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	tracker := state.NewModeTracker()
+	tracker.EnterPairing()
+	tracker.RecordPairingAttempt()
+	env.WSManager.SetModeTracker(tracker)
+
+	statuses := make(chan map[string]interface{}, 5)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		if message["type"] == "status" {
+			statuses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	for i := 0; i < 2; i++ {
+		select {
+		case status := <-statuses:
+			mode, hasMode := status["mode"].(map[string]interface{})
+			if i == 0 {
+				if !hasMode {
+					t.Fatalf("Expected mode summary in first status, got %v", status)
+				}
+				if mode["previous_mode"] != string(state.ModePairing) || mode["pairing_attempts"] != float64(1) {
+					t.Errorf("Unexpected mode summary: %v", mode)
+				}
+			} else if hasMode {
+				t.Errorf("Expected mode summary only in first status, got %v", mode)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for status %d", i+1)
+		}
+	}
+
+	if tracker.Mode() != state.ModeConnected {
+		t.Errorf("Expected tracker in %s mode, got %s", state.ModeConnected, tracker.Mode())
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestStatusFieldSelection(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	env.Config.DisableCommands = false
+	env.Config.StatusFields = []string{"uptime"}
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	statuses := make(chan map[string]interface{}, 5)
+	responses := make(chan map[string]interface{}, 5)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch {
+		case message["type"] == "status":
+			select {
+			case statuses <- message:
+			default:
+			}
+		case message["command"] == string(CommandStatus):
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case status := <-statuses:
+		for _, key := range []string{"clientId", "timestamp", "uptime"} {
+			if _, ok := status[key]; !ok {
+				t.Errorf("Expected %s in status, got %v", key, status)
+			}
+		}
+		if _, ok := status["interfaces"]; ok {
+			t.Errorf("Expected interfaces to be excluded from status, got %v", status["interfaces"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for status")
+	}
+
+	requestStatus := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		message := map[string]interface{}{
+			"type":       "command",
+			"command":    "status",
+			"command_id": "status-1",
+		}
+		if params != nil {
+			message["params"] = params
+		}
+		if err := env.MockServer.SendMessage(message); err != nil {
+			t.Fatalf("Failed to send status command: %v", err)
+		}
+		select {
+		case response := <-responses:
+			data, _ := response["data"].(map[string]interface{})
+			return data
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for status response")
+		}
+		return nil
+	}
+
+	// The status command honors the configured selection
+	data := requestStatus(nil)
+	if _, ok := data["uptime"]; !ok {
+		t.Errorf("Expected uptime in status command data, got %v", data)
+	}
+	if _, ok := data["interfaces"]; ok {
+		t.Error("Expected interfaces to be excluded from status command data")
+	}
+
+	// params.include overrides it
+	data = requestStatus(map[string]interface{}{"include": []interface{}{"interfaces"}})
+	if _, ok := data["interfaces"]; !ok {
+		t.Errorf("Expected interfaces with params.include, got %v", data)
+	}
+	if _, ok := data["uptime"]; ok {
+		t.Error("Expected uptime to be excluded with params.include")
+	}
+	if _, ok := data["clientId"]; !ok {
+		t.Error("Expected clientId to always be included")
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestStatusRedactsNetworkIdentifiers(t *testing.T) {
+	wsm := NewWebSocketManager()
+	wsm.clientConfig = config.ClientConfig{ClientID: "test-client", RedactNetworkIdentifiers: true}
+
+	status := wsm.generateStatusData()
+	interfaces, ok := status["interfaces"].([]utils.InterfaceInfo)
+	if !ok {
+		t.Fatalf("Expected interfaces in status, got %T", status["interfaces"])
+	}
+	for _, iface := range interfaces {
+		if iface.IPAddress != "" && !strings.HasSuffix(iface.IPAddress, ".x") && !strings.HasSuffix(iface.IPAddress, "/64") {
+			t.Errorf("Expected redacted IP for %s, got %s", iface.Name, iface.IPAddress)
+		}
+		if iface.MACAddress != "" && !strings.HasSuffix(iface.MACAddress, ":xx:xx:xx") {
+			t.Errorf("Expected redacted MAC for %s, got %s", iface.Name, iface.MACAddress)
+		}
+	}
+}
+
+func TestRemoteLogForwarding(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.EnableRemoteLogging = true
+	env.Config.RemoteLogLevel = "info"
+
+	connected := make(chan bool, 1)
+	entries := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case string(MessageTypeLogEntry):
+			entries <- message
+		}
+	})
+
+	level, err := logger.ParseLevel(env.Config.GetRemoteLogLevel())
+	if err != nil {
+		t.Fatalf("Invalid remote log level: %v", err)
+	}
+	remote := slog.New(logger.NewRemoteHandler(slog.NewTextHandler(io.Discard, nil), level, env.WSManager.SendLogEntry))
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	remote.Debug("below the remote level")
+	remote.Info("display attached", "screen", "hdmi-1")
+
+	select {
+	case entry := <-entries:
+		if entry["level"] != "info" || entry["message"] != "display attached" {
+			t.Errorf("Unexpected log entry %v", entry)
+		}
+		if fields, _ := entry["fields"].(map[string]interface{}); fields["screen"] != "hdmi-1" {
+			t.Errorf("Expected screen field, got %v", entry["fields"])
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(entry["timestamp"])); err != nil {
+			t.Errorf("Invalid timestamp %v", entry["timestamp"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the log_entry message")
+	}
+}
+
+func TestRemoteLoggingDefaultLogger(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	entries := make(chan map[string]interface{}, 100)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case string(MessageTypeLogEntry):
+			select {
+			case entries <- message:
+			default:
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// Installed like main does, so the standard log package goes through it too
+	previous := slog.Default()
+	logger.Install(logger.NewRemoteHandler(slog.NewTextHandler(io.Discard, nil), slog.LevelInfo, env.WSManager.SendLogEntry))
+	defer func() {
+		slog.SetDefault(previous)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	log.Printf("Warning: display disconnected")
+
+	select {
+	case entry := <-entries:
+		if entry["level"] != "warn" || entry["message"] != "Warning: display disconnected" {
+			t.Errorf("Unexpected log entry %v", entry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the log_entry message")
+	}
+
+	// Sending the entry must not log a line that is forwarded in turn
+	select {
+	case entry := <-entries:
+		t.Errorf("Unexpected log entry after the warning: %v", entry)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	env.WSManager.ShutdownWebSocket(false)
+}
+
+func TestGetThermalStatusCommand(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	sysfs := t.TempDir()
+	t.Setenv("MSC_SYSFS_PATH", sysfs)
+	for name, content := range map[string]string{
+		"class/thermal/thermal_zone0/type":                 "cpu-thermal",
+		"class/thermal/thermal_zone0/temp":                 "52300",
+		"class/thermal/thermal_zone1/type":                 "gpu-thermal",
+		"class/thermal/thermal_zone1/temp":                 "48100",
+		"devices/system/cpu/cpu0/cpufreq/scaling_cur_freq": "600000",
+		"devices/system/cpu/cpu0/cpufreq/scaling_max_freq": "1500000",
+	} {
+		path := filepath.Join(sysfs, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.Config.DisableCommands = false
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			responses <- message
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":       "command",
+		"command":    string(CommandGetThermalStatus),
+		"command_id": "thermal-1",
+	}); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	select {
+	case response := <-responses:
+		if response["status"] != string(StatusSuccess) {
+			t.Fatalf("Expected success, got %v", response)
+		}
+		data, _ := response["data"].(map[string]interface{})
+		zones, _ := data["zones"].([]interface{})
+		if len(zones) != 2 {
+			t.Fatalf("Expected 2 thermal zones, got %v", data["zones"])
+		}
+		if zone, _ := zones[0].(map[string]interface{}); zone["zone"] != "cpu-thermal" || zone["temp_celsius"] != 52.3 {
+			t.Errorf("Unexpected first zone %v", zones[0])
+		}
+		if data["throttling_status"] != true {
+			t.Errorf("Expected throttling_status true, got %v", data["throttling_status"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for command response")
+	}
+}
+
+func TestReconnectCyclesDoNotLeakGoroutines(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+
+	waitFor := func(what string, condition func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	baseline := runtime.NumGoroutine()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	}()
+
+	const cycles = 50
+	previousID := ""
+	for i := 0; i < cycles; i++ {
+		waitFor("a new connection", func() bool {
+			id := env.WSManager.ConnectionID()
+			return id != "" && id != previousID
+		})
+		previousID = env.WSManager.ConnectionID()
+
+		// The goroutines of the previous connection have all exited
+		if running := env.WSManager.ConnectionGoroutines(); running > 5 {
+			t.Fatalf("Cycle %d: expected at most 5 connection goroutines, got %d", i, running)
+		}
+		if conn := env.WSManager.GetConnection(); conn != nil {
+			conn.Close()
+		}
+	}
+
+	waitFor("the last connection", func() bool {
+		id := env.WSManager.ConnectionID()
+		return id != "" && id != previousID
+	})
+	env.WSManager.ShutdownWebSocket(false)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectWebSocket should return after shutdown")
+	}
+
+	if running := env.WSManager.ConnectionGoroutines(); running != 0 {
+		t.Errorf("Expected no connection goroutines after shutdown, got %d", running)
+	}
+
+	// Allow the mock server's handlers for the closed connections to finish
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := runtime.NumGoroutine(); count > baseline {
+		t.Errorf("Goroutines grew from %d to %d over %d reconnect cycles", baseline, count, cycles)
+	}
+}
+
+func TestCheckPortCommand(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.DisableCommands = false
+
+	connected := make(chan bool, 1)
+	responses := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "status":
+			select {
+			case connected <- true:
+			default:
+			}
+		case "command_response":
+			if message["command"] == "check_port" {
+				responses <- message
+			}
+		}
+	})
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// Stands in for the pairing server the check is usually pointed at
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	checkPort := func(id string) map[string]interface{} {
+		t.Helper()
+		if err := env.MockServer.SendMessage(map[string]interface{}{
+			"type":       "command",
+			"command":    "check_port",
+			"command_id": id,
+			"params":     map[string]interface{}{"host": "localhost", "port": port, "proto": "tcp"},
+		}); err != nil {
+			t.Fatalf("Failed to send check_port: %v", err)
+		}
+		select {
+		case response := <-responses:
+			data, _ := response["data"].(map[string]interface{})
+			return data
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timeout waiting for the check_port response")
+			return nil
+		}
+	}
+
+	if data := checkPort("check-open"); data["reachable"] != true {
+		t.Errorf("Expected the listening port reachable, got %v", data)
+	}
+
+	listener.Close()
+	if data := checkPort("check-closed"); data["reachable"] != false || data["error"] == nil {
+		t.Errorf("Expected the closed port unreachable with an error, got %v", data)
+	}
+}
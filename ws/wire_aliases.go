@@ -0,0 +1,57 @@
+package ws
+
+// wireAliases returns the configured message type and status field aliases
+func (wsm *WebSocketManager) wireAliases() (messageTypes, statusFields map[string]string) {
+	wsm.mu.RLock()
+	defer wsm.mu.RUnlock()
+	return wsm.clientConfig.MessageTypeAliases, wsm.clientConfig.StatusFieldAliases
+}
+
+// aliasOutgoing renames the type of an outgoing message, and the fields of a
+// status message, to their configured wire names. Without aliases the message
+// is returned unchanged.
+func (wsm *WebSocketManager) aliasOutgoing(messageType MessageType, response map[string]interface{}) map[string]interface{} {
+	typeAliases, fieldAliases := wsm.wireAliases()
+	if alias, ok := typeAliases[string(messageType)]; ok {
+		response["type"] = alias
+	}
+	if messageType != MessageTypeStatus || len(fieldAliases) == 0 {
+		return response
+	}
+
+	aliased := make(map[string]interface{}, len(response))
+	for key, value := range response {
+		if alias, ok := fieldAliases[key]; ok {
+			key = alias
+		}
+		aliased[key] = value
+	}
+	return aliased
+}
+
+// unaliasIncoming replaces the wire name of an incoming message type with the
+// canonical one, so handlers only see canonical names
+func (wsm *WebSocketManager) unaliasIncoming(message map[string]interface{}) {
+	typeAliases, _ := wsm.wireAliases()
+	msgType, ok := message["type"].(string)
+	if !ok || len(typeAliases) == 0 {
+		return
+	}
+	for canonical, alias := range typeAliases {
+		if alias == msgType {
+			message["type"] = canonical
+			return
+		}
+	}
+}
+
+// canonicalStatusField returns the status field a wire name stands for
+func (wsm *WebSocketManager) canonicalStatusField(name string) string {
+	_, fieldAliases := wsm.wireAliases()
+	for canonical, alias := range fieldAliases {
+		if alias == name {
+			return canonical
+		}
+	}
+	return name
+}
@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"msm-client/config"
+	"msm-client/state"
+)
+
+func TestWireAliases(t *testing.T) {
+	env := SetupTestEnvironment(t)
+	defer env.Cleanup()
+
+	if err := env.CreateTestState(); err != nil {
+		t.Fatalf("Failed to create test state: %v", err)
+	}
+	env.Config.MessageTypeAliases = map[string]string{
+		"status":         "heartbeat",
+		"status_request": "refresh",
+		"error":          "fault",
+	}
+	env.Config.StatusFieldAliases = map[string]string{"clientId": "deviceId"}
+
+	heartbeats := make(chan map[string]interface{}, 10)
+	env.MockServer.SetOnMessage(func(message map[string]interface{}) {
+		switch message["type"] {
+		case "heartbeat":
+			heartbeats <- message
+		case "status":
+			t.Errorf("Expected the status message type aliased, got %v", message)
+		}
+	})
+	serverErrors := make(chan state.ServerError, 1)
+	env.WSManager.SetOnServerError(func(serverError state.ServerError) { serverErrors <- serverError })
+
+	go env.WSManager.ConnectWebSocket(env.Config, env.MockServer.GetURL())
+	defer env.WSManager.ShutdownWebSocket(false)
+
+	var heartbeat map[string]interface{}
+	select {
+	case heartbeat = <-heartbeats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a heartbeat")
+	}
+	if _, ok := heartbeat["deviceId"]; !ok {
+		t.Errorf("Expected deviceId on the wire, got %v", heartbeat)
+	}
+	if _, ok := heartbeat["clientId"]; ok {
+		t.Errorf("Expected clientId renamed on the wire, got %v", heartbeat)
+	}
+
+	// Aliased incoming messages reach the handlers under their canonical names
+	if err := env.MockServer.SendMessage(map[string]interface{}{"type": "fault", "message": "aliased"}); err != nil {
+		t.Fatalf("Failed to send error message: %v", err)
+	}
+	select {
+	case serverError := <-serverErrors:
+		if serverError.Message != "aliased" {
+			t.Errorf("Expected the aliased error handled, got %+v", serverError)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the aliased error to be handled")
+	}
+
+	// Drain the periodic heartbeat so the next one is the requested status
+	<-heartbeats
+	if err := env.MockServer.SendMessage(map[string]interface{}{
+		"type":   "refresh",
+		"fields": []interface{}{"deviceId"},
+	}); err != nil {
+		t.Fatalf("Failed to send status request: %v", err)
+	}
+	select {
+	case heartbeat = <-heartbeats:
+		if _, ok := heartbeat["uptime"]; ok {
+			t.Errorf("Expected the requested fields only, got %v", heartbeat)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Timeout waiting for the requested heartbeat")
+	}
+}
+
+func TestWireAliasesPreserveCanonicalMessages(t *testing.T) {
+	wsm := NewWebSocketManager()
+	response := map[string]interface{}{"type": "status", "clientId": "abc", "uptime": 1}
+	if aliased := wsm.aliasOutgoing(MessageTypeStatus, response); len(aliased) != 3 || aliased["type"] != "status" || aliased["clientId"] != "abc" {
+		t.Errorf("Expected the message unchanged without aliases, got %v", aliased)
+	}
+
+	message := map[string]interface{}{"type": "fault"}
+	wsm.unaliasIncoming(message)
+	if message["type"] != "fault" {
+		t.Errorf("Expected the type unchanged without aliases, got %v", message["type"])
+	}
+}
+
+func TestKnownMessageTypes(t *testing.T) {
+	for messageType := range frameTypeCodes {
+		if !slices.Contains(config.KnownMessageTypes, string(messageType)) {
+			t.Errorf("Message type %q missing from config.KnownMessageTypes", messageType)
+		}
+	}
+	if len(config.KnownMessageTypes) != len(frameTypeCodes) {
+		t.Errorf("Expected %d known message types, got %d", len(frameTypeCodes), len(config.KnownMessageTypes))
+	}
+}